@@ -40,10 +40,16 @@ type Agent struct {
 	MachineID          string
 	Capabilities       map[string]interface{}
 	OnRegistered       OnRegistered
+	Browsers           *BrowserPool // shared by crawl sessions; created on first use
+	Visual             *VisualOptions // screenshot comparison unless an assignment sets its own
+	Perf               *PerfOptions   // page metrics collection unless an assignment sets its own
+	CrawlLimits        *CrawlLimits   // local ceilings for crawl session budgets
 
 	client      *http.Client
 	activeTests sync.Map
 	activeCount int
+	crawlCount  int
+	crawls      sync.WaitGroup // running crawl sessions, waited for before closing Browsers
 	mu          sync.Mutex
 	running     bool
 }
@@ -317,6 +323,9 @@ type Assignment struct {
 	Browser        string      `json:"browser"`
 	ViewportWidth  int         `json:"viewport_width"`
 	ViewportHeight int         `json:"viewport_height"`
+	ProjectID      json.Number `json:"project_id"`
+	Visual         *VisualOptions `json:"visual,omitempty"`
+	Perf           *PerfOptions   `json:"perf,omitempty"`
 }
 
 // PollAssignments fetches pending assignments from the server.
@@ -350,7 +359,6 @@ func (a *Agent) PollAssignments() ([]Assignment, error) {
 // ExecuteTest runs a single test assignment.
 func (a *Agent) ExecuteTest(ctx context.Context, assignment Assignment) {
 	assignmentID := assignment.ID.String()
-	scriptID := assignment.ScriptID.String()
 	if assignmentID == "" {
 		log.Printf("ERROR: Assignment has empty ID, skipping")
 		return
@@ -362,6 +370,16 @@ func (a *Agent) ExecuteTest(ctx context.Context, assignment Assignment) {
 		a.activeCount--
 		a.mu.Unlock()
 	}()
+	success, message, resultData := a.runTest(ctx, assignment)
+	a.reportResult(assignmentID, success, message, resultData)
+}
+
+// runTest runs an assignment's script with Playwright and returns what
+// ExecuteTest reports: whether it passed, the message and the result data.
+// It is also used by `qmax ci run --local`.
+func (a *Agent) runTest(ctx context.Context, assignment Assignment) (bool, string, map[string]interface{}) {
+	assignmentID := assignment.ID.String()
+	scriptID := assignment.ScriptID.String()
 	testCode := assignment.Code
 	browser := assignment.Browser
 	if browser == "" {
@@ -391,25 +409,28 @@ func (a *Agent) ExecuteTest(ctx context.Context, assignment Assignment) {
 
 	if testCode == "" {
 		log.Printf("ERROR: Assignment %s has no test code", assignmentID)
-		a.reportResult(assignmentID, false, "No test code provided", nil)
-		return
+		return false, "No test code provided", nil
 	}
 
 	log.Printf("Executing test assignment %s (script %s)", assignmentID, scriptID)
 
 	testDir, err := os.MkdirTemp("", fmt.Sprintf("qmax-%s-", assignmentID))
 	if err != nil {
-		a.reportResult(assignmentID, false, fmt.Sprintf("Failed to create temp dir: %v", err), nil)
-		return
+		return false, fmt.Sprintf("Failed to create temp dir: %v", err), nil
 	}
 	defer os.RemoveAll(testDir)
 
 	a.updateAssignmentStatus(assignmentID, "started")
 
+	perfOpts := a.perfOptions(assignment)
+	perfDir := ""
+	if perfOpts != nil {
+		testCode, perfDir = setupPerfFixture(testDir, testCode)
+	}
+
 	testFile := filepath.Join(testDir, "test.spec.js")
 	if err := os.WriteFile(testFile, []byte(testCode), 0644); err != nil {
-		a.reportResult(assignmentID, false, fmt.Sprintf("Failed to write test file: %v", err), nil)
-		return
+		return false, fmt.Sprintf("Failed to write test file: %v", err), nil
 	}
 
 	packageJSON := map[string]interface{}{
@@ -424,8 +445,7 @@ func (a *Agent) ExecuteTest(ctx context.Context, assignment Assignment) {
 	}
 	pkgData, _ := json.MarshalIndent(packageJSON, "", "  ")
 	if err := os.WriteFile(filepath.Join(testDir, "package.json"), pkgData, 0644); err != nil {
-		a.reportResult(assignmentID, false, fmt.Sprintf("Failed to write package.json: %v", err), nil)
-		return
+		return false, fmt.Sprintf("Failed to write package.json: %v", err), nil
 	}
 
 	browserMap := map[string][2]string{
@@ -476,16 +496,14 @@ module.exports = defineConfig({
 
 	configPath := filepath.Join(testDir, "playwright.config.js")
 	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
-		a.reportResult(assignmentID, false, fmt.Sprintf("Failed to write config: %v", err), nil)
-		return
+		return false, fmt.Sprintf("Failed to write config: %v", err), nil
 	}
 
 	log.Printf("Created Playwright config with headless=%v, browser=%s", headless, playwrightBrowser)
 
 	log.Printf("Installing dependencies for assignment %s", assignmentID)
 	if err := a.runCommand(ctx, testDir, "npm", "install", 300*time.Second); err != nil {
-		a.reportResult(assignmentID, false, fmt.Sprintf("Dependency installation failed: %v", err), nil)
-		return
+		return false, fmt.Sprintf("Dependency installation failed: %v", err), nil
 	}
 
 	log.Printf("Installing Playwright browser '%s' for assignment %s", playwrightBrowser, assignmentID)
@@ -500,6 +518,9 @@ module.exports = defineConfig({
 	defer testCancel()
 	cmd := exec.CommandContext(testCtx, "npx", "playwright", "test", "--project", playwrightBrowser)
 	cmd.Dir = testDir
+	if perfDir != "" {
+		cmd.Env = append(os.Environ(), "QMAX_PERF_DIR="+perfDir)
+	}
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
@@ -530,7 +551,28 @@ module.exports = defineConfig({
 		"artifacts": artifacts,
 	}
 
-	a.reportResult(assignmentID, success, stdout.String(), resultData)
+	var visual *VisualReport
+	if opts := a.visualOptions(assignment); opts != nil {
+		key := visualKey{
+			ProjectID: assignment.ProjectID.String(),
+			ScriptID:  scriptID,
+			Browser:   playwrightBrowser,
+			Width:     vpWidth,
+			Height:    vpHeight,
+		}
+		if visual = a.runVisualComparison(assignmentID, *opts, testDir, key); visual != nil {
+			resultData["visual"] = visual
+		}
+	}
+	var perf *PerfReport
+	if perfDir != "" {
+		perf = readPerfReport(perfDir, perfOpts.Budget)
+		logPerfReport(assignmentID, perf)
+		resultData["perf"] = perf
+	}
+	resultData["category"] = resultCategory(success, visual, perf)
+
+	return success, stdout.String(), resultData
 }
 
 func (a *Agent) runCommand(ctx context.Context, dir, name, argsStr string, timeout time.Duration) error {
@@ -666,6 +708,11 @@ func (a *Agent) reportResult(assignmentID string, success bool, message string,
 		"errors":    errors,
 		"artifacts": artifacts,
 	}
+	for _, k := range []string{"category", "visual", "perf"} {
+		if v, ok := resultData[k]; ok {
+			payload[k] = v
+		}
+	}
 
 	resp, body, err := a.doJSON("POST", url, payload, a.authHeaders())
 	if err != nil {
@@ -715,6 +762,10 @@ func (a *Agent) Run(ctx context.Context) error {
 			a.running = false
 			a.mu.Unlock()
 			a.waitForActiveTests()
+			a.crawls.Wait()
+			if a.Browsers != nil {
+				a.Browsers.Close()
+			}
 			return nil
 		case <-ticker.C:
 			assignments, err := a.PollAssignments()
@@ -741,17 +792,49 @@ func (a *Agent) Run(ctx context.Context) error {
 				go a.ExecuteTest(ctx, assignment)
 			}
 
-			// Poll for crawl sessions
-			crawlSession, crawlErr := a.PollCrawlSessions()
-			if crawlErr != nil {
-				log.Printf("WARN: polling crawl sessions: %v", crawlErr)
-			} else if crawlSession != nil {
-				go a.ExecuteCrawlSession(ctx, *crawlSession)
-			}
+			// Poll for crawl sessions until the browser pool is full
+			a.pollCrawlSessions(ctx)
 		}
 	}
 }
 
+// pollCrawlSessions starts pending crawl sessions while the browser pool has
+// free capacity.
+func (a *Agent) pollCrawlSessions(ctx context.Context) {
+	pool := a.browserPool()
+	for {
+		a.mu.Lock()
+		free := pool.MaxSessions() - a.crawlCount
+		a.mu.Unlock()
+		if free <= 0 {
+			return
+		}
+
+		crawlSession, err := a.PollCrawlSessions()
+		if err != nil {
+			log.Printf("WARN: polling crawl sessions: %v", err)
+			return
+		}
+		if crawlSession == nil {
+			return
+		}
+
+		a.mu.Lock()
+		a.crawlCount++
+		a.mu.Unlock()
+		a.crawls.Add(1)
+		go func(session CrawlSession) {
+			defer a.crawls.Done()
+			defer func() {
+				a.mu.Lock()
+				a.crawlCount--
+				a.mu.Unlock()
+			}()
+			a.ExecuteCrawlSession(ctx, session)
+		}(*crawlSession)
+	}
+}
+
 func (a *Agent) waitForActiveTests() {
 	a.mu.Lock()
 	count := a.activeCount
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/chromedp"
+)
+
+const defaultMaxBrowserSessions = 2
+
+// errBrowserPoolClosed is returned by Acquire after Close.
+var errBrowserPoolClosed = errors.New("browser pool is closed")
+
+// BrowserPool keeps one warm Chrome process and hands out isolated browser
+// contexts (incognito targets), at most MaxSessions at a time. The browser is
+// started lazily and restarted if it dies.
+type BrowserPool struct {
+	maxSessions int
+	headless    bool
+	slots       chan struct{}
+
+	mu            sync.Mutex
+	allocCancel   context.CancelFunc
+	browserCtx    context.Context
+	browserCancel context.CancelFunc
+	closed        bool
+}
+
+// NewBrowserPool creates a pool. maxSessions <= 0 uses the default of 2.
+func NewBrowserPool(maxSessions int, headless bool) *BrowserPool {
+	if maxSessions <= 0 {
+		maxSessions = defaultMaxBrowserSessions
+	}
+	return &BrowserPool{
+		maxSessions: maxSessions,
+		headless:    headless,
+		slots:       make(chan struct{}, maxSessions),
+	}
+}
+
+// MaxSessions returns the number of sessions the pool hands out concurrently.
+func (p *BrowserPool) MaxSessions() int {
+	return p.maxSessions
+}
+
+// InUse returns the number of sessions currently checked out.
+func (p *BrowserPool) InUse() int {
+	return len(p.slots)
+}
+
+// Start launches Chrome ahead of the first Acquire.
+func (p *BrowserPool) Start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := p.browserLocked()
+	return err
+}
+
+// browserLocked returns the running browser's root context, launching Chrome
+// if it is not running. Callers must hold p.mu.
+func (p *BrowserPool) browserLocked() (context.Context, error) {
+	if p.closed {
+		return nil, errBrowserPoolClosed
+	}
+	if p.browserCtx != nil && p.browserCtx.Err() == nil {
+		return p.browserCtx, nil
+	}
+	if p.browserCtx != nil {
+		log.Printf("BROWSER: Chrome exited, restarting")
+		p.browserCancel()
+		p.allocCancel()
+	}
+
+	opts := append(chromedp.DefaultExecAllocatorOptions[:],
+		chromedp.Flag("headless", p.headless),
+		chromedp.Flag("disable-gpu", true),
+	)
+	allocCtx, allocCancel := chromedp.NewExecAllocator(context.Background(), opts...)
+	browserCtx, browserCancel := chromedp.NewContext(allocCtx,
+		chromedp.WithLogf(func(s string, args ...interface{}) {
+			log.Printf("BROWSER chromedp: "+s, args...)
+		}),
+		chromedp.WithErrorf(logChromedpError),
+	)
+	// The first Run starts Chrome; the root tab stays open to keep it alive
+	if err := chromedp.Run(browserCtx); err != nil {
+		browserCancel()
+		allocCancel()
+		p.browserCtx = nil
+		return nil, fmt.Errorf("start Chrome: %w", err)
+	}
+
+	p.allocCancel = allocCancel
+	p.browserCtx = browserCtx
+	p.browserCancel = browserCancel
+	log.Printf("BROWSER: Chrome started (headless=%v, max sessions=%d)", p.headless, p.maxSessions)
+	return browserCtx, nil
+}
+
+// chromedpNoise matches chromedp errors about CDP events newer than the
+// bundled cdproto knows, which Chrome sends constantly and are harmless.
+var chromedpNoise = []string{
+	"could not unmarshal event",
+	"unhandled page event",
+	"unhandled node event",
+}
+
+// logChromedpError logs chromedp errors other than the known noise.
+func logChromedpError(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	for _, noise := range chromedpNoise {
+		if strings.Contains(msg, noise) {
+			return
+		}
+	}
+	log.Printf("BROWSER chromedp error: %s", msg)
+}
+
+// Acquire waits for a free slot and returns a chromedp context for a new tab
+// in its own browser context, so sessions share no cookies or storage. The
+// tab is closed and the slot released when ctx is done or the returned
+// cancel func is called.
+func (p *BrowserPool) Acquire(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+
+	p.mu.Lock()
+	browserCtx, err := p.browserLocked()
+	p.mu.Unlock()
+	if err != nil {
+		<-p.slots
+		return nil, nil, err
+	}
+
+	tabCtx, tabCancel := chromedp.NewContext(browserCtx, chromedp.WithNewBrowserContext())
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			tabCancel()
+			<-p.slots
+		})
+	}
+	stop := context.AfterFunc(ctx, release)
+	return tabCtx, func() {
+		stop()
+		release()
+	}, nil
+}
+
+// Close shuts Chrome down. Sessions still checked out are cancelled.
+func (p *BrowserPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	if p.browserCtx != nil {
+		p.browserCancel()
+		p.allocCancel()
+		p.browserCtx = nil
+	}
+}
+
+// browserPool returns the agent's pool, creating a default one on first use.
+func (a *Agent) browserPool() *BrowserPool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.Browsers == nil {
+		a.Browsers = NewBrowserPool(defaultMaxBrowserSessions, !crawlHeaded())
+	}
+	return a.Browsers
+}
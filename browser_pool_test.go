@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+func TestNewBrowserPool_Defaults(t *testing.T) {
+	p := NewBrowserPool(0, true)
+	if p.MaxSessions() != defaultMaxBrowserSessions {
+		t.Errorf("MaxSessions: got %d", p.MaxSessions())
+	}
+	if p.InUse() != 0 {
+		t.Errorf("InUse: got %d", p.InUse())
+	}
+	if NewBrowserPool(5, true).MaxSessions() != 5 {
+		t.Error("explicit max sessions should be kept")
+	}
+}
+
+func TestBrowserPool_AcquireAfterClose(t *testing.T) {
+	p := NewBrowserPool(1, true)
+	p.Close()
+
+	_, _, err := p.Acquire(context.Background())
+	if !errors.Is(err, errBrowserPoolClosed) {
+		t.Fatalf("expected errBrowserPoolClosed, got %v", err)
+	}
+	if p.InUse() != 0 {
+		t.Error("failed Acquire should release its slot")
+	}
+}
+
+func TestBrowserPool_AcquireWaitsForSlot(t *testing.T) {
+	p := NewBrowserPool(1, true)
+	p.slots <- struct{}{} // simulate a session in use
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := p.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded while the pool is full, got %v", err)
+	}
+}
+
+func TestAgent_BrowserPoolLazyDefault(t *testing.T) {
+	a := &Agent{}
+	p := a.browserPool()
+	if p == nil || p.MaxSessions() != defaultMaxBrowserSessions {
+		t.Fatalf("unexpected default pool: %+v", p)
+	}
+	if a.browserPool() != p {
+		t.Error("pool should be created once")
+	}
+
+	custom := NewBrowserPool(4, true)
+	b := &Agent{Browsers: custom}
+	if b.browserPool() != custom {
+		t.Error("configured pool should be used")
+	}
+}
+
+func TestPollCrawlSessions_RespectsPoolCapacity(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	var polls atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/crawl/pending") {
+			n := polls.Add(1)
+			fmt.Fprintf(w, `{"session": {"session_id": "s%d", "url": "https://example.com", "max_steps": 1}}`, n)
+			return
+		}
+		// Hold the session's error report so it keeps its pool slot
+		<-release
+		fmt.Fprint(w, `{}`)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	a := newTestAgent(server.URL)
+	a.Browsers = NewBrowserPool(3, true)
+	a.Browsers.Close() // sessions fail fast without launching Chrome
+	a.crawlCount = 1
+
+	a.pollCrawlSessions(context.Background())
+
+	if got := polls.Load(); got != 2 {
+		t.Errorf("expected 2 polls to fill the pool, got %d", got)
+	}
+	a.mu.Lock()
+	count := a.crawlCount
+	a.mu.Unlock()
+	if count != 3 {
+		t.Errorf("crawlCount: got %d, want 3", count)
+	}
+
+	// A full pool does not poll at all
+	a.pollCrawlSessions(context.Background())
+	if got := polls.Load(); got != 2 {
+		t.Errorf("full pool should not poll, got %d polls", got)
+	}
+}
+
+func TestBrowserPool_IsolatedSessions(t *testing.T) {
+	if os.Getenv("QMAX_BROWSER_TESTS") == "" {
+		t.Skip("Skipping browser test (set QMAX_BROWSER_TESTS=1 to run)")
+	}
+	skipIfNoChrome(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/set" {
+			http.SetCookie(w, &http.Cookie{Name: "sid", Value: "one"})
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<!DOCTYPE html><html><body>ok</body></html>`)
+	}))
+	defer ts.Close()
+
+	p := NewBrowserPool(2, true)
+	defer p.Close()
+
+	first, releaseFirst, err := p.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	defer releaseFirst()
+	second, releaseSecond, err := p.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	defer releaseSecond()
+	if p.InUse() != 2 {
+		t.Errorf("InUse: got %d", p.InUse())
+	}
+
+	var cookieA, cookieB string
+	if err := chromedp.Run(first, chromedp.Navigate(ts.URL+"/set"), chromedp.Evaluate(`document.cookie`, &cookieA)); err != nil {
+		t.Fatalf("first session: %v", err)
+	}
+	if err := chromedp.Run(second, chromedp.Navigate(ts.URL+"/"), chromedp.Evaluate(`document.cookie`, &cookieB)); err != nil {
+		t.Fatalf("second session: %v", err)
+	}
+	if cookieA != "sid=one" || cookieB != "" {
+		t.Errorf("sessions should not share cookies: first=%q second=%q", cookieA, cookieB)
+	}
+
+	releaseFirst()
+	if p.InUse() != 1 {
+		t.Errorf("release should free a slot, InUse=%d", p.InUse())
+	}
+}
+
+func TestBrowserPool_ReleasedWhenContextDone(t *testing.T) {
+	if os.Getenv("QMAX_BROWSER_TESTS") == "" {
+		t.Skip("Skipping browser test (set QMAX_BROWSER_TESTS=1 to run)")
+	}
+	skipIfNoChrome(t)
+
+	p := NewBrowserPool(1, true)
+	defer p.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tab, release, err := p.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	defer release()
+
+	cancel()
+	<-tab.Done()
+	deadline := time.Now().Add(5 * time.Second)
+	for p.InUse() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if p.InUse() != 0 {
+		t.Errorf("cancelling ctx should free the slot, InUse=%d", p.InUse())
+	}
+	release() // must not wait for a second slot
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ciArtifactStore saves the screenshots, videos and traces of executions
+// under dir, one folder per script and attempt:
+//
+//	<dir>/script-<id>/attempt-<n>/<file>
+//
+// Paths are recorded on the result they belong to.
+type ciArtifactStore struct {
+	dir    string
+	all    bool // every execution, not only those that did not pass
+	client *http.Client
+	apiURL string
+	token  string
+
+	mu       sync.Mutex
+	attempts map[int]int
+}
+
+func newCIArtifactStore(dir string, all bool, client *http.Client, apiURL, token string) *ciArtifactStore {
+	return &ciArtifactStore{dir: dir, all: all, client: client, apiURL: apiURL, token: token, attempts: map[int]int{}}
+}
+
+func (s *ciArtifactStore) wants(r ciTestResult) bool {
+	return s.all || r.Status != "passed"
+}
+
+// attemptDir creates the folder for the next attempt of a script.
+func (s *ciArtifactStore) attemptDir(scriptID int) (string, error) {
+	s.mu.Lock()
+	s.attempts[scriptID]++
+	n := s.attempts[scriptID]
+	s.mu.Unlock()
+
+	dir := filepath.Join(s.dir, fmt.Sprintf("script-%d", scriptID), fmt.Sprintf("attempt-%d", n))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// downloadAll downloads the artifacts of cloud executions, at most parallel
+// at a time. Download problems are warnings; the paths of what was saved
+// are still recorded.
+func (s *ciArtifactStore) downloadAll(results []ciTestResult, parallel int) {
+	ciForEach(len(results), parallel, func(i int) {
+		r := &results[i]
+		if r.ExecutionID == "" || !s.wants(*r) {
+			return
+		}
+		if err := s.downloadExecution(r); err != nil {
+			fmt.Fprintf(os.Stderr, "  Warning: artifacts of script #%d: %v\n", r.ScriptID, err)
+		}
+	})
+}
+
+// downloadExecution downloads the artifacts the execution status lists.
+func (s *ciArtifactStore) downloadExecution(r *ciTestResult) error {
+	status, err := ciGetExecutionStatus(s.client, s.apiURL, s.token, r.ExecutionID)
+	if err != nil {
+		return err
+	}
+	sources := append([]string{}, status.Screenshots...)
+	for _, u := range []string{status.VideoURL, status.TraceURL} {
+		if u != "" {
+			sources = append(sources, u)
+		}
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+
+	dir, err := s.attemptDir(r.ScriptID)
+	if err != nil {
+		return err
+	}
+	var errs []string
+	for i, src := range sources {
+		p, err := s.fetch(src, dir, i)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		r.Artifacts = append(r.Artifacts, p)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// fetch saves one artifact, given as a URL, a path on the API server or a
+// data: URI, into dir and returns the saved path.
+func (s *ciArtifactStore) fetch(src, dir string, index int) (string, error) {
+	if strings.HasPrefix(src, "data:") {
+		return saveDataURI(src, dir, index)
+	}
+
+	u := src
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		u = s.apiURL + "/" + strings.TrimPrefix(src, "/")
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return "", fmt.Errorf("create request: %w", err)
+	}
+	// Only the API gets the token; artifact URLs may point at storage
+	// that another party runs, or at the API host over plain HTTP.
+	if sameCIOrigin(u, s.apiURL) {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("download %s: %w", src, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download %s: HTTP %d", src, resp.StatusCode)
+	}
+
+	name := ciArtifactName(u, index)
+	p := filepath.Join(dir, name)
+	f, err := os.Create(p)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return "", fmt.Errorf("download %s: %w", src, err)
+	}
+	return p, f.Close()
+}
+
+// sameCIOrigin reports whether two URLs have the same scheme and host.
+func sameCIOrigin(a, b string) bool {
+	ua, err1 := url.Parse(a)
+	ub, err2 := url.Parse(b)
+	return err1 == nil && err2 == nil &&
+		strings.EqualFold(ua.Scheme, ub.Scheme) && strings.EqualFold(ua.Host, ub.Host)
+}
+
+// ciArtifactName is the file name for an artifact URL: the last path
+// element, prefixed with its position so two "screenshot.png" do not clash.
+func ciArtifactName(u string, index int) string {
+	name := "artifact"
+	if parsed, err := url.Parse(u); err == nil {
+		if base := path.Base(parsed.Path); base != "." && base != "/" && base != "" {
+			name = base
+		}
+	}
+	return fmt.Sprintf("%02d-%s", index+1, ciSafeFileName(name))
+}
+
+// ciSafeFileName replaces characters that are not safe in file names.
+func ciSafeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+var dataURIExtensions = map[string]string{
+	"image/png":       ".png",
+	"image/jpeg":      ".jpg",
+	"video/webm":      ".webm",
+	"application/zip": ".zip",
+}
+
+func saveDataURI(src, dir string, index int) (string, error) {
+	meta, data, ok := strings.Cut(strings.TrimPrefix(src, "data:"), ",")
+	if !ok || !strings.HasSuffix(meta, ";base64") {
+		return "", fmt.Errorf("unsupported data URI")
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", fmt.Errorf("decode data URI: %w", err)
+	}
+	ext := dataURIExtensions[strings.TrimSuffix(meta, ";base64")]
+	p := filepath.Join(dir, fmt.Sprintf("%02d-artifact%s", index+1, ext))
+	return p, os.WriteFile(p, raw, 0644)
+}
+
+// saveLocal writes the screenshots and video a local run collected, which
+// the runner returns base64-encoded in its result data.
+func (s *ciArtifactStore) saveLocal(r *ciTestResult, resultData map[string]interface{}) error {
+	if !s.wants(*r) || resultData["artifacts"] == nil {
+		return nil
+	}
+	type file struct {
+		Filename string `json:"filename"`
+		Data     string `json:"data"`
+	}
+	var artifacts struct {
+		Screenshots []file `json:"screenshots"`
+		Video       *file  `json:"video"`
+	}
+	data, err := json.Marshal(resultData["artifacts"])
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &artifacts); err != nil {
+		return fmt.Errorf("parse artifacts: %w", err)
+	}
+	files := artifacts.Screenshots
+	if artifacts.Video != nil {
+		files = append(files, *artifacts.Video)
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	dir, err := s.attemptDir(r.ScriptID)
+	if err != nil {
+		return err
+	}
+	for i, f := range files {
+		raw, err := base64.StdEncoding.DecodeString(f.Data)
+		if err != nil {
+			return fmt.Errorf("decode %s: %w", f.Filename, err)
+		}
+		p := filepath.Join(dir, fmt.Sprintf("%02d-%s", i+1, ciSafeFileName(f.Filename)))
+		if err := os.WriteFile(p, raw, 0644); err != nil {
+			return err
+		}
+		r.Artifacts = append(r.Artifacts, p)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCIArtifactStore_DownloadAll(t *testing.T) {
+	var storageAuth string
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		storageAuth = r.Header.Get("Authorization")
+		w.Write([]byte("video-bytes"))
+	}))
+	defer storage.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/playwright-execution/status/exec-2":
+			w.Write([]byte(`{"status": "failed",
+				"screenshots": ["/files/exec-2/failure.png", "data:image/png;base64,` + base64.StdEncoding.EncodeToString([]byte("png")) + `"],
+				"video_url": "` + storage.URL + `/videos/exec-2.webm?sig=abc",
+				"trace_url": "files/exec-2/trace.zip"}`))
+		case "/files/exec-2/failure.png":
+			w.Write([]byte("screenshot"))
+		case "/files/exec-2/trace.zip":
+			w.Write([]byte("trace"))
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	store := newCIArtifactStore(dir, false, srv.Client(), srv.URL, "tok")
+	results := []ciTestResult{
+		{ScriptID: 1, Status: "passed", ExecutionID: "exec-1"},
+		{ScriptID: 2, Status: "failed", ExecutionID: "exec-2"},
+		{ScriptID: 3, Status: "error"},
+	}
+	store.downloadAll(results, 2)
+
+	attempt := filepath.Join(dir, "script-2", "attempt-1")
+	want := []string{
+		filepath.Join(attempt, "01-failure.png"),
+		filepath.Join(attempt, "02-artifact.png"),
+		filepath.Join(attempt, "03-exec-2.webm"),
+		filepath.Join(attempt, "04-trace.zip"),
+	}
+	if !reflect.DeepEqual(results[1].Artifacts, want) {
+		t.Fatalf("artifacts = %v, want %v", results[1].Artifacts, want)
+	}
+	for path, content := range map[string]string{want[0]: "screenshot", want[1]: "png", want[2]: "video-bytes", want[3]: "trace"} {
+		if data, err := os.ReadFile(path); err != nil || string(data) != content {
+			t.Errorf("%s = %q, %v", path, data, err)
+		}
+	}
+	if storageAuth != "" {
+		t.Errorf("token sent to artifact storage: %q", storageAuth)
+	}
+	if results[0].Artifacts != nil || results[2].Artifacts != nil {
+		t.Errorf("artifacts downloaded for passed or unstarted scripts: %+v", results)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "script-1")); !os.IsNotExist(err) {
+		t.Errorf("folder created for a passed script: %v", err)
+	}
+}
+
+func TestCIArtifactStore_SaveLocal(t *testing.T) {
+	dir := t.TempDir()
+	store := newCIArtifactStore(dir, true, nil, "", "")
+	resultData := map[string]interface{}{
+		"artifacts": map[string]interface{}{
+			"screenshots": []map[string]string{
+				{"filename": "test-finished-1.png", "data": base64.StdEncoding.EncodeToString([]byte("shot"))},
+			},
+			"video": map[string]string{"filename": "video.webm", "data": base64.StdEncoding.EncodeToString([]byte("video"))},
+		},
+	}
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		r := ciTestResult{ScriptID: 5, Status: "passed"}
+		if err := store.saveLocal(&r, resultData); err != nil {
+			t.Fatal(err)
+		}
+		base := filepath.Join(dir, "script-5", fmt.Sprintf("attempt-%d", attempt))
+		want := []string{filepath.Join(base, "01-test-finished-1.png"), filepath.Join(base, "02-video.webm")}
+		if !reflect.DeepEqual(r.Artifacts, want) {
+			t.Errorf("attempt %d: artifacts = %v, want %v", attempt, r.Artifacts, want)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "script-5", "attempt-1", "02-video.webm")); err != nil || string(data) != "video" {
+		t.Errorf("video = %q, %v", data, err)
+	}
+
+	// Only failures by default
+	failedOnly := newCIArtifactStore(t.TempDir(), false, nil, "", "")
+	r := ciTestResult{ScriptID: 5, Status: "passed"}
+	if err := failedOnly.saveLocal(&r, resultData); err != nil || r.Artifacts != nil {
+		t.Errorf("saved artifacts of a passed script: %v, %v", r.Artifacts, err)
+	}
+}
+
+func TestCIRetryFailed_KeepsArtifacts(t *testing.T) {
+	results := []ciTestResult{{ScriptID: 1, Status: "failed", Artifacts: []string{"a1/shot.png"}}}
+	results = ciRetryFailed(results, 1, time.Time{}, func(ids []int) ([]ciTestResult, error) {
+		return []ciTestResult{{ScriptID: 1, Status: "passed", Artifacts: []string{"a2/shot.png"}}}, nil
+	})
+	if want := []string{"a1/shot.png", "a2/shot.png"}; !reflect.DeepEqual(results[0].Artifacts, want) {
+		t.Errorf("artifacts = %v, want %v", results[0].Artifacts, want)
+	}
+}
+
+func TestCIRetryFailed_ArtifactsNotShared(t *testing.T) {
+	first := make([]string, 1, 4)
+	first[0] = "a1/shot.png"
+	results := []ciTestResult{{ScriptID: 1, Status: "failed", Artifacts: first}}
+	results = ciRetryFailed(results, 1, time.Time{}, func(ids []int) ([]ciTestResult, error) {
+		return []ciTestResult{{ScriptID: 1, Status: "failed", Artifacts: []string{"a2/shot.png"}}}, nil
+	})
+	results[0].Artifacts[1] = "changed"
+	if first[:2][1] == "changed" {
+		t.Error("retry artifacts share the first attempt's backing array")
+	}
+}
+
+func TestCIReports_Artifacts(t *testing.T) {
+	results := testCIResults()
+	results[1].Artifacts = []string{"qmax-artifacts/script-2/attempt-1/01-failure.png"}
+
+	var junit bytes.Buffer
+	if err := ciWriteReport(&junit, "junit", results, 42, 12.5); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(junit.String(), "<system-out>[[ATTACHMENT|qmax-artifacts/script-2/attempt-1/01-failure.png]]") {
+		t.Errorf("JUnit report missing attachment:\n%s", junit.String())
+	}
+
+	var js bytes.Buffer
+	ciOutputJSON(&js, results, 42, 12.5)
+	if !strings.Contains(js.String(), `"Artifacts": [`) {
+		t.Errorf("JSON report missing artifacts:\n%s", js.String())
+	}
+}
+
+func TestSameCIOrigin(t *testing.T) {
+	tests := []struct {
+		u    string
+		same bool
+	}{
+		{"https://app.qualitymax.io/files/a.png", true},
+		{"https://APP.qualitymax.io/files/a.png", true},
+		{"http://app.qualitymax.io/files/a.png", false},
+		{"https://app.qualitymax.io:8443/files/a.png", false},
+		{"https://storage.example.com/a.png", false},
+	}
+	for _, tt := range tests {
+		if got := sameCIOrigin(tt.u, "https://app.qualitymax.io"); got != tt.same {
+			t.Errorf("sameCIOrigin(%q) = %v, want %v", tt.u, got, tt.same)
+		}
+	}
+}
+
+func TestCIArtifactName(t *testing.T) {
+	tests := map[string]string{
+		"https://s3.example.com/b/run%201/video.webm?X-Sig=1": "03-video.webm",
+		"https://app.example.com/":                            "03-artifact",
+		"https://app.example.com/files/a b;c.png":             "03-a_b_c.png",
+	}
+	for u, want := range tests {
+		if got := ciArtifactName(u, 2); got != want {
+			t.Errorf("ciArtifactName(%q) = %q, want %q", u, got, want)
+		}
+	}
+}
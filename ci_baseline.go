@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Runs for --compare-to last-main are recorded in the project's user data,
+// the store `qmax capture` saves login state to. Each recorded run is a
+// field of the "CI baselines" category keyed by branch; the latest one of
+// a branch is its baseline.
+const (
+	ciBaselineCategory    = "CI baselines"
+	ciBaselineFieldPrefix = "ci-last-run:"
+	ciBaselineMaxError    = 500
+)
+
+// ciBaselineRecord is the value of a recorded run field.
+type ciBaselineRecord struct {
+	Branch     string       `json:"branch"`
+	RecordedAt time.Time    `json:"recorded_at"`
+	Report     ciJSONReport `json:"report"`
+}
+
+// ciUserDataCategory is a user data category with its fields, as listed by
+// /api/projects/{id}/user-data/all.
+type ciUserDataCategory struct {
+	ID     json.Number `json:"id"`
+	Name   string      `json:"name"`
+	Fields []struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	} `json:"fields"`
+}
+
+func ciFetchUserData(client *http.Client, apiURL, token string, projectID int) ([]ciUserDataCategory, error) {
+	url := fmt.Sprintf("%s/api/projects/%d/user-data/all", apiURL, projectID)
+	body, err := ciAuthGet(client, url, token)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Categories []ciUserDataCategory `json:"categories"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("parse user data: %w", err)
+	}
+	return resp.Categories, nil
+}
+
+func findCIBaselineCategory(categories []ciUserDataCategory) *ciUserDataCategory {
+	for i := range categories {
+		if strings.EqualFold(categories[i].Name, ciBaselineCategory) {
+			return &categories[i]
+		}
+	}
+	return nil
+}
+
+// loadCIBaseline returns the report to compare against: a JSON report file,
+// or for "last-main" the latest run recorded for branch with
+// --record-baseline. It returns nil without an error when no run of the
+// branch has been recorded yet.
+func loadCIBaseline(client *http.Client, apiURL, token string, projectID int, compareTo, branch string) (*ciJSONReport, error) {
+	if compareTo != "last-main" {
+		return readCIJSONReport(compareTo)
+	}
+
+	categories, err := ciFetchUserData(client, apiURL, token, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("fetch recorded runs: %w", err)
+	}
+	cat := findCIBaselineCategory(categories)
+	if cat == nil {
+		return nil, nil
+	}
+	var latest *ciBaselineRecord
+	for _, f := range cat.Fields {
+		if f.Key != ciBaselineFieldPrefix+branch {
+			continue
+		}
+		var record ciBaselineRecord
+		if err := json.Unmarshal([]byte(f.Value), &record); err != nil {
+			continue
+		}
+		if latest == nil || !record.RecordedAt.Before(latest.RecordedAt) {
+			latest = &record
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+	return &latest.Report, nil
+}
+
+// recordCIBaseline records a run as the latest run of branch. Errors are
+// cut short and artifacts and comparisons left out to keep the field small.
+func recordCIBaseline(client *http.Client, apiURL, token string, projectID int, branch string, results []ciTestResult, duration float64) error {
+	categories, err := ciFetchUserData(client, apiURL, token, projectID)
+	if err != nil {
+		return fmt.Errorf("fetch user data: %w", err)
+	}
+	var catID string
+	if cat := findCIBaselineCategory(categories); cat != nil {
+		catID = cat.ID.String()
+	} else if catID, err = ciCreateUserDataCategory(client, apiURL, token, projectID, ciBaselineCategory); err != nil {
+		return err
+	}
+
+	record := ciBaselineRecord{
+		Branch:     branch,
+		RecordedAt: time.Now().UTC(),
+		Report:     ciJSONReport{ProjectID: projectID, Duration: duration, Results: make([]ciTestResult, len(results))},
+	}
+	for i, r := range results {
+		r.Error = truncate(r.Error, ciBaselineMaxError)
+		r.Artifacts = nil
+		r.BaselineStatus = ""
+		r.BaselineDuration = 0
+		record.Report.Results[i] = r
+	}
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/api/projects/%d/user-data/categories/%s/fields", apiURL, projectID, catID)
+	payload := map[string]interface{}{
+		"key":       ciBaselineFieldPrefix + branch,
+		"value":     string(value),
+		"is_secret": false,
+	}
+	if _, err := ciAuthPost(client, url, token, payload); err != nil {
+		return fmt.Errorf("save run: %w", err)
+	}
+	return nil
+}
+
+func ciCreateUserDataCategory(client *http.Client, apiURL, token string, projectID int, name string) (string, error) {
+	url := fmt.Sprintf("%s/api/projects/%d/user-data/categories", apiURL, projectID)
+	body, err := ciAuthPost(client, url, token, map[string]string{"name": name})
+	if err != nil {
+		return "", fmt.Errorf("create category: %w", err)
+	}
+	var created struct {
+		Category struct {
+			ID json.Number `json:"id"`
+		} `json:"category"`
+	}
+	if err := json.Unmarshal(body, &created); err != nil || created.Category.ID == "" {
+		return "", fmt.Errorf("parse created category: %s", truncate(string(body), 200))
+	}
+	return created.Category.ID.String(), nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeUserDataServer stores user data categories and fields in memory.
+func fakeUserDataServer(t *testing.T) *httptest.Server {
+	var categories []map[string]interface{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/api/projects/42/user-data/all":
+			json.NewEncoder(w).Encode(map[string]interface{}{"categories": categories})
+		case r.Method == "POST" && r.URL.Path == "/api/projects/42/user-data/categories":
+			var payload map[string]string
+			json.NewDecoder(r.Body).Decode(&payload)
+			categories = append(categories, map[string]interface{}{"id": 7, "name": payload["name"], "fields": []interface{}{}})
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"category": {"id": 7}}`))
+		case r.Method == "POST" && r.URL.Path == "/api/projects/42/user-data/categories/7/fields":
+			var field map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&field)
+			categories[0]["fields"] = append(categories[0]["fields"].([]interface{}), field)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestCIBaseline_RecordAndLoad(t *testing.T) {
+	srv := fakeUserDataServer(t)
+	defer srv.Close()
+
+	report, err := loadCIBaseline(srv.Client(), srv.URL, "tok", 42, "last-main", "main")
+	if err != nil || report != nil {
+		t.Fatalf("nothing recorded yet: %+v, %v", report, err)
+	}
+
+	first := []ciTestResult{{ScriptID: 1, Status: "failed", Error: strings.Repeat("x", 2000), Artifacts: []string{"a.png"}}}
+	second := []ciTestResult{{ScriptID: 1, Status: "passed", Duration: 3}}
+	for _, results := range [][]ciTestResult{first, second} {
+		if err := recordCIBaseline(srv.Client(), srv.URL, "tok", 42, "main", results, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recordCIBaseline(srv.Client(), srv.URL, "tok", 42, "release", first, 10); err != nil {
+		t.Fatal(err)
+	}
+	if first[0].Artifacts == nil {
+		t.Error("recording changed the results")
+	}
+
+	report, err = loadCIBaseline(srv.Client(), srv.URL, "tok", 42, "last-main", "main")
+	if err != nil || report == nil || len(report.Results) != 1 || report.Results[0].Status != "passed" {
+		t.Fatalf("latest main run: %+v, %v", report, err)
+	}
+	report, err = loadCIBaseline(srv.Client(), srv.URL, "tok", 42, "last-main", "release")
+	if err != nil || report == nil || len(report.Results[0].Error) > ciBaselineMaxError+3 || report.Results[0].Artifacts != nil {
+		t.Fatalf("release run should be trimmed: %+v, %v", report, err)
+	}
+	if report, err := loadCIBaseline(srv.Client(), srv.URL, "tok", 42, "last-main", "develop"); err != nil || report != nil {
+		t.Errorf("unrecorded branch: %+v, %v", report, err)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A test that passed in both runs is a duration regression when it got
+// this much slower, both relatively and in seconds. The absolute floor
+// keeps short tests from flagging on ordinary jitter.
+const (
+	ciDurationRegressionRatio   = 1.5
+	ciDurationRegressionMinimum = 5.0
+)
+
+// ciBaselineNew is the BaselineStatus of a script the baseline did not run.
+const ciBaselineNew = "new"
+
+// Changes of a result against the baseline, as returned by ciChange.
+const (
+	ciChangeNewFailure   = "new_failure"
+	ciChangeFixed        = "fixed"
+	ciChangeStillFailing = "still_failing"
+	ciChangeSlower       = "slower"
+)
+
+// ciFailOnModes are the values accepted by --fail-on.
+var ciFailOnModes = []string{"any", "new-failures"}
+
+// applyCIBaseline records each result's status and duration in the
+// baseline. Scripts the baseline did not run are marked new.
+func applyCIBaseline(results []ciTestResult, baseline *ciJSONReport) {
+	byScript := make(map[int]ciTestResult, len(baseline.Results))
+	for _, r := range baseline.Results {
+		byScript[r.ScriptID] = r
+	}
+	for i, r := range results {
+		b, ok := byScript[r.ScriptID]
+		if !ok {
+			results[i].BaselineStatus = ciBaselineNew
+			continue
+		}
+		results[i].BaselineStatus = b.Status
+		results[i].BaselineDuration = b.Duration
+	}
+}
+
+// ciChange classifies a result against the baseline, or returns "" when
+// nothing changed or there was no comparison.
+func ciChange(r ciTestResult) string {
+	if r.BaselineStatus == "" {
+		return ""
+	}
+	failing := r.Status != "passed"
+	wasFailing := r.BaselineStatus != "passed" && r.BaselineStatus != ciBaselineNew
+	switch {
+	case failing && wasFailing:
+		return ciChangeStillFailing
+	case failing:
+		return ciChangeNewFailure
+	case wasFailing:
+		return ciChangeFixed
+	case r.BaselineStatus == "passed" && r.BaselineDuration > 0 &&
+		r.Duration >= r.BaselineDuration*ciDurationRegressionRatio &&
+		r.Duration-r.BaselineDuration >= ciDurationRegressionMinimum:
+		return ciChangeSlower
+	}
+	return ""
+}
+
+// ciComparison counts the changes against the baseline.
+type ciComparison struct {
+	NewFailures         int `json:"new_failures"`
+	Fixed               int `json:"fixed"`
+	StillFailing        int `json:"still_failing"`
+	DurationRegressions int `json:"duration_regressions"`
+}
+
+// compareCIResults counts the changes against the baseline. It returns
+// nil when the results were not compared to one.
+func compareCIResults(results []ciTestResult) *ciComparison {
+	var cmp *ciComparison
+	for _, r := range results {
+		if r.BaselineStatus == "" {
+			continue
+		}
+		if cmp == nil {
+			cmp = &ciComparison{}
+		}
+		switch ciChange(r) {
+		case ciChangeNewFailure:
+			cmp.NewFailures++
+		case ciChangeFixed:
+			cmp.Fixed++
+		case ciChangeStillFailing:
+			cmp.StillFailing++
+		case ciChangeSlower:
+			cmp.DurationRegressions++
+		}
+	}
+	return cmp
+}
+
+// ciFailsBuild reports whether the results fail the build under the
+// --fail-on mode: any failing test, or only failures the baseline did not
+// have. Quarantined failures never fail the build.
+func ciFailsBuild(results []ciTestResult, failOn string) bool {
+	for _, r := range results {
+		if !ciFailed(r) {
+			continue
+		}
+		if failOn != "new-failures" || r.BaselineStatus == "" || ciChange(r) == ciChangeNewFailure {
+			return true
+		}
+	}
+	return false
+}
+
+// ciMarkdownComparisonSection lists the changes against the baseline, or
+// returns "" when the results were not compared to one.
+func ciMarkdownComparisonSection(results []ciTestResult) string {
+	cmp := compareCIResults(results)
+	if cmp == nil {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\n### \U0001f4ca Compared to Baseline\n\n")
+	if *cmp == (ciComparison{}) {
+		sb.WriteString("No changes.\n\n")
+		return sb.String()
+	}
+	for _, group := range []struct {
+		change, title string
+	}{
+		{ciChangeNewFailure, "\u274c New failures"},
+		{ciChangeFixed, "\u2705 Fixed"},
+		{ciChangeStillFailing, "\U0001f501 Still failing"},
+		{ciChangeSlower, "\U0001f422 Slower"},
+	} {
+		var names []string
+		for _, r := range results {
+			if ciChange(r) != group.change {
+				continue
+			}
+			name := ciTestName(r)
+			if group.change == ciChangeSlower {
+				name = fmt.Sprintf("%s (%.1fs \u2192 %.1fs)", name, r.BaselineDuration, r.Duration)
+			} else if r.BaselineStatus == ciBaselineNew {
+				name += " (new test)"
+			}
+			names = append(names, name)
+		}
+		if len(names) > 0 {
+			sb.WriteString(fmt.Sprintf("**%s (%d):** %s\n\n", group.title, len(names), strings.Join(names, ", ")))
+		}
+	}
+	return sb.String()
+}
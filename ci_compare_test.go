@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testComparedCIResults() []ciTestResult {
+	results := []ciTestResult{
+		{ScriptID: 1, ScriptName: "Login", Status: "failed"},
+		{ScriptID: 2, ScriptName: "Checkout", Status: "passed", Duration: 4},
+		{ScriptID: 3, ScriptName: "Search", Status: "error"},
+		{ScriptID: 4, ScriptName: "Profile", Status: "passed", Duration: 20},
+		{ScriptID: 5, ScriptName: "Signup", Status: "failed"},
+		{ScriptID: 6, ScriptName: "Logout", Status: "passed", Duration: 2.5},
+	}
+	baseline := &ciJSONReport{Results: []ciTestResult{
+		{ScriptID: 1, Status: "passed", Duration: 3},
+		{ScriptID: 2, Status: "failed", Duration: 4},
+		{ScriptID: 3, Status: "failed"},
+		{ScriptID: 4, Status: "passed", Duration: 10},
+		{ScriptID: 6, Status: "passed", Duration: 1},
+	}}
+	applyCIBaseline(results, baseline)
+	return results
+}
+
+func TestCIChange(t *testing.T) {
+	results := testComparedCIResults()
+	want := []string{ciChangeNewFailure, ciChangeFixed, ciChangeStillFailing, ciChangeSlower, ciChangeNewFailure, ""}
+	for i, r := range results {
+		if got := ciChange(r); got != want[i] {
+			t.Errorf("%s: change = %q, want %q", r.ScriptName, got, want[i])
+		}
+	}
+	if results[4].BaselineStatus != ciBaselineNew {
+		t.Errorf("script missing from the baseline: %+v", results[4])
+	}
+	if got := ciChange(ciTestResult{Status: "failed"}); got != "" {
+		t.Errorf("uncompared result: %q", got)
+	}
+}
+
+func TestCompareCIResults(t *testing.T) {
+	if cmp := compareCIResults(testCIResults()); cmp != nil {
+		t.Errorf("uncompared results: %+v", cmp)
+	}
+	cmp := compareCIResults(testComparedCIResults())
+	if want := (ciComparison{NewFailures: 2, Fixed: 1, StillFailing: 1, DurationRegressions: 1}); cmp == nil || *cmp != want {
+		t.Errorf("comparison = %+v, want %+v", cmp, want)
+	}
+}
+
+func TestCIFailsBuild(t *testing.T) {
+	results := testComparedCIResults()
+	if !ciFailsBuild(results, "any") || !ciFailsBuild(results, "new-failures") {
+		t.Error("new failures did not fail the build")
+	}
+
+	// Only the still-failing script is left failing
+	results[0].Status = "passed"
+	results[4].Quarantined = true
+	if !ciFailsBuild(results, "any") {
+		t.Error("still-failing test did not fail the build with --fail-on any")
+	}
+	if ciFailsBuild(results, "new-failures") {
+		t.Error("still-failing test failed the build with --fail-on new-failures")
+	}
+
+	// Without a baseline every failure counts
+	if !ciFailsBuild(testCIResults(), "new-failures") {
+		t.Error("uncompared failures did not fail the build")
+	}
+}
+
+func TestCIComparisonOutputs(t *testing.T) {
+	var md bytes.Buffer
+	ciOutputMarkdown(&md, testComparedCIResults(), 42, 30)
+	for _, want := range []string{
+		"### \U0001f4ca Compared to Baseline",
+		"**\u274c New failures (2):** Login, Signup (new test)",
+		"**\u2705 Fixed (1):** Checkout",
+		"**\U0001f501 Still failing (1):** Search",
+		"**\U0001f422 Slower (1):** Profile (10.0s \u2192 20.0s)",
+	} {
+		if !strings.Contains(md.String(), want) {
+			t.Errorf("markdown missing %q:\n%s", want, md.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	ciOutputJSON(&buf, testComparedCIResults(), 42, 30)
+	var out struct {
+		Comparison *ciComparison `json:"comparison"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Comparison == nil || out.Comparison.NewFailures != 2 || out.Comparison.DurationRegressions != 1 {
+		t.Errorf("JSON comparison = %+v", out.Comparison)
+	}
+
+	buf.Reset()
+	ciOutputJSON(&buf, testCIResults(), 42, 30)
+	if strings.Contains(buf.String(), `"comparison"`) {
+		t.Errorf("uncompared JSON report has a comparison:\n%s", buf.String())
+	}
+}
+
+func TestApplyCIBaseline_FromReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baseline.json")
+	var buf bytes.Buffer
+	ciOutputJSON(&buf, testCIResults(), 42, 12.5)
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	baseline, err := loadCIBaseline(nil, "", "", 42, path, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := []ciTestResult{
+		{ScriptID: 1, Status: "failed"},
+		{ScriptID: 99, Status: "passed"},
+	}
+	applyCIBaseline(results, baseline)
+	if results[0].BaselineStatus != "passed" || ciChange(results[0]) != ciChangeNewFailure {
+		t.Errorf("script in the baseline: %+v", results[0])
+	}
+	if results[1].BaselineStatus != ciBaselineNew {
+		t.Errorf("script not in the baseline: %+v", results[1])
+	}
+}
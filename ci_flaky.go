@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ciRunFunc runs scripts and returns one result per script, in order.
+type ciRunFunc func(scriptIDs []int) ([]ciTestResult, error)
+
+// ciRetryFailed re-runs the scripts that failed or errored, up to retries
+// more times each. Visual diffs and performance regressions are results of
+// a run that worked and are not retried. A script that passes on a retry is
+// reported as passed and flaky, keeping the error from its first failed
+// attempt. Artifacts of every attempt are kept. No retry round starts after
+// deadline, if set, and if a round cannot be started the results so far are
+// kept.
+func ciRetryFailed(results []ciTestResult, retries int, deadline time.Time, run ciRunFunc) []ciTestResult {
+	for i := range results {
+		if results[i].Attempts == 0 {
+			results[i].Attempts = 1
+		}
+	}
+	firstErrors := make(map[int]string)
+	for attempt := 2; attempt <= retries+1; attempt++ {
+		var idx []int
+		var ids []int
+		for i, r := range results {
+			if ciRetryable(r) {
+				idx = append(idx, i)
+				ids = append(ids, r.ScriptID)
+				if _, ok := firstErrors[i]; !ok {
+					firstErrors[i] = r.Error
+				}
+			}
+		}
+		if len(ids) == 0 {
+			break
+		}
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			fmt.Fprintf(os.Stderr, "Not retrying %d failed test(s): the --timeout has passed\n", len(ids))
+			break
+		}
+
+		fmt.Fprintf(os.Stderr, "Retrying %d failed test(s), attempt %d of %d...\n", len(ids), attempt, retries+1)
+		retried, err := run(ids)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not retry failed tests: %v\n", err)
+			break
+		}
+		for j, i := range idx {
+			if j >= len(retried) {
+				break
+			}
+			r := retried[j]
+			if r.ScriptName == "" {
+				r.ScriptName = results[i].ScriptName
+			}
+			r.Attempts = attempt
+			r.Artifacts = append(append([]string{}, results[i].Artifacts...), r.Artifacts...)
+			if r.Status == "passed" {
+				r.Flaky = true
+				r.Error = firstErrors[i]
+			}
+			results[i] = r
+		}
+	}
+	return results
+}
+
+// ciRetryable reports whether a result is worth running again.
+func ciRetryable(r ciTestResult) bool {
+	return r.Status == "failed" || r.Status == "error"
+}
+
+// ciFailed reports whether a result fails the build: it did not pass and
+// is not quarantined.
+func ciFailed(r ciTestResult) bool {
+	return r.Status != "passed" && !r.Quarantined
+}
+
+// ciCounts tallies results for the report headers and CI outputs. Flaky
+// tests count as passed and quarantined failures are counted on their own,
+// not as failed.
+type ciCounts struct {
+	Passed      int
+	Failed      int
+	Flaky       int
+	Quarantined int
+}
+
+func countCIResults(results []ciTestResult) ciCounts {
+	var c ciCounts
+	for _, r := range results {
+		switch {
+		case r.Status == "passed":
+			c.Passed++
+			if r.Flaky {
+				c.Flaky++
+			}
+		case r.Quarantined:
+			c.Quarantined++
+		default:
+			c.Failed++
+		}
+	}
+	return c
+}
+
+func (c ciCounts) status() string {
+	if c.Failed > 0 {
+		return "failed"
+	}
+	return "passed"
+}
+
+// --- Quarantine ---
+
+// loadCIQuarantine reads a quarantine file: one script ID or name glob per
+// line, as for --exclude. Blank lines and text after '#' are ignored.
+func loadCIQuarantine(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			patterns = append(patterns, line)
+		}
+	}
+	return patterns, sc.Err()
+}
+
+// ciFetchQuarantine fetches the quarantine list from the project's CI
+// settings. A server without project settings has nothing quarantined.
+func ciFetchQuarantine(client *http.Client, apiURL, token string, projectID int) ([]string, error) {
+	url := fmt.Sprintf("%s/api/projects/%d/settings", apiURL, projectID)
+	body, err := ciAuthGet(client, url, token)
+	if err != nil {
+		var httpErr *ciHTTPError
+		if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var resp struct {
+		CIQuarantine []json.RawMessage `json:"ci_quarantine"`
+		Settings     struct {
+			CIQuarantine []json.RawMessage `json:"ci_quarantine"`
+		} `json:"settings"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("parse project settings: %w", err)
+	}
+	entries := resp.CIQuarantine
+	if len(entries) == 0 {
+		entries = resp.Settings.CIQuarantine
+	}
+
+	// Entries are script IDs or name globs
+	var patterns []string
+	for _, raw := range entries {
+		var id int
+		if err := json.Unmarshal(raw, &id); err == nil {
+			patterns = append(patterns, strconv.Itoa(id))
+			continue
+		}
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil && strings.TrimSpace(s) != "" {
+			patterns = append(patterns, strings.TrimSpace(s))
+		}
+	}
+	return patterns, nil
+}
+
+// applyCIQuarantine marks the results matching the quarantine patterns.
+// Only results that did not pass are marked, so a quarantined test that
+// passes is reported as an ordinary pass.
+func applyCIQuarantine(results []ciTestResult, patterns []string) {
+	if len(patterns) == 0 {
+		return
+	}
+	for i, r := range results {
+		if r.Status != "passed" && ciExcluded(ciScript{ID: r.ScriptID, Name: r.ScriptName}, patterns) {
+			results[i].Quarantined = true
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCIRetryFailed(t *testing.T) {
+	results := []ciTestResult{
+		{ScriptID: 1, ScriptName: "Login", Status: "passed"},
+		{ScriptID: 2, ScriptName: "Checkout", Status: "failed", Error: "first failure"},
+		{ScriptID: 3, ScriptName: "Search", Status: "error", Error: "timeout"},
+	}
+	var calls [][]int
+	run := func(ids []int) ([]ciTestResult, error) {
+		calls = append(calls, ids)
+		out := make([]ciTestResult, len(ids))
+		for i, id := range ids {
+			out[i] = ciTestResult{ScriptID: id, Status: "failed", Error: "still failing"}
+			// Checkout passes on the second retry
+			if id == 2 && len(calls) == 2 {
+				out[i] = ciTestResult{ScriptID: id, Status: "passed"}
+			}
+		}
+		return out, nil
+	}
+
+	results = ciRetryFailed(results, 2, time.Time{}, run)
+
+	if want := [][]int{{2, 3}, {2, 3}}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("retried %v, want %v", calls, want)
+	}
+	if r := results[0]; r.Attempts != 1 || r.Flaky {
+		t.Errorf("passing script changed: %+v", r)
+	}
+	if r := results[1]; r.Status != "passed" || !r.Flaky || r.Attempts != 3 || r.Error != "first failure" || r.ScriptName != "Checkout" {
+		t.Errorf("flaky script: %+v", r)
+	}
+	if r := results[2]; r.Status != "failed" || r.Flaky || r.Attempts != 3 || r.Error != "still failing" {
+		t.Errorf("failing script: %+v", r)
+	}
+}
+
+func TestCIRetryFailed_NoRetries(t *testing.T) {
+	results := []ciTestResult{{ScriptID: 1, Status: "failed"}}
+	results = ciRetryFailed(results, 0, time.Time{}, func([]int) ([]ciTestResult, error) {
+		t.Fatal("run called without retries")
+		return nil, nil
+	})
+	if results[0].Attempts != 1 {
+		t.Errorf("attempts = %d", results[0].Attempts)
+	}
+}
+
+func TestCIRetryFailed_RunError(t *testing.T) {
+	results := []ciTestResult{{ScriptID: 1, Status: "failed", Error: "boom"}}
+	calls := 0
+	results = ciRetryFailed(results, 3, time.Time{}, func([]int) ([]ciTestResult, error) {
+		calls++
+		return nil, errors.New("unavailable")
+	})
+	if calls != 1 || results[0].Status != "failed" || results[0].Error != "boom" {
+		t.Errorf("calls = %d, result = %+v", calls, results[0])
+	}
+}
+
+func TestCIRetryFailed_OnlyFailures(t *testing.T) {
+	results := []ciTestResult{
+		{ScriptID: 1, Status: "visual_diff"},
+		{ScriptID: 2, Status: "degraded"},
+		{ScriptID: 3, Status: "error"},
+	}
+	var retried []int
+	results = ciRetryFailed(results, 1, time.Time{}, func(ids []int) ([]ciTestResult, error) {
+		retried = ids
+		return []ciTestResult{{ScriptID: 3, Status: "passed"}}, nil
+	})
+	if !reflect.DeepEqual(retried, []int{3}) || results[0].Attempts != 1 || results[1].Status != "degraded" {
+		t.Errorf("retried %v, results %+v", retried, results)
+	}
+}
+
+func TestCIRetryFailed_DeadlinePassed(t *testing.T) {
+	results := []ciTestResult{{ScriptID: 1, Status: "failed"}}
+	results = ciRetryFailed(results, 2, time.Now().Add(-time.Second), func([]int) ([]ciTestResult, error) {
+		t.Fatal("run called after the deadline")
+		return nil, nil
+	})
+	if results[0].Attempts != 1 || results[0].Status != "failed" {
+		t.Errorf("result changed: %+v", results[0])
+	}
+}
+
+func TestCountCIResults(t *testing.T) {
+	results := []ciTestResult{
+		{Status: "passed"},
+		{Status: "passed", Flaky: true},
+		{Status: "failed", Quarantined: true},
+		{Status: "error"},
+	}
+	c := countCIResults(results)
+	if want := (ciCounts{Passed: 2, Failed: 1, Flaky: 1, Quarantined: 1}); c != want {
+		t.Errorf("counts = %+v, want %+v", c, want)
+	}
+	if c.status() != "failed" {
+		t.Errorf("status = %s", c.status())
+	}
+	if c := countCIResults(results[:3]); c.status() != "passed" {
+		t.Errorf("quarantined failure failed the run: %+v", c)
+	}
+}
+
+func TestLoadCIQuarantine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quarantine")
+	data := "# known flaky\n12\n\nCheckout*  # ticket QA-7\n  7 \n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadCIQuarantine(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"12", "Checkout*", "7"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := loadCIQuarantine(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected error for a missing file")
+	}
+}
+
+func TestCIFetchQuarantine(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/projects/42/settings":
+			w.Write([]byte(`{"settings": {"ci_quarantine": [12, "Checkout*", ""]}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	got, err := ciFetchQuarantine(srv.Client(), srv.URL, "tok", 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"12", "Checkout*"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	got, err = ciFetchQuarantine(srv.Client(), srv.URL, "tok", 7)
+	if err != nil || len(got) != 0 {
+		t.Errorf("missing settings: %q, %v", got, err)
+	}
+}
+
+func TestApplyCIQuarantine(t *testing.T) {
+	results := testCIResults()
+	applyCIQuarantine(results, []string{"1", "Check*"})
+	if results[0].Quarantined {
+		t.Error("passing script was quarantined")
+	}
+	if !results[1].Quarantined {
+		t.Error("failing script matching a glob was not quarantined")
+	}
+	if results[2].Quarantined {
+		t.Error("script not in the quarantine was quarantined")
+	}
+}
+
+func testFlakyCIResults() []ciTestResult {
+	results := testCIResults()
+	results[0].Flaky = true
+	results[0].Attempts = 2
+	results[0].Error = "net::ERR_CONNECTION_RESET"
+	results[1].Quarantined = true
+	return results
+}
+
+func TestCIOutputJSON_FlakyAndQuarantined(t *testing.T) {
+	var buf bytes.Buffer
+	ciOutputJSON(&buf, testFlakyCIResults(), 42, 12.5)
+	var out struct {
+		Status      string `json:"status"`
+		Passed      int    `json:"passed"`
+		Failed      int    `json:"failed"`
+		Flaky       int    `json:"flaky"`
+		Quarantined int    `json:"quarantined"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Status != "failed" || out.Passed != 1 || out.Failed != 1 || out.Flaky != 1 || out.Quarantined != 1 {
+		t.Errorf("unexpected counts: %+v", out)
+	}
+}
+
+func TestCIOutputMarkdown_FlakyAndQuarantined(t *testing.T) {
+	var buf bytes.Buffer
+	ciOutputMarkdown(&buf, testFlakyCIResults(), 42, 12.5)
+	md := buf.String()
+	for _, want := range []string{
+		"| **Flaky:** 1 | **Quarantined:** 1",
+		"Passed (flaky, 2 attempts)",
+		"**Checkout (quarantined)**",
+		"### \u26a0\ufe0f Flaky Tests",
+		"**Login works** passed on attempt 2",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("markdown missing %q:\n%s", want, md)
+		}
+	}
+}
+
+func TestCIWriteGitHubOutputs_Flaky(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "output")
+	ciWriteGitHubOutputs(envMap(map[string]string{"GITHUB_OUTPUT": out}), testFlakyCIResults(), 42, 12.5)
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"failed=1\n", "flaky=1\n", "quarantined=1\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("outputs missing %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestCIReports_Quarantined(t *testing.T) {
+	var junit bytes.Buffer
+	if err := ciWriteReport(&junit, "junit", testFlakyCIResults(), 42, 12.5); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`failures="0" errors="1" skipped="1"`,
+		`<skipped message="quarantined: expect(locator).toBeVisible() failed"></skipped>`,
+		`<property name="flaky" value="true"></property>`,
+	} {
+		if !strings.Contains(junit.String(), want) {
+			t.Errorf("JUnit report missing %q:\n%s", want, junit.String())
+		}
+	}
+
+	var tap bytes.Buffer
+	if err := ciWriteReport(&tap, "tap", testFlakyCIResults(), 42, 12.5); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"ok 1 - Login works\n# flaky: passed on attempt 2\n", "not ok 2 - Checkout # TODO quarantined\n"} {
+		if !strings.Contains(tap.String(), want) {
+			t.Errorf("TAP report missing %q:\n%s", want, tap.String())
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultGitHubAPIURL = "https://api.github.com"
+	// GitHub rejects comment bodies and check run summaries over 65536
+	// characters, and commit status descriptions over 140.
+	githubMaxBody        = 65000
+	githubMaxDescription = 140
+)
+
+// ciGitHubFlags are the flags for posting results to a pull request, shared
+// by `ci run` and `ci merge`.
+type ciGitHubFlags struct {
+	comment  *bool
+	status   *string
+	prNumber *int
+	sha      *string
+	token    *string
+	apiURL   *string
+}
+
+func registerCIGitHubFlags(fs *flag.FlagSet) *ciGitHubFlags {
+	return &ciGitHubFlags{
+		comment:  fs.Bool("pr-comment", false, "Post the summary as a sticky pull request comment and set a commit status"),
+		status:   fs.String("pr-status", "status", "With --pr-comment, report pass/fail as a commit status, a check run, or none"),
+		prNumber: fs.Int("pr-number", 0, "Pull request to comment on (default: from the GitHub Actions event)"),
+		sha:      fs.String("commit-sha", "", "Commit for the status (default: the pull request head)"),
+		token:    fs.String("github-token", "", "GitHub token (or GITHUB_TOKEN / GH_TOKEN env var)"),
+		apiURL:   fs.String("github-api-url", "", "GitHub API base URL, for GitHub Enterprise Server (or GITHUB_API_URL env var)"),
+	}
+}
+
+// publisher returns the pull request publisher the flags ask for, or nil
+// without --pr-comment.
+func (f *ciGitHubFlags) publisher(getenv func(string) string) (*githubPRPublisher, error) {
+	if !*f.comment {
+		return nil, nil
+	}
+	switch *f.status {
+	case "status", "check", "none":
+	default:
+		return nil, fmt.Errorf("unknown --pr-status %q (use status, check or none)", *f.status)
+	}
+
+	p := &githubPRPublisher{
+		client:     &http.Client{Timeout: 30 * time.Second},
+		apiURL:     strings.TrimRight(firstNonEmpty(*f.apiURL, getenv("GITHUB_API_URL"), defaultGitHubAPIURL), "/"),
+		token:      firstNonEmpty(*f.token, getenv("GITHUB_TOKEN"), getenv("GH_TOKEN")),
+		repo:       getenv("GITHUB_REPOSITORY"),
+		pr:         *f.prNumber,
+		sha:        *f.sha,
+		statusMode: *f.status,
+	}
+	if p.token == "" {
+		return nil, fmt.Errorf("--pr-comment needs a GitHub token: use --github-token or GITHUB_TOKEN")
+	}
+	if p.repo == "" {
+		return nil, fmt.Errorf("--pr-comment needs GITHUB_REPOSITORY (owner/repo)")
+	}
+
+	event := readGitHubEvent(getenv("GITHUB_EVENT_PATH"))
+	if p.pr == 0 {
+		p.pr = event.PullRequest.Number
+	}
+	if p.pr == 0 {
+		p.pr = githubPRFromRef(getenv("GITHUB_REF"))
+	}
+	if p.pr == 0 {
+		return nil, fmt.Errorf("--pr-comment: not a pull request build, use --pr-number")
+	}
+	if p.sha == "" {
+		p.sha = firstNonEmpty(event.PullRequest.Head.SHA, getenv("GITHUB_SHA"))
+	}
+	if server, runID := getenv("GITHUB_SERVER_URL"), getenv("GITHUB_RUN_ID"); server != "" && runID != "" {
+		p.runURL = fmt.Sprintf("%s/%s/actions/runs/%s", strings.TrimRight(server, "/"), p.repo, runID)
+	}
+	return p, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// githubEvent is the part of the Actions event payload naming the PR.
+type githubEvent struct {
+	PullRequest struct {
+		Number int `json:"number"`
+		Head   struct {
+			SHA string `json:"sha"`
+		} `json:"head"`
+	} `json:"pull_request"`
+}
+
+func readGitHubEvent(path string) githubEvent {
+	var event githubEvent
+	if path == "" {
+		return event
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		_ = json.Unmarshal(data, &event)
+	}
+	return event
+}
+
+var githubPRRefRe = regexp.MustCompile(`^refs/pull/(\d+)/`)
+
+// githubPRFromRef returns the PR number of a refs/pull/N/merge ref, or 0.
+func githubPRFromRef(ref string) int {
+	m := githubPRRefRe.FindStringSubmatch(ref)
+	if m == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
+
+// githubPRPublisher posts run results to a pull request through the GitHub
+// REST API: one comment per project, updated on every run, and a commit
+// status or check run on the head commit.
+type githubPRPublisher struct {
+	client     *http.Client
+	apiURL     string
+	token      string
+	repo       string
+	pr         int
+	sha        string
+	statusMode string
+	runURL     string
+}
+
+// githubCommentMarker identifies the comment for a project, so later runs
+// update it instead of adding another.
+func githubCommentMarker(projectID int) string {
+	return fmt.Sprintf("<!-- qmax-ci-results project=%d -->", projectID)
+}
+
+// Publish posts the comment and sets the status. failed is whether the run
+// fails the build, which decides the status.
+func (p *githubPRPublisher) Publish(s ciRunSummary, failed bool) error {
+	var md bytes.Buffer
+	ciOutputMarkdown(&md, s.Results, s.ProjectID, s.TotalDuration)
+	body := githubCommentMarker(s.ProjectID) + "\n" + truncate(md.String(), githubMaxBody)
+
+	if err := p.upsertComment(githubCommentMarker(s.ProjectID), body); err != nil {
+		return fmt.Errorf("PR comment: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Posted results to %s#%d\n", p.repo, p.pr)
+
+	if p.statusMode == "none" {
+		return nil
+	}
+	if p.sha == "" {
+		return fmt.Errorf("commit status: no commit SHA, use --commit-sha")
+	}
+	if p.statusMode == "check" {
+		if err := p.createCheckRun(s, failed, md.String()); err != nil {
+			return fmt.Errorf("check run: %w", err)
+		}
+		return nil
+	}
+	if err := p.setStatus(s, failed); err != nil {
+		return fmt.Errorf("commit status: %w", err)
+	}
+	return nil
+}
+
+func (p *githubPRPublisher) upsertComment(marker, body string) error {
+	for page := 1; ; page++ {
+		var comments []struct {
+			ID   int64  `json:"id"`
+			Body string `json:"body"`
+		}
+		path := fmt.Sprintf("/repos/%s/issues/%d/comments?per_page=100&page=%d", p.repo, p.pr, page)
+		if err := p.do("GET", path, nil, &comments); err != nil {
+			return err
+		}
+		for _, c := range comments {
+			if strings.Contains(c.Body, marker) {
+				return p.do("PATCH", fmt.Sprintf("/repos/%s/issues/comments/%d", p.repo, c.ID), map[string]string{"body": body}, nil)
+			}
+		}
+		if len(comments) < 100 {
+			break
+		}
+	}
+	return p.do("POST", fmt.Sprintf("/repos/%s/issues/%d/comments", p.repo, p.pr), map[string]string{"body": body}, nil)
+}
+
+// githubStatusDescription summarizes the counts in one short line.
+func githubStatusDescription(s ciRunSummary) string {
+	c := s.counts()
+	desc := fmt.Sprintf("%d passed, %d failed", c.Passed, c.Failed)
+	if c.Flaky > 0 {
+		desc += fmt.Sprintf(", %d flaky", c.Flaky)
+	}
+	if c.Quarantined > 0 {
+		desc += fmt.Sprintf(", %d quarantined", c.Quarantined)
+	}
+	if cmp := compareCIResults(s.Results); cmp != nil {
+		desc += fmt.Sprintf(", %d new failures", cmp.NewFailures)
+	}
+	return truncate(desc, githubMaxDescription)
+}
+
+func (p *githubPRPublisher) setStatus(s ciRunSummary, failed bool) error {
+	state := "success"
+	if failed {
+		state = "failure"
+	}
+	payload := map[string]string{
+		"state":       state,
+		"context":     fmt.Sprintf("qmax/project-%d", s.ProjectID),
+		"description": githubStatusDescription(s),
+	}
+	if p.runURL != "" {
+		payload["target_url"] = p.runURL
+	}
+	return p.do("POST", fmt.Sprintf("/repos/%s/statuses/%s", p.repo, p.sha), payload, nil)
+}
+
+func (p *githubPRPublisher) createCheckRun(s ciRunSummary, failed bool, summary string) error {
+	conclusion := "success"
+	if failed {
+		conclusion = "failure"
+	}
+	payload := map[string]interface{}{
+		"name":       fmt.Sprintf("QualityMax project #%d", s.ProjectID),
+		"head_sha":   p.sha,
+		"status":     "completed",
+		"conclusion": conclusion,
+		"output": map[string]string{
+			"title":   githubStatusDescription(s),
+			"summary": truncate(summary, githubMaxBody),
+		},
+	}
+	if p.runURL != "" {
+		payload["details_url"] = p.runURL
+	}
+	return p.do("POST", fmt.Sprintf("/repos/%s/check-runs", p.repo), payload, nil)
+}
+
+// do sends a GitHub API request and decodes the response into out, if set.
+func (p *githubPRPublisher) do(method, path string, payload, out interface{}) error {
+	var reqBody io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, p.apiURL+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, ciMaxBody))
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: HTTP %d: %s", method, path, resp.StatusCode, truncate(string(body), 200))
+	}
+	if out != nil {
+		if err := json.Unmarshal(body, out); err != nil {
+			return fmt.Errorf("parse response: %w", err)
+		}
+	}
+	return nil
+}
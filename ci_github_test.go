@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeGitHub records the requests the publisher makes and serves the
+// existing comments of one pull request.
+type fakeGitHub struct {
+	mu       sync.Mutex
+	comments []map[string]interface{}
+	requests []string
+	bodies   map[string]map[string]interface{}
+}
+
+func (g *fakeGitHub) handler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer gh-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		key := r.Method + " " + r.URL.Path
+		g.requests = append(g.requests, key)
+		if r.Method != "GET" {
+			data, _ := io.ReadAll(r.Body)
+			var body map[string]interface{}
+			if err := json.Unmarshal(data, &body); err != nil {
+				t.Errorf("%s: invalid JSON body: %v", key, err)
+			}
+			g.bodies[key] = body
+		}
+		switch key {
+		case "GET /repos/acme/shop/issues/7/comments":
+			json.NewEncoder(w).Encode(g.comments)
+		default:
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{}`))
+		}
+	})
+}
+
+func testPRPublisher(t *testing.T, g *fakeGitHub, env map[string]string, args ...string) *githubPRPublisher {
+	t.Helper()
+	srv := httptest.NewServer(g.handler(t))
+	t.Cleanup(srv.Close)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := registerCIGitHubFlags(fs)
+	if err := fs.Parse(append([]string{"--pr-comment", "--github-api-url", srv.URL}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	base := map[string]string{
+		"GITHUB_TOKEN":      "gh-token",
+		"GITHUB_REPOSITORY": "acme/shop",
+		"GITHUB_REF":        "refs/pull/7/merge",
+		"GITHUB_SHA":        "merge-sha",
+	}
+	for k, v := range env {
+		base[k] = v
+	}
+	p, err := flags.publisher(envMap(base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestGitHubPRPublisher_CreatesCommentAndStatus(t *testing.T) {
+	g := &fakeGitHub{bodies: map[string]map[string]interface{}{}}
+	p := testPRPublisher(t, g, map[string]string{
+		"GITHUB_SERVER_URL": "https://github.example.com",
+		"GITHUB_RUN_ID":     "99",
+	})
+	if err := p.Publish(testCIRunSummary(), true); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"GET /repos/acme/shop/issues/7/comments",
+		"POST /repos/acme/shop/issues/7/comments",
+		"POST /repos/acme/shop/statuses/merge-sha",
+	}
+	if strings.Join(g.requests, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("requests:\n%s", strings.Join(g.requests, "\n"))
+	}
+	body, _ := g.bodies["POST /repos/acme/shop/issues/7/comments"]["body"].(string)
+	if !strings.HasPrefix(body, githubCommentMarker(42)) || !strings.Contains(body, "QualityMax Test Results") {
+		t.Errorf("comment body:\n%s", body)
+	}
+	status := g.bodies["POST /repos/acme/shop/statuses/merge-sha"]
+	if status["state"] != "failure" || status["context"] != "qmax/project-42" || status["description"] != "1 passed, 2 failed" ||
+		status["target_url"] != "https://github.example.com/acme/shop/actions/runs/99" {
+		t.Errorf("status: %v", status)
+	}
+}
+
+func TestGitHubPRPublisher_UpdatesStickyComment(t *testing.T) {
+	g := &fakeGitHub{
+		bodies: map[string]map[string]interface{}{},
+		comments: []map[string]interface{}{
+			{"id": 1, "body": "LGTM"},
+			{"id": 2, "body": githubCommentMarker(42) + "\nold results"},
+		},
+	}
+	p := testPRPublisher(t, g, nil, "--pr-status", "none")
+	if err := p.Publish(testCIRunSummary(), false); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"GET /repos/acme/shop/issues/7/comments",
+		"PATCH /repos/acme/shop/issues/comments/2",
+	}
+	if strings.Join(g.requests, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("requests:\n%s", strings.Join(g.requests, "\n"))
+	}
+}
+
+func TestGitHubPRPublisher_CheckRun(t *testing.T) {
+	event := filepath.Join(t.TempDir(), "event.json")
+	if err := os.WriteFile(event, []byte(`{"pull_request": {"number": 7, "head": {"sha": "head-sha"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	g := &fakeGitHub{bodies: map[string]map[string]interface{}{}}
+	p := testPRPublisher(t, g, map[string]string{"GITHUB_EVENT_PATH": event, "GITHUB_REF": "refs/heads/feature"}, "--pr-status", "check")
+	if err := p.Publish(testCIRunSummary(), false); err != nil {
+		t.Fatal(err)
+	}
+	check := g.bodies["POST /repos/acme/shop/check-runs"]
+	if check == nil || check["head_sha"] != "head-sha" || check["conclusion"] != "success" || check["status"] != "completed" {
+		t.Errorf("check run: %v (requests %v)", check, g.requests)
+	}
+}
+
+func TestGitHubPRPublisher_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+	}{
+		{"no token", map[string]string{"GITHUB_REPOSITORY": "acme/shop", "GITHUB_REF": "refs/pull/7/merge"}, nil},
+		{"no repository", map[string]string{"GITHUB_TOKEN": "t", "GITHUB_REF": "refs/pull/7/merge"}, nil},
+		{"not a pull request", map[string]string{"GITHUB_TOKEN": "t", "GITHUB_REPOSITORY": "acme/shop", "GITHUB_REF": "refs/heads/main"}, nil},
+		{"bad status mode", map[string]string{"GITHUB_TOKEN": "t", "GITHUB_REPOSITORY": "acme/shop"}, []string{"--pr-number", "7", "--pr-status", "both"}},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		flags := registerCIGitHubFlags(fs)
+		if err := fs.Parse(append([]string{"--pr-comment"}, tt.args...)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := flags.publisher(envMap(tt.env)); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := registerCIGitHubFlags(fs)
+	if p, err := flags.publisher(envMap(nil)); p != nil || err != nil {
+		t.Errorf("without --pr-comment: %v, %v", p, err)
+	}
+}
+
+func TestGitHubPRPublisher_APIError(t *testing.T) {
+	g := &fakeGitHub{bodies: map[string]map[string]interface{}{}}
+	p := testPRPublisher(t, g, nil)
+	p.token = "wrong"
+	if err := p.Publish(testCIRunSummary(), false); err == nil || !strings.Contains(err.Error(), "HTTP 401") {
+		t.Errorf("expected HTTP 401 error, got %v", err)
+	}
+}
+
+func TestGitHubPRFromRef(t *testing.T) {
+	for ref, want := range map[string]int{"refs/pull/12/merge": 12, "refs/heads/main": 0, "": 0} {
+		if got := githubPRFromRef(ref); got != want {
+			t.Errorf("githubPRFromRef(%q) = %d, want %d", ref, got, want)
+		}
+	}
+}
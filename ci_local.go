@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ciLocalOptions configures `qmax ci run --local`.
+type ciLocalOptions struct {
+	ProjectID     int
+	BaseURL       string
+	Headless      bool
+	Browser       string
+	ReportToCloud bool
+	Artifacts     *ciArtifactStore // nil unless --artifacts-dir
+}
+
+// ciTestRunner runs one assignment the way Agent.runTest does.
+type ciTestRunner func(ctx context.Context, assignment Assignment) (bool, string, map[string]interface{})
+
+// ciRunLocal runs the scripts one at a time on this machine with the agent's
+// Playwright runner. Scripts left when the deadline passes are reported as
+// timed out. If the server turns out not to accept local results,
+// opts.ReportToCloud is switched off for this and later runs.
+func ciRunLocal(ctx context.Context, client *http.Client, apiURL, token string, scriptIDs []int, opts *ciLocalOptions, run ciTestRunner) []ciTestResult {
+	results := make([]ciTestResult, len(scriptIDs))
+	for i, sid := range scriptIDs {
+		if ctx.Err() != nil {
+			results[i] = ciTestResult{ScriptID: sid, Status: "error", Error: "timeout waiting for execution to complete"}
+			continue
+		}
+
+		name, code, err := ciFetchScript(client, apiURL, token, sid)
+		if err != nil {
+			results[i] = ciTestResult{ScriptID: sid, Status: "error", Error: fmt.Sprintf("fetch script: %v", err)}
+			continue
+		}
+
+		fmt.Fprintf(os.Stderr, "  Running script #%d (%s) locally\n", sid, name)
+		assignment := Assignment{
+			ID:        json.Number(fmt.Sprintf("ci-%d", sid)),
+			ScriptID:  json.Number(fmt.Sprintf("%d", sid)),
+			ProjectID: json.Number(fmt.Sprintf("%d", opts.ProjectID)),
+			Code:      code,
+			CustomURL: opts.BaseURL,
+			Headless:  opts.Headless,
+			Browser:   opts.Browser,
+		}
+		start := time.Now()
+		success, message, resultData := run(ctx, assignment)
+		results[i] = ciLocalResult(sid, name, success, message, resultData, time.Since(start).Seconds())
+		if opts.Artifacts != nil {
+			if err := opts.Artifacts.saveLocal(&results[i], resultData); err != nil {
+				fmt.Fprintf(os.Stderr, "  Warning: artifacts of script #%d: %v\n", sid, err)
+			}
+		}
+
+		if opts.ReportToCloud {
+			executionID, err := ciReportLocalResult(client, apiURL, token, results[i], *opts, resultData)
+			if errors.Is(err, errCILocalResultUnsupported) {
+				fmt.Fprintln(os.Stderr, "  Notice: the server does not accept local results; they are not recorded in the cloud")
+				opts.ReportToCloud = false
+			} else if err != nil {
+				fmt.Fprintf(os.Stderr, "  Warning: could not report script #%d to the cloud: %v\n", sid, err)
+			} else {
+				results[i].ExecutionID = executionID
+			}
+		}
+
+		statusIcon := "PASS"
+		if results[i].Status != "passed" {
+			statusIcon = "FAIL"
+		}
+		fmt.Fprintf(os.Stderr, "  [%s] Script #%d (%s) - %.1fs\n", statusIcon, sid, name, results[i].Duration)
+	}
+	return results
+}
+
+// ciLocalResult converts what the runner returned into a CI result. The
+// runner returns no result data when the test could not be run at all.
+func ciLocalResult(sid int, name string, success bool, message string, resultData map[string]interface{}, duration float64) ciTestResult {
+	r := ciTestResult{ScriptID: sid, ScriptName: name, Duration: duration}
+	if resultData == nil {
+		r.Status = "error"
+		r.Error = message
+		return r
+	}
+
+	r.Status, _ = resultData["category"].(string)
+	if r.Status == "" {
+		r.Status = "failed"
+		if success {
+			r.Status = "passed"
+		}
+	}
+	switch r.Status {
+	case "passed":
+	case "visual_diff":
+		r.Error = "screenshots differ from the approved baselines"
+	case "degraded":
+		r.Error = "pages exceeded the performance budget"
+	default:
+		output, _ := resultData["output"].(string)
+		r.Error = playwrightFailureMessage(output)
+		if r.Error == "" {
+			r.Error, _ = resultData["errors"].(string)
+		}
+	}
+	return r
+}
+
+// ciFetchScript fetches a script's name and code.
+func ciFetchScript(client *http.Client, apiURL, token string, scriptID int) (string, string, error) {
+	url := fmt.Sprintf("%s/api/automation/scripts/%d", apiURL, scriptID)
+	body, err := ciAuthGet(client, url, token)
+	if err != nil {
+		return "", "", err
+	}
+
+	var data struct {
+		Name string `json:"name"`
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return "", "", fmt.Errorf("parse script: %w", err)
+	}
+	if data.Code == "" {
+		return "", "", fmt.Errorf("script #%d has no code", scriptID)
+	}
+	return data.Name, data.Code, nil
+}
+
+// errCILocalResultUnsupported means the server has no endpoint for local
+// results.
+var errCILocalResultUnsupported = errors.New("local results endpoint not available")
+
+// ciReportLocalResult records a local run in the cloud and returns the
+// execution ID the server assigned to it. It returns
+// errCILocalResultUnsupported when the server does not have the endpoint.
+func ciReportLocalResult(client *http.Client, apiURL, token string, r ciTestResult, opts ciLocalOptions, resultData map[string]interface{}) (string, error) {
+	url := fmt.Sprintf("%s/api/playwright-execution/local-result", apiURL)
+
+	payload := map[string]interface{}{
+		"script_id":      r.ScriptID,
+		"project_id":     opts.ProjectID,
+		"success":        r.Status == "passed",
+		"status":         r.Status,
+		"error_message":  r.Error,
+		"execution_time": r.Duration,
+		"browser":        opts.Browser,
+		"headless":       opts.Headless,
+		"source":         "ci_local",
+	}
+	if opts.BaseURL != "" {
+		payload["base_url"] = opts.BaseURL
+	}
+	for _, k := range []string{"output", "errors", "artifacts", "category", "visual", "perf"} {
+		if v, ok := resultData[k]; ok {
+			payload[k] = v
+		}
+	}
+
+	body, err := ciAuthPost(client, url, token, payload)
+	if err != nil {
+		var httpErr *ciHTTPError
+		if errors.As(err, &httpErr) {
+			switch httpErr.StatusCode {
+			case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+				return "", errCILocalResultUnsupported
+			}
+		}
+		return "", err
+	}
+	var resp struct {
+		ExecutionID string `json:"execution_id"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", fmt.Errorf("parse response: %w", err)
+	}
+	return resp.ExecutionID, nil
+}
+
+var ansiEscapeRe = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// playwrightFailureMessage returns the first error message in the output of
+// Playwright's JSON reporter, or "" if there is none.
+func playwrightFailureMessage(output string) string {
+	start := strings.Index(output, "{")
+	if start < 0 {
+		return ""
+	}
+	var report struct {
+		Suites []playwrightSuite `json:"suites"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal([]byte(output[start:]), &report); err != nil {
+		return ""
+	}
+	for _, suite := range report.Suites {
+		if msg := suite.failureMessage(); msg != "" {
+			return msg
+		}
+	}
+	for _, e := range report.Errors {
+		if e.Message != "" {
+			return ansiEscapeRe.ReplaceAllString(e.Message, "")
+		}
+	}
+	return ""
+}
+
+// playwrightSuite is the part of a JSON reporter suite needed to find errors.
+type playwrightSuite struct {
+	Suites []playwrightSuite `json:"suites"`
+	Specs  []struct {
+		Tests []struct {
+			Results []struct {
+				Status string `json:"status"`
+				Error  *struct {
+					Message string `json:"message"`
+				} `json:"error"`
+			} `json:"results"`
+		} `json:"tests"`
+	} `json:"specs"`
+}
+
+func (s playwrightSuite) failureMessage() string {
+	for _, spec := range s.Specs {
+		for _, test := range spec.Tests {
+			for _, result := range test.Results {
+				if result.Status != "passed" && result.Status != "skipped" && result.Error != nil && result.Error.Message != "" {
+					return ansiEscapeRe.ReplaceAllString(result.Error.Message, "")
+				}
+			}
+		}
+	}
+	for _, child := range s.Suites {
+		if msg := child.failureMessage(); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
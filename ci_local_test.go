@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testPlaywrightReport = `{
+  "config": {},
+  "suites": [{
+    "title": "test.spec.js",
+    "specs": [],
+    "suites": [{
+      "title": "checkout",
+      "specs": [{
+        "title": "pays",
+        "ok": false,
+        "tests": [{"results": [
+          {"status": "failed", "error": {"message": "\u001b[31mError: expect(locator).toBeVisible() failed\u001b[39m\n\nLocator: #pay"}}
+        ]}]
+      }]
+    }]
+  }],
+  "errors": []
+}`
+
+func TestPlaywrightFailureMessage(t *testing.T) {
+	got := playwrightFailureMessage("Running 1 test\n" + testPlaywrightReport)
+	if got != "Error: expect(locator).toBeVisible() failed\n\nLocator: #pay" {
+		t.Errorf("unexpected message %q", got)
+	}
+	if got := playwrightFailureMessage(`{"suites": [], "errors": [{"message": "Error: No tests found"}]}`); got != "Error: No tests found" {
+		t.Errorf("top-level errors should be used: %q", got)
+	}
+	if playwrightFailureMessage("not json") != "" || playwrightFailureMessage(`{"suites": []}`) != "" {
+		t.Error("expected no message")
+	}
+}
+
+func TestCILocalResult(t *testing.T) {
+	r := ciLocalResult(1, "Login", false, "", map[string]interface{}{"category": "failed", "output": testPlaywrightReport, "errors": "stderr"}, 2.5)
+	if r.Status != "failed" || !strings.HasPrefix(r.Error, "Error: expect(locator)") || r.Duration != 2.5 || r.ScriptName != "Login" {
+		t.Errorf("unexpected failed result: %+v", r)
+	}
+	r = ciLocalResult(1, "Login", false, "", map[string]interface{}{"category": "failed", "output": "", "errors": "npx: command not found"}, 0)
+	if r.Error != "npx: command not found" {
+		t.Errorf("stderr should be the fallback error: %+v", r)
+	}
+	if r := ciLocalResult(1, "", true, "", map[string]interface{}{"category": "passed"}, 1); r.Status != "passed" || r.Error != "" {
+		t.Errorf("unexpected passed result: %+v", r)
+	}
+	if r := ciLocalResult(1, "", true, "", map[string]interface{}{"category": "degraded"}, 1); r.Status != "degraded" || r.Error == "" {
+		t.Errorf("unexpected degraded result: %+v", r)
+	}
+	if r := ciLocalResult(1, "", false, "Dependency installation failed: exit 1", nil, 1); r.Status != "error" || r.Error != "Dependency installation failed: exit 1" {
+		t.Errorf("a test that could not run is an error: %+v", r)
+	}
+}
+
+func TestCIRunLocal(t *testing.T) {
+	var reported []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/automation/scripts/1":
+			fmt.Fprint(w, `{"id": 1, "name": "Login", "code": "test('login', async () => {});"}`)
+		case "/api/automation/scripts/2":
+			fmt.Fprint(w, `{"id": 2, "name": "Checkout", "code": "test('checkout', async () => {});"}`)
+		case "/api/automation/scripts/3":
+			fmt.Fprint(w, `{"id": 3, "name": "Empty", "code": ""}`)
+		case "/api/playwright-execution/local-result":
+			var payload map[string]interface{}
+			_ = json.NewDecoder(r.Body).Decode(&payload)
+			reported = append(reported, payload)
+			fmt.Fprintf(w, `{"execution_id": "local-%v"}`, payload["script_id"])
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	var assignments []Assignment
+	run := func(ctx context.Context, a Assignment) (bool, string, map[string]interface{}) {
+		assignments = append(assignments, a)
+		if a.ScriptID == "2" {
+			return false, "", map[string]interface{}{"category": "failed", "output": testPlaywrightReport}
+		}
+		return true, "", map[string]interface{}{"category": "passed", "output": "{}"}
+	}
+
+	opts := ciLocalOptions{ProjectID: 42, BaseURL: "http://localhost:3000", Headless: true, Browser: "chromium", ReportToCloud: true}
+	results := ciRunLocal(context.Background(), server.Client(), server.URL, "tok", []int{1, 2, 3}, &opts, run)
+
+	if len(assignments) != 2 || assignments[0].CustomURL != "http://localhost:3000" || !strings.Contains(assignments[0].Code, "login") ||
+		assignments[0].ProjectID != "42" || assignments[1].ID != "ci-2" {
+		t.Errorf("unexpected assignments: %+v", assignments)
+	}
+	if results[0].Status != "passed" || results[0].ScriptName != "Login" || results[0].ExecutionID != "local-1" {
+		t.Errorf("unexpected result 0: %+v", results[0])
+	}
+	if results[1].Status != "failed" || !strings.Contains(results[1].Error, "toBeVisible") || results[1].ExecutionID != "local-2" {
+		t.Errorf("unexpected result 1: %+v", results[1])
+	}
+	if results[2].Status != "error" || !strings.Contains(results[2].Error, "has no code") {
+		t.Errorf("unexpected result 2: %+v", results[2])
+	}
+	if len(reported) != 2 || reported[1]["status"] != "failed" || reported[1]["source"] != "ci_local" || reported[1]["base_url"] != "http://localhost:3000" {
+		t.Errorf("unexpected reports: %v", reported)
+	}
+}
+
+func TestCIRunLocal_ReportUnsupported(t *testing.T) {
+	reports := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/playwright-execution/local-result" {
+			reports++
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"name": "Login", "code": "test('login', async () => {});"}`)
+	}))
+	defer server.Close()
+
+	opts := &ciLocalOptions{ReportToCloud: true}
+	results := ciRunLocal(context.Background(), server.Client(), server.URL, "tok", []int{1, 2}, opts, func(context.Context, Assignment) (bool, string, map[string]interface{}) {
+		return true, "", map[string]interface{}{"category": "passed"}
+	})
+	if reports != 1 || opts.ReportToCloud {
+		t.Errorf("expected one report attempt and reporting switched off: %d attempts, ReportToCloud=%v", reports, opts.ReportToCloud)
+	}
+	if results[0].Status != "passed" || results[1].Status != "passed" {
+		t.Errorf("an unsupported report must not change results: %+v", results)
+	}
+}
+
+func TestCIRunLocal_Deadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	results := ciRunLocal(ctx, http.DefaultClient, "http://127.0.0.1:0", "tok", []int{1, 2}, &ciLocalOptions{}, func(context.Context, Assignment) (bool, string, map[string]interface{}) {
+		calls++
+		return true, "", nil
+	})
+	if calls != 0 || results[0].Status != "error" || !strings.Contains(results[1].Error, "timeout") {
+		t.Errorf("expired context should skip all scripts: %d calls, %+v", calls, results)
+	}
+}
+
+func TestAgentRunTest_NoCode(t *testing.T) {
+	a := &Agent{CloudURL: "http://127.0.0.1:0", client: http.DefaultClient}
+	success, message, data := a.runTest(context.Background(), Assignment{ID: "ci-1"})
+	if success || message != "No test code provided" || data != nil {
+		t.Errorf("unexpected result: %v %q %v", success, message, data)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// cmdCIMerge combines the JSON reports of sharded `qmax ci run` jobs into
+// one report, publishes it like a single run and exits non-zero if any
+// shard had a failing test, or with --fail-on new-failures, a new failure.
+func cmdCIMerge(args []string) {
+	fs := flag.NewFlagSet("ci merge", flag.ExitOnError)
+	format := fs.String("format", "markdown", "Output format: markdown, json, junit, tap or sarif-like")
+	var reportFileArgs stringListFlag
+	fs.Var(&reportFileArgs, "report-file", "Write a report to a file: format=path or a .md/.json/.xml/.tap/.sarif path (repeatable)")
+	failOn := fs.String("fail-on", "any", "When to fail the build: any or new-failures (as compared by the shards' --compare-to)")
+	githubFlags := registerCIGitHubFlags(fs)
+	ciProviderName := fs.String("ci-provider", "auto", "CI system to publish results to: auto, github, gitlab, azure, buildkite, circleci or none")
+	_ = fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Error: give the JSON reports of the shards to merge")
+		fmt.Fprintln(os.Stderr, "Usage: qmax ci merge [--format markdown] [--report-file path] shard-1.json shard-2.json ...")
+		os.Exit(1)
+	}
+	if !isCIReportFormat(*format) {
+		fmt.Fprintf(os.Stderr, "Error: unknown --format %q (use %s)\n", *format, strings.Join(ciReportFormats, ", "))
+		os.Exit(1)
+	}
+	if *failOn != "any" && *failOn != "new-failures" {
+		fmt.Fprintf(os.Stderr, "Error: unknown --fail-on %q (use %s)\n", *failOn, strings.Join(ciFailOnModes, ", "))
+		os.Exit(1)
+	}
+	var reportFiles []ciReportFile
+	for _, v := range reportFileArgs {
+		rf, err := parseCIReportFile(v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		reportFiles = append(reportFiles, rf)
+	}
+	provider, err := newCIProvider(*ciProviderName, os.Getenv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	prPublisher, err := githubFlags.publisher(os.Getenv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var reports []*ciJSONReport
+	for _, path := range fs.Args() {
+		report, err := readCIJSONReport(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading shard report: %v\n", err)
+			os.Exit(1)
+		}
+		reports = append(reports, report)
+	}
+	merged, err := mergeCIReports(reports)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Merged %d result(s) from %d shard report(s)\n", len(merged.Results), len(reports))
+
+	if err := ciWriteReport(os.Stdout, *format, merged.Results, merged.ProjectID, merged.Duration); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing %s report: %v\n", *format, err)
+	}
+	for _, rf := range reportFiles {
+		if err := ciWriteReportFile(rf, merged.Results, merged.ProjectID, merged.Duration); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing %s report to %s: %v\n", rf.Format, rf.Path, err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Wrote %s report to %s\n", rf.Format, rf.Path)
+	}
+
+	summary := ciRunSummary{ProjectID: merged.ProjectID, Results: merged.Results, TotalDuration: merged.Duration}
+	if provider != nil {
+		if err := provider.Publish(summary); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not publish results to %s: %v\n", provider.Name(), err)
+		}
+	}
+
+	failsBuild := ciFailsBuild(merged.Results, *failOn)
+	if prPublisher != nil {
+		if err := prPublisher.Publish(summary, failsBuild); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not post results to the pull request: %v\n", err)
+		}
+	}
+	if failsBuild {
+		os.Exit(1)
+	}
+}
+
+// mergeCIReports combines shard reports of one project. Shards run side by
+// side, so the merged duration is that of the slowest shard. A script that
+// appears in more than one report keeps its place from the first and its
+// result from the last.
+func mergeCIReports(reports []*ciJSONReport) (*ciJSONReport, error) {
+	merged := &ciJSONReport{Results: []ciTestResult{}}
+	index := make(map[int]int)
+	for i, report := range reports {
+		if i == 0 {
+			merged.ProjectID = report.ProjectID
+		} else if report.ProjectID != merged.ProjectID {
+			return nil, fmt.Errorf("shard reports are for different projects (#%d and #%d)", merged.ProjectID, report.ProjectID)
+		}
+		if report.Duration > merged.Duration {
+			merged.Duration = report.Duration
+		}
+		for _, r := range report.Results {
+			if j, ok := index[r.ScriptID]; ok {
+				merged.Results[j] = r
+				continue
+			}
+			index[r.ScriptID] = len(merged.Results)
+			merged.Results = append(merged.Results, r)
+		}
+	}
+	return merged, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMergeCIReports(t *testing.T) {
+	results := testCIResults()
+	dir := t.TempDir()
+	shards := [][]ciTestResult{results[:2], results[2:]}
+	var reports []*ciJSONReport
+	for i, shard := range shards {
+		var buf bytes.Buffer
+		ciOutputJSON(&buf, shard, 42, float64(10*(i+1)))
+		path := filepath.Join(dir, "shard.json")
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		report, err := readCIJSONReport(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reports = append(reports, report)
+	}
+
+	merged, err := mergeCIReports(reports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.ProjectID != 42 || merged.Duration != 20 || len(merged.Results) != 3 {
+		t.Fatalf("merged = %+v", merged)
+	}
+	for i, r := range merged.Results {
+		if !reflect.DeepEqual(r, results[i]) {
+			t.Errorf("result %d = %+v, want %+v", i, r, results[i])
+		}
+	}
+}
+
+func TestMergeCIReports_LaterResultWins(t *testing.T) {
+	reports := []*ciJSONReport{
+		{ProjectID: 42, Results: []ciTestResult{{ScriptID: 1, Status: "failed"}, {ScriptID: 2, Status: "passed"}}},
+		{ProjectID: 42, Results: []ciTestResult{{ScriptID: 1, Status: "passed"}}},
+	}
+	merged, err := mergeCIReports(reports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged.Results) != 2 || merged.Results[0].ScriptID != 1 || merged.Results[0].Status != "passed" {
+		t.Errorf("merged = %+v", merged.Results)
+	}
+}
+
+func TestMergeCIReports_DifferentProjects(t *testing.T) {
+	reports := []*ciJSONReport{{ProjectID: 1}, {ProjectID: 2}}
+	if _, err := mergeCIReports(reports); err == nil {
+		t.Error("expected error for reports of different projects")
+	}
+}
+
+func TestReadCIJSONReport_NotJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "summary.md")
+	if err := os.WriteFile(path, []byte("## QualityMax Test Results\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readCIJSONReport(path); err == nil {
+		t.Error("expected error for a markdown report")
+	}
+}
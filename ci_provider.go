@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Files written by the providers that hand reports to the CI system, relative
+// to the working directory. Pipelines point their artifact settings at them.
+const (
+	ciGitLabJUnitFile    = "qmax-junit.xml"
+	ciGitLabDotenvFile   = "qmax.env"
+	ciAzureJUnitFile     = "qmax-junit.xml"
+	ciAzureSummaryFile   = "qmax-summary.md"
+	ciCircleCIResultsDir = "qmax-test-results"
+)
+
+// ciRunSummary is what providers publish about a finished `qmax ci run`.
+type ciRunSummary struct {
+	ProjectID     int
+	Results       []ciTestResult
+	TotalDuration float64
+}
+
+func (s ciRunSummary) counts() ciCounts {
+	return countCIResults(s.Results)
+}
+
+func (s ciRunSummary) status() string {
+	return s.counts().status()
+}
+
+// ciProvider publishes run results in the form a CI system understands.
+type ciProvider interface {
+	Name() string
+	Publish(s ciRunSummary) error
+}
+
+// ciProviderNames are the values accepted by --ci-provider.
+var ciProviderNames = []string{"auto", "github", "gitlab", "azure", "buildkite", "circleci", "none"}
+
+// newCIProvider returns the named provider, or detects one from the
+// environment for "auto". It returns nil for "none" and when no CI system
+// is detected.
+func newCIProvider(name string, getenv func(string) string) (ciProvider, error) {
+	if name == "auto" || name == "" {
+		name = detectCIProvider(getenv)
+	}
+	switch name {
+	case "github":
+		return &githubCIProvider{getenv: getenv}, nil
+	case "gitlab":
+		return &gitlabCIProvider{dir: "."}, nil
+	case "azure":
+		return &azureCIProvider{dir: ".", out: os.Stderr}, nil
+	case "buildkite":
+		return &buildkiteCIProvider{run: runBuildkiteAgent}, nil
+	case "circleci":
+		return &circleCIProvider{dir: "."}, nil
+	case "none":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown CI provider %q (use %s)", name, strings.Join(ciProviderNames, ", "))
+}
+
+// detectCIProvider names the CI system from the variables it sets in every
+// job, or returns "none".
+func detectCIProvider(getenv func(string) string) string {
+	switch {
+	case getenv("GITHUB_ACTIONS") == "true", getenv("GITHUB_OUTPUT") != "":
+		return "github"
+	case getenv("GITLAB_CI") == "true":
+		return "gitlab"
+	case strings.EqualFold(getenv("TF_BUILD"), "true"):
+		return "azure"
+	case getenv("BUILDKITE") == "true":
+		return "buildkite"
+	case getenv("CIRCLECI") == "true":
+		return "circleci"
+	}
+	return "none"
+}
+
+// writeCIReportTo writes a report in the given format to path, creating
+// parent directories.
+func writeCIReportTo(path, format string, s ciRunSummary) error {
+	return ciWriteReportFile(ciReportFile{Format: format, Path: path}, s.Results, s.ProjectID, s.TotalDuration)
+}
+
+// --- GitHub Actions ---
+
+// githubCIProvider writes step outputs and the job summary.
+type githubCIProvider struct {
+	getenv func(string) string
+}
+
+func (p *githubCIProvider) Name() string { return "GitHub Actions" }
+
+func (p *githubCIProvider) Publish(s ciRunSummary) error {
+	ciWriteGitHubOutputs(p.getenv, s.Results, s.ProjectID, s.TotalDuration)
+	return nil
+}
+
+// --- GitLab CI ---
+
+// gitlabCIProvider writes a JUnit report for artifacts:reports:junit and a
+// dotenv file for artifacts:reports:dotenv.
+type gitlabCIProvider struct {
+	dir string
+}
+
+func (p *gitlabCIProvider) Name() string { return "GitLab CI" }
+
+func (p *gitlabCIProvider) Publish(s ciRunSummary) error {
+	if err := writeCIReportTo(filepath.Join(p.dir, ciGitLabJUnitFile), "junit", s); err != nil {
+		return err
+	}
+	c := s.counts()
+	env := fmt.Sprintf("QMAX_STATUS=%s\nQMAX_TOTAL=%d\nQMAX_PASSED=%d\nQMAX_FAILED=%d\nQMAX_FLAKY=%d\nQMAX_QUARANTINED=%d\nQMAX_DURATION=%.1f\n",
+		c.status(), len(s.Results), c.Passed, c.Failed, c.Flaky, c.Quarantined, s.TotalDuration)
+	return os.WriteFile(filepath.Join(p.dir, ciGitLabDotenvFile), []byte(env), 0644)
+}
+
+// --- Azure Pipelines ---
+
+// azureCIProvider emits ##vso logging commands: output variables, an issue
+// per failed test, the markdown summary and a JUnit test run. The commands
+// go to stderr so stdout stays a clean report.
+type azureCIProvider struct {
+	dir string
+	out io.Writer
+}
+
+func (p *azureCIProvider) Name() string { return "Azure Pipelines" }
+
+func (p *azureCIProvider) Publish(s ciRunSummary) error {
+	c := s.counts()
+	for _, v := range []struct{ name, value string }{
+		{"status", c.status()},
+		{"total", fmt.Sprintf("%d", len(s.Results))},
+		{"passed", fmt.Sprintf("%d", c.Passed)},
+		{"failed", fmt.Sprintf("%d", c.Failed)},
+		{"flaky", fmt.Sprintf("%d", c.Flaky)},
+		{"quarantined", fmt.Sprintf("%d", c.Quarantined)},
+		{"duration", fmt.Sprintf("%.1f", s.TotalDuration)},
+	} {
+		fmt.Fprintf(p.out, "##vso[task.setvariable variable=qmax_%s;isOutput=true]%s\n", v.name, v.value)
+	}
+	for _, r := range s.Results {
+		if r.Status == "passed" {
+			continue
+		}
+		issue := "error"
+		if r.Quarantined || r.Status == "visual_diff" || r.Status == "degraded" {
+			issue = "warning"
+		}
+		fmt.Fprintf(p.out, "##vso[task.logissue type=%s]%s\n", issue, azureEscape(ciTestName(r)+": "+ciFailureSummary(r)))
+	}
+
+	summaryPath, err := filepath.Abs(filepath.Join(p.dir, ciAzureSummaryFile))
+	if err != nil {
+		return err
+	}
+	if err := writeCIReportTo(summaryPath, "markdown", s); err != nil {
+		return err
+	}
+	fmt.Fprintf(p.out, "##vso[task.uploadsummary]%s\n", summaryPath)
+
+	junitPath, err := filepath.Abs(filepath.Join(p.dir, ciAzureJUnitFile))
+	if err != nil {
+		return err
+	}
+	if err := writeCIReportTo(junitPath, "junit", s); err != nil {
+		return err
+	}
+	fmt.Fprintf(p.out, "##vso[results.publish type=JUnit;runTitle=QualityMax project #%d;resultFiles=%s]\n", s.ProjectID, junitPath)
+	return nil
+}
+
+// azureEscape escapes a logging command message so it stays on one line.
+func azureEscape(s string) string {
+	return strings.NewReplacer("%", "%AZP25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// --- Buildkite ---
+
+// buildkiteCIProvider adds the markdown summary as a build annotation and
+// stores the status as build meta-data.
+type buildkiteCIProvider struct {
+	run func(stdin string, args ...string) error
+}
+
+func (p *buildkiteCIProvider) Name() string { return "Buildkite" }
+
+func (p *buildkiteCIProvider) Publish(s ciRunSummary) error {
+	var md bytes.Buffer
+	ciOutputMarkdown(&md, s.Results, s.ProjectID, s.TotalDuration)
+	style := "success"
+	if s.status() != "passed" {
+		style = "error"
+	}
+	if err := p.run(md.String(), "annotate", "--style", style, "--context", fmt.Sprintf("qmax-project-%d", s.ProjectID)); err != nil {
+		return err
+	}
+	return p.run("", "meta-data", "set", "qmax-status", s.status())
+}
+
+func runBuildkiteAgent(stdin string, args ...string) error {
+	cmd := exec.Command("buildkite-agent", args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("buildkite-agent %s: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// --- CircleCI ---
+
+// circleCIProvider writes a JUnit report into a directory for
+// store_test_results, which feeds CircleCI's test metadata and insights.
+type circleCIProvider struct {
+	dir string
+}
+
+func (p *circleCIProvider) Name() string { return "CircleCI" }
+
+func (p *circleCIProvider) Publish(s ciRunSummary) error {
+	return writeCIReportTo(filepath.Join(p.dir, ciCircleCIResultsDir, "qmax", "results.xml"), "junit", s)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func envMap(m map[string]string) func(string) string {
+	return func(k string) string { return m[k] }
+}
+
+func testCIRunSummary() ciRunSummary {
+	return ciRunSummary{ProjectID: 42, Results: testCIResults(), TotalDuration: 12.5}
+}
+
+func TestDetectCIProvider(t *testing.T) {
+	tests := []struct {
+		env  map[string]string
+		want string
+	}{
+		{map[string]string{"GITHUB_ACTIONS": "true"}, "github"},
+		{map[string]string{"GITHUB_OUTPUT": "/tmp/out"}, "github"},
+		{map[string]string{"GITLAB_CI": "true", "CI": "true"}, "gitlab"},
+		{map[string]string{"TF_BUILD": "True"}, "azure"},
+		{map[string]string{"BUILDKITE": "true"}, "buildkite"},
+		{map[string]string{"CIRCLECI": "true"}, "circleci"},
+		{map[string]string{"CI": "true"}, "none"},
+	}
+	for _, tt := range tests {
+		if got := detectCIProvider(envMap(tt.env)); got != tt.want {
+			t.Errorf("detectCIProvider(%v) = %s, want %s", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestNewCIProvider(t *testing.T) {
+	p, err := newCIProvider("auto", envMap(map[string]string{"GITLAB_CI": "true"}))
+	if err != nil || p == nil || p.Name() != "GitLab CI" {
+		t.Errorf("unexpected provider %v, %v", p, err)
+	}
+	if p, err := newCIProvider("auto", envMap(nil)); p != nil || err != nil {
+		t.Errorf("no CI environment should mean no provider: %v, %v", p, err)
+	}
+	if p, err := newCIProvider("none", envMap(map[string]string{"GITHUB_ACTIONS": "true"})); p != nil || err != nil {
+		t.Errorf("none should disable publishing: %v, %v", p, err)
+	}
+	if p, _ := newCIProvider("circleci", envMap(nil)); p == nil || p.Name() != "CircleCI" {
+		t.Errorf("explicit provider should not need detection: %v", p)
+	}
+	if _, err := newCIProvider("jenkins", envMap(nil)); err == nil {
+		t.Error("expected error for unknown provider")
+	}
+}
+
+func TestGitHubCIProvider(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "output")
+	summary := filepath.Join(dir, "summary.md")
+	p := &githubCIProvider{getenv: envMap(map[string]string{"GITHUB_OUTPUT": output, "GITHUB_STEP_SUMMARY": summary})}
+	if err := p.Publish(testCIRunSummary()); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(output); !strings.Contains(string(data), "status=failed\ntotal=3\npassed=1\nfailed=2\n") {
+		t.Errorf("unexpected outputs:\n%s", data)
+	}
+	if data, _ := os.ReadFile(summary); !strings.Contains(string(data), "| Checkout |") {
+		t.Errorf("unexpected step summary:\n%s", data)
+	}
+}
+
+func TestGitLabCIProvider(t *testing.T) {
+	dir := t.TempDir()
+	if err := (&gitlabCIProvider{dir: dir}).Publish(testCIRunSummary()); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, ciGitLabJUnitFile)); err != nil || !strings.Contains(string(data), `<testsuites name="QualityMax" tests="3" failures="1" errors="1"`) {
+		t.Errorf("unexpected JUnit report: %v\n%s", err, data)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ciGitLabDotenvFile))
+	if err != nil || string(data) != "QMAX_STATUS=failed\nQMAX_TOTAL=3\nQMAX_PASSED=1\nQMAX_FAILED=2\nQMAX_FLAKY=0\nQMAX_QUARANTINED=0\nQMAX_DURATION=12.5\n" {
+		t.Errorf("unexpected dotenv: %v\n%s", err, data)
+	}
+}
+
+func TestAzureCIProvider(t *testing.T) {
+	dir := t.TempDir()
+	var out bytes.Buffer
+	if err := (&azureCIProvider{dir: dir, out: &out}).Publish(testCIRunSummary()); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	for _, want := range []string{
+		"##vso[task.setvariable variable=qmax_status;isOutput=true]failed\n",
+		"##vso[task.setvariable variable=qmax_passed;isOutput=true]1\n",
+		"##vso[task.logissue type=error]Checkout: expect(locator).toBeVisible() failed\n",
+		"##vso[task.uploadsummary]" + filepath.Join(dir, ciAzureSummaryFile) + "\n",
+		"##vso[results.publish type=JUnit;runTitle=QualityMax project #42;resultFiles=" + filepath.Join(dir, ciAzureJUnitFile) + "]\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+	for _, f := range []string{ciAzureSummaryFile, ciAzureJUnitFile} {
+		if _, err := os.Stat(filepath.Join(dir, f)); err != nil {
+			t.Errorf("%s not written: %v", f, err)
+		}
+	}
+	if got := azureEscape("50% done\r\nnext"); got != "50%AZP25 done%0D%0Anext" {
+		t.Errorf("azureEscape = %q", got)
+	}
+}
+
+func TestBuildkiteCIProvider(t *testing.T) {
+	var calls [][]string
+	var annotation string
+	p := &buildkiteCIProvider{run: func(stdin string, args ...string) error {
+		calls = append(calls, args)
+		if args[0] == "annotate" {
+			annotation = stdin
+		}
+		return nil
+	}}
+	if err := p.Publish(testCIRunSummary()); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 2 || strings.Join(calls[0], " ") != "annotate --style error --context qmax-project-42" ||
+		strings.Join(calls[1], " ") != "meta-data set qmax-status failed" {
+		t.Errorf("unexpected buildkite-agent calls: %v", calls)
+	}
+	if !strings.Contains(annotation, "QualityMax Test Results") {
+		t.Errorf("annotation should be the markdown summary:\n%s", annotation)
+	}
+}
+
+func TestCircleCIProvider(t *testing.T) {
+	dir := t.TempDir()
+	if err := (&circleCIProvider{dir: dir}).Publish(testCIRunSummary()); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, ciCircleCIResultsDir, "qmax", "results.xml")); err != nil || !strings.Contains(string(data), "<testcase") {
+		t.Errorf("unexpected test results: %v", err)
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Report formats for `qmax ci run --format` and --report-file.
+var ciReportFormats = []string{"markdown", "json", "junit", "tap", "sarif-like"}
+
+// ciReportFile is a --report-file target.
+type ciReportFile struct {
+	Format string
+	Path   string
+}
+
+// parseCIReportFile parses a --report-file value: either format=path, or a
+// path whose extension names the format (.md, .json, .xml, .tap, .sarif).
+func parseCIReportFile(v string) (ciReportFile, error) {
+	if i := strings.Index(v, "="); i > 0 && isCIReportFormat(v[:i]) {
+		if v[i+1:] == "" {
+			return ciReportFile{}, fmt.Errorf("--report-file %q: missing path", v)
+		}
+		return ciReportFile{Format: v[:i], Path: v[i+1:]}, nil
+	}
+	var format string
+	switch strings.ToLower(filepath.Ext(v)) {
+	case ".md", ".markdown":
+		format = "markdown"
+	case ".json":
+		format = "json"
+	case ".xml":
+		format = "junit"
+	case ".tap":
+		format = "tap"
+	case ".sarif":
+		format = "sarif-like"
+	default:
+		return ciReportFile{}, fmt.Errorf("--report-file %q: cannot tell the format from the extension, use format=path (%s)", v, strings.Join(ciReportFormats, ", "))
+	}
+	return ciReportFile{Format: format, Path: v}, nil
+}
+
+func isCIReportFormat(format string) bool {
+	for _, f := range ciReportFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// ciWriteReport renders the results in the given format.
+func ciWriteReport(w io.Writer, format string, results []ciTestResult, projectID int, totalDuration float64) error {
+	switch format {
+	case "markdown":
+		ciOutputMarkdown(w, results, projectID, totalDuration)
+		return nil
+	case "json":
+		ciOutputJSON(w, results, projectID, totalDuration)
+		return nil
+	case "junit":
+		return ciOutputJUnit(w, results, projectID, totalDuration)
+	case "tap":
+		ciOutputTAP(w, results)
+		return nil
+	case "sarif-like":
+		return ciOutputSARIF(w, results, projectID)
+	}
+	return fmt.Errorf("unknown format %q (use %s)", format, strings.Join(ciReportFormats, ", "))
+}
+
+// ciWriteReportFile writes one --report-file target.
+func ciWriteReportFile(rf ciReportFile, results []ciTestResult, projectID int, totalDuration float64) error {
+	if dir := filepath.Dir(rf.Path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	f, err := os.Create(rf.Path)
+	if err != nil {
+		return err
+	}
+	if err := ciWriteReport(f, rf.Format, results, projectID, totalDuration); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ciTestName is the display name of a result.
+func ciTestName(r ciTestResult) string {
+	if r.ScriptName != "" {
+		return r.ScriptName
+	}
+	return fmt.Sprintf("Script #%d", r.ScriptID)
+}
+
+// ciFailureSummary returns the first line of a result's error.
+func ciFailureSummary(r ciTestResult) string {
+	msg := strings.TrimSpace(r.Error)
+	if msg == "" {
+		return "Unknown error"
+	}
+	if i := strings.IndexByte(msg, '\n'); i >= 0 {
+		msg = msg[:i]
+	}
+	return truncate(msg, 200)
+}
+
+// --- JUnit XML ---
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name       string          `xml:"name,attr"`
+	ClassName  string          `xml:"classname,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Failure    *junitFailure   `xml:"failure,omitempty"`
+	Error      *junitFailure   `xml:"error,omitempty"`
+	Skipped    *junitSkipped   `xml:"skipped,omitempty"`
+	SystemOut  string          `xml:"system-out,omitempty"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// ciOutputJUnit writes the results as a JUnit XML report with one test suite
+// for the project. Infrastructure errors (a run that never started or timed
+// out) are <error>, everything else that did not pass is <failure>, except
+// quarantined failures, which are <skipped> so they do not fail the build.
+// Saved artifacts are listed in <system-out> as [[ATTACHMENT|path]] lines,
+// which Jenkins and GitLab show with the test.
+func ciOutputJUnit(w io.Writer, results []ciTestResult, projectID int, totalDuration float64) error {
+	suite := junitTestSuite{
+		Name:  fmt.Sprintf("QualityMax project #%d", projectID),
+		Tests: len(results),
+		Time:  fmt.Sprintf("%.3f", totalDuration),
+	}
+	for _, r := range results {
+		tc := junitTestCase{
+			Name:      ciTestName(r),
+			ClassName: fmt.Sprintf("qmax.project%d", projectID),
+			Time:      fmt.Sprintf("%.3f", r.Duration),
+			Properties: []junitProperty{
+				{Name: "script_id", Value: fmt.Sprintf("%d", r.ScriptID)},
+			},
+		}
+		if r.ExecutionID != "" {
+			tc.Properties = append(tc.Properties, junitProperty{Name: "execution_id", Value: r.ExecutionID})
+		}
+		if r.Attempts > 1 {
+			tc.Properties = append(tc.Properties, junitProperty{Name: "attempts", Value: fmt.Sprintf("%d", r.Attempts)})
+		}
+		if r.Flaky {
+			tc.Properties = append(tc.Properties, junitProperty{Name: "flaky", Value: "true"})
+		}
+		for _, p := range r.Artifacts {
+			tc.SystemOut += "[[ATTACHMENT|" + p + "]]\n"
+		}
+		if r.Quarantined {
+			tc.Skipped = &junitSkipped{Message: "quarantined: " + ciFailureSummary(r)}
+			suite.Skipped++
+		} else if r.Status != "passed" {
+			f := &junitFailure{Message: ciFailureSummary(r), Type: r.Status, Text: r.Error}
+			if r.Status == "error" {
+				tc.Error = f
+				suite.Errors++
+			} else {
+				tc.Failure = f
+				suite.Failures++
+			}
+		}
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+
+	doc := junitTestSuites{
+		Name:     "QualityMax",
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Errors:   suite.Errors,
+		Skipped:  suite.Skipped,
+		Time:     suite.Time,
+		Suites:   []junitTestSuite{suite},
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// --- TAP ---
+
+// ciOutputTAP writes the results as TAP version 13, with a YAML block of
+// details for every test that did not pass. Quarantined failures carry a
+// TODO directive, so TAP consumers do not count them as failures.
+func ciOutputTAP(w io.Writer, results []ciTestResult) {
+	fmt.Fprintln(w, "TAP version 13")
+	fmt.Fprintf(w, "1..%d\n", len(results))
+	for i, r := range results {
+		// An unescaped '#' starts a TAP directive
+		name := strings.ReplaceAll(ciTestName(r), "#", `\#`)
+		if r.Status == "passed" {
+			fmt.Fprintf(w, "ok %d - %s\n", i+1, name)
+			if r.Flaky {
+				fmt.Fprintf(w, "# flaky: passed on attempt %d\n", r.Attempts)
+			}
+			continue
+		}
+		directive := ""
+		if r.Quarantined {
+			directive = " # TODO quarantined"
+		}
+		fmt.Fprintf(w, "not ok %d - %s%s\n", i+1, name, directive)
+		fmt.Fprintln(w, "  ---")
+		fmt.Fprintf(w, "  status: %s\n", r.Status)
+		fmt.Fprintf(w, "  script_id: %d\n", r.ScriptID)
+		if r.Attempts > 1 {
+			fmt.Fprintf(w, "  attempts: %d\n", r.Attempts)
+		}
+		if r.ExecutionID != "" {
+			fmt.Fprintf(w, "  execution_id: %s\n", r.ExecutionID)
+		}
+		fmt.Fprintf(w, "  duration_ms: %d\n", int64(r.Duration*1000))
+		if len(r.Artifacts) > 0 {
+			fmt.Fprintln(w, "  artifacts:")
+			for _, p := range r.Artifacts {
+				fmt.Fprintf(w, "    - %q\n", p)
+			}
+		}
+		fmt.Fprintln(w, "  message: |")
+		msg := r.Error
+		if msg == "" {
+			msg = "Unknown error"
+		}
+		for _, line := range strings.Split(strings.TrimRight(msg, "\n"), "\n") {
+			fmt.Fprintf(w, "    %s\n", line)
+		}
+		fmt.Fprintln(w, "  ...")
+	}
+}
+
+// --- SARIF-like ---
+
+// ciOutputSARIF writes the tests that did not pass as a SARIF 2.1.0 log.
+// Test results have no source locations, so each result points at the
+// script through a logical location instead; code scanning tools that
+// require physical locations may not display them.
+func ciOutputSARIF(w io.Writer, results []ciTestResult, projectID int) error {
+	type message struct {
+		Text string `json:"text"`
+	}
+	type rule struct {
+		ID               string  `json:"id"`
+		ShortDescription message `json:"shortDescription"`
+	}
+	type logicalLocation struct {
+		Name               string `json:"name"`
+		FullyQualifiedName string `json:"fullyQualifiedName"`
+		Kind               string `json:"kind"`
+	}
+	type location struct {
+		LogicalLocations []logicalLocation `json:"logicalLocations"`
+	}
+	type result struct {
+		RuleID     string         `json:"ruleId"`
+		Level      string         `json:"level"`
+		Message    message        `json:"message"`
+		Locations  []location     `json:"locations"`
+		Properties map[string]any `json:"properties"`
+	}
+
+	rules := []rule{
+		{ID: "qmax/failed", ShortDescription: message{"Test failed"}},
+		{ID: "qmax/error", ShortDescription: message{"Test could not be run"}},
+		{ID: "qmax/visual_diff", ShortDescription: message{"Screenshots differ from the approved baselines"}},
+		{ID: "qmax/degraded", ShortDescription: message{"Pages exceeded the performance budget"}},
+	}
+	out := []result{}
+	for _, r := range results {
+		if r.Status == "passed" {
+			continue
+		}
+		level := "error"
+		if r.Status == "visual_diff" || r.Status == "degraded" {
+			level = "warning"
+		}
+		if r.Quarantined {
+			level = "note"
+		}
+		ruleID := "qmax/" + r.Status
+		if r.Status != "error" && r.Status != "visual_diff" && r.Status != "degraded" {
+			ruleID = "qmax/failed"
+		}
+		text := r.Error
+		if text == "" {
+			text = "Unknown error"
+		}
+		props := map[string]any{
+			"script_id":  r.ScriptID,
+			"status":     r.Status,
+			"duration_s": r.Duration,
+		}
+		if r.ExecutionID != "" {
+			props["execution_id"] = r.ExecutionID
+		}
+		if r.Attempts > 1 {
+			props["attempts"] = r.Attempts
+		}
+		if r.Quarantined {
+			props["quarantined"] = true
+		}
+		out = append(out, result{
+			RuleID:  ruleID,
+			Level:   level,
+			Message: message{fmt.Sprintf("%s: %s", ciTestName(r), text)},
+			Locations: []location{{LogicalLocations: []logicalLocation{{
+				Name:               ciTestName(r),
+				FullyQualifiedName: fmt.Sprintf("project/%d/script/%d", projectID, r.ScriptID),
+				Kind:               "test",
+			}}}},
+			Properties: props,
+		})
+	}
+
+	doc := map[string]any{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []any{map[string]any{
+			"tool": map[string]any{"driver": map[string]any{
+				"name":           "qmax",
+				"version":        Version,
+				"informationUri": "https://qualitymax.io",
+				"rules":          rules,
+			}},
+			"results": out,
+		}},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testCIResults() []ciTestResult {
+	return []ciTestResult{
+		{ScriptID: 1, ScriptName: "Login works", Status: "passed", Duration: 3.25, ExecutionID: "exec-1"},
+		{ScriptID: 2, ScriptName: "Checkout", Status: "failed", Duration: 7.5, Error: "expect(locator).toBeVisible() failed\n  at checkout.spec.js:12", ExecutionID: "exec-2"},
+		{ScriptID: 3, Status: "error", Error: "timeout waiting for execution to complete"},
+	}
+}
+
+func TestParseCIReportFile(t *testing.T) {
+	tests := []struct {
+		in     string
+		format string
+		path   string
+	}{
+		{"junit=out/results.xml", "junit", "out/results.xml"},
+		{"sarif-like=report.json", "sarif-like", "report.json"},
+		{"results.xml", "junit", "results.xml"},
+		{"summary.md", "markdown", "summary.md"},
+		{"results.tap", "tap", "results.tap"},
+		{"results.sarif", "sarif-like", "results.sarif"},
+		{"dir/a=b.json", "json", "dir/a=b.json"},
+	}
+	for _, tt := range tests {
+		got, err := parseCIReportFile(tt.in)
+		if err != nil || got.Format != tt.format || got.Path != tt.path {
+			t.Errorf("parseCIReportFile(%q) = %+v, %v", tt.in, got, err)
+		}
+	}
+	for _, bad := range []string{"results.txt", "junit="} {
+		if _, err := parseCIReportFile(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestCIOutputJUnit(t *testing.T) {
+	var buf bytes.Buffer
+	if err := ciWriteReport(&buf, "junit", testCIResults(), 42, 12.5); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "<?xml") {
+		t.Errorf("missing XML header: %s", buf.String())
+	}
+
+	var doc junitTestSuites
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid XML: %v\n%s", err, buf.String())
+	}
+	if doc.Tests != 3 || doc.Failures != 1 || doc.Errors != 1 || doc.Time != "12.500" || len(doc.Suites) != 1 {
+		t.Fatalf("unexpected totals: %+v", doc)
+	}
+	cases := doc.Suites[0].TestCases
+	if cases[0].Name != "Login works" || cases[0].Time != "3.250" || cases[0].Failure != nil {
+		t.Errorf("unexpected passed case: %+v", cases[0])
+	}
+	if f := cases[1].Failure; f == nil || f.Message != "expect(locator).toBeVisible() failed" || !strings.Contains(f.Text, "checkout.spec.js:12") || f.Type != "failed" {
+		t.Errorf("unexpected failure: %+v", cases[1].Failure)
+	}
+	if cases[1].Properties[1] != (junitProperty{Name: "execution_id", Value: "exec-2"}) {
+		t.Errorf("missing execution ID: %+v", cases[1].Properties)
+	}
+	if cases[2].Name != "Script #3" || cases[2].Error == nil || cases[2].Failure != nil {
+		t.Errorf("unexpected error case: %+v", cases[2])
+	}
+}
+
+func TestCIOutputTAP(t *testing.T) {
+	var buf bytes.Buffer
+	if err := ciWriteReport(&buf, "tap", testCIResults(), 42, 12.5); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"TAP version 13\n1..3\n",
+		"ok 1 - Login works\n",
+		"not ok 2 - Checkout\n  ---\n  status: failed\n  script_id: 2\n  execution_id: exec-2\n  duration_ms: 7500\n",
+		"  message: |\n    expect(locator).toBeVisible() failed\n      at checkout.spec.js:12\n  ...\n",
+		`not ok 3 - Script \#3`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("TAP output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestCIOutputSARIF(t *testing.T) {
+	var buf bytes.Buffer
+	if err := ciWriteReport(&buf, "sarif-like", testCIResults(), 42, 12.5); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			Results []struct {
+				RuleID    string `json:"ruleId"`
+				Level     string `json:"level"`
+				Message   struct{ Text string }
+				Locations []struct {
+					LogicalLocations []struct {
+						FullyQualifiedName string `json:"fullyQualifiedName"`
+					} `json:"logicalLocations"`
+				} `json:"locations"`
+				Properties map[string]any `json:"properties"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != "2.1.0" || len(doc.Runs) != 1 || len(doc.Runs[0].Results) != 2 {
+		t.Fatalf("unexpected SARIF log: %s", buf.String())
+	}
+	r := doc.Runs[0].Results[0]
+	if r.RuleID != "qmax/failed" || r.Level != "error" || !strings.HasPrefix(r.Message.Text, "Checkout: ") ||
+		r.Locations[0].LogicalLocations[0].FullyQualifiedName != "project/42/script/2" || r.Properties["execution_id"] != "exec-2" {
+		t.Errorf("unexpected result: %+v", r)
+	}
+	if doc.Runs[0].Results[1].RuleID != "qmax/error" {
+		t.Errorf("unexpected rule: %s", doc.Runs[0].Results[1].RuleID)
+	}
+}
+
+func TestCIWriteReport_MarkdownAndJSON(t *testing.T) {
+	var buf bytes.Buffer
+	_ = ciWriteReport(&buf, "markdown", testCIResults(), 42, 12.5)
+	if !strings.Contains(buf.String(), "| Checkout | \u274c Failed | 7.5s |") {
+		t.Errorf("unexpected markdown:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	_ = ciWriteReport(&buf, "json", testCIResults(), 42, 12.5)
+	var out struct {
+		Total  int `json:"total"`
+		Failed int `json:"failed"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil || out.Total != 3 || out.Failed != 2 {
+		t.Errorf("unexpected JSON %+v, %v", out, err)
+	}
+
+	if err := ciWriteReport(&buf, "html", nil, 42, 0); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestCIWriteReportFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, rf := range []ciReportFile{
+		{Format: "junit", Path: filepath.Join(dir, "reports", "results.xml")},
+		{Format: "markdown", Path: filepath.Join(dir, "summary.md")},
+	} {
+		if err := ciWriteReportFile(rf, testCIResults(), 42, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "reports", "results.xml")); err != nil || !strings.Contains(string(data), "<testsuites") {
+		t.Errorf("JUnit file not written: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "summary.md")); err != nil || !strings.Contains(string(data), "QualityMax Test Results") {
+		t.Errorf("markdown file not written: %v", err)
+	}
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ciScript is a project script with the fields test selection looks at.
+type ciScript struct {
+	ID         int
+	Name       string
+	TestCaseID int
+	Tags       []string
+}
+
+// ciTestCase is the part of a test case used for selection, the same fields
+// `qmax test cases` shows.
+type ciTestCase struct {
+	ID       int
+	Category string
+	Priority int
+	Tags     []string
+}
+
+// ciSelector narrows the scripts a CI run executes. Each non-empty field
+// must match; values within a field are alternatives.
+type ciSelector struct {
+	Tags       []string
+	Categories []string
+	Priorities []int
+	Exclude    []string // script IDs or name globs
+	// Affected limits the run to scripts covering changed files; nil means
+	// no limit.
+	Affected map[int]bool
+}
+
+// needsTestCases reports whether selection needs the project's test cases.
+func (s ciSelector) needsTestCases() bool {
+	return len(s.Tags) > 0 || len(s.Categories) > 0 || len(s.Priorities) > 0
+}
+
+func (s ciSelector) empty() bool {
+	return !s.needsTestCases() && len(s.Exclude) == 0 && s.Affected == nil
+}
+
+// selectCIScripts returns the IDs of the scripts that match the selector,
+// in the given order.
+func selectCIScripts(scripts []ciScript, testCases map[int]ciTestCase, sel ciSelector) []int {
+	ids := []int{}
+	for _, sc := range scripts {
+		tc := testCases[sc.TestCaseID]
+		switch {
+		case len(sel.Tags) > 0 && !ciHasAnyTag(append(append([]string{}, sc.Tags...), tc.Tags...), sel.Tags):
+		case len(sel.Categories) > 0 && !ciContainsFold(sel.Categories, tc.Category):
+		case len(sel.Priorities) > 0 && !ciContainsInt(sel.Priorities, tc.Priority):
+		case ciExcluded(sc, sel.Exclude):
+		case sel.Affected != nil && !sel.Affected[sc.ID]:
+		default:
+			ids = append(ids, sc.ID)
+		}
+	}
+	return ids
+}
+
+func ciHasAnyTag(have, want []string) bool {
+	for _, w := range want {
+		if ciContainsFold(have, w) {
+			return true
+		}
+	}
+	return false
+}
+
+func ciContainsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(strings.TrimSpace(v), strings.TrimSpace(s)) {
+			return true
+		}
+	}
+	return false
+}
+
+func ciContainsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+// ciExcluded reports whether an --exclude value matches the script: a
+// number is a script ID, anything else a case-insensitive glob on the name.
+func ciExcluded(sc ciScript, patterns []string) bool {
+	for _, p := range patterns {
+		if id, err := strconv.Atoi(p); err == nil {
+			if id == sc.ID {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(strings.ToLower(p), strings.ToLower(sc.Name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ciParsePriorities parses "1,2" or ranges like "1-2".
+func ciParsePriorities(s string) ([]int, error) {
+	var out []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(part, "-")
+		from, err := strconv.Atoi(strings.TrimSpace(lo))
+		if err != nil {
+			return nil, fmt.Errorf("invalid priority %q", part)
+		}
+		to := from
+		if isRange {
+			if to, err = strconv.Atoi(strings.TrimSpace(hi)); err != nil || to < from {
+				return nil, fmt.Errorf("invalid priority range %q", part)
+			}
+		}
+		for p := from; p <= to; p++ {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+// --- API ---
+
+// ciFetchProjectScripts fetches all scripts of a project. The test case
+// and tags of a script are only read with metadata, for selectors that
+// need them; metadata the agent cannot read counts as none.
+func ciFetchProjectScripts(client *http.Client, apiURL, token string, projectID int, metadata bool) ([]ciScript, error) {
+	url := fmt.Sprintf("%s/api/automation/scripts/project/%d?limit=500", apiURL, projectID)
+	body, err := ciAuthGet(client, url, token)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Scripts []struct {
+			ID         json.Number     `json:"id"`
+			Name       string          `json:"name"`
+			TestCaseID json.RawMessage `json:"test_case_id"`
+			Tags       json.RawMessage `json:"tags"`
+		} `json:"scripts"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("parse scripts response: %w", err)
+	}
+	scripts := make([]ciScript, 0, len(resp.Scripts))
+	for _, raw := range resp.Scripts {
+		id, err := strconv.Atoi(raw.ID.String())
+		if err != nil {
+			return nil, fmt.Errorf("parse scripts response: script ID %q is not a number", raw.ID)
+		}
+		sc := ciScript{ID: id, Name: raw.Name}
+		if metadata {
+			sc.TestCaseID = ciParseID(raw.TestCaseID)
+			sc.Tags = ciParseTags(raw.Tags)
+		}
+		scripts = append(scripts, sc)
+	}
+	return scripts, nil
+}
+
+// ciFetchTestCases fetches a project's test cases by ID.
+func ciFetchTestCases(client *http.Client, apiURL, token string, projectID int) (map[int]ciTestCase, error) {
+	url := fmt.Sprintf("%s/api/test-cases/project/%d?limit=1000", apiURL, projectID)
+	body, err := ciAuthGet(client, url, token)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		TestCases []struct {
+			ID       json.Number     `json:"id"`
+			Category string          `json:"category"`
+			Priority json.Number     `json:"priority"`
+			Tags     json.RawMessage `json:"tags"`
+		} `json:"test_cases"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("parse test cases response: %w", err)
+	}
+	byID := make(map[int]ciTestCase, len(resp.TestCases))
+	for _, raw := range resp.TestCases {
+		id, err := strconv.Atoi(raw.ID.String())
+		if err != nil {
+			continue
+		}
+		priority, _ := strconv.Atoi(raw.Priority.String())
+		byID[id] = ciTestCase{ID: id, Category: raw.Category, Priority: priority, Tags: ciParseTags(raw.Tags)}
+	}
+	return byID, nil
+}
+
+// ciParseID reads an ID given as a number or a numeric string; anything
+// else, including null, is 0.
+func ciParseID(raw json.RawMessage) int {
+	var n json.Number
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return 0
+	}
+	id, _ := strconv.Atoi(n.String())
+	return id
+}
+
+// ciParseTags reads tags given as a list, a comma-separated string or null.
+func ciParseTags(raw json.RawMessage) []string {
+	var list []interface{}
+	if err := json.Unmarshal(raw, &list); err != nil {
+		var csv string
+		if err := json.Unmarshal(raw, &csv); err != nil {
+			return nil
+		}
+		for _, t := range strings.Split(csv, ",") {
+			list = append(list, t)
+		}
+	}
+	var tags []string
+	for _, t := range list {
+		if s, ok := t.(string); ok && strings.TrimSpace(s) != "" {
+			tags = append(tags, strings.TrimSpace(s))
+		}
+	}
+	return tags
+}
+
+// ciFetchCoverage fetches the repository coverage data that
+// `qmax repo coverage` shows.
+func ciFetchCoverage(client *http.Client, apiURL, token string, repoID int) (interface{}, error) {
+	url := fmt.Sprintf("%s/api/repositories/%d/coverage", apiURL, repoID)
+	body, err := ciAuthGet(client, url, token)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Coverage interface{} `json:"coverage"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("parse coverage response: %w", err)
+	}
+	if resp.Coverage == nil {
+		return nil, fmt.Errorf("no coverage data for repository #%d; run a repository analysis first", repoID)
+	}
+	return resp.Coverage, nil
+}
+
+// --- Changed files ---
+
+// ciChangedFiles lists the files changed between the merge base of ref and
+// HEAD, relative to the repository root.
+func ciChangedFiles(dir, ref string) ([]string, error) {
+	cmd := exec.Command("git", "diff", "--name-only", ref+"...HEAD")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("git diff %s...HEAD: %s", ref, strings.TrimSpace(string(ee.Stderr)))
+		}
+		return nil, fmt.Errorf("git diff: %w", err)
+	}
+	var files []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
+
+// coverageEntry links a source file to the scripts and test cases that
+// cover it.
+type coverageEntry struct {
+	File        string
+	ScriptIDs   []int
+	TestCaseIDs []int
+}
+
+// Keys that name a file and the scripts or test cases covering it in
+// coverage data.
+var (
+	coverageFileKeys     = []string{"file", "path", "file_path", "source_file", "filename"}
+	coverageScriptKeys   = []string{"script_ids", "scripts", "test_scripts", "covered_by_scripts"}
+	coverageTestCaseKeys = []string{"test_case_ids", "test_cases", "covered_by"}
+)
+
+// coverageEntries collects file-to-test links from coverage data. The
+// analysis output is not fixed, so any object that names a file and lists
+// scripts or test cases counts, wherever it is nested. Objects keyed by
+// file path ({"src/a.js": {"script_ids": [1]}}) work too.
+func coverageEntries(v interface{}) []coverageEntry {
+	var entries []coverageEntry
+	var walk func(v interface{}, key string)
+	walk = func(v interface{}, key string) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			file := ""
+			for _, k := range coverageFileKeys {
+				if s, ok := v[k].(string); ok && s != "" {
+					file = s
+					break
+				}
+			}
+			if file == "" && strings.ContainsAny(key, "./") {
+				file = key
+			}
+			if file != "" {
+				e := coverageEntry{File: file}
+				for _, k := range coverageScriptKeys {
+					e.ScriptIDs = append(e.ScriptIDs, coverageIDs(v[k])...)
+				}
+				for _, k := range coverageTestCaseKeys {
+					e.TestCaseIDs = append(e.TestCaseIDs, coverageIDs(v[k])...)
+				}
+				if len(e.ScriptIDs) > 0 || len(e.TestCaseIDs) > 0 {
+					entries = append(entries, e)
+				}
+			}
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				walk(v[k], k)
+			}
+		case []interface{}:
+			for _, item := range v {
+				walk(item, "")
+			}
+		}
+	}
+	walk(v, "")
+	return entries
+}
+
+// coverageIDs reads a list of IDs given as numbers, numeric strings or
+// objects with an "id".
+func coverageIDs(v interface{}) []int {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+	var ids []int
+	for _, item := range list {
+		switch item := item.(type) {
+		case float64:
+			ids = append(ids, int(item))
+		case string:
+			if id, err := strconv.Atoi(item); err == nil {
+				ids = append(ids, id)
+			}
+		case map[string]interface{}:
+			if id, ok := item["id"].(float64); ok {
+				ids = append(ids, int(id))
+			}
+		}
+	}
+	return ids
+}
+
+// affectedScripts returns the scripts covering any of the changed files.
+// Coverage paths and git paths are compared after normalizing; a path that
+// ends with the other (as a whole path segment) also matches, since
+// coverage may be recorded relative to a subdirectory.
+func affectedScripts(entries []coverageEntry, changed []string, scripts []ciScript) map[int]bool {
+	byTestCase := map[int][]int{}
+	for _, sc := range scripts {
+		if sc.TestCaseID != 0 {
+			byTestCase[sc.TestCaseID] = append(byTestCase[sc.TestCaseID], sc.ID)
+		}
+	}
+
+	affected := map[int]bool{}
+	for _, e := range entries {
+		file := normalizeRepoPath(e.File)
+		for _, c := range changed {
+			if !sameRepoPath(file, normalizeRepoPath(c)) {
+				continue
+			}
+			for _, id := range e.ScriptIDs {
+				affected[id] = true
+			}
+			for _, tc := range e.TestCaseIDs {
+				for _, id := range byTestCase[tc] {
+					affected[id] = true
+				}
+			}
+			break
+		}
+	}
+	return affected
+}
+
+func normalizeRepoPath(p string) string {
+	p = strings.ReplaceAll(p, "\\", "/")
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	return p
+}
+
+func sameRepoPath(a, b string) bool {
+	return a == b || strings.HasSuffix(a, "/"+b) || strings.HasSuffix(b, "/"+a)
+}
+
+// ciSelection is what `qmax ci run` was asked to run.
+type ciSelection struct {
+	ScriptIDs    []int // --script-ids; nil means the whole project
+	Selector     ciSelector
+	ChangedSince string // git ref for --changed-since
+	RepoID       int    // repository whose coverage maps files to scripts
+}
+
+// resolveCIScripts returns the IDs of the scripts to run. Explicit script
+// IDs without selectors are used as given; otherwise the project's scripts
+// are fetched and filtered.
+func resolveCIScripts(client *http.Client, apiURL, token string, projectID int, sel ciSelection) ([]int, error) {
+	if sel.ScriptIDs != nil && sel.Selector.empty() && sel.ChangedSince == "" {
+		return sel.ScriptIDs, nil
+	}
+
+	metadata := sel.Selector.needsTestCases() || sel.ChangedSince != ""
+	projectScripts, err := ciFetchProjectScripts(client, apiURL, token, projectID, metadata)
+	if err != nil {
+		return nil, fmt.Errorf("fetch project scripts: %w", err)
+	}
+	scripts := projectScripts
+	if sel.ScriptIDs != nil {
+		byID := map[int]ciScript{}
+		for _, sc := range projectScripts {
+			byID[sc.ID] = sc
+		}
+		scripts = make([]ciScript, len(sel.ScriptIDs))
+		for i, id := range sel.ScriptIDs {
+			sc, ok := byID[id]
+			if !ok {
+				sc = ciScript{ID: id}
+			}
+			scripts[i] = sc
+		}
+	} else if len(scripts) == 0 {
+		return nil, fmt.Errorf("no scripts found in project")
+	}
+
+	var testCases map[int]ciTestCase
+	if sel.Selector.needsTestCases() {
+		if testCases, err = ciFetchTestCases(client, apiURL, token, projectID); err != nil {
+			return nil, fmt.Errorf("fetch test cases: %w", err)
+		}
+	}
+
+	if sel.ChangedSince != "" {
+		changed, err := ciChangedFiles(".", sel.ChangedSince)
+		if err != nil {
+			return nil, err
+		}
+		coverage, err := ciFetchCoverage(client, apiURL, token, sel.RepoID)
+		if err != nil {
+			return nil, fmt.Errorf("fetch coverage: %w", err)
+		}
+		sel.Selector.Affected = affectedScripts(coverageEntries(coverage), changed, scripts)
+		fmt.Fprintf(os.Stderr, "%d file(s) changed since %s, covered by %d script(s)\n",
+			len(changed), sel.ChangedSince, len(sel.Selector.Affected))
+	}
+
+	ids := selectCIScripts(scripts, testCases, sel.Selector)
+	fmt.Fprintf(os.Stderr, "Selected %d of %d script(s)\n", len(ids), len(scripts))
+	return ids, nil
+}
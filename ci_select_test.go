@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func testCIScripts() []ciScript {
+	return []ciScript{
+		{ID: 1, Name: "Login works", TestCaseID: 10, Tags: []string{"smoke"}},
+		{ID: 2, Name: "Checkout pays", TestCaseID: 20},
+		{ID: 3, Name: "Flaky search", TestCaseID: 30},
+		{ID: 4, Name: "Profile", TestCaseID: 0},
+	}
+}
+
+func testCITestCases() map[int]ciTestCase {
+	return map[int]ciTestCase{
+		10: {ID: 10, Category: "auth", Priority: 1},
+		20: {ID: 20, Category: "payments", Priority: 1, Tags: []string{"Smoke", "critical"}},
+		30: {ID: 30, Category: "search", Priority: 3},
+	}
+}
+
+func TestSelectCIScripts(t *testing.T) {
+	tests := []struct {
+		name string
+		sel  ciSelector
+		want []int
+	}{
+		{"no selector", ciSelector{}, []int{1, 2, 3, 4}},
+		{"tag on script or test case", ciSelector{Tags: []string{"smoke"}}, []int{1, 2}},
+		{"any of several tags", ciSelector{Tags: []string{"critical", "nope"}}, []int{2}},
+		{"category", ciSelector{Categories: []string{"Payments", "search"}}, []int{2, 3}},
+		{"priority", ciSelector{Priorities: []int{1}}, []int{1, 2}},
+		{"fields combine", ciSelector{Tags: []string{"smoke"}, Categories: []string{"auth"}}, []int{1}},
+		{"exclude by ID and glob", ciSelector{Exclude: []string{"2", "flaky*"}}, []int{1, 4}},
+		{"affected", ciSelector{Affected: map[int]bool{3: true, 4: true}}, []int{3, 4}},
+		{"nothing affected", ciSelector{Affected: map[int]bool{}}, []int{}},
+	}
+	for _, tt := range tests {
+		if got := selectCIScripts(testCIScripts(), testCITestCases(), tt.sel); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCIParsePriorities(t *testing.T) {
+	got, err := ciParsePriorities("1, 3-5")
+	if err != nil || !reflect.DeepEqual(got, []int{1, 3, 4, 5}) {
+		t.Errorf("got %v, %v", got, err)
+	}
+	for _, bad := range []string{"high", "3-1", "1-x"} {
+		if _, err := ciParsePriorities(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestCoverageEntries(t *testing.T) {
+	var coverage interface{}
+	_ = json.Unmarshal([]byte(`{
+		"summary": {"covered": 3},
+		"files": [
+			{"path": "src/auth/login.js", "script_ids": [1]},
+			{"file": "src/cart.js", "test_cases": [{"id": 20, "title": "Checkout"}]},
+			{"path": "README.md"}
+		],
+		"by_file": {"src/search.ts": {"scripts": ["3"]}}
+	}`), &coverage)
+
+	entries := coverageEntries(coverage)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].File < entries[j].File })
+	want := []coverageEntry{
+		{File: "src/auth/login.js", ScriptIDs: []int{1}},
+		{File: "src/cart.js", TestCaseIDs: []int{20}},
+		{File: "src/search.ts", ScriptIDs: []int{3}},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("got %+v, want %+v", entries, want)
+	}
+}
+
+func TestAffectedScripts(t *testing.T) {
+	entries := []coverageEntry{
+		{File: "./src/auth/login.js", ScriptIDs: []int{1}},
+		{File: "src/cart.js", TestCaseIDs: []int{20}},
+		{File: "search.ts", ScriptIDs: []int{3}},
+	}
+	got := affectedScripts(entries, []string{"src/auth/login.js", "src/cart.js", "docs/x.md"}, testCIScripts())
+	if !reflect.DeepEqual(got, map[int]bool{1: true, 2: true}) {
+		t.Errorf("got %v", got)
+	}
+	if got := affectedScripts(entries, []string{"web/search.ts"}, testCIScripts()); !got[3] {
+		t.Errorf("a coverage path relative to a subdirectory should match: %v", got)
+	}
+	if got := affectedScripts(entries, []string{"src/research.ts"}, testCIScripts()); len(got) != 0 {
+		t.Errorf("suffix matches must be whole path segments: %v", got)
+	}
+}
+
+func TestCIChangedFiles(t *testing.T) {
+	if !commandExists("git") {
+		t.Skip("git not available")
+	}
+	dir := t.TempDir()
+	gitRun := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=t", "-c", "user.email=t@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	gitRun("init", "-q")
+	_ = os.WriteFile(filepath.Join(dir, "a.js"), []byte("a"), 0644)
+	gitRun("add", ".")
+	gitRun("commit", "-qm", "base")
+	gitRun("tag", "base")
+	_ = os.MkdirAll(filepath.Join(dir, "src"), 0755)
+	_ = os.WriteFile(filepath.Join(dir, "src", "b.js"), []byte("b"), 0644)
+	_ = os.WriteFile(filepath.Join(dir, "a.js"), []byte("a2"), 0644)
+	gitRun("add", ".")
+	gitRun("commit", "-qm", "change")
+
+	files, err := ciChangedFiles(dir, "base")
+	if err != nil || !reflect.DeepEqual(files, []string{"a.js", "src/b.js"}) {
+		t.Errorf("got %v, %v", files, err)
+	}
+	if _, err := ciChangedFiles(dir, "no-such-ref"); err == nil {
+		t.Error("expected error for unknown ref")
+	}
+}
+
+func TestResolveCIScripts(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		switch r.URL.Path {
+		case "/api/automation/scripts/project/42":
+			// IDs as numbers or strings, tags as a list, a string or null
+			w.Write([]byte(`{"scripts": [
+				{"id": 1, "name": "Login works", "test_case_id": "10", "tags": "smoke, "},
+				{"id": "2", "name": "Checkout pays", "test_case_id": 20, "tags": null},
+				{"id": 3, "name": "Flaky search", "test_case_id": 30, "tags": []},
+				{"id": 4, "name": "Profile", "test_case_id": null}
+			]}`))
+		case "/api/test-cases/project/42":
+			w.Write([]byte(`{"test_cases": [
+				{"id": 10, "category": "auth", "priority": 1},
+				{"id": "20", "category": "payments", "priority": 1, "tags": ["Smoke", "critical"]},
+				{"id": 30, "category": "search", "priority": "3", "tags": "flaky"}
+			]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	ids, err := resolveCIScripts(server.Client(), server.URL, "tok", 42, ciSelection{ScriptIDs: []int{7, 8}})
+	if err != nil || !reflect.DeepEqual(ids, []int{7, 8}) || len(paths) != 0 {
+		t.Errorf("explicit IDs should be used without API calls: %v %v %v", ids, err, paths)
+	}
+
+	paths = nil
+	ids, err = resolveCIScripts(server.Client(), server.URL, "tok", 42, ciSelection{})
+	if err != nil || !reflect.DeepEqual(ids, []int{1, 2, 3, 4}) {
+		t.Errorf("--all: %v %v", ids, err)
+	}
+	if len(paths) != 1 {
+		t.Errorf("--all should not fetch test cases: %v", paths)
+	}
+
+	ids, err = resolveCIScripts(server.Client(), server.URL, "tok", 42, ciSelection{Selector: ciSelector{Tags: []string{"smoke"}}})
+	if err != nil || !reflect.DeepEqual(ids, []int{1, 2}) {
+		t.Errorf("tags: %v %v", ids, err)
+	}
+
+	ids, err = resolveCIScripts(server.Client(), server.URL, "tok", 42, ciSelection{Selector: ciSelector{Priorities: []int{1}, Exclude: []string{"1"}}})
+	if err != nil || !reflect.DeepEqual(ids, []int{2}) {
+		t.Errorf("priority with exclude: %v %v", ids, err)
+	}
+
+	ids, err = resolveCIScripts(server.Client(), server.URL, "tok", 42, ciSelection{ScriptIDs: []int{3, 1, 99}, Selector: ciSelector{Exclude: []string{"flaky*"}}})
+	if err != nil || !reflect.DeepEqual(ids, []int{1, 99}) {
+		t.Errorf("selectors should filter explicit IDs in order: %v %v", ids, err)
+	}
+
+	if _, err := resolveCIScripts(server.Client(), server.URL, "tok", 7, ciSelection{}); err == nil {
+		t.Error("expected error when scripts cannot be fetched")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ciShard is a --shard value: run part Index of Total, counting from 1.
+type ciShard struct {
+	Index int
+	Total int
+}
+
+func (s ciShard) String() string {
+	return fmt.Sprintf("%d/%d", s.Index, s.Total)
+}
+
+// parseCIShard parses "i/n" with 1 <= i <= n.
+func parseCIShard(v string) (ciShard, error) {
+	i, n, ok := strings.Cut(v, "/")
+	if !ok {
+		return ciShard{}, fmt.Errorf("--shard %q: use i/n, e.g. 1/4", v)
+	}
+	index, err1 := strconv.Atoi(strings.TrimSpace(i))
+	total, err2 := strconv.Atoi(strings.TrimSpace(n))
+	if err1 != nil || err2 != nil || total < 1 || index < 1 || index > total {
+		return ciShard{}, fmt.Errorf("--shard %q: use i/n with 1 <= i <= n", v)
+	}
+	return ciShard{Index: index, Total: total}, nil
+}
+
+// shardCIScripts returns the scripts of one shard, in selection order. Every
+// job of the matrix computes the same split from the same selection: by
+// script ID round-robin, or, when durations from earlier runs are known, by
+// handing the longest scripts out first to the shard with the least work.
+// Scripts without a known duration count as the average known duration.
+func shardCIScripts(scriptIDs []int, shard ciShard, durations map[int]float64) []int {
+	if shard.Total <= 1 {
+		return scriptIDs
+	}
+
+	order := make([]int, len(scriptIDs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return scriptIDs[order[a]] < scriptIDs[order[b]]
+	})
+
+	assigned := make([]int, len(scriptIDs))
+	if len(durations) == 0 {
+		for k, i := range order {
+			assigned[i] = k % shard.Total
+		}
+	} else {
+		var sum float64
+		for _, d := range durations {
+			sum += d
+		}
+		avg := sum / float64(len(durations))
+		weight := func(i int) float64 {
+			if d, ok := durations[scriptIDs[i]]; ok {
+				return d
+			}
+			return avg
+		}
+		// Order is by ID already, so equal weights keep a deterministic order
+		sort.SliceStable(order, func(a, b int) bool {
+			return weight(order[a]) > weight(order[b])
+		})
+		load := make([]float64, shard.Total)
+		for _, i := range order {
+			least := 0
+			for s := 1; s < shard.Total; s++ {
+				if load[s] < load[least] {
+					least = s
+				}
+			}
+			assigned[i] = least
+			load[least] += weight(i)
+		}
+	}
+
+	var out []int
+	for i, sid := range scriptIDs {
+		if assigned[i] == shard.Index-1 {
+			out = append(out, sid)
+		}
+	}
+	return out
+}
+
+// ciJSONReport is the report written by --format json, read back by
+// `qmax ci merge` and as a durations file.
+type ciJSONReport struct {
+	ProjectID int            `json:"project_id"`
+	Duration  float64        `json:"duration"`
+	Results   []ciTestResult `json:"results"`
+}
+
+// readCIJSONReport reads a report written by --format json.
+func readCIJSONReport(path string) (*ciJSONReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var report ciJSONReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("%s: not a qmax ci JSON report: %w", path, err)
+	}
+	return &report, nil
+}
+
+// loadCIDurations reads script durations from the JSON report of an
+// earlier run, such as the output of `qmax ci merge`. Scripts that errored
+// or have no duration are left out, since their time says nothing about
+// how long they take to run.
+func loadCIDurations(path string) (map[int]float64, error) {
+	report, err := readCIJSONReport(path)
+	if err != nil {
+		return nil, err
+	}
+	durations := make(map[int]float64)
+	for _, r := range report.Results {
+		if r.Status == "error" || r.Duration <= 0 {
+			continue
+		}
+		durations[r.ScriptID] = r.Duration
+	}
+	return durations, nil
+}
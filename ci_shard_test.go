@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseCIShard(t *testing.T) {
+	if got, err := parseCIShard("2/4"); err != nil || got != (ciShard{Index: 2, Total: 4}) {
+		t.Errorf("parseCIShard(2/4) = %+v, %v", got, err)
+	}
+	for _, bad := range []string{"2", "0/4", "5/4", "a/b", "1/0"} {
+		if _, err := parseCIShard(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestShardCIScripts_RoundRobin(t *testing.T) {
+	ids := []int{9, 3, 7, 1, 5}
+	var all []int
+	for i := 1; i <= 2; i++ {
+		all = append(all, shardCIScripts(ids, ciShard{Index: i, Total: 2}, nil)...)
+	}
+	// Sorted IDs 1,3,5,7,9 alternate between the shards; each shard keeps
+	// the selection order
+	if got := shardCIScripts(ids, ciShard{Index: 1, Total: 2}, nil); !reflect.DeepEqual(got, []int{9, 1, 5}) {
+		t.Errorf("shard 1/2 = %v", got)
+	}
+	sort.Ints(all)
+	if !reflect.DeepEqual(all, []int{1, 3, 5, 7, 9}) {
+		t.Errorf("shards do not cover the selection exactly once: %v", all)
+	}
+}
+
+func TestShardCIScripts_Balanced(t *testing.T) {
+	ids := []int{1, 2, 3, 4, 5}
+	durations := map[int]float64{1: 60, 2: 10, 3: 20, 4: 30}
+	// Script 5 has no duration and counts as the average, 30s
+	shards := make([][]int, 2)
+	for i := range shards {
+		shards[i] = shardCIScripts(ids, ciShard{Index: i + 1, Total: 2}, durations)
+	}
+	// Longest first: 1 (60) -> A, 4 (30) -> B, 5 (30) -> B, 3 (20) -> A, 2 (10) -> B
+	if !reflect.DeepEqual(shards[0], []int{1, 3}) || !reflect.DeepEqual(shards[1], []int{2, 4, 5}) {
+		t.Errorf("shards = %v", shards)
+	}
+}
+
+func TestShardCIScripts_SingleShard(t *testing.T) {
+	ids := []int{3, 1}
+	if got := shardCIScripts(ids, ciShard{Index: 1, Total: 1}, nil); !reflect.DeepEqual(got, ids) {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestLoadCIDurations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "durations.json")
+	data := `{"project_id": 42, "duration": 20, "results": [
+		{"ScriptID": 1, "Status": "passed", "Duration": 3.5},
+		{"ScriptID": 2, "Status": "failed", "Duration": 7},
+		{"ScriptID": 3, "Status": "error", "Duration": 0}
+	]}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadCIDurations(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int]float64{1: 3.5, 2: 7}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := loadCIDurations(filepath.Join(t.TempDir(), "missing.json")); !os.IsNotExist(err) {
+		t.Errorf("missing file: %v", err)
+	}
+}
@@ -51,20 +51,16 @@ func cmdCapture(args []string) {
 		os.Exit(1)
 	}
 
-	// Launch visible Chrome
+	// Launch visible Chrome with a single isolated session
 	fmt.Println("Launching Chrome...")
-	opts := append(chromedp.DefaultExecAllocatorOptions[:],
-		chromedp.Flag("headless", false),
-		chromedp.Flag("disable-gpu", false),
-	)
-
-	allocCtx, allocCancel := chromedp.NewExecAllocator(context.Background(), opts...)
-	defer allocCancel()
-
-	ctx, cancel := chromedp.NewContext(allocCtx,
-		chromedp.WithLogf(func(string, ...interface{}) {}),
-		chromedp.WithErrorf(func(string, ...interface{}) {}),
-	)
+	pool := NewBrowserPool(1, false)
+	defer pool.Close()
+
+	ctx, cancel, err := pool.Acquire(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error launching Chrome: %v\n", err)
+		os.Exit(1)
+	}
 	defer cancel()
 
 	// Navigate to URL
@@ -74,7 +70,7 @@ func cmdCapture(args []string) {
 		os.Exit(1)
 	}
 
-	fmt.Println("\nA fresh Chrome window has opened (no existing sessions).")
+	fmt.Println("\nA fresh incognito Chrome window has opened (no existing sessions).")
 	fmt.Println("Complete the full login flow in the browser window.")
 	fmt.Println("Once you see the authenticated page, come back here.")
 	fmt.Print("\nPress ENTER when login is complete...")
@@ -124,7 +120,7 @@ func cmdCapture(args []string) {
 	}
 
 	cancel()
-	allocCancel()
+	pool.Close()
 
 	fmt.Printf("Captured %d cookies\n", len(cookies))
 
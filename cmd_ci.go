@@ -2,7 +2,9 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -10,6 +12,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -19,23 +22,38 @@ import (
 // Usage:
 //   qmax ci run --project-id 42 [--script-ids 1,2,3] [--base-url https://staging.app.com]
 //   qmax ci run --project-id 42 --all    (run all scripts in project)
+//   qmax ci run --project-id 42 --all --local    (run on this machine)
 //
 // Auth: uses --token flag or QMAX_TOKEN env var (no browser needed)
-// Output: markdown summary + GitHub Actions step outputs
+// Output: markdown, JSON, JUnit XML, TAP or SARIF-like report + CI provider outputs
+// (GitHub Actions, GitLab CI, Azure Pipelines, Buildkite, CircleCI)
 
 const (
-	defaultCITimeout = 600
-	ciMaxBody        = 10 * 1024 * 1024 // 10MB
+	defaultCITimeout  = 600
+	defaultCIParallel = 8
+	ciMaxBody         = 10 * 1024 * 1024 // 10MB
 )
 
 // ciTestResult holds the result of a single test execution.
 type ciTestResult struct {
 	ScriptID    int
 	ScriptName  string
-	Status      string // passed, failed, error
+	Status      string // passed, failed, error, visual_diff, degraded
 	Duration    float64
 	Error       string
 	ExecutionID string
+	Attempts    int  // runs of the script, counting retries
+	Flaky       bool // passed on a retry after failing
+	Quarantined bool // failed, but listed in the quarantine
+
+	// Set by --compare-to: the script's status and duration in the
+	// baseline run, with status "new" if the baseline did not run it
+	BaselineStatus   string
+	BaselineDuration float64
+
+	// Set by --artifacts-dir: paths of the saved screenshots, videos and
+	// traces, from every attempt
+	Artifacts []string
 }
 
 func cmdCI(args []string) {
@@ -48,6 +66,8 @@ func cmdCI(args []string) {
 	switch sub {
 	case "run":
 		cmdCIRun(args[1:])
+	case "merge":
+		cmdCIMerge(args[1:])
 	case "help", "--help", "-h":
 		printCIUsage()
 	default:
@@ -62,22 +82,90 @@ func printCIUsage() {
 
 Subcommands:
   run        Execute tests in CI mode (headless, no interactive prompts)
+  merge      Combine the JSON reports of sharded runs into one report
 
 Run flags:
   --project-id    Project ID (required)
   --script-ids    Comma-separated script IDs to execute
   --all           Run all scripts in the project
+  --tag           Only scripts with this tag on the script or its test case (repeatable)
+  --category      Only scripts whose test case has this category (repeatable)
+  --priority      Only scripts whose test case has one of these priorities: 1,2 or 1-2
+  --exclude       Skip a script ID, or scripts whose name matches a glob (repeatable)
+  --changed-since Only scripts covering files changed since a git ref, from the
+                  coverage data of --repo-id (see qmax repo coverage)
+  --repo-id       Repository ID for --changed-since
   --base-url      Override base URL for all tests
   --token         Auth token (or set QMAX_TOKEN env var)
   --api-url       API base URL (default: https://app.qualitymax.io)
   --headless      Run in headless mode (default: true)
   --browser       Browser to use (default: chromium)
   --timeout       Overall timeout in seconds (default: 600)
-  --format        Output format: markdown or json (default: markdown)
+  --format        Output format: markdown, json, junit, tap or sarif-like (default: markdown)
+  --report-file   Also write a report to a file: format=path, or a path ending in
+                  .md, .json, .xml (junit), .tap or .sarif (repeatable)
+  --parallel      Maximum concurrent API calls when starting and polling (default: 8)
+  --batch         Start all scripts with one call to /api/automation/execute-batch;
+                  falls back to one call per script if the server lacks it, and stops
+                  if the response does not list the executions
+  --local         Run scripts on this machine with Playwright (Node.js required)
+                  instead of the cloud executor, one at a time
+  --report-to-cloud
+                  With --local, record the results in the cloud
+  --retries       Re-run failed scripts up to N more times; a script that passes
+                  on a retry is reported as flaky (default: 0)
+  --quarantine    File of script IDs or name globs, one per line, whose failures
+                  are reported but do not fail the build
+  --project-quarantine
+                  Also quarantine the scripts listed in the project's CI settings
+  --shard         Run only part i of n of the selected scripts, e.g. 2/4
+  --durations-file
+                  JSON report of an earlier run (e.g. from ci merge) used to
+                  balance --shard by script duration
+  --compare-to    Compare with a baseline: a JSON report file, or last-main for
+                  the latest run recorded with --record-baseline
+  --baseline-branch
+                  Branch whose latest run last-main means (default: main)
+  --record-baseline
+                  Record this run in the project as the latest run of
+                  --baseline-branch, for later --compare-to last-main
+  --fail-on       When to fail the build: any failing test, or only
+                  new-failures against --compare-to (default: any)
+  --artifacts-dir Download screenshots, videos and traces into this directory,
+                  one folder per script and attempt
+  --artifacts-for Executions to download artifacts for: failed or all
+                  (default: failed)
+  --pr-comment    Post the summary as a sticky comment on the GitHub pull request
+                  and set a commit status (needs GITHUB_TOKEN)
+  --pr-status     With --pr-comment: status, check (a check run) or none
+                  (default: status)
+  --pr-number     Pull request number (default: from the GitHub Actions event)
+  --commit-sha    Commit for the status (default: the pull request head)
+  --github-token  GitHub token (or GITHUB_TOKEN / GH_TOKEN env var)
+  --github-api-url
+                  GitHub API base URL for GitHub Enterprise Server or a mock
+                  (or GITHUB_API_URL env var; default: https://api.github.com)
+  --ci-provider   CI system to publish results to: auto, github, gitlab, azure,
+                  buildkite, circleci or none (default: auto)
+
+Merge flags:
+  --format, --report-file, --fail-on, the --pr-* and --github-* flags and
+  --ci-provider as for run, followed by the JSON reports of the shards
 
 Examples:
   qmax ci run --project-id 42 --all
   qmax ci run --project-id 42 --script-ids 1,2,3 --base-url https://staging.app.com
+  qmax ci run --project-id 42 --all --format junit > results.xml
+  qmax ci run --project-id 42 --all --report-file junit=results.xml --report-file summary.md
+  qmax ci run --project-id 42 --all --local --base-url http://localhost:3000
+  qmax ci run --project-id 42 --tag smoke --priority 1-2 --exclude 'Flaky*'
+  qmax ci run --project-id 42 --changed-since origin/main --repo-id 101
+  qmax ci run --project-id 42 --all --retries 2 --quarantine .qmax-quarantine
+  qmax ci run --project-id 42 --all --shard 2/4 --durations-file durations.json --format json > shard-2.json
+  qmax ci merge --report-file junit=results.xml shard-*.json
+  qmax ci run --project-id 42 --all --compare-to last-main --fail-on new-failures
+  qmax ci run --project-id 42 --all --pr-comment
+  qmax ci run --project-id 42 --all --artifacts-dir ./qmax-artifacts
   QMAX_TOKEN=xxx qmax ci run --project-id 42 --all`)
 }
 
@@ -93,18 +181,134 @@ func cmdCIRun(args []string) {
 	headless := fs.Bool("headless", true, "Run in headless mode")
 	browser := fs.String("browser", "chromium", "Browser to use")
 	timeout := fs.Int("timeout", defaultCITimeout, "Overall timeout in seconds")
-	format := fs.String("format", "markdown", "Output format: markdown or json")
+	format := fs.String("format", "markdown", "Output format: markdown, json, junit, tap or sarif-like")
+	var reportFileArgs stringListFlag
+	fs.Var(&reportFileArgs, "report-file", "Write a report to a file: format=path or a .md/.json/.xml/.tap/.sarif path (repeatable)")
+	parallel := fs.Int("parallel", defaultCIParallel, "Maximum concurrent API calls when starting and polling executions")
+	batch := fs.Bool("batch", false, "Start all scripts with one call to the batch execution endpoint, if the server has it")
+	local := fs.Bool("local", false, "Run the scripts on this machine with Playwright instead of the cloud executor")
+	reportToCloud := fs.Bool("report-to-cloud", false, "With --local, record the results in the cloud")
+	var tags, categories, excludes stringListFlag
+	fs.Var(&tags, "tag", "Run scripts with this tag on the script or its test case (repeatable)")
+	fs.Var(&categories, "category", "Run scripts whose test case has this category (repeatable)")
+	priorityStr := fs.String("priority", "", "Run scripts whose test case has one of these priorities, e.g. 1,2 or 1-2")
+	fs.Var(&excludes, "exclude", "Skip a script ID or scripts whose name matches a glob (repeatable)")
+	changedSince := fs.String("changed-since", "", "Run only scripts covering files changed since this git ref (needs --repo-id)")
+	repoID := fs.Int("repo-id", 0, "Repository whose coverage data maps changed files to scripts")
+	retries := fs.Int("retries", 0, "Re-run failed scripts up to this many more times, reporting passes on retry as flaky")
+	quarantineFile := fs.String("quarantine", "", "File of script IDs or name globs whose failures do not fail the build")
+	projectQuarantine := fs.Bool("project-quarantine", false, "Also quarantine the scripts listed in the project's CI settings")
+	shardStr := fs.String("shard", "", "Run only part i of n of the selected scripts, e.g. 2/4")
+	durationsFile := fs.String("durations-file", "", "JSON report of an earlier run used to balance --shard by duration")
+	compareTo := fs.String("compare-to", "", "Baseline to compare with: a JSON report file or last-main")
+	baselineBranch := fs.String("baseline-branch", "main", "Branch whose latest run --compare-to last-main uses")
+	recordBaseline := fs.Bool("record-baseline", false, "Record this run as the latest run of --baseline-branch")
+	failOn := fs.String("fail-on", "any", "When to fail the build: any or new-failures")
+	artifactsDir := fs.String("artifacts-dir", "", "Download screenshots, videos and traces into this directory")
+	artifactsFor := fs.String("artifacts-for", "failed", "Executions to download artifacts for: failed or all")
+	githubFlags := registerCIGitHubFlags(fs)
+	ciProviderName := fs.String("ci-provider", "auto", "CI system to publish results to: auto, github, gitlab, azure, buildkite, circleci or none")
 
 	_ = fs.Parse(args)
 
+	if *parallel < 1 {
+		fmt.Fprintln(os.Stderr, "Error: --parallel must be at least 1")
+		os.Exit(1)
+	}
+	if *retries < 0 {
+		fmt.Fprintln(os.Stderr, "Error: --retries cannot be negative")
+		os.Exit(1)
+	}
+	if *reportToCloud && !*local {
+		fmt.Fprintln(os.Stderr, "Error: --report-to-cloud requires --local")
+		os.Exit(1)
+	}
+	if *local && *batch {
+		fmt.Fprintln(os.Stderr, "Error: --batch cannot be used with --local")
+		os.Exit(1)
+	}
+	if *recordBaseline && *shardStr != "" {
+		fmt.Fprintln(os.Stderr, "Error: --record-baseline records a whole run and cannot be used with --shard")
+		os.Exit(1)
+	}
+	if !isCIReportFormat(*format) {
+		fmt.Fprintf(os.Stderr, "Error: unknown --format %q (use %s)\n", *format, strings.Join(ciReportFormats, ", "))
+		os.Exit(1)
+	}
+	var reportFiles []ciReportFile
+	for _, v := range reportFileArgs {
+		rf, err := parseCIReportFile(v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		reportFiles = append(reportFiles, rf)
+	}
+	switch *failOn {
+	case "any":
+	case "new-failures":
+		if *compareTo == "" {
+			fmt.Fprintln(os.Stderr, "Error: --fail-on new-failures requires --compare-to")
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown --fail-on %q (use %s)\n", *failOn, strings.Join(ciFailOnModes, ", "))
+		os.Exit(1)
+	}
+	if *artifactsFor != "failed" && *artifactsFor != "all" {
+		fmt.Fprintf(os.Stderr, "Error: unknown --artifacts-for %q (use failed or all)\n", *artifactsFor)
+		os.Exit(1)
+	}
+	var shard ciShard
+	if *shardStr != "" {
+		var err error
+		if shard, err = parseCIShard(*shardStr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	} else if *durationsFile != "" {
+		fmt.Fprintln(os.Stderr, "Error: --durations-file requires --shard")
+		os.Exit(1)
+	}
+	var quarantine []string
+	if *quarantineFile != "" {
+		var err error
+		if quarantine, err = loadCIQuarantine(*quarantineFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading --quarantine: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	provider, err := newCIProvider(*ciProviderName, os.Getenv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	prPublisher, err := githubFlags.publisher(os.Getenv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	if *projectID == 0 {
 		fmt.Fprintln(os.Stderr, "Error: --project-id is required")
 		fs.Usage()
 		os.Exit(1)
 	}
 
-	if *scriptIDsStr == "" && !*allScripts {
-		fmt.Fprintln(os.Stderr, "Error: either --script-ids or --all is required")
+	var priorities []int
+	if *priorityStr != "" {
+		if priorities, err = ciParsePriorities(*priorityStr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing --priority: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *changedSince != "" && *repoID == 0 {
+		fmt.Fprintln(os.Stderr, "Error: --changed-since requires --repo-id for the repository coverage data")
+		os.Exit(1)
+	}
+	hasSelector := len(tags) > 0 || len(categories) > 0 || len(priorities) > 0 || *changedSince != ""
+	if *scriptIDsStr == "" && !*allScripts && !hasSelector {
+		fmt.Fprintln(os.Stderr, "Error: either --script-ids, --all or a selector (--tag, --category, --priority, --changed-since) is required")
 		fs.Usage()
 		os.Exit(1)
 	}
@@ -146,138 +350,287 @@ func cmdCIRun(args []string) {
 
 	// Resolve script IDs
 	var scriptIDs []int
-	if *allScripts {
-		scriptIDs, err = ciFetchProjectScripts(client, resolvedAPIURL, resolvedToken, *projectID)
+	sel := ciSelection{
+		Selector: ciSelector{
+			Tags:       tags,
+			Categories: categories,
+			Priorities: priorities,
+			Exclude:    excludes,
+		},
+		ChangedSince: *changedSince,
+		RepoID:       *repoID,
+	}
+	if !*allScripts && *scriptIDsStr != "" {
+		sel.ScriptIDs, err = ciParseIntList(*scriptIDsStr)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error fetching project scripts: %v\n", err)
-			os.Exit(1)
-		}
-		if len(scriptIDs) == 0 {
-			fmt.Fprintln(os.Stderr, "Error: no scripts found in project")
+			fmt.Fprintf(os.Stderr, "Error parsing --script-ids: %v\n", err)
 			os.Exit(1)
 		}
+	}
+	scriptIDs, err = resolveCIScripts(client, resolvedAPIURL, resolvedToken, *projectID, sel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error selecting scripts: %v\n", err)
+		os.Exit(1)
+	}
+	if sel.ScriptIDs == nil && sel.Selector.empty() && sel.ChangedSince == "" {
 		fmt.Fprintf(os.Stderr, "Found %d scripts in project #%d\n", len(scriptIDs), *projectID)
-	} else {
-		scriptIDs, err = ciParseIntList(*scriptIDsStr)
+	}
+
+	if shard.Total > 0 {
+		var durations map[int]float64
+		if *durationsFile != "" {
+			durations, err = loadCIDurations(*durationsFile)
+			if os.IsNotExist(err) {
+				// A cold CI cache: split by ID this time
+				fmt.Fprintf(os.Stderr, "No durations file at %s, splitting scripts by ID\n", *durationsFile)
+			} else if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading --durations-file: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		selected := len(scriptIDs)
+		scriptIDs = shardCIScripts(scriptIDs, shard, durations)
+		fmt.Fprintf(os.Stderr, "Shard %s: %d of %d script(s)\n", shard, len(scriptIDs), selected)
+	}
+
+	if *projectQuarantine {
+		patterns, err := ciFetchQuarantine(client, resolvedAPIURL, resolvedToken, *projectID)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error parsing --script-ids: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Error fetching the project quarantine: %v\n", err)
 			os.Exit(1)
 		}
+		quarantine = append(quarantine, patterns...)
 	}
 
-	// Execute tests
-	fmt.Fprintf(os.Stderr, "Executing %d test(s)...\n", len(scriptIDs))
+	var artifacts *ciArtifactStore
+	if *artifactsDir != "" {
+		artifacts = newCIArtifactStore(*artifactsDir, *artifactsFor == "all", client, resolvedAPIURL, resolvedToken)
+	}
+
+	// Execute tests, sharing one deadline between the first run and retries;
+	// no retry round starts once it has passed
+	deadline := time.Now().Add(time.Duration(*timeout) * time.Second)
+	var runScripts ciRunFunc
+	if *local {
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		defer cancel()
+		agent := &Agent{CloudURL: resolvedAPIURL, client: client}
+		opts := ciLocalOptions{
+			ProjectID:     *projectID,
+			BaseURL:       *baseURL,
+			Headless:      *headless,
+			Browser:       *browser,
+			ReportToCloud: *reportToCloud,
+			Artifacts:     artifacts,
+		}
+		runScripts = func(ids []int) ([]ciTestResult, error) {
+			fmt.Fprintf(os.Stderr, "Running %d test(s) locally...\n", len(ids))
+			return ciRunLocal(ctx, client, resolvedAPIURL, resolvedToken, ids, &opts, agent.runTest), nil
+		}
+	} else {
+		runScripts = func(ids []int) ([]ciTestResult, error) {
+			fmt.Fprintf(os.Stderr, "Executing %d test(s)...\n", len(ids))
+
+			var executionIDs []ciExecution
+			err := errCIBatchUnavailable
+			if *batch {
+				executionIDs, err = ciExecuteBatch(client, resolvedAPIURL, resolvedToken, ids, *baseURL, *headless, *browser)
+				if errors.Is(err, errCIBatchUnavailable) {
+					fmt.Fprintln(os.Stderr, "Batch endpoint not available, starting scripts individually")
+				}
+			}
+			if errors.Is(err, errCIBatchUnavailable) {
+				executionIDs, err = ciExecuteTests(client, resolvedAPIURL, resolvedToken, ids, *baseURL, *headless, *browser, *parallel)
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			// Poll for results
+			results := ciPollAllExecutions(client, resolvedAPIURL, resolvedToken, executionIDs, deadline, *parallel)
+			if artifacts != nil {
+				artifacts.downloadAll(results, *parallel)
+			}
+			return results, nil
+		}
+	}
 
-	executionIDs, err := ciExecuteTests(client, resolvedAPIURL, resolvedToken, scriptIDs, *baseURL, *headless, *browser)
+	results, err := runScripts(scriptIDs)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error starting test executions: %v\n", err)
 		os.Exit(1)
 	}
-
-	// Poll for results
-	deadline := time.Now().Add(time.Duration(*timeout) * time.Second)
-	results := ciPollAllExecutions(client, resolvedAPIURL, resolvedToken, executionIDs, deadline)
+	results = ciRetryFailed(results, *retries, deadline, runScripts)
+	applyCIQuarantine(results, quarantine)
+
+	if *compareTo != "" {
+		baseline, err := loadCIBaseline(client, resolvedAPIURL, resolvedToken, *projectID, *compareTo, *baselineBranch)
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Warning: no baseline to compare with, every failure counts: %v\n", err)
+		case baseline == nil:
+			fmt.Fprintf(os.Stderr, "Warning: no run of %s recorded with --record-baseline yet, every failure counts\n", *baselineBranch)
+		default:
+			applyCIBaseline(results, baseline)
+		}
+	}
 
 	totalDuration := time.Since(startTime).Seconds()
+	if *recordBaseline {
+		if err := recordCIBaseline(client, resolvedAPIURL, resolvedToken, *projectID, *baselineBranch, results, totalDuration); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not record this run as the baseline of %s: %v\n", *baselineBranch, err)
+		} else {
+			fmt.Fprintf(os.Stderr, "Recorded this run as the latest run of %s\n", *baselineBranch)
+		}
+	}
 
 	// Generate output
-	switch *format {
-	case "json":
-		ciOutputJSON(results, *projectID, totalDuration)
-	default:
-		ciOutputMarkdown(results, *projectID, totalDuration)
+	if err := ciWriteReport(os.Stdout, *format, results, *projectID, totalDuration); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing %s report: %v\n", *format, err)
+	}
+	for _, rf := range reportFiles {
+		if err := ciWriteReportFile(rf, results, *projectID, totalDuration); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing %s report to %s: %v\n", rf.Format, rf.Path, err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Wrote %s report to %s\n", rf.Format, rf.Path)
 	}
 
-	// Write GitHub Actions outputs
-	ciWriteGitHubOutputs(results, *projectID, totalDuration)
+	// Publish to the CI system
+	summary := ciRunSummary{ProjectID: *projectID, Results: results, TotalDuration: totalDuration}
+	if provider != nil {
+		if err := provider.Publish(summary); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not publish results to %s: %v\n", provider.Name(), err)
+		}
+	}
 
-	// Exit code
-	for _, r := range results {
-		if r.Status != "passed" {
-			os.Exit(1)
+	// Post to the pull request; its status follows the exit code
+	failsBuild := ciFailsBuild(results, *failOn)
+	if prPublisher != nil {
+		if err := prPublisher.Publish(summary, failsBuild); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not post results to the pull request: %v\n", err)
 		}
 	}
+
+	// Exit code
+	if failsBuild {
+		os.Exit(1)
+	}
 }
 
-// ciFetchProjectScripts fetches all script IDs for a project.
-func ciFetchProjectScripts(client *http.Client, apiURL, token string, projectID int) ([]int, error) {
-	url := fmt.Sprintf("%s/api/automation/scripts/project/%d?limit=500", apiURL, projectID)
-	body, err := ciAuthGet(client, url, token)
+// ciExecuteTests starts execution for each script, at most parallel at a
+// time, and returns execution IDs in script order.
+func ciExecuteTests(client *http.Client, apiURL, token string, scriptIDs []int, baseURL string, headless bool, browser string, parallel int) ([]ciExecution, error) {
+	executions := make([]ciExecution, len(scriptIDs))
+	ciForEach(len(scriptIDs), parallel, func(i int) {
+		executions[i] = ciStartExecution(client, apiURL, token, scriptIDs[i], baseURL, headless, browser)
+	})
+	return executions, nil
+}
+
+// ciStartExecution starts one script. Failures are recorded on the
+// execution rather than returned, so other scripts still run.
+func ciStartExecution(client *http.Client, apiURL, token string, sid int, baseURL string, headless bool, browser string) ciExecution {
+	url := fmt.Sprintf("%s/api/playwright-execution/run/%d", apiURL, sid)
+
+	payload := map[string]interface{}{
+		"headless": headless,
+		"browser":  browser,
+	}
+	if baseURL != "" {
+		payload["base_url"] = baseURL
+	}
+
+	body, err := ciAuthPost(client, url, token, payload)
 	if err != nil {
-		return nil, err
+		return ciExecution{ScriptID: sid, Error: err.Error()}
 	}
 
 	var resp struct {
-		Scripts []struct {
-			ID   int    `json:"id"`
-			Name string `json:"name"`
-		} `json:"scripts"`
+		Success     bool   `json:"success"`
+		ExecutionID string `json:"execution_id"`
+		Message     string `json:"message"`
 	}
 	if err := json.Unmarshal(body, &resp); err != nil {
-		return nil, fmt.Errorf("parse scripts response: %w", err)
+		return ciExecution{ScriptID: sid, Error: fmt.Sprintf("parse response: %v", err)}
 	}
 
-	ids := make([]int, len(resp.Scripts))
-	for i, s := range resp.Scripts {
-		ids[i] = s.ID
+	if !resp.Success {
+		return ciExecution{ScriptID: sid, Error: resp.Message}
 	}
-	return ids, nil
-}
 
-// ciExecuteTests starts execution for each script and returns execution IDs.
-func ciExecuteTests(client *http.Client, apiURL, token string, scriptIDs []int, baseURL string, headless bool, browser string) ([]ciExecution, error) {
-	var executions []ciExecution
-
-	for _, sid := range scriptIDs {
-		url := fmt.Sprintf("%s/api/playwright-execution/run/%d", apiURL, sid)
+	fmt.Fprintf(os.Stderr, "  Started script #%d -> execution %s\n", sid, resp.ExecutionID)
+	return ciExecution{ScriptID: sid, ExecutionID: resp.ExecutionID}
+}
 
-		payload := map[string]interface{}{
-			"headless": headless,
-			"browser":  browser,
-		}
-		if baseURL != "" {
-			payload["base_url"] = baseURL
-		}
+// errCIBatchUnavailable means the server has no batch endpoint.
+var errCIBatchUnavailable = errors.New("batch execution endpoint not available")
+
+// ciExecuteBatch starts all scripts with one call to the batch endpoint. It
+// returns errCIBatchUnavailable when the server does not have the endpoint,
+// so the caller can fall back to starting scripts one by one. A batch that
+// started without listing its executions cannot be polled; that is an error
+// for the whole run rather than a failure of every script, and the scripts
+// are not started a second time. Scripts missing from a list that is there
+// are recorded as errors.
+func ciExecuteBatch(client *http.Client, apiURL, token string, scriptIDs []int, baseURL string, headless bool, browser string) ([]ciExecution, error) {
+	url := fmt.Sprintf("%s/api/automation/execute-batch", apiURL)
+
+	payload := map[string]interface{}{
+		"script_ids": scriptIDs,
+		"headless":   headless,
+		"browser":    browser,
+	}
+	if baseURL != "" {
+		payload["custom_url"] = baseURL
+	}
 
-		body, err := ciAuthPost(client, url, token, payload)
-		if err != nil {
-			// Record as error but continue with other scripts
-			executions = append(executions, ciExecution{
-				ScriptID:    sid,
-				ExecutionID: "",
-				Error:       err.Error(),
-			})
-			continue
+	body, err := ciAuthPost(client, url, token, payload)
+	if err != nil {
+		var httpErr *ciHTTPError
+		if errors.As(err, &httpErr) {
+			switch httpErr.StatusCode {
+			case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+				return nil, errCIBatchUnavailable
+			}
 		}
+		return nil, err
+	}
 
-		var resp struct {
-			Success     bool   `json:"success"`
+	var resp struct {
+		Success    bool   `json:"success"`
+		Message    string `json:"message"`
+		Executions []struct {
+			ScriptID    int    `json:"script_id"`
 			ExecutionID string `json:"execution_id"`
-			Message     string `json:"message"`
-		}
-		if err := json.Unmarshal(body, &resp); err != nil {
-			executions = append(executions, ciExecution{
-				ScriptID: sid,
-				Error:    fmt.Sprintf("parse response: %v", err),
-			})
-			continue
-		}
+			Error       string `json:"error"`
+		} `json:"executions"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("parse batch response: %w", err)
+	}
+	if !resp.Success {
+		return nil, fmt.Errorf("batch execution failed: %s", resp.Message)
+	}
+	if len(resp.Executions) == 0 {
+		return nil, errors.New("the batch endpoint did not return execution IDs, so results cannot be collected; run without --batch")
+	}
 
-		if !resp.Success {
-			executions = append(executions, ciExecution{
-				ScriptID: sid,
-				Error:    resp.Message,
-			})
-			continue
+	byScript := map[int]ciExecution{}
+	for _, e := range resp.Executions {
+		byScript[e.ScriptID] = ciExecution{ScriptID: e.ScriptID, ExecutionID: e.ExecutionID, Error: e.Error}
+	}
+	executions := make([]ciExecution, len(scriptIDs))
+	for i, sid := range scriptIDs {
+		e, ok := byScript[sid]
+		if !ok || (e.ExecutionID == "" && e.Error == "") {
+			e = ciExecution{ScriptID: sid, Error: "batch execution returned no execution ID for this script"}
 		}
-
-		fmt.Fprintf(os.Stderr, "  Started script #%d -> execution %s\n", sid, resp.ExecutionID)
-		executions = append(executions, ciExecution{
-			ScriptID:    sid,
-			ExecutionID: resp.ExecutionID,
-		})
+		if e.ExecutionID != "" {
+			fmt.Fprintf(os.Stderr, "  Started script #%d -> execution %s\n", sid, e.ExecutionID)
+		}
+		executions[i] = e
 	}
-
 	return executions, nil
 }
 
@@ -287,8 +640,12 @@ type ciExecution struct {
 	Error       string
 }
 
-// ciPollAllExecutions polls all executions until they complete or timeout.
-func ciPollAllExecutions(client *http.Client, apiURL, token string, executions []ciExecution, deadline time.Time) []ciTestResult {
+// ciPollInterval is the pause between polling rounds.
+var ciPollInterval = 5 * time.Second
+
+// ciPollAllExecutions polls all executions until they complete or timeout,
+// checking at most parallel executions at a time in each round.
+func ciPollAllExecutions(client *http.Client, apiURL, token string, executions []ciExecution, deadline time.Time, parallel int) []ciTestResult {
 	results := make([]ciTestResult, len(executions))
 
 	// Initialize results for executions that already errored
@@ -302,11 +659,8 @@ func ciPollAllExecutions(client *http.Client, apiURL, token string, executions [
 		}
 	}
 
-	// Poll active executions
-	pollInterval := 5 * time.Second
-
 	for {
-		allDone := true
+		var pending []int
 		for i, exec := range executions {
 			if exec.Error != "" || exec.ExecutionID == "" {
 				continue // already done
@@ -314,13 +668,22 @@ func ciPollAllExecutions(client *http.Client, apiURL, token string, executions [
 			if results[i].Status != "" {
 				continue // already resolved
 			}
+			pending = append(pending, i)
+		}
+
+		if len(pending) == 0 {
+			break
+		}
 
-			allDone = false
+		// Each worker writes only its own results[i]
+		ciForEach(len(pending), parallel, func(j int) {
+			i := pending[j]
+			exec := executions[i]
 
 			status, err := ciGetExecutionStatus(client, apiURL, token, exec.ExecutionID)
 			if err != nil {
 				// Transient error, keep polling
-				continue
+				return
 			}
 
 			if status.isTerminal() {
@@ -332,6 +695,12 @@ func ciPollAllExecutions(client *http.Client, apiURL, token string, executions [
 					Error:       status.ErrorMessage,
 					ExecutionID: exec.ExecutionID,
 				}
+				if results[i].Status == "visual_diff" && results[i].Error == "" {
+					results[i].Error = "screenshots differ from the approved baselines"
+				}
+				if results[i].Status == "degraded" && results[i].Error == "" {
+					results[i].Error = "pages exceeded the performance budget"
+				}
 				statusIcon := "PASS"
 				if results[i].Status != "passed" {
 					statusIcon = "FAIL"
@@ -339,11 +708,7 @@ func ciPollAllExecutions(client *http.Client, apiURL, token string, executions [
 				fmt.Fprintf(os.Stderr, "  [%s] Script #%d (%s) - %.1fs\n",
 					statusIcon, exec.ScriptID, results[i].ScriptName, status.Duration)
 			}
-		}
-
-		if allDone {
-			break
-		}
+		})
 
 		if time.Now().After(deadline) {
 			// Timeout remaining executions
@@ -360,12 +725,45 @@ func ciPollAllExecutions(client *http.Client, apiURL, token string, executions [
 			break
 		}
 
-		time.Sleep(pollInterval)
+		if ciAllResolved(executions, results) {
+			break
+		}
+		time.Sleep(ciPollInterval)
 	}
 
 	return results
 }
 
+// ciAllResolved reports whether every started execution has a result.
+func ciAllResolved(executions []ciExecution, results []ciTestResult) bool {
+	for i, exec := range executions {
+		if exec.Error == "" && exec.ExecutionID != "" && results[i].Status == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// ciForEach calls fn for 0..n-1 with at most parallel calls running at once
+// and returns when all have finished.
+func ciForEach(n, parallel int, fn func(i int)) {
+	if parallel < 1 {
+		parallel = 1
+	}
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
 type ciExecutionStatus struct {
 	Status       string  `json:"status"`
 	Progress     int     `json:"progress"`
@@ -375,6 +773,10 @@ type ciExecutionStatus struct {
 	ErrorMessage string  `json:"error_message"`
 	Errors       []string `json:"errors"`
 	TestErrors   string  `json:"test_errors"`
+	Category     string  `json:"category"`
+	Screenshots  []string `json:"screenshots"`
+	VideoURL     string   `json:"video_url"`
+	TraceURL     string   `json:"trace_url"`
 }
 
 func (s *ciExecutionStatus) isTerminal() bool {
@@ -391,6 +793,9 @@ func (s *ciExecutionStatus) resultStatus() string {
 		if len(s.Errors) > 0 || s.TestErrors != "" {
 			return "failed"
 		}
+		if s.Category == "visual_diff" || s.Category == "degraded" {
+			return s.Category
+		}
 		return "passed"
 	}
 	return "failed"
@@ -420,19 +825,75 @@ func ciGetExecutionStatus(client *http.Client, apiURL, token, executionID string
 	return &status, nil
 }
 
-// ciOutputMarkdown generates a markdown summary of the test results.
-func ciOutputMarkdown(results []ciTestResult, projectID int, totalDuration float64) {
-	var sb strings.Builder
+// ciStatusLabel returns the status column text for a result.
+func ciStatusLabel(status string) string {
+	switch status {
+	case "passed":
+		return "\u2705 Passed"
+	case "visual_diff":
+		return "\U0001f5bc Visual diff"
+	case "degraded":
+		return "\u26a0\ufe0f Degraded"
+	default:
+		return "\u274c Failed"
+	}
+}
+
+// ciResultLabel returns the status column text for a result, noting flaky
+// and quarantined tests.
+func ciResultLabel(r ciTestResult) string {
+	label := ciStatusLabel(r.Status)
+	switch {
+	case r.Flaky:
+		label += fmt.Sprintf(" (flaky, %d attempts)", r.Attempts)
+	case r.Quarantined:
+		label += " (quarantined)"
+	}
+	return label
+}
+
+// ciMarkdownExtraCounts returns the summary line fields for flaky and
+// quarantined tests, or "" when there are none.
+func ciMarkdownExtraCounts(c ciCounts) string {
+	var s string
+	if c.Flaky > 0 {
+		s += fmt.Sprintf(" | **Flaky:** %d", c.Flaky)
+	}
+	if c.Quarantined > 0 {
+		s += fmt.Sprintf(" | **Quarantined:** %d", c.Quarantined)
+	}
+	return s
+}
 
-	passed := 0
-	failed := 0
+// ciMarkdownFlakySection lists the tests that passed on a retry with the
+// error of their first attempt, or returns "" when there are none.
+func ciMarkdownFlakySection(results []ciTestResult) string {
+	var sb strings.Builder
 	for _, r := range results {
-		if r.Status == "passed" {
-			passed++
-		} else {
-			failed++
+		if !r.Flaky {
+			continue
+		}
+		if sb.Len() == 0 {
+			sb.WriteString("\n### \u26a0\ufe0f Flaky Tests\n\n")
 		}
+		errMsg := r.Error
+		if errMsg == "" {
+			errMsg = "Unknown error"
+		}
+		if len(errMsg) > 500 {
+			errMsg = errMsg[:500] + "..."
+		}
+		sb.WriteString(fmt.Sprintf("**%s** passed on attempt %d, first failure:\n```\n%s\n```\n\n", ciTestName(r), r.Attempts, errMsg))
 	}
+	return sb.String()
+}
+
+// ciOutputMarkdown generates a markdown summary of the test results.
+func ciOutputMarkdown(w io.Writer, results []ciTestResult, projectID int, totalDuration float64) {
+	var sb strings.Builder
+
+	c := countCIResults(results)
+	failed := c.Failed
 
 	overallStatus := "Passed"
 	statusIcon := "\u2705"
@@ -442,8 +903,8 @@ func ciOutputMarkdown(results []ciTestResult, projectID int, totalDuration float
 	}
 
 	sb.WriteString("## \U0001f9ea QualityMax Test Results\n\n")
-	sb.WriteString(fmt.Sprintf("**Project:** #%d | **Status:** %s %s | **Duration:** %.1fs\n\n",
-		projectID, statusIcon, overallStatus, totalDuration))
+	sb.WriteString(fmt.Sprintf("**Project:** #%d | **Status:** %s %s | **Duration:** %.1fs%s\n\n",
+		projectID, statusIcon, overallStatus, totalDuration, ciMarkdownExtraCounts(c)))
 
 	sb.WriteString("| Test | Status | Duration |\n")
 	sb.WriteString("|------|--------|----------|\n")
@@ -453,15 +914,12 @@ func ciOutputMarkdown(results []ciTestResult, projectID int, totalDuration float
 		if name == "" {
 			name = fmt.Sprintf("Script #%d", r.ScriptID)
 		}
-		icon := "\u2705 Passed"
-		if r.Status != "passed" {
-			icon = "\u274c Failed"
-		}
+		icon := ciResultLabel(r)
 		sb.WriteString(fmt.Sprintf("| %s | %s | %.1fs |\n", name, icon, r.Duration))
 	}
 
 	// Failed test details
-	if failed > 0 {
+	if failed+c.Quarantined > 0 {
 		sb.WriteString("\n### \u274c Failed Tests\n\n")
 		for _, r := range results {
 			if r.Status == "passed" {
@@ -471,6 +929,9 @@ func ciOutputMarkdown(results []ciTestResult, projectID int, totalDuration float
 			if name == "" {
 				name = fmt.Sprintf("Script #%d", r.ScriptID)
 			}
+			if r.Quarantined {
+				name += " (quarantined)"
+			}
 			errMsg := r.Error
 			if errMsg == "" {
 				errMsg = "Unknown error"
@@ -482,63 +943,46 @@ func ciOutputMarkdown(results []ciTestResult, projectID int, totalDuration float
 			sb.WriteString(fmt.Sprintf("**%s** (%.1fs)\n```\n%s\n```\n\n", name, r.Duration, errMsg))
 		}
 	}
+	sb.WriteString(ciMarkdownFlakySection(results))
+	sb.WriteString(ciMarkdownComparisonSection(results))
 
 	sb.WriteString("---\n*Powered by [QualityMax](https://qualitymax.io) — AI-powered test automation*\n")
 
 	summary := sb.String()
-	fmt.Print(summary)
+	fmt.Fprint(w, summary)
 }
 
 // ciOutputJSON generates JSON output of the test results.
-func ciOutputJSON(results []ciTestResult, projectID int, totalDuration float64) {
-	passed := 0
-	failed := 0
-	for _, r := range results {
-		if r.Status == "passed" {
-			passed++
-		} else {
-			failed++
-		}
-	}
-
-	status := "passed"
-	if failed > 0 {
-		status = "failed"
-	}
+func ciOutputJSON(w io.Writer, results []ciTestResult, projectID int, totalDuration float64) {
+	c := countCIResults(results)
 
 	output := map[string]interface{}{
-		"project_id": projectID,
-		"status":     status,
-		"total":      len(results),
-		"passed":     passed,
-		"failed":     failed,
-		"duration":   totalDuration,
-		"results":    results,
+		"project_id":  projectID,
+		"status":      c.status(),
+		"total":       len(results),
+		"passed":      c.Passed,
+		"failed":      c.Failed,
+		"flaky":       c.Flaky,
+		"quarantined": c.Quarantined,
+		"duration":    totalDuration,
+		"results":     results,
+	}
+	if cmp := compareCIResults(results); cmp != nil {
+		output["comparison"] = cmp
 	}
 
 	data, _ := json.MarshalIndent(output, "", "  ")
-	fmt.Println(string(data))
+	fmt.Fprintln(w, string(data))
 }
 
 // ciWriteGitHubOutputs writes outputs for GitHub Actions.
-func ciWriteGitHubOutputs(results []ciTestResult, projectID int, totalDuration float64) {
-	passed := 0
-	failed := 0
-	for _, r := range results {
-		if r.Status == "passed" {
-			passed++
-		} else {
-			failed++
-		}
-	}
-
-	status := "passed"
-	if failed > 0 {
-		status = "failed"
-	}
+func ciWriteGitHubOutputs(getenv func(string) string, results []ciTestResult, projectID int, totalDuration float64) {
+	c := countCIResults(results)
+	passed, failed := c.Passed, c.Failed
+	status := c.status()
 
 	// Write to GITHUB_OUTPUT
-	if ghOutput := os.Getenv("GITHUB_OUTPUT"); ghOutput != "" {
+	if ghOutput := getenv("GITHUB_OUTPUT"); ghOutput != "" {
 		f, err := os.OpenFile(ghOutput, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err == nil {
 			defer f.Close()
@@ -546,6 +990,12 @@ func ciWriteGitHubOutputs(results []ciTestResult, projectID int, totalDuration f
 			fmt.Fprintf(f, "total=%d\n", len(results))
 			fmt.Fprintf(f, "passed=%d\n", passed)
 			fmt.Fprintf(f, "failed=%d\n", failed)
+			fmt.Fprintf(f, "flaky=%d\n", c.Flaky)
+			fmt.Fprintf(f, "quarantined=%d\n", c.Quarantined)
+			if cmp := compareCIResults(results); cmp != nil {
+				fmt.Fprintf(f, "new_failures=%d\n", cmp.NewFailures)
+				fmt.Fprintf(f, "fixed=%d\n", cmp.Fixed)
+			}
 			fmt.Fprintf(f, "duration=%.1f\n", totalDuration)
 
 			// For multiline summary, use heredoc delimiter
@@ -558,15 +1008,15 @@ func ciWriteGitHubOutputs(results []ciTestResult, projectID int, totalDuration f
 				statusIcon = "\u274c"
 				overallLabel = "Failed"
 			}
-			sb.WriteString(fmt.Sprintf("**Project:** #%d | **Status:** %s %s | **Passed:** %d/%d | **Duration:** %.1fs",
-				projectID, statusIcon, overallLabel, passed, len(results), totalDuration))
+			sb.WriteString(fmt.Sprintf("**Project:** #%d | **Status:** %s %s | **Passed:** %d/%d | **Duration:** %.1fs%s",
+				projectID, statusIcon, overallLabel, passed, len(results), totalDuration, ciMarkdownExtraCounts(c)))
 			fmt.Fprintf(f, "%s\n", sb.String())
 			fmt.Fprintf(f, "QMAX_EOF\n")
 		}
 	}
 
 	// Write to GITHUB_STEP_SUMMARY
-	if ghSummary := os.Getenv("GITHUB_STEP_SUMMARY"); ghSummary != "" {
+	if ghSummary := getenv("GITHUB_STEP_SUMMARY"); ghSummary != "" {
 		f, err := os.OpenFile(ghSummary, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err == nil {
 			defer f.Close()
@@ -579,8 +1029,8 @@ func ciWriteGitHubOutputs(results []ciTestResult, projectID int, totalDuration f
 			}
 
 			fmt.Fprintf(f, "## \U0001f9ea QualityMax Test Results\n\n")
-			fmt.Fprintf(f, "**Project:** #%d | **Status:** %s %s | **Duration:** %.1fs\n\n",
-				projectID, statusIcon, overallLabel, totalDuration)
+			fmt.Fprintf(f, "**Project:** #%d | **Status:** %s %s | **Duration:** %.1fs%s\n\n",
+				projectID, statusIcon, overallLabel, totalDuration, ciMarkdownExtraCounts(c))
 			fmt.Fprintf(f, "| Test | Status | Duration |\n")
 			fmt.Fprintf(f, "|------|--------|----------|\n")
 
@@ -589,14 +1039,11 @@ func ciWriteGitHubOutputs(results []ciTestResult, projectID int, totalDuration f
 				if name == "" {
 					name = fmt.Sprintf("Script #%d", r.ScriptID)
 				}
-				icon := "\u2705 Passed"
-				if r.Status != "passed" {
-					icon = "\u274c Failed"
-				}
+				icon := ciResultLabel(r)
 				fmt.Fprintf(f, "| %s | %s | %.1fs |\n", name, icon, r.Duration)
 			}
 
-			if failed > 0 {
+			if failed+c.Quarantined > 0 {
 				fmt.Fprintf(f, "\n### \u274c Failed Tests\n\n")
 				for _, r := range results {
 					if r.Status == "passed" {
@@ -606,6 +1053,9 @@ func ciWriteGitHubOutputs(results []ciTestResult, projectID int, totalDuration f
 					if name == "" {
 						name = fmt.Sprintf("Script #%d", r.ScriptID)
 					}
+					if r.Quarantined {
+						name += " (quarantined)"
+					}
 					errMsg := r.Error
 					if errMsg == "" {
 						errMsg = "Unknown error"
@@ -616,6 +1066,8 @@ func ciWriteGitHubOutputs(results []ciTestResult, projectID int, totalDuration f
 					fmt.Fprintf(f, "**%s** (%.1fs)\n```\n%s\n```\n\n", name, r.Duration, errMsg)
 				}
 			}
+			fmt.Fprint(f, ciMarkdownFlakySection(results))
+			fmt.Fprint(f, ciMarkdownComparisonSection(results))
 
 			fmt.Fprintf(f, "\n---\n*Powered by [QualityMax](https://qualitymax.io) — AI-powered test automation*\n")
 		}
@@ -643,7 +1095,7 @@ func ciAuthGet(client *http.Client, url, token string) ([]byte, error) {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+		return nil, &ciHTTPError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	return body, nil
@@ -673,13 +1125,23 @@ func ciAuthPost(client *http.Client, url, token string, payload interface{}) ([]
 		return nil, fmt.Errorf("read response: %w", err)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, &ciHTTPError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	return body, nil
 }
 
+// ciHTTPError is an unsuccessful response from the API.
+type ciHTTPError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *ciHTTPError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Body)
+}
+
 func ciParseIntList(s string) ([]int, error) {
 	parts := strings.Split(s, ",")
 	var ids []int
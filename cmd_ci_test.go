@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// concurrencyTracker records the highest number of requests in flight.
+type concurrencyTracker struct {
+	current, max int32
+}
+
+func (c *concurrencyTracker) enter() {
+	n := atomic.AddInt32(&c.current, 1)
+	for {
+		m := atomic.LoadInt32(&c.max)
+		if n <= m || atomic.CompareAndSwapInt32(&c.max, m, n) {
+			return
+		}
+	}
+}
+
+func (c *concurrencyTracker) leave() { atomic.AddInt32(&c.current, -1) }
+
+func TestCIForEach(t *testing.T) {
+	var tracker concurrencyTracker
+	var mu sync.Mutex
+	seen := map[int]bool{}
+	ciForEach(20, 4, func(i int) {
+		tracker.enter()
+		defer tracker.leave()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		seen[i] = true
+		mu.Unlock()
+	})
+	if len(seen) != 20 {
+		t.Errorf("fn called for %d of 20 items", len(seen))
+	}
+	if tracker.max > 4 || tracker.max < 2 {
+		t.Errorf("max concurrency %d, want 2..4", tracker.max)
+	}
+
+	calls := 0
+	ciForEach(3, 0, func(int) { calls++ })
+	if calls != 3 {
+		t.Errorf("parallel 0 should run sequentially, got %d calls", calls)
+	}
+}
+
+func TestCIExecuteTests_Parallel(t *testing.T) {
+	var tracker concurrencyTracker
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tracker.enter()
+		defer tracker.leave()
+		time.Sleep(10 * time.Millisecond)
+		sid := strings.TrimPrefix(r.URL.Path, "/api/playwright-execution/run/")
+		if sid == "3" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, `{"success": true, "execution_id": "exec-%s"}`, sid)
+	}))
+	defer server.Close()
+
+	ids := []int{1, 2, 3, 4, 5, 6, 7, 8}
+	executions, err := ciExecuteTests(server.Client(), server.URL, "tok", ids, "", true, "chromium", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, e := range executions {
+		if e.ScriptID != ids[i] {
+			t.Fatalf("executions out of order: %+v", executions)
+		}
+		if e.ScriptID == 3 {
+			if !strings.Contains(e.Error, "HTTP 500") {
+				t.Errorf("expected start error for script 3: %+v", e)
+			}
+		} else if e.ExecutionID != fmt.Sprintf("exec-%d", e.ScriptID) {
+			t.Errorf("unexpected execution: %+v", e)
+		}
+	}
+	if tracker.max > 3 || tracker.max < 2 {
+		t.Errorf("max concurrency %d, want 2..3", tracker.max)
+	}
+}
+
+func TestCIExecuteBatch(t *testing.T) {
+	var received map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/automation/execute-batch" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		_ = json.NewDecoder(r.Body).Decode(&received)
+		fmt.Fprint(w, `{"success": true, "executions": [
+			{"script_id": 2, "execution_id": "exec-2"},
+			{"script_id": 1, "execution_id": "exec-1"},
+			{"script_id": 4, "error": "script has no code"}]}`)
+	}))
+	defer server.Close()
+
+	executions, err := ciExecuteBatch(server.Client(), server.URL, "tok", []int{1, 2, 3, 4}, "https://staging.example.com", true, "firefox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received["custom_url"] != "https://staging.example.com" || received["browser"] != "firefox" || len(received["script_ids"].([]any)) != 4 {
+		t.Errorf("unexpected payload: %v", received)
+	}
+	if executions[0].ExecutionID != "exec-1" || executions[1].ExecutionID != "exec-2" {
+		t.Errorf("executions should follow script order: %+v", executions)
+	}
+	if executions[2].ScriptID != 3 || !strings.Contains(executions[2].Error, "no execution ID") {
+		t.Errorf("missing scripts should be errors: %+v", executions[2])
+	}
+	if executions[3].Error != "script has no code" {
+		t.Errorf("per-script errors should be kept: %+v", executions[3])
+	}
+}
+
+func TestCIExecuteBatch_Unavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	if _, err := ciExecuteBatch(server.Client(), server.URL, "tok", []int{1}, "", true, "chromium"); !errors.Is(err, errCIBatchUnavailable) {
+		t.Errorf("expected errCIBatchUnavailable, got %v", err)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"success": false, "message": "quota exceeded"}`)
+	}))
+	defer failing.Close()
+	if _, err := ciExecuteBatch(failing.Client(), failing.URL, "tok", []int{1}, "", true, "chromium"); err == nil || errors.Is(err, errCIBatchUnavailable) || !strings.Contains(err.Error(), "quota exceeded") {
+		t.Errorf("a failed batch must not fall back: %v", err)
+	}
+}
+
+func TestCIExecuteBatch_NoExecutions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"success": true, "message": "Started 2 executions"}`)
+	}))
+	defer server.Close()
+	executions, err := ciExecuteBatch(server.Client(), server.URL, "tok", []int{1, 2}, "", true, "chromium")
+	if err == nil || errors.Is(err, errCIBatchUnavailable) || executions != nil {
+		t.Errorf("a batch without execution IDs must fail the run without falling back: %v, %v", executions, err)
+	}
+}
+
+func TestCIPollAllExecutions_Parallel(t *testing.T) {
+	old := ciPollInterval
+	ciPollInterval = 10 * time.Millisecond
+	defer func() { ciPollInterval = old }()
+
+	var tracker concurrencyTracker
+	var mu sync.Mutex
+	polls := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tracker.enter()
+		defer tracker.leave()
+		time.Sleep(5 * time.Millisecond)
+		id := strings.TrimPrefix(r.URL.Path, "/api/playwright-execution/status/")
+		mu.Lock()
+		polls[id]++
+		n := polls[id]
+		mu.Unlock()
+		if n < 2 {
+			fmt.Fprint(w, `{"status": "running", "progress": 50}`)
+			return
+		}
+		fmt.Fprintf(w, `{"status": "completed", "success": true, "script_name": "Script %s", "execution_time": 1.5}`, id)
+	}))
+	defer server.Close()
+
+	var executions []ciExecution
+	for i := 1; i <= 6; i++ {
+		executions = append(executions, ciExecution{ScriptID: i, ExecutionID: fmt.Sprintf("e%d", i)})
+	}
+	executions = append(executions, ciExecution{ScriptID: 7, Error: "could not start"})
+
+	results := ciPollAllExecutions(server.Client(), server.URL, "tok", executions, time.Now().Add(10*time.Second), 3)
+	for i, r := range results[:6] {
+		if r.Status != "passed" || r.ScriptName != fmt.Sprintf("Script e%d", i+1) || r.ExecutionID != executions[i].ExecutionID {
+			t.Errorf("unexpected result %d: %+v", i, r)
+		}
+	}
+	if results[6].Status != "error" || results[6].Error != "could not start" {
+		t.Errorf("start errors should be kept: %+v", results[6])
+	}
+	if tracker.max > 3 || tracker.max < 2 {
+		t.Errorf("max concurrency %d, want 2..3", tracker.max)
+	}
+	for id, n := range polls {
+		if n != 2 {
+			t.Errorf("%s polled %d times, want 2", id, n)
+		}
+	}
+}
+
+func TestCIPollAllExecutions_Timeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status": "running", "progress": 10}`)
+	}))
+	defer server.Close()
+
+	results := ciPollAllExecutions(server.Client(), server.URL, "tok", []ciExecution{{ScriptID: 1, ExecutionID: "e1"}}, time.Now(), 2)
+	if results[0].Status != "error" || !strings.Contains(results[0].Error, "timeout") || results[0].ExecutionID != "e1" {
+		t.Errorf("unexpected timeout result: %+v", results[0])
+	}
+}
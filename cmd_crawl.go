@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -24,6 +28,8 @@ func cmdCrawl(args []string) {
 		cmdCrawlResults(args[1:])
 	case "jobs":
 		cmdCrawlJobs(args[1:])
+	case "local":
+		cmdCrawlLocal(args[1:])
 	case "help", "--help", "-h":
 		printCrawlUsage()
 	default:
@@ -41,13 +47,16 @@ Subcommands:
   status     Check crawl job status
   results    Get results of a completed crawl
   jobs       List recent crawl jobs
+  local      Run an AI crawl in a browser on this machine
 
 Examples:
   qmax crawl start --project-id 42 --url https://app.example.com
   qmax crawl start --project-id 42 --url https://app.example.com --depth 5 --pages 20
   qmax crawl status --crawl-id abc123
   qmax crawl results --crawl-id abc123
-  qmax crawl jobs --limit 10`)
+  qmax crawl results --crawl-id abc123 --sitemap sitemap.html
+  qmax crawl jobs --limit 10
+  qmax crawl local --project-id 42 --url http://localhost:3000 --exclude '/admin'`)
 }
 
 // --- crawl start ---
@@ -121,6 +130,186 @@ func cmdCrawlStart(args []string) {
 	}
 }
 
+// --- crawl local ---
+
+// stringListFlag collects the values of a repeatable string flag.
+type stringListFlag []string
+
+func (f *stringListFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringListFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+func cmdCrawlLocal(args []string) {
+	fs := flag.NewFlagSet("crawl local", flag.ExitOnError)
+	projectID := fs.Int("project-id", 0, "Project ID (required)")
+	url := fs.String("url", "", "URL to crawl (required)")
+	pagesLimit := fs.Int("pages", 10, "Maximum number of pages to crawl")
+	testType := fs.String("test-type", "e2e", "Test type: e2e, functional, ui, integration")
+	instructions := fs.String("instructions", "", "Custom AI instructions for the crawl")
+	storageState := fs.String("storage-state", "", "Playwright storage state file to start the crawl logged in")
+	cookieConsent := fs.String("cookie-consent", "", "Answer cookie banners with accept, reject or none")
+	emitSpec := fs.String("emit-spec", "", "Write a Playwright spec replaying the crawl to this file")
+	sitemap := fs.String("sitemap", "", "Write the crawl sitemap to this file (.json, .dot or .html)")
+	a11y := fs.Bool("a11y", false, "Run an axe-core accessibility audit on every step")
+	axeScript := fs.String("axe-script", "", "Local axe.min.js to use instead of downloading axe-core")
+	axeIntegrity := fs.String("axe-integrity", "", "Integrity hash the downloaded axe-core must match, e.g. sha384-<base64>")
+	perfBudget := fs.String("perf-budget", "", "JSON file with page metric limits to check on every step")
+	sessionTimeout := fs.Duration("session-timeout", 0, "Overall session time budget, e.g. 15m (default: server value or 10m)")
+	actionTimeout := fs.Duration("action-timeout", 0, "Time budget per action, e.g. 10s (default: server value or 5s)")
+	snapshotTimeout := fs.Duration("snapshot-timeout", 0, "Time budget per snapshot, e.g. 10s (default: server value or 5s)")
+	maxSteps := fs.Int("max-steps", 0, "Stop after this many steps (default: server value)")
+	maxPages := fs.Int("max-pages", 0, "Stop after this many distinct pages (default: server value)")
+	var allowHosts, includes, excludes, denyActions stringListFlag
+	fs.Var(&allowHosts, "allow-host", "Host the crawl may visit, *.example.com for subdomains (repeatable)")
+	fs.Var(&includes, "include", "Regex every visited URL must match (repeatable)")
+	fs.Var(&excludes, "exclude", "Regex for URLs the crawl must not visit (repeatable)")
+	fs.Var(&denyActions, "deny-action", "Regex on element text or selector to never act on (repeatable)")
+	allowDestructive := fs.Bool("allow-destructive", false, "Disable the built-in denylist for delete/logout/payment actions")
+	_ = fs.Parse(args)
+
+	if *projectID == 0 {
+		fmt.Fprintln(os.Stderr, "Error: --project-id is required")
+		os.Exit(1)
+	}
+	if *url == "" {
+		fmt.Fprintln(os.Stderr, "Error: --url is required")
+		os.Exit(1)
+	}
+
+	localScope := &CrawlScope{
+		AllowedHosts:       allowHosts,
+		IncludeURLPatterns: includes,
+		ExcludeURLPatterns: excludes,
+		DenyActionPatterns: denyActions,
+	}
+	// Only an explicit --allow-destructive overrides the server's scope
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "allow-destructive" {
+			localScope.AllowDestructive = allowDestructive
+		}
+	})
+	if _, err := newCrawlScopeGuard(*url, localScope); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if _, err := normalizeConsentPreference(*cookieConsent); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	var budget *PerfBudget
+	if *perfBudget != "" {
+		var err error
+		if budget, err = LoadPerfBudget(*perfBudget); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *sessionTimeout < 0 || *actionTimeout < 0 || *snapshotTimeout < 0 || *maxSteps < 0 || *maxPages < 0 {
+		fmt.Fprintln(os.Stderr, "Error: budgets must not be negative")
+		os.Exit(1)
+	}
+
+	cfg := mustLoadConfig()
+	if cfg.AgentID == "" || cfg.APIKey == "" {
+		fmt.Fprintln(os.Stderr, "Error: agent not registered. Run `qmax run` once to register this machine.")
+		os.Exit(1)
+	}
+	apiURL := cfg.GetAPIBaseURL()
+
+	payload := map[string]interface{}{
+		"project_id":     *projectID,
+		"url":            *url,
+		"pages_limit":    *pagesLimit,
+		"test_type":      *testType,
+		"framework":      "playwright",
+		"agent_id":       cfg.AgentID,
+		"execution_mode": "local_agent",
+	}
+	if *instructions != "" {
+		payload["custom_instructions"] = *instructions
+	}
+
+	body := authPost(cfg, fmt.Sprintf("%s/api/ai-crawl/start", apiURL), payload)
+	var resp struct {
+		CrawlID string `json:"crawl_id"`
+	}
+	mustUnmarshal(body, &resp)
+	fmt.Printf("Crawl started: %s\n", resp.CrawlID)
+
+	agent := NewAgent(apiURL, cfg.APIKey, cfg.AgentID, cfg.RegistrationSecret, 2*time.Second, 60*time.Second)
+	pool := agent.browserPool()
+	defer pool.Close()
+	session := waitForCrawlSession(agent, resp.CrawlID, 60*time.Second)
+	if session == nil {
+		fmt.Fprintln(os.Stderr, "Error: the server did not hand this agent a crawl session (is `qmax run` already running with the same agent?)")
+		os.Exit(1)
+	}
+
+	session.Scope = mergeCrawlScope(session.Scope, localScope)
+	if *storageState != "" {
+		session.StorageState = nil
+		session.StorageStatePath = *storageState
+	}
+	if *cookieConsent != "" {
+		session.CookieConsent = *cookieConsent
+	}
+	session.EmitSpecPath = *emitSpec
+	session.SitemapPath = *sitemap
+	if budget != nil {
+		session.PerfBudget = budget
+	}
+	overrideCrawlBudgets(session, *sessionTimeout, *actionTimeout, *snapshotTimeout, *maxSteps, *maxPages)
+	agent.CrawlLimits = cfg.Crawl
+	if *a11y || *axeScript != "" || *axeIntegrity != "" {
+		if session.A11yAudit == nil {
+			session.A11yAudit = &CrawlA11yAudit{}
+		}
+		session.A11yAudit.Enabled = true
+		if *axeScript != "" {
+			session.A11yAudit.ScriptPath = *axeScript
+		}
+		if *axeIntegrity != "" {
+			session.A11yAudit.Integrity = *axeIntegrity
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	fmt.Printf("Crawling %s locally (session %s)...\n", session.URL, session.SessionID)
+	agent.ExecuteCrawlSession(ctx, *session)
+
+	fmt.Printf("\nGet results: qmax crawl results --crawl-id %s\n", resp.CrawlID)
+}
+
+// waitForCrawlSession polls until the server assigns the agent a session of
+// the crawl crawlID. Sessions of other crawls, such as one queued for a
+// `qmax run` with the same agent, are skipped.
+func waitForCrawlSession(agent *Agent, crawlID string, timeout time.Duration) *CrawlSession {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		session, err := agent.PollCrawlSessions()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WARN: polling crawl sessions: %v\n", err)
+		} else if session != nil && session.CrawlID == crawlID {
+			return session
+		} else if session != nil {
+			fmt.Fprintf(os.Stderr, "WARN: skipping crawl session %s, it is not for crawl %s\n", session.SessionID, crawlID)
+		}
+		time.Sleep(agent.PollInterval)
+	}
+	return nil
+}
+
 // --- crawl status ---
 
 func cmdCrawlStatus(args []string) {
@@ -176,18 +365,40 @@ func cmdCrawlResults(args []string) {
 	fs := flag.NewFlagSet("crawl results", flag.ExitOnError)
 	crawlID := fs.String("crawl-id", "", "Crawl job ID (required)")
 	jsonOut := fs.Bool("json", false, "Output raw JSON")
+	sitemap := fs.String("sitemap", "", "Write the crawl sitemap to this file (.json, .dot or .html)")
+	sitemapFormat := fs.String("sitemap-format", "", "Sitemap format: json, dot or html (default: from the file extension)")
 	_ = fs.Parse(args)
 
 	if *crawlID == "" {
 		fmt.Fprintln(os.Stderr, "Error: --crawl-id is required")
 		os.Exit(1)
 	}
+	if *sitemap != "" {
+		if _, err := crawlGraphFormat(*sitemap, *sitemapFormat); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
 	cfg := mustLoadConfig()
 	apiURL := cfg.GetAPIBaseURL()
 
 	body := authGet(cfg, fmt.Sprintf("%s/api/ai-crawl/results/%s", apiURL, *crawlID))
 
+	if *sitemap != "" {
+		graph, err := crawlGraphFromResults(body, *crawlID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := graph.WriteFile(*sitemap, *sitemapFormat); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing sitemap: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Sitemap: %d pages, %d transitions, %d forms → %s\n",
+			len(graph.Pages), len(graph.Transitions), len(graph.Forms), *sitemap)
+	}
+
 	if *jsonOut {
 		fmt.Println(string(body))
 		return
@@ -32,6 +32,12 @@ func cmdRun(args []string) {
 	registrationSecret := fs.String("registration-secret", defaultSecret, "Registration secret (must match AGENT_REGISTRATION_SECRET on server)")
 	pollInterval := fs.Int("poll-interval", 5, "Polling interval in seconds")
 	heartbeatInterval := fs.Int("heartbeat-interval", 60, "Heartbeat interval in seconds")
+	maxBrowserSessions := fs.Int("max-browser-sessions", defaultMaxBrowserSessions, "Maximum concurrent crawl sessions sharing the browser")
+	warmBrowser := fs.Bool("warm-browser", false, "Start Chrome at launch instead of on the first crawl")
+	visual := fs.Bool("visual", false, "Compare test screenshots against local baselines")
+	visualConfig := fs.String("visual-config", "", "JSON file with visual comparison settings (threshold, max_diff_ratio, ignore_regions)")
+	perf := fs.Bool("perf", false, "Record page performance metrics in test runs")
+	perfBudget := fs.String("perf-budget", "", "JSON file with metric limits; pages over them mark the result degraded (implies --perf)")
 	_ = fs.Parse(args)
 
 	if *cloudURL == "" {
@@ -49,6 +55,36 @@ func cmdRun(args []string) {
 		time.Duration(*heartbeatInterval)*time.Second,
 	)
 
+	agent.CrawlLimits = cfg.Crawl
+	agent.Browsers = NewBrowserPool(*maxBrowserSessions, !crawlHeaded())
+	if *warmBrowser {
+		if err := agent.Browsers.Start(); err != nil {
+			log.Printf("WARN: could not start browser: %v", err)
+		}
+	}
+
+	if *visualConfig != "" {
+		opts, err := LoadVisualOptions(*visualConfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		agent.Visual = opts
+	} else if *visual {
+		agent.Visual = &VisualOptions{Enabled: true}
+	}
+
+	if *perfBudget != "" {
+		budget, err := LoadPerfBudget(*perfBudget)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		agent.Perf = &PerfOptions{Enabled: true, Budget: budget}
+	} else if *perf {
+		agent.Perf = &PerfOptions{Enabled: true}
+	}
+
 	// Save credentials back to config after successful registration
 	agent.OnRegistered = func(newAgentID, newAPIKey string) {
 		cfg.AgentID = newAgentID
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// qmax visual — manage local visual regression baselines
+
+func cmdVisual(args []string) {
+	if len(args) < 1 {
+		printVisualUsage()
+		os.Exit(1)
+	}
+
+	sub := args[0]
+	switch sub {
+	case "list":
+		cmdVisualList(args[1:])
+	case "approve":
+		cmdVisualApprove(args[1:])
+	case "help", "--help", "-h":
+		printVisualUsage()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown visual subcommand: %s\n\n", sub)
+		printVisualUsage()
+		os.Exit(1)
+	}
+}
+
+func printVisualUsage() {
+	fmt.Println(`Usage: qmax visual <subcommand> [flags]
+
+Subcommands:
+  list       List changed screenshots waiting for approval
+  approve    Promote changed screenshots to be the new baselines
+
+Flags:
+  --project-id    Only screenshots of this project
+  --script-id     Only screenshots of this script
+  --name          Only screenshots whose name contains this text
+  --dir           Baseline store (default: $QMAX_VISUAL_DIR or ~/.qmax/visual)
+  --all           Approve every pending screenshot (approve only)
+
+Examples:
+  qmax visual list
+  qmax visual approve --project-id 42 --script-id 7
+  qmax visual approve --all`)
+}
+
+type visualFilterFlags struct {
+	projectID *string
+	scriptID  *string
+	name      *string
+	dir       *string
+}
+
+func addVisualFilterFlags(fs *flag.FlagSet) visualFilterFlags {
+	return visualFilterFlags{
+		projectID: fs.String("project-id", "", "Only screenshots of this project"),
+		scriptID:  fs.String("script-id", "", "Only screenshots of this script"),
+		name:      fs.String("name", "", "Only screenshots whose name contains this text"),
+		dir:       fs.String("dir", "", "Baseline store directory"),
+	}
+}
+
+func (f visualFilterFlags) pending() (*visualStore, []pendingVisual) {
+	store := &visualStore{Root: *f.dir}
+	if store.Root == "" {
+		var err error
+		if store, err = defaultVisualStore(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	pending, err := store.listPending(*f.projectID, *f.scriptID, *f.name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", store.Root, err)
+		os.Exit(1)
+	}
+	return store, pending
+}
+
+func cmdVisualList(args []string) {
+	fs := flag.NewFlagSet("visual list", flag.ExitOnError)
+	filter := addVisualFilterFlags(fs)
+	_ = fs.Parse(args)
+
+	_, pending := filter.pending()
+	if len(pending) == 0 {
+		fmt.Println("No changed screenshots.")
+		return
+	}
+	fmt.Printf("%d changed screenshot(s):\n\n", len(pending))
+	for _, p := range pending {
+		fmt.Printf("  %s/%s\n", p.Dir, p.Name)
+		fmt.Printf("    actual:   %s\n", p.Pending)
+		if _, err := os.Stat(p.Diff); err == nil {
+			fmt.Printf("    diff:     %s\n", p.Diff)
+		}
+		fmt.Printf("    baseline: %s\n", p.Baseline)
+	}
+}
+
+func cmdVisualApprove(args []string) {
+	fs := flag.NewFlagSet("visual approve", flag.ExitOnError)
+	filter := addVisualFilterFlags(fs)
+	all := fs.Bool("all", false, "Approve every pending screenshot")
+	_ = fs.Parse(args)
+
+	if !*all && *filter.projectID == "" && *filter.scriptID == "" && *filter.name == "" {
+		fmt.Fprintln(os.Stderr, "Error: pass --project-id, --script-id or --name, or --all to approve everything")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	store, pending := filter.pending()
+	if len(pending) == 0 {
+		fmt.Println("No changed screenshots to approve.")
+		return
+	}
+	failed := 0
+	for _, p := range pending {
+		if err := store.approve(p); err != nil {
+			fmt.Fprintf(os.Stderr, "  FAILED %s/%s: %v\n", p.Dir, p.Name, err)
+			failed++
+			continue
+		}
+		fmt.Printf("  Approved %s/%s\n", p.Dir, p.Name)
+	}
+	fmt.Printf("\n%d baseline(s) updated.\n", len(pending)-failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
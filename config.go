@@ -15,6 +15,9 @@ type Config struct {
 	AgentID            string `json:"agent_id,omitempty"`
 	APIKey             string `json:"api_key,omitempty"`
 	RegistrationSecret string `json:"registration_secret,omitempty"`
+
+	// Ceilings for crawl session budgets on this machine.
+	Crawl *CrawlLimits `json:"crawl,omitempty"`
 }
 
 const (
@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -13,8 +14,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/chromedp/cdproto/emulation"
-	"github.com/chromedp/cdproto/page"
 	"github.com/chromedp/chromedp"
 )
 
@@ -23,10 +22,53 @@ import (
 // CrawlSession represents a pending crawl session from the server.
 type CrawlSession struct {
 	SessionID      string `json:"session_id"`
+	CrawlID        string `json:"crawl_id,omitempty"` // the crawl job the session runs for
 	URL            string `json:"url"`
 	Instructions   string `json:"instructions"`
 	MaxSteps       int    `json:"max_steps"`
 	SnapshotScript string `json:"snapshot_script"`
+
+	// Optional Playwright storage state (as produced by `qmax capture`) to
+	// start the crawl authenticated. StorageState is the inline JSON and wins
+	// over StorageStatePath, a file on the agent machine.
+	StorageState        json.RawMessage `json:"storage_state,omitempty"`
+	StorageStatePath    string          `json:"storage_state_path,omitempty"`
+	LoggedOutURLPattern string          `json:"logged_out_url_pattern,omitempty"`
+
+	// Optional viewport/device emulation and screenshot settings. Defaults
+	// to a 1280x720 desktop viewport with PNG viewport screenshots.
+	Viewport   *CrawlViewport          `json:"viewport,omitempty"`
+	Screenshot *CrawlScreenshotOptions `json:"screenshot,omitempty"`
+
+	// Optional navigation and action rules; see CrawlScope.
+	Scope *CrawlScope `json:"scope,omitempty"`
+
+	// How to answer cookie banners: accept, reject or none. Defaults to the
+	// preference in the cookie consent rules.
+	CookieConsent string `json:"cookie_consent,omitempty"`
+
+	// Cap on waiting for the page to settle after each action; 0 means 5s.
+	SettleMaxMs int `json:"settle_max_ms,omitempty"`
+
+	// Budgets; zero means the default (10min session, 5s per action, 5s
+	// per snapshot, no page limit). Local ceilings in config lower them.
+	SessionTimeoutSec int `json:"session_timeout_sec,omitempty"`
+	ActionTimeoutMs   int `json:"action_timeout_ms,omitempty"`
+	SnapshotTimeoutMs int `json:"snapshot_timeout_ms,omitempty"`
+	MaxPages          int `json:"max_pages,omitempty"` // distinct URLs, ignoring fragments
+
+	// Optional axe-core audit on every step.
+	A11yAudit *CrawlA11yAudit `json:"a11y_audit,omitempty"`
+
+	// Optional limits for page metrics; pages over them are reported in
+	// perf_violations.
+	PerfBudget *PerfBudget `json:"perf_budget,omitempty"`
+
+	// Local only: write the generated Playwright spec here at session end.
+	EmitSpecPath string `json:"-"`
+
+	// Local only: also write the sitemap here (.json, .dot or .html).
+	SitemapPath string `json:"-"`
 }
 
 // CrawlSnapshot is sent to the server after each crawl step.
@@ -39,7 +81,20 @@ type CrawlSnapshot struct {
 	Forms               []map[string]any `json:"forms"`
 	Selectors           map[string]string `json:"selectors"`
 	ScreenshotBase64    string           `json:"screenshot_base64"`
+	ScreenshotFormat    string           `json:"screenshot_format,omitempty"`
+	ScreenshotMode      string           `json:"screenshot_mode,omitempty"`
 	AccessibilityTree   string           `json:"accessibility_tree"`
+	ConsoleEntries      []CrawlConsoleEntry `json:"console_entries,omitempty"`
+	LoggedOut           bool             `json:"logged_out,omitempty"`
+	LoggedOutReason     string           `json:"logged_out_reason,omitempty"`
+	LastAction          *CrawlActionResult `json:"last_action,omitempty"`
+	CookieConsent       *CookieConsentResult `json:"cookie_consent,omitempty"`
+	A11yViolations      []CrawlA11yViolation `json:"a11y_violations,omitempty"`
+	A11yAuditError      string               `json:"a11y_audit_error,omitempty"`
+	SettleMs            int64                `json:"settle_ms"`
+	SettleTimedOut      bool                 `json:"settle_timed_out,omitempty"`
+	Perf                *PerfMetrics         `json:"perf,omitempty"`
+	PerfViolations      []PerfViolation      `json:"perf_violations,omitempty"`
 }
 
 // CrawlAction is the server's response telling the agent what to do next.
@@ -51,6 +106,15 @@ type CrawlAction struct {
 	StepNum  int    `json:"step_num"`
 }
 
+// CrawlActionResult tells the server how the previous step's action went,
+// so the planner can react to failures and refusals.
+type CrawlActionResult struct {
+	Action   string `json:"action"`
+	Selector string `json:"selector"`
+	Status   string `json:"status"` // ok, failed, blocked
+	Error    string `json:"error,omitempty"`
+}
+
 // --- HTTP helpers with retry ---
 
 // doJSONWithRetry wraps doJSON with retry logic for 5xx errors.
@@ -148,64 +212,151 @@ func (a *Agent) PollCrawlSessions() (*CrawlSession, error) {
 
 // --- Crawl execution ---
 
-// ExecuteCrawlSession runs a discovery crawl using chromedp.
+// crawlHeaded reports whether QMAX_CRAWL_HEADED asks for a visible browser.
+func crawlHeaded() bool {
+	return strings.EqualFold(os.Getenv("QMAX_CRAWL_HEADED"), "true")
+}
+
+// ExecuteCrawlSession runs a discovery crawl in a browser from the agent's pool.
 func (a *Agent) ExecuteCrawlSession(ctx context.Context, session CrawlSession) {
-	log.Printf("CRAWL [%s] Starting crawl session: url=%s, max_steps=%d", session.SessionID, session.URL, session.MaxSteps)
+	session, notes := applyCrawlLimits(session, a.CrawlLimits)
+	log.Printf("CRAWL [%s] Starting crawl session: url=%s, max_steps=%d, max_pages=%d, timeout=%s",
+		session.SessionID, session.URL, session.MaxSteps, session.MaxPages, session.sessionTimeout())
+	for _, note := range notes {
+		log.Printf("CRAWL [%s] %s", session.SessionID, note)
+	}
+	run := newCrawlRun(session)
 
-	// Overall session timeout: 10 minutes
-	sessionCtx, sessionCancel := context.WithTimeout(ctx, 10*time.Minute)
+	sessionCtx, sessionCancel := context.WithTimeout(ctx, session.sessionTimeout())
 	defer sessionCancel()
 
-	// Determine headless mode
-	headed := strings.EqualFold(os.Getenv("QMAX_CRAWL_HEADED"), "true")
+	viewport, err := resolveCrawlViewport(session.Viewport)
+	if err != nil {
+		log.Printf("CRAWL [%s] ERROR: invalid viewport: %v", session.SessionID, err)
+		a.submitCrawlError(session.SessionID, fmt.Sprintf("invalid viewport: %v", err))
+		return
+	}
+	if _, err := resolveCrawlScreenshot(session.Screenshot); err != nil {
+		log.Printf("CRAWL [%s] ERROR: invalid screenshot options: %v", session.SessionID, err)
+		a.submitCrawlError(session.SessionID, fmt.Sprintf("invalid screenshot options: %v", err))
+		return
+	}
+	scope, err := newCrawlScopeGuard(session.URL, session.Scope)
+	if err != nil {
+		log.Printf("CRAWL [%s] ERROR: invalid scope: %v", session.SessionID, err)
+		a.submitCrawlError(session.SessionID, fmt.Sprintf("invalid scope: %v", err))
+		return
+	}
 
-	opts := append(chromedp.DefaultExecAllocatorOptions[:],
-		chromedp.Flag("headless", !headed),
-		chromedp.Flag("disable-gpu", true),
-		chromedp.WindowSize(1280, 720),
-	)
+	consentRules, err := loadCookieConsentRules(cookieConsentRulesPath())
+	if err != nil {
+		log.Printf("CRAWL [%s] WARN: ignoring %s: %v", session.SessionID, cookieConsentRulesFile, err)
+		consentRules, _ = loadCookieConsentRules("")
+	}
+	if session.CookieConsent != "" {
+		if consentRules, err = consentRules.withPreference(session.CookieConsent); err != nil {
+			log.Printf("CRAWL [%s] ERROR: %v", session.SessionID, err)
+			a.submitCrawlError(session.SessionID, err.Error())
+			return
+		}
+	}
 
-	allocCtx, allocCancel := chromedp.NewExecAllocator(sessionCtx, opts...)
-	defer allocCancel()
+	// A missing axe-core script only disables the audit
+	auditor, err := a.newCrawlA11yAuditor(session.A11yAudit)
+	auditErr := ""
+	if err != nil {
+		log.Printf("CRAWL [%s] WARN: accessibility audit disabled: %v", session.SessionID, err)
+		auditErr = err.Error()
+	}
 
-	browserCtx, browserCancel := chromedp.NewContext(allocCtx,
-		chromedp.WithLogf(func(s string, args ...interface{}) {
-			log.Printf("CRAWL [%s] chromedp: "+s, append([]interface{}{session.SessionID}, args...)...)
-		}),
-	)
+	// Each session gets its own incognito context in the shared browser
+	browserCtx, browserCancel, err := a.browserPool().Acquire(sessionCtx)
+	if err != nil {
+		log.Printf("CRAWL [%s] ERROR starting browser: %v", session.SessionID, err)
+		a.submitCrawlError(session.SessionID, fmt.Sprintf("failed to start browser: %v", err))
+		return
+	}
 	defer browserCancel()
 
-	// Set viewport
-	if err := chromedp.Run(browserCtx,
-		emulation.SetDeviceMetricsOverride(1280, 720, 1.0, false),
-	); err != nil {
+	// Collect console errors and uncaught exceptions for every step, and
+	// network totals for the completion report
+	console := newCrawlConsoleCollector()
+	chromedp.ListenTarget(browserCtx, console.handleEvent)
+	netStats := newCrawlNetworkStats()
+	chromedp.ListenTarget(browserCtx, netStats.handleEvent)
+	defer a.completeCrawlSession(session.SessionID, run, console, netStats)
+
+	// Track in-flight requests to tell when a page has settled
+	netTracker := newCrawlNetworkTracker()
+	chromedp.ListenTarget(browserCtx, netTracker.handleEvent)
+	settleOpts := defaultCrawlSettleOptions(session.SettleMaxMs)
+
+	// Set viewport and device emulation
+	if err := chromedp.Run(browserCtx, emulateCrawlViewport(viewport)); err != nil {
 		log.Printf("CRAWL [%s] ERROR setting viewport: %v", session.SessionID, err)
 		a.submitCrawlError(session.SessionID, fmt.Sprintf("failed to set viewport: %v", err))
 		return
 	}
 
+	// Record Web Vitals from the first document on
+	if err := enableCrawlPerf(browserCtx); err != nil {
+		log.Printf("CRAWL [%s] WARN: perf metrics disabled: %v", session.SessionID, err)
+	}
+
+	// Start authenticated if the session carries a storage state
+	state, err := loadCrawlStorageState(session)
+	if err != nil {
+		log.Printf("CRAWL [%s] ERROR loading storage state: %v", session.SessionID, err)
+		a.submitCrawlError(session.SessionID, fmt.Sprintf("failed to load storage state: %v", err))
+		return
+	}
+	var authWatcher *crawlAuthWatcher
+	if state != nil {
+		if authWatcher, err = newCrawlAuthWatcher(session, state); err != nil {
+			log.Printf("CRAWL [%s] ERROR: %v", session.SessionID, err)
+			a.submitCrawlError(session.SessionID, err.Error())
+			return
+		}
+		if err := a.applyStorageState(browserCtx, session.SessionID, state); err != nil {
+			log.Printf("CRAWL [%s] ERROR injecting storage state: %v", session.SessionID, err)
+			a.submitCrawlError(session.SessionID, fmt.Sprintf("failed to inject storage state: %v", err))
+			return
+		}
+	}
+
 	// Navigate to the target URL
 	log.Printf("CRAWL [%s] Navigating to %s", session.SessionID, session.URL)
 	if err := chromedp.Run(browserCtx,
 		chromedp.Navigate(session.URL),
 		chromedp.WaitReady("body"),
-		chromedp.Sleep(500*time.Millisecond),
 	); err != nil {
 		log.Printf("CRAWL [%s] ERROR navigating: %v", session.SessionID, err)
 		a.submitCrawlError(session.SessionID, fmt.Sprintf("failed to navigate to %s: %v", session.URL, err))
 		return
 	}
 
+	settle, settleCapped := waitForSettle(browserCtx, netTracker, settleOpts)
+
 	// Dismiss cookie consent overlays
-	a.dismissCookieConsent(browserCtx, session.SessionID)
+	consent := a.dismissCookieConsent(browserCtx, session.SessionID, consentRules)
+
+	// Record the executed path; it becomes a Playwright spec at session end
+	trace := &CrawlTrace{
+		SessionID:     session.SessionID,
+		StartURL:      session.URL,
+		Viewport:      viewport,
+		Authenticated: state != nil,
+		CookieConsent: consent,
+	}
+	graph := newCrawlGraph(session.SessionID, session.URL)
+	defer a.finishCrawlRecording(session, trace, graph)
 
 	// Main crawl loop
+	var lastAction *CrawlActionResult
 	for step := 1; step <= session.MaxSteps; step++ {
-		select {
-		case <-sessionCtx.Done():
-			log.Printf("CRAWL [%s] Session context cancelled at step %d", session.SessionID, step)
+		if run.endFromContext(sessionCtx) {
+			log.Printf("CRAWL [%s] Session %s at step %d", session.SessionID, run.reason, step)
 			return
-		default:
 		}
 
 		log.Printf("CRAWL [%s] Step %d/%d", session.SessionID, step, session.MaxSteps)
@@ -213,14 +364,45 @@ func (a *Agent) ExecuteCrawlSession(ctx context.Context, session CrawlSession) {
 		// Capture snapshot
 		snapshot, err := a.captureSnapshot(browserCtx, session, step)
 		if err != nil {
+			if run.endFromContext(sessionCtx) {
+				log.Printf("CRAWL [%s] Session %s while capturing step %d", session.SessionID, run.reason, step)
+				return
+			}
 			log.Printf("CRAWL [%s] ERROR capturing snapshot at step %d: %v", session.SessionID, step, err)
 			a.submitCrawlError(session.SessionID, fmt.Sprintf("snapshot capture failed at step %d: %v", step, err))
 			return
 		}
+		run.step(snapshot.URL)
+		snapshot.ConsoleEntries = console.drain(step)
+		snapshot.SettleMs, snapshot.SettleTimedOut = settle.Milliseconds(), settleCapped
+		snapshot.LastAction = lastAction
+		if step == 1 {
+			snapshot.CookieConsent = consent
+			trace.LandedURL, trace.StartTitle = snapshot.URL, snapshot.Title
+		}
+		if authWatcher != nil {
+			snapshot.LoggedOut, snapshot.LoggedOutReason = authWatcher.check(browserCtx, session.SessionID, step, snapshot.URL)
+		}
+		if auditor != nil {
+			if snapshot.A11yViolations, err = auditor.audit(browserCtx); err != nil {
+				log.Printf("CRAWL [%s] WARN: accessibility audit failed at step %d: %v", session.SessionID, step, err)
+				snapshot.A11yAuditError = err.Error()
+			}
+		} else if step == 1 {
+			snapshot.A11yAuditError = auditErr
+		}
+		perf, err := collectCrawlPerf(browserCtx, netTracker.takeTransferred())
+		if err != nil {
+			log.Printf("CRAWL [%s] WARN: perf metrics at step %d: %v", session.SessionID, step, err)
+		}
+		snapshot.Perf = perf
+		snapshot.PerfViolations = session.PerfBudget.check(perf)
+		graph.observe(snapshot)
 
 		// Send snapshot to server and get next action
 		action, err := a.submitSnapshot(session.SessionID, snapshot)
 		if err != nil {
+			run.end(crawlEndError)
 			log.Printf("CRAWL [%s] ERROR submitting snapshot at step %d: %v", session.SessionID, step, err)
 			a.submitCrawlError(session.SessionID, fmt.Sprintf("snapshot submission failed at step %d: %v", step, err))
 			return
@@ -232,25 +414,69 @@ func (a *Agent) ExecuteCrawlSession(ctx context.Context, session CrawlSession) {
 		// Check if done
 		if action.Action == "done" {
 			log.Printf("CRAWL [%s] Crawl completed at step %d: %s", session.SessionID, step, action.Reason)
+			run.end(crawlEndDone)
+			return
+		}
+		if run.pagesExhausted() {
+			log.Printf("CRAWL [%s] Reached max pages (%d)", session.SessionID, session.MaxPages)
+			run.end(crawlEndMaxPages)
 			return
 		}
 
 		// Execute the action
-		if err := a.executeCrawlAction(browserCtx, session.SessionID, action); err != nil {
+		lastAction = &CrawlActionResult{Action: action.Action, Selector: action.Selector, Status: "ok"}
+		err = a.executeCrawlAction(browserCtx, session.SessionID, action, scope, session.actionTimeout())
+		if err != nil {
 			log.Printf("CRAWL [%s] ERROR executing action at step %d: %v", session.SessionID, step, err)
 			// Don't abort on action failure — let the server decide on the next snapshot
+			lastAction.Status = "failed"
+			var blocked *crawlActionBlockedError
+			if errors.As(err, &blocked) {
+				lastAction.Status = "blocked"
+			}
+			lastAction.Error = err.Error()
 		}
+		run.action(lastAction)
 
 		// Wait for page to settle after action
-		_ = chromedp.Run(browserCtx, chromedp.Sleep(1*time.Second))
+		settle, settleCapped = waitForSettle(browserCtx, netTracker, settleOpts)
+
+		// Redirects and scripts can still take the page out of scope
+		if lastAction.Status == "ok" {
+			if err := a.enforceCrawlScope(browserCtx, session.SessionID, scope); err != nil {
+				lastAction.Status = "blocked"
+				lastAction.Error = err.Error()
+			}
+		}
+		trace.recordStep(browserCtx, step, action, lastAction)
 	}
 
 	log.Printf("CRAWL [%s] Reached max steps (%d)", session.SessionID, session.MaxSteps)
+	run.end(crawlEndMaxSteps)
+}
+
+// logConsoleSummary logs the console activity aggregated over the session.
+func (a *Agent) logConsoleSummary(sessionID string, console *crawlConsoleCollector) {
+	summary := console.summary()
+	log.Printf("CRAWL [%s] Console summary: %d errors, %d warnings, %d exceptions (steps with errors: %v)",
+		sessionID, summary.Errors, summary.Warnings, summary.Exceptions, summary.StepsWithErrors)
+	for _, m := range summary.TopMessages {
+		log.Printf("CRAWL [%s]   %dx [%s/%s] %s", sessionID, m.Count, m.Source, m.Level, truncate(m.Text, 200))
+	}
 }
 
 // captureSnapshot takes a screenshot and evaluates the snapshot script.
 func (a *Agent) captureSnapshot(ctx context.Context, session CrawlSession, stepNum int) (*CrawlSnapshot, error) {
-	actionCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	viewport, err := resolveCrawlViewport(session.Viewport)
+	if err != nil {
+		return nil, err
+	}
+	shotOpts, err := resolveCrawlScreenshot(session.Screenshot)
+	if err != nil {
+		return nil, err
+	}
+
+	actionCtx, cancel := context.WithTimeout(ctx, session.snapshotTimeout())
 	defer cancel()
 
 	// Get current URL and title
@@ -266,12 +492,7 @@ func (a *Agent) captureSnapshot(ctx context.Context, session CrawlSession, stepN
 	var screenshotBuf []byte
 	if err := chromedp.Run(actionCtx, chromedp.ActionFunc(func(ctx context.Context) error {
 		var err error
-		screenshotBuf, err = page.CaptureScreenshot().
-			WithFormat(page.CaptureScreenshotFormatPng).
-			WithClip(&page.Viewport{
-				X: 0, Y: 0, Width: 1280, Height: 720, Scale: 1,
-			}).
-			Do(ctx)
+		screenshotBuf, err = captureCrawlScreenshot(ctx, viewport, shotOpts)
 		return err
 	})); err != nil {
 		return nil, fmt.Errorf("capture screenshot: %w", err)
@@ -283,11 +504,22 @@ func (a *Agent) captureSnapshot(ctx context.Context, session CrawlSession, stepN
 		URL:              currentURL,
 		Title:            title,
 		ScreenshotBase64: base64.StdEncoding.EncodeToString(screenshotBuf),
+		ScreenshotFormat: shotOpts.Format,
+		ScreenshotMode:   shotOpts.Mode,
+	}
+
+	// Accessibility tree from CDP; a snapshot script may replace it below
+	treeCtx, treeCancel := context.WithTimeout(ctx, session.snapshotTimeout())
+	defer treeCancel()
+	if tree, err := captureAccessibilityTree(treeCtx); err != nil {
+		log.Printf("CRAWL [%s] WARN: accessibility tree capture failed: %v", session.SessionID, err)
+	} else {
+		snapshot.AccessibilityTree = tree
 	}
 
 	// Evaluate snapshot script if provided
 	if session.SnapshotScript != "" {
-		scriptCtx, scriptCancel := context.WithTimeout(ctx, 5*time.Second)
+		scriptCtx, scriptCancel := context.WithTimeout(ctx, session.snapshotTimeout())
 		defer scriptCancel()
 
 		var result string
@@ -309,11 +541,22 @@ func (a *Agent) captureSnapshot(ctx context.Context, session CrawlSession, stepN
 				snapshot.InteractiveElements = scriptData.InteractiveElements
 				snapshot.Forms = scriptData.Forms
 				snapshot.Selectors = scriptData.Selectors
-				snapshot.AccessibilityTree = scriptData.AccessibilityTree
+				if scriptData.AccessibilityTree != "" {
+					snapshot.AccessibilityTree = scriptData.AccessibilityTree
+				}
 			}
 		}
 	}
 
+	// Elements inside iframes and shadow roots, with extended selectors
+	nestedCtx, nestedCancel := context.WithTimeout(ctx, session.snapshotTimeout())
+	defer nestedCancel()
+	if nested, err := collectNestedCrawlElements(nestedCtx); err != nil {
+		log.Printf("CRAWL [%s] WARN: iframe/shadow DOM element scan failed: %v", session.SessionID, err)
+	} else {
+		snapshot.InteractiveElements = append(snapshot.InteractiveElements, nested...)
+	}
+
 	return snapshot, nil
 }
 
@@ -356,33 +599,59 @@ func (a *Agent) submitCrawlError(sessionID, errorMsg string) {
 // --- Action execution ---
 
 // executeCrawlAction performs the browser action specified by the server.
-func (a *Agent) executeCrawlAction(ctx context.Context, sessionID string, action *CrawlAction) error {
-	actionCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+// Extended selectors (see crawlSelectorSeparator) reach into iframes and
+// shadow roots.
+// When scope is set, the target element is inspected first and actions that
+// break the scope rules are refused with a crawlActionBlockedError.
+// The whole action, inspection and every stage included, runs within
+// timeout (5s if zero); the inspection may use at most half of it.
+func (a *Agent) executeCrawlAction(ctx context.Context, sessionID string, action *CrawlAction, scope *crawlScopeGuard, timeout time.Duration) error {
+	actionCtx, cancel := context.WithTimeout(ctx, durationOr(timeout, defaultCrawlActionTimeout))
 	defer cancel()
 
+	if scope != nil {
+		inspectCtx, inspectCancel := crawlStageContext(actionCtx, 2)
+		el := inspectCrawlElement(inspectCtx, action.Selector)
+		inspectCancel()
+		if err := scope.checkAction(action, el); err != nil {
+			log.Printf("CRAWL [%s] Refusing %s on %q: %v", sessionID, action.Action, action.Selector, err)
+			return err
+		}
+	}
+
+	if isExtendedCrawlSelector(action.Selector) {
+		return a.executeNestedCrawlAction(actionCtx, sessionID, action)
+	}
+
 	switch action.Action {
 	case "click":
 		return a.crawlClick(actionCtx, sessionID, action.Selector)
+	case "combobox_select":
+		return a.crawlComboboxSelect(actionCtx, action.Selector, action.Value)
 	case "fill":
 		return a.crawlFill(actionCtx, action.Selector, action.Value)
 	case "select":
 		return a.crawlSelect(actionCtx, action.Selector, action.Value)
-	case "combobox_select":
-		return a.crawlComboboxSelect(ctx, action.Selector, action.Value)
 	default:
 		return fmt.Errorf("unknown action: %s", action.Action)
 	}
 }
 
-// crawlClick clicks an element, falling back to JS click on failure.
+// crawlClick clicks an element, falling back to JS click on failure. The
+// native click may use half the time left on ctx, so that a click waiting
+// on a hidden element still leaves time for the fallback.
 func (a *Agent) crawlClick(ctx context.Context, sessionID, selector string) error {
-	err := chromedp.Run(ctx, chromedp.Click(selector, chromedp.ByQuery))
+	clickCtx, cancel := crawlStageContext(ctx, 2)
+	err := chromedp.Run(clickCtx, chromedp.Click(selector, chromedp.ByQuery))
+	cancel()
 	if err != nil {
 		log.Printf("CRAWL [%s] WARN: native click failed on %q, trying JS click: %v", sessionID, selector, err)
 		// Fallback: JS click
+		jsCtx, cancel := crawlStageContext(ctx, 1)
+		defer cancel()
 		jsClick := fmt.Sprintf(`document.querySelector(%q)?.click()`, selector)
 		var result interface{}
-		return chromedp.Run(ctx, chromedp.Evaluate(jsClick, &result))
+		return chromedp.Run(jsCtx, chromedp.Evaluate(jsClick, &result))
 	}
 	return nil
 }
@@ -402,10 +671,11 @@ func (a *Agent) crawlSelect(ctx context.Context, selector, value string) error {
 	)
 }
 
-// crawlComboboxSelect handles custom combobox/dropdown components.
+// crawlComboboxSelect handles custom combobox/dropdown components. The
+// stages share the time left on ctx.
 func (a *Agent) crawlComboboxSelect(ctx context.Context, selector, value string) error {
 	// Click the combobox trigger
-	clickCtx, clickCancel := context.WithTimeout(ctx, 5*time.Second)
+	clickCtx, clickCancel := crawlStageContext(ctx, 3)
 	defer clickCancel()
 	if err := chromedp.Run(clickCtx, chromedp.Click(selector, chromedp.ByQuery)); err != nil {
 		return fmt.Errorf("click combobox trigger: %w", err)
@@ -415,7 +685,7 @@ func (a *Agent) crawlComboboxSelect(ctx context.Context, selector, value string)
 	_ = chromedp.Run(ctx, chromedp.Sleep(300*time.Millisecond))
 
 	// Type the value to filter options
-	typeCtx, typeCancel := context.WithTimeout(ctx, 5*time.Second)
+	typeCtx, typeCancel := crawlStageContext(ctx, 2)
 	defer typeCancel()
 	if err := chromedp.Run(typeCtx, chromedp.SendKeys(selector, value, chromedp.ByQuery)); err != nil {
 		return fmt.Errorf("type combobox value: %w", err)
@@ -424,11 +694,7 @@ func (a *Agent) crawlComboboxSelect(ctx context.Context, selector, value string)
 	// Wait for options to filter
 	_ = chromedp.Run(ctx, chromedp.Sleep(300*time.Millisecond))
 
-	// Click the first visible option
-	optionCtx, optionCancel := context.WithTimeout(ctx, 5*time.Second)
-	defer optionCancel()
-
-	// Try common option selectors
+	// Click the first visible option, trying common option selectors
 	optionSelectors := []string{
 		`[role="option"]:not([aria-hidden="true"])`,
 		`[role="listbox"] [role="option"]`,
@@ -436,8 +702,11 @@ func (a *Agent) crawlComboboxSelect(ctx context.Context, selector, value string)
 		`li[data-value]`,
 	}
 
-	for _, optSel := range optionSelectors {
-		if err := chromedp.Run(optionCtx, chromedp.Click(optSel, chromedp.ByQuery)); err == nil {
+	for i, optSel := range optionSelectors {
+		optionCtx, optionCancel := crawlStageContext(ctx, len(optionSelectors)-i)
+		err := chromedp.Run(optionCtx, chromedp.Click(optSel, chromedp.ByQuery))
+		optionCancel()
+		if err == nil {
 			return nil
 		}
 	}
@@ -447,43 +716,3 @@ func (a *Agent) crawlComboboxSelect(ctx context.Context, selector, value string)
 
 // --- Cookie consent dismissal ---
 
-// dismissCookieConsent attempts to dismiss common cookie consent overlays.
-func (a *Agent) dismissCookieConsent(ctx context.Context, sessionID string) {
-	// Try each button text with exact matching to avoid false positives
-	buttonTexts := []string{
-		"Accept All",
-		"Accept all",
-		"Accept All Cookies",
-		"Accept all cookies",
-		"Accept Cookies",
-		"Accept cookies",
-		"I Accept",
-		"I Agree",
-		"Agree",
-		"Allow All",
-		"Allow all",
-		"Got it",
-		"OK",
-	}
-
-	for _, text := range buttonTexts {
-		// Use XPath to find buttons with exact text content
-		xpath := fmt.Sprintf(
-			`//button[normalize-space(.)=%q] | //a[normalize-space(.)=%q] | //*[@role="button"][normalize-space(.)=%q]`,
-			text, text, text,
-		)
-
-		clickCtx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
-		err := chromedp.Run(clickCtx, chromedp.Click(xpath, chromedp.BySearch))
-		cancel()
-
-		if err == nil {
-			log.Printf("CRAWL [%s] Dismissed cookie consent with %q", sessionID, text)
-			// Wait for overlay to disappear
-			_ = chromedp.Run(ctx, chromedp.Sleep(500*time.Millisecond))
-			return
-		}
-	}
-
-	log.Printf("CRAWL [%s] No cookie consent overlay detected", sessionID)
-}
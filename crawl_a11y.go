@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/accessibility"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	axeCoreVersion      = "4.10.2"
+	defaultAxeScriptURL = "https://cdn.jsdelivr.net/npm/axe-core@" + axeCoreVersion + "/axe.min.js"
+
+	maxA11yTreeLines         = 1500
+	maxA11yNodesPerViolation = 10
+	maxA11yHTMLLen           = 300
+)
+
+// CrawlA11yAudit enables an axe-core audit on every crawl step. The script
+// is downloaded from ScriptURL (default: jsDelivr) once and cached in
+// ~/.qmax/cache; it is only used when it matches Integrity.
+type CrawlA11yAudit struct {
+	Enabled   bool     `json:"enabled"`
+	Tags      []string `json:"tags,omitempty"` // e.g. wcag2a, wcag2aa, best-practice
+	ScriptURL string   `json:"script_url,omitempty"`
+	Integrity string   `json:"script_integrity,omitempty"` // e.g. sha384-<base64>
+
+	// Local only: read the script from this file instead (--axe-script).
+	ScriptPath string `json:"-"`
+}
+
+// CrawlA11yViolation is one failed axe-core rule on a page.
+type CrawlA11yViolation struct {
+	Rule        string          `json:"rule"`
+	Impact      string          `json:"impact"`
+	Description string          `json:"description"`
+	HelpURL     string          `json:"help_url,omitempty"`
+	NodeCount   int             `json:"node_count"`
+	Nodes       []CrawlA11yNode `json:"nodes"`
+}
+
+// CrawlA11yNode is an element that failed a rule.
+type CrawlA11yNode struct {
+	Target string `json:"target"`
+	HTML   string `json:"html"`
+}
+
+// --- Accessibility tree ---
+
+// captureAccessibilityTree renders the page's full accessibility tree from
+// the CDP Accessibility domain as indented "role "name"" lines.
+func captureAccessibilityTree(ctx context.Context) (string, error) {
+	var nodes []*accessibility.Node
+	if err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		nodes, err = accessibility.GetFullAXTree().Do(ctx)
+		return err
+	})); err != nil {
+		return "", err
+	}
+	return renderAccessibilityTree(nodes), nil
+}
+
+// renderAccessibilityTree formats AX nodes depth-first. Ignored nodes and
+// unnamed generic containers are skipped, but their children are kept.
+func renderAccessibilityTree(nodes []*accessibility.Node) string {
+	byID := make(map[accessibility.NodeID]*accessibility.Node, len(nodes))
+	for _, n := range nodes {
+		byID[n.NodeID] = n
+	}
+
+	var b strings.Builder
+	lines := 0
+	var walk func(n *accessibility.Node, depth int)
+	walk = func(n *accessibility.Node, depth int) {
+		if lines >= maxA11yTreeLines {
+			return
+		}
+		role := axValueString(n.Role)
+		name := axValueString(n.Name)
+		skip := n.Ignored || ((role == "generic" || role == "none" || role == "") && name == "")
+		if !skip {
+			b.WriteString(strings.Repeat("  ", depth))
+			b.WriteString(role)
+			if name != "" {
+				b.WriteString(" " + jsString(truncate(name, 200)))
+			}
+			if v := axValueString(n.Value); v != "" {
+				b.WriteString(" value=" + jsString(truncate(v, 100)))
+			}
+			for _, p := range n.Properties {
+				switch p.Name {
+				case accessibility.PropertyNameChecked, accessibility.PropertyNameDisabled,
+					accessibility.PropertyNameExpanded, accessibility.PropertyNameLevel,
+					accessibility.PropertyNameRequired, accessibility.PropertyNameSelected,
+					accessibility.PropertyNameInvalid:
+					if v := axValueString(p.Value); v != "" && v != "false" {
+						fmt.Fprintf(&b, " %s=%s", p.Name, v)
+					}
+				}
+			}
+			b.WriteByte('\n')
+			lines++
+			depth++
+		}
+		for _, id := range n.ChildIDs {
+			if child, ok := byID[id]; ok {
+				walk(child, depth)
+			}
+		}
+	}
+
+	for _, n := range nodes {
+		if n.ParentID == "" {
+			walk(n, 0)
+		}
+	}
+	if lines >= maxA11yTreeLines {
+		b.WriteString("... (truncated)\n")
+	}
+	return b.String()
+}
+
+// axValueString returns the plain value of an AX value, or "".
+func axValueString(v *accessibility.Value) string {
+	if v == nil || len(v.Value) == 0 {
+		return ""
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(v.Value, &decoded); err != nil {
+		return ""
+	}
+	switch x := decoded.(type) {
+	case string:
+		return x
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+// --- axe-core audit ---
+
+// crawlA11yAuditor runs axe-core on each step of one crawl session.
+type crawlA11yAuditor struct {
+	source string
+	tags   []string
+}
+
+// newCrawlA11yAuditor loads the axe-core script. Returns nil, nil when the
+// audit is disabled.
+func (a *Agent) newCrawlA11yAuditor(audit *CrawlA11yAudit) (*crawlA11yAuditor, error) {
+	if audit == nil || !audit.Enabled {
+		return nil, nil
+	}
+	source, err := a.loadAxeSource(audit)
+	if err != nil {
+		return nil, err
+	}
+	return &crawlA11yAuditor{source: source, tags: audit.Tags}, nil
+}
+
+// loadAxeSource reads axe-core from the local script path, the local cache,
+// or downloads it into the cache. Downloaded and cached copies must match
+// the audit's integrity hash.
+func (a *Agent) loadAxeSource(audit *CrawlA11yAudit) (string, error) {
+	if audit.ScriptPath != "" {
+		path, err := expandHomePath(audit.ScriptPath)
+		if err != nil {
+			return "", err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("read axe-core script: %w", err)
+		}
+		return string(data), nil
+	}
+	if audit.Integrity == "" {
+		return "", errors.New("no integrity hash for axe-core: set script_integrity, or pass --axe-integrity or --axe-script")
+	}
+
+	scriptURL := audit.ScriptURL
+	cacheName := "axe-core-" + axeCoreVersion + ".min.js"
+	if scriptURL == "" {
+		scriptURL = defaultAxeScriptURL
+	} else {
+		cacheName = ""
+	}
+
+	var cachePath string
+	if dir, err := ConfigDir(); err == nil && cacheName != "" {
+		cachePath = filepath.Join(dir, "cache", cacheName)
+		if data, err := os.ReadFile(cachePath); err == nil && checkIntegrity(data, audit.Integrity) == nil {
+			return string(data), nil
+		}
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(scriptURL)
+	if err != nil {
+		return "", fmt.Errorf("download axe-core: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download axe-core: HTTP %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 5*1024*1024))
+	if err != nil {
+		return "", fmt.Errorf("download axe-core: %w", err)
+	}
+	if err := checkIntegrity(data, audit.Integrity); err != nil {
+		return "", fmt.Errorf("download axe-core from %s: %w", scriptURL, err)
+	}
+
+	if cachePath != "" {
+		if err := os.MkdirAll(filepath.Dir(cachePath), 0700); err == nil {
+			_ = os.WriteFile(cachePath, data, 0600)
+		}
+	}
+	return string(data), nil
+}
+
+// checkIntegrity checks data against a Subresource Integrity value: one or
+// more space-separated "sha256-", "sha384-" or "sha512-" base64 digests, of
+// which one must match.
+func checkIntegrity(data []byte, integrity string) error {
+	for _, want := range strings.Fields(integrity) {
+		alg, digest, _ := strings.Cut(want, "-")
+		var sum []byte
+		switch alg {
+		case "sha256":
+			s := sha256.Sum256(data)
+			sum = s[:]
+		case "sha384":
+			s := sha512.Sum384(data)
+			sum = s[:]
+		case "sha512":
+			s := sha512.Sum512(data)
+			sum = s[:]
+		default:
+			continue
+		}
+		if base64.StdEncoding.EncodeToString(sum) == digest {
+			return nil
+		}
+	}
+	return fmt.Errorf("integrity check failed: content does not match %q", integrity)
+}
+
+// axeRunJS returns a script that runs axe and maps its violations to the
+// CrawlA11yViolation layout.
+func axeRunJS(tags []string) string {
+	options := "{}"
+	if len(tags) > 0 {
+		data, _ := json.Marshal(map[string]interface{}{
+			"runOnly": map[string]interface{}{"type": "tag", "values": tags},
+		})
+		options = string(data)
+	}
+	return fmt.Sprintf(`axe.run(document, %s).then((r) => r.violations.map((v) => ({
+	rule: v.id,
+	impact: v.impact || '',
+	description: v.help || v.description || '',
+	help_url: v.helpUrl || '',
+	node_count: v.nodes.length,
+	nodes: v.nodes.slice(0, %d).map((n) => ({
+		target: (n.target || []).map((t) => Array.isArray(t) ? t.join(' >>> ') : t).join(' '),
+		html: (n.html || '').slice(0, %d),
+	})),
+})))`, options, maxA11yNodesPerViolation, maxA11yHTMLLen)
+}
+
+// audit injects axe-core if the page does not have it yet and returns the
+// violations found on the current page.
+func (au *crawlA11yAuditor) audit(ctx context.Context) ([]CrawlA11yViolation, error) {
+	auditCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	var loaded bool
+	if err := chromedp.Run(auditCtx, chromedp.Evaluate(`typeof window.axe !== 'undefined'`, &loaded)); err != nil {
+		return nil, fmt.Errorf("check axe-core: %w", err)
+	}
+	if !loaded {
+		var ignored interface{}
+		if err := chromedp.Run(auditCtx, chromedp.Evaluate(au.source+"\n;true", &ignored)); err != nil {
+			return nil, fmt.Errorf("inject axe-core: %w", err)
+		}
+	}
+
+	var violations []CrawlA11yViolation
+	if err := chromedp.Run(auditCtx, chromedp.Evaluate(axeRunJS(au.tags), &violations,
+		func(p *runtime.EvaluateParams) *runtime.EvaluateParams { return p.WithAwaitPromise(true) },
+	)); err != nil {
+		return nil, fmt.Errorf("run axe-core: %w", err)
+	}
+	return violations, nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chromedp/cdproto/accessibility"
+)
+
+func axValue(v interface{}) *accessibility.Value {
+	data, _ := json.Marshal(v)
+	return &accessibility.Value{Type: accessibility.ValueTypeString, Value: data}
+}
+
+func TestRenderAccessibilityTree(t *testing.T) {
+	nodes := []*accessibility.Node{
+		{NodeID: "1", Role: axValue("RootWebArea"), Name: axValue("Shop"), ChildIDs: []accessibility.NodeID{"2", "5"}},
+		{NodeID: "2", ParentID: "1", Role: axValue("generic"), ChildIDs: []accessibility.NodeID{"3", "4"}},
+		{NodeID: "3", ParentID: "2", Role: axValue("heading"), Name: axValue("Cart"), Properties: []*accessibility.Property{
+			{Name: accessibility.PropertyNameLevel, Value: axValue(2)},
+			{Name: accessibility.PropertyNameDisabled, Value: axValue(false)},
+		}},
+		{NodeID: "4", ParentID: "2", Role: axValue("textbox"), Name: axValue("Qty"), Value: axValue("3"), Properties: []*accessibility.Property{
+			{Name: accessibility.PropertyNameRequired, Value: axValue(true)},
+		}},
+		{NodeID: "5", ParentID: "1", Ignored: true, Role: axValue("button"), Name: axValue("Hidden"), ChildIDs: []accessibility.NodeID{"6"}},
+		{NodeID: "6", ParentID: "5", Role: axValue("StaticText"), Name: axValue("kept")},
+	}
+
+	got := renderAccessibilityTree(nodes)
+	want := `RootWebArea "Shop"
+  heading "Cart" level=2
+  textbox "Qty" value="3" required=true
+  StaticText "kept"
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRenderAccessibilityTree_Truncates(t *testing.T) {
+	root := &accessibility.Node{NodeID: "root", Role: axValue("RootWebArea")}
+	nodes := []*accessibility.Node{root}
+	for i := 0; i < maxA11yTreeLines+10; i++ {
+		id := accessibility.NodeID(fmt.Sprintf("n%d", i))
+		root.ChildIDs = append(root.ChildIDs, id)
+		nodes = append(nodes, &accessibility.Node{NodeID: id, ParentID: "root", Role: axValue("link"), Name: axValue("x")})
+	}
+	got := renderAccessibilityTree(nodes)
+	if strings.Count(got, "\n") != maxA11yTreeLines+1 || !strings.HasSuffix(got, "... (truncated)\n") {
+		t.Errorf("expected %d lines plus truncation marker, got %d", maxA11yTreeLines, strings.Count(got, "\n"))
+	}
+}
+
+func TestAxValueString(t *testing.T) {
+	if axValueString(nil) != "" || axValueString(&accessibility.Value{}) != "" {
+		t.Error("empty values should render as empty strings")
+	}
+	if got := axValueString(axValue(true)); got != "true" {
+		t.Errorf("bool: got %q", got)
+	}
+	if got := axValueString(axValue("Sign in")); got != "Sign in" {
+		t.Errorf("string: got %q", got)
+	}
+}
+
+func TestAxeRunJS(t *testing.T) {
+	if js := axeRunJS(nil); !strings.HasPrefix(js, "axe.run(document, {})") {
+		t.Errorf("unexpected script: %s", js)
+	}
+	js := axeRunJS([]string{"wcag2a", "wcag2aa"})
+	if !strings.Contains(js, `{"runOnly":{"type":"tag","values":["wcag2a","wcag2aa"]}}`) {
+		t.Errorf("tags not passed to axe: %s", js)
+	}
+}
+
+func TestNewCrawlA11yAuditor_Disabled(t *testing.T) {
+	a := &Agent{}
+	for _, audit := range []*CrawlA11yAudit{nil, {Enabled: false, ScriptPath: "/nope"}} {
+		au, err := a.newCrawlA11yAuditor(audit)
+		if au != nil || err != nil {
+			t.Errorf("disabled audit should give nil, nil; got %v, %v", au, err)
+		}
+	}
+}
+
+func TestLoadAxeSource_Path(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "axe.min.js")
+	if err := os.WriteFile(path, []byte("window.axe = {};"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	a := &Agent{}
+	au, err := a.newCrawlA11yAuditor(&CrawlA11yAudit{Enabled: true, ScriptPath: path, Tags: []string{"wcag2a"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if au.source != "window.axe = {};" || len(au.tags) != 1 {
+		t.Errorf("unexpected auditor: %+v", au)
+	}
+
+	if _, err := a.loadAxeSource(&CrawlA11yAudit{ScriptPath: filepath.Join(t.TempDir(), "missing.js")}); err == nil {
+		t.Error("expected error for missing script")
+	}
+}
+
+func testIntegrity(data string) string {
+	sum := sha512.Sum384([]byte(data))
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestLoadAxeSource_URL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/axe.min.js" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, "/* axe */")
+	}))
+	defer server.Close()
+
+	a := &Agent{}
+	source, err := a.loadAxeSource(&CrawlA11yAudit{ScriptURL: server.URL + "/axe.min.js", Integrity: testIntegrity("/* axe */")})
+	if err != nil || source != "/* axe */" {
+		t.Errorf("got %q, %v", source, err)
+	}
+	if _, err := a.loadAxeSource(&CrawlA11yAudit{ScriptURL: server.URL + "/missing.js", Integrity: testIntegrity("/* axe */")}); err == nil {
+		t.Error("expected error on 404")
+	}
+	if _, err := a.loadAxeSource(&CrawlA11yAudit{ScriptURL: server.URL + "/axe.min.js", Integrity: testIntegrity("/* other */")}); err == nil {
+		t.Error("expected error when the download does not match the integrity hash")
+	}
+	if _, err := a.loadAxeSource(&CrawlA11yAudit{ScriptURL: server.URL + "/axe.min.js"}); err == nil {
+		t.Error("expected error without an integrity hash")
+	}
+}
+
+func TestLoadAxeSource_Cache(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	cache := filepath.Join(home, configDirName, "cache", "axe-core-"+axeCoreVersion+".min.js")
+	if err := os.MkdirAll(filepath.Dir(cache), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cache, []byte("/* cached */"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	a := &Agent{}
+	source, err := a.loadAxeSource(&CrawlA11yAudit{Enabled: true, Integrity: testIntegrity("/* cached */")})
+	if err != nil || source != "/* cached */" {
+		t.Errorf("expected cached copy, got %q, %v", source, err)
+	}
+}
+
+func TestCheckIntegrity(t *testing.T) {
+	data := []byte("window.axe = {};")
+	sum256 := sha256.Sum256(data)
+	sum512 := sha512.Sum512(data)
+	for _, integrity := range []string{
+		testIntegrity(string(data)),
+		"sha256-" + base64.StdEncoding.EncodeToString(sum256[:]),
+		"sha512-" + base64.StdEncoding.EncodeToString(sum512[:]),
+		"md5-abc " + testIntegrity(string(data)),
+	} {
+		if err := checkIntegrity(data, integrity); err != nil {
+			t.Errorf("%s: %v", integrity, err)
+		}
+	}
+	for _, integrity := range []string{"", "md5-abc", testIntegrity("tampered"), "sha384-"} {
+		if err := checkIntegrity(data, integrity); err == nil {
+			t.Errorf("%q should not match", integrity)
+		}
+	}
+}
+
+func TestCrawlA11yAudit_ScriptPathIsLocalOnly(t *testing.T) {
+	var audit CrawlA11yAudit
+	if err := json.Unmarshal([]byte(`{"enabled": true, "script_path": "/etc/passwd"}`), &audit); err != nil {
+		t.Fatal(err)
+	}
+	if audit.ScriptPath != "" {
+		t.Errorf("script_path from the server should be ignored, got %q", audit.ScriptPath)
+	}
+}
+
+func TestCrawlSnapshot_A11ySerialization(t *testing.T) {
+	snap := CrawlSnapshot{StepNum: 1, A11yViolations: []CrawlA11yViolation{{
+		Rule: "image-alt", Impact: "critical", Description: "Images must have alternate text", NodeCount: 1,
+		Nodes: []CrawlA11yNode{{Target: "img.logo", HTML: `<img class="logo">`}},
+	}}}
+	data, _ := json.Marshal(snap)
+	if !strings.Contains(string(data), `"a11y_violations":[{"rule":"image-alt","impact":"critical"`) {
+		t.Errorf("unexpected JSON: %s", data)
+	}
+
+	var session CrawlSession
+	if err := json.Unmarshal([]byte(`{"session_id": "a", "a11y_audit": {"enabled": true, "tags": ["wcag2aa"]}}`), &session); err != nil {
+		t.Fatal(err)
+	}
+	if session.A11yAudit == nil || !session.A11yAudit.Enabled || session.A11yAudit.Tags[0] != "wcag2aa" {
+		t.Errorf("A11yAudit: got %+v", session.A11yAudit)
+	}
+}
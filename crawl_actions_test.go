@@ -250,7 +250,7 @@ func TestDismissCookieConsent_WithAcceptAllButton(t *testing.T) {
 	}
 
 	a := &Agent{}
-	a.dismissCookieConsent(ctx, "cookie-test")
+	a.dismissCookieConsent(ctx, "cookie-test", nil)
 
 	var title string
 	_ = chromedp.Run(ctx, chromedp.Title(&title))
@@ -280,7 +280,7 @@ func TestDismissCookieConsent_NoBannerPresent(t *testing.T) {
 
 	a := &Agent{}
 	// Should not panic when no cookie banner exists
-	a.dismissCookieConsent(ctx, "no-cookie-test")
+	a.dismissCookieConsent(ctx, "no-cookie-test", nil)
 }
 
 // --- captureSnapshot tests ---
@@ -567,19 +567,19 @@ func TestExecuteCrawlAction_AllActionTypes(t *testing.T) {
 	a := &Agent{}
 
 	// Test fill action
-	err := a.executeCrawlAction(ctx, "multi-test", &CrawlAction{Action: "fill", Selector: "#input", Value: "hello"})
+	err := a.executeCrawlAction(ctx, "multi-test", &CrawlAction{Action: "fill", Selector: "#input", Value: "hello"}, nil, 0)
 	if err != nil {
 		t.Errorf("fill action failed: %v", err)
 	}
 
 	// Test select action
-	err = a.executeCrawlAction(ctx, "multi-test", &CrawlAction{Action: "select", Selector: "#sel", Value: "b"})
+	err = a.executeCrawlAction(ctx, "multi-test", &CrawlAction{Action: "select", Selector: "#sel", Value: "b"}, nil, 0)
 	if err != nil {
 		t.Errorf("select action failed: %v", err)
 	}
 
 	// Test click action
-	err = a.executeCrawlAction(ctx, "multi-test", &CrawlAction{Action: "click", Selector: "#btn"})
+	err = a.executeCrawlAction(ctx, "multi-test", &CrawlAction{Action: "click", Selector: "#btn"}, nil, 0)
 	if err != nil {
 		t.Errorf("click action failed: %v", err)
 	}
@@ -607,7 +607,7 @@ func TestExecuteCrawlAction_ComboboxSelect(t *testing.T) {
 	}
 
 	a := &Agent{}
-	err := a.executeCrawlAction(ctx, "combo-test", &CrawlAction{Action: "combobox_select", Selector: "#combo", Value: "test"})
+	err := a.executeCrawlAction(ctx, "combo-test", &CrawlAction{Action: "combobox_select", Selector: "#combo", Value: "test"}, nil, 0)
 	// Expected to fail (no options to select)
 	if err == nil {
 		t.Log("combobox_select succeeded (unexpected but not an error)")
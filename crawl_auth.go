@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/storage"
+	"github.com/chromedp/chromedp"
+)
+
+// defaultLoggedOutURLPattern matches the usual login/sign-in routes an app
+// redirects to once its session is gone.
+const defaultLoggedOutURLPattern = `(?i)/(login|log-in|signin|sign-in|auth/login|session/new)(/|\?|#|$)`
+
+// loadCrawlStorageState resolves the Playwright storage state a crawl session
+// should start with. The inline copy from the server wins over a local file.
+// Returns nil (no error) when the session is unauthenticated.
+func loadCrawlStorageState(session CrawlSession) (*playwrightStorageState, error) {
+	data := []byte(session.StorageState)
+	if len(data) == 0 || string(data) == "null" {
+		if session.StorageStatePath == "" {
+			return nil, nil
+		}
+		path, err := expandHomePath(session.StorageStatePath)
+		if err != nil {
+			return nil, err
+		}
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read storage state: %w", err)
+		}
+	}
+
+	// The server may send the state as a JSON string, as stored in user data fields
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err == nil {
+		data = []byte(encoded)
+	}
+
+	var state playwrightStorageState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("parse storage state: %w", err)
+	}
+	return &state, nil
+}
+
+// expandHomePath expands a leading ~/ to the user's home directory.
+func expandHomePath(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine home directory: %w", err)
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~")), nil
+}
+
+// storageStateCookieParams converts Playwright cookies to CDP cookie params.
+func storageStateCookieParams(state *playwrightStorageState) []*network.CookieParam {
+	params := make([]*network.CookieParam, 0, len(state.Cookies))
+	for _, c := range state.Cookies {
+		p := &network.CookieParam{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HTTPOnly: c.HTTPOnly,
+		}
+		switch c.SameSite {
+		case "Lax":
+			p.SameSite = network.CookieSameSiteLax
+		case "Strict":
+			p.SameSite = network.CookieSameSiteStrict
+		case "None":
+			p.SameSite = network.CookieSameSiteNone
+		}
+		// Playwright uses -1 for session cookies
+		if c.Expires > 0 {
+			sec, frac := math.Modf(c.Expires)
+			expires := cdp.TimeSinceEpoch(time.Unix(int64(sec), int64(frac*1e9)))
+			p.Expires = &expires
+		}
+		params = append(params, p)
+	}
+	return params
+}
+
+// storageStateLocalStorage extracts origin -> localStorage entries from the
+// loosely typed Origins section of a storage state.
+func storageStateLocalStorage(state *playwrightStorageState) map[string][][2]string {
+	result := map[string][][2]string{}
+	for _, o := range state.Origins {
+		origin, _ := o["origin"].(string)
+		if origin == "" {
+			continue
+		}
+		var items [][2]string
+		switch entries := o["localStorage"].(type) {
+		case []interface{}:
+			for _, raw := range entries {
+				entry, ok := raw.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				name, _ := entry["name"].(string)
+				value, _ := entry["value"].(string)
+				if name != "" {
+					items = append(items, [2]string{name, value})
+				}
+			}
+		case []map[string]string:
+			for _, entry := range entries {
+				if entry["name"] != "" {
+					items = append(items, [2]string{entry["name"], entry["value"]})
+				}
+			}
+		}
+		if len(items) > 0 {
+			result[strings.TrimRight(origin, "/")] = items
+		}
+	}
+	return result
+}
+
+// storageStateLocalStorageScript builds a script that seeds localStorage the
+// first time each origin is loaded in the tab. A sessionStorage marker keeps
+// it from re-seeding after the app clears storage on logout.
+func storageStateLocalStorageScript(state *playwrightStorageState) string {
+	origins := storageStateLocalStorage(state)
+	if len(origins) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(origins)
+	return fmt.Sprintf(`(function () {
+  var origins = %s;
+  var items = origins[location.origin];
+  if (!items) return;
+  try {
+    if (sessionStorage.getItem('__qmax_storage_state')) return;
+    for (var i = 0; i < items.length; i++) localStorage.setItem(items[i][0], items[i][1]);
+    sessionStorage.setItem('__qmax_storage_state', '1');
+  } catch (e) {}
+})();`, data)
+}
+
+// applyStorageState injects cookies and localStorage into the crawl browser.
+// Must run before the first navigation.
+func (a *Agent) applyStorageState(ctx context.Context, sessionID string, state *playwrightStorageState) error {
+	cookies := storageStateCookieParams(state)
+	script := storageStateLocalStorageScript(state)
+
+	if err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		if len(cookies) > 0 {
+			if err := network.SetCookies(cookies).Do(ctx); err != nil {
+				return fmt.Errorf("set cookies: %w", err)
+			}
+		}
+		if script != "" {
+			if _, err := page.AddScriptToEvaluateOnNewDocument(script).Do(ctx); err != nil {
+				return fmt.Errorf("seed localStorage: %w", err)
+			}
+		}
+		return nil
+	})); err != nil {
+		return err
+	}
+
+	log.Printf("CRAWL [%s] Injected storage state: %d cookies, %d localStorage origins",
+		sessionID, len(cookies), len(storageStateLocalStorage(state)))
+	return nil
+}
+
+// --- Logout detection ---
+
+// crawlAuthWatcher detects when an authenticated crawl loses its session,
+// either by landing on a login route or by its auth cookies disappearing.
+type crawlAuthWatcher struct {
+	loggedOutURL *regexp.Regexp
+	cookieNames  map[string]bool
+	checkURL     bool
+	loggedOut    bool
+}
+
+func newCrawlAuthWatcher(session CrawlSession, state *playwrightStorageState) (*crawlAuthWatcher, error) {
+	pattern := session.LoggedOutURLPattern
+	if pattern == "" {
+		pattern = defaultLoggedOutURLPattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid logged_out_url_pattern: %w", err)
+	}
+
+	w := &crawlAuthWatcher{
+		loggedOutURL: re,
+		cookieNames:  map[string]bool{},
+		// A crawl deliberately started on the login page tells us nothing
+		checkURL: !re.MatchString(session.URL),
+	}
+	now := float64(time.Now().Unix())
+	for _, c := range state.Cookies {
+		if c.Expires <= 0 || c.Expires > now {
+			w.cookieNames[c.Name] = true
+		}
+	}
+	return w, nil
+}
+
+// detect reports whether the page looks logged out, given the current URL and
+// the cookie names present in the browser.
+func (w *crawlAuthWatcher) detect(currentURL string, cookieNames map[string]bool) (bool, string) {
+	if w.checkURL && w.loggedOutURL.MatchString(currentURL) {
+		return true, fmt.Sprintf("redirected to login page %s", currentURL)
+	}
+	if len(w.cookieNames) > 0 {
+		for name := range w.cookieNames {
+			if cookieNames[name] {
+				return false, ""
+			}
+		}
+		return true, "all injected auth cookies were cleared"
+	}
+	return false, ""
+}
+
+// check inspects the browser and returns whether the session is logged out.
+// Only the first detection is logged; later calls keep reporting true.
+func (w *crawlAuthWatcher) check(ctx context.Context, sessionID string, stepNum int, currentURL string) (bool, string) {
+	present := map[string]bool{}
+	if len(w.cookieNames) > 0 {
+		checkCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		var cookies []*network.Cookie
+		if err := chromedp.Run(checkCtx, chromedp.ActionFunc(func(ctx context.Context) error {
+			var err error
+			cookies, err = storage.GetCookies().Do(ctx)
+			return err
+		})); err != nil {
+			log.Printf("CRAWL [%s] WARN: could not read cookies for logout check: %v", sessionID, err)
+			return w.loggedOut, ""
+		}
+		for _, c := range cookies {
+			present[c.Name] = true
+		}
+	}
+
+	loggedOut, reason := w.detect(currentURL, present)
+	if loggedOut && !w.loggedOut {
+		log.Printf("CRAWL [%s] WARN: session logged out at step %d: %s", sessionID, stepNum, reason)
+	}
+	w.loggedOut = w.loggedOut || loggedOut
+	return loggedOut, reason
+}
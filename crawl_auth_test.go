@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+const testStorageStateJSON = `{
+  "cookies": [
+    {"name": "sid", "value": "abc", "domain": ".example.com", "path": "/", "expires": -1, "httpOnly": true, "secure": true, "sameSite": "Lax"},
+    {"name": "remember", "value": "1", "domain": "example.com", "path": "/", "expires": 4102444800.5, "httpOnly": false, "secure": false, "sameSite": "None"}
+  ],
+  "origins": [
+    {"origin": "https://example.com", "localStorage": [{"name": "token", "value": "jwt-123"}]}
+  ]
+}`
+
+func TestLoadCrawlStorageState_None(t *testing.T) {
+	state, err := loadCrawlStorageState(CrawlSession{SessionID: "s"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if state != nil {
+		t.Errorf("expected nil state, got %+v", state)
+	}
+}
+
+func TestLoadCrawlStorageState_Inline(t *testing.T) {
+	state, err := loadCrawlStorageState(CrawlSession{StorageState: json.RawMessage(testStorageStateJSON)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(state.Cookies) != 2 {
+		t.Errorf("expected 2 cookies, got %d", len(state.Cookies))
+	}
+	if len(state.Origins) != 1 {
+		t.Errorf("expected 1 origin, got %d", len(state.Origins))
+	}
+}
+
+func TestLoadCrawlStorageState_InlineJSONString(t *testing.T) {
+	// User data fields store the state as a string value
+	encoded, _ := json.Marshal(testStorageStateJSON)
+	state, err := loadCrawlStorageState(CrawlSession{StorageState: encoded})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(state.Cookies) != 2 {
+		t.Errorf("expected 2 cookies, got %d", len(state.Cookies))
+	}
+}
+
+func TestLoadCrawlStorageState_FromFile(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("HOME", tmp)
+	if err := os.WriteFile(filepath.Join(tmp, "state.json"), []byte(testStorageStateJSON), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := loadCrawlStorageState(CrawlSession{StorageStatePath: "~/state.json"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(state.Cookies) != 2 {
+		t.Errorf("expected 2 cookies, got %d", len(state.Cookies))
+	}
+}
+
+func TestLoadCrawlStorageState_Errors(t *testing.T) {
+	if _, err := loadCrawlStorageState(CrawlSession{StorageStatePath: filepath.Join(t.TempDir(), "missing.json")}); err == nil {
+		t.Error("expected error for missing file")
+	}
+	if _, err := loadCrawlStorageState(CrawlSession{StorageState: json.RawMessage(`{"cookies": 5}`)}); err == nil {
+		t.Error("expected error for malformed state")
+	}
+}
+
+func TestStorageStateCookieParams(t *testing.T) {
+	state, _ := loadCrawlStorageState(CrawlSession{StorageState: json.RawMessage(testStorageStateJSON)})
+	params := storageStateCookieParams(state)
+	if len(params) != 2 {
+		t.Fatalf("expected 2 params, got %d", len(params))
+	}
+
+	sid := params[0]
+	if sid.Name != "sid" || sid.Domain != ".example.com" || !sid.HTTPOnly || !sid.Secure {
+		t.Errorf("unexpected sid param: %+v", sid)
+	}
+	if sid.SameSite != network.CookieSameSiteLax {
+		t.Errorf("SameSite: got %q", sid.SameSite)
+	}
+	if sid.Expires != nil {
+		t.Error("session cookie should not have an expiry")
+	}
+
+	remember := params[1]
+	if remember.SameSite != network.CookieSameSiteNone {
+		t.Errorf("SameSite: got %q", remember.SameSite)
+	}
+	if remember.Expires == nil {
+		t.Fatal("expected expiry on persistent cookie")
+	}
+	if got := remember.Expires.Time().Unix(); got != 4102444800 {
+		t.Errorf("Expires: got %d", got)
+	}
+}
+
+func TestStorageStateLocalStorage(t *testing.T) {
+	// Built in-process by qmax capture
+	built := buildPlaywrightStorageState(nil, "https://app.example.com/dashboard", []map[string]string{
+		{"name": "a", "value": "1"},
+	})
+	got := storageStateLocalStorage(&built)
+	if items := got["https://app.example.com"]; len(items) != 1 || items[0] != [2]string{"a", "1"} {
+		t.Errorf("unexpected items from built state: %v", got)
+	}
+
+	// Parsed from JSON
+	state, _ := loadCrawlStorageState(CrawlSession{StorageState: json.RawMessage(testStorageStateJSON)})
+	got = storageStateLocalStorage(state)
+	if items := got["https://example.com"]; len(items) != 1 || items[0] != [2]string{"token", "jwt-123"} {
+		t.Errorf("unexpected items from parsed state: %v", got)
+	}
+}
+
+func TestStorageStateLocalStorageScript(t *testing.T) {
+	if script := storageStateLocalStorageScript(&playwrightStorageState{}); script != "" {
+		t.Errorf("expected no script without localStorage, got %q", script)
+	}
+
+	state, _ := loadCrawlStorageState(CrawlSession{StorageState: json.RawMessage(testStorageStateJSON)})
+	script := storageStateLocalStorageScript(state)
+	if !strings.Contains(script, `"https://example.com":[["token","jwt-123"]]`) {
+		t.Errorf("script missing origin entries: %s", script)
+	}
+	if !strings.Contains(script, "__qmax_storage_state") {
+		t.Error("script should guard against re-seeding")
+	}
+}
+
+func TestCrawlAuthWatcher_Detect(t *testing.T) {
+	state, _ := loadCrawlStorageState(CrawlSession{StorageState: json.RawMessage(testStorageStateJSON)})
+	w, err := newCrawlAuthWatcher(CrawlSession{URL: "https://example.com/dashboard"}, state)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		url       string
+		cookies   map[string]bool
+		loggedOut bool
+	}{
+		{"authenticated", "https://example.com/settings", map[string]bool{"sid": true}, false},
+		{"one cookie left", "https://example.com/settings", map[string]bool{"remember": true}, false},
+		{"login redirect", "https://example.com/login?next=/settings", map[string]bool{"sid": true}, true},
+		{"sign-in route", "https://example.com/users/sign-in", map[string]bool{"sid": true}, true},
+		{"cookies cleared", "https://example.com/", map[string]bool{"other": true}, true},
+		{"login-like path segment", "https://example.com/blog/logins-explained", map[string]bool{"sid": true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := w.detect(tt.url, tt.cookies)
+			if got != tt.loggedOut {
+				t.Errorf("detect(%q) = %v (%s), want %v", tt.url, got, reason, tt.loggedOut)
+			}
+			if got && reason == "" {
+				t.Error("expected a reason when logged out")
+			}
+		})
+	}
+}
+
+func TestCrawlAuthWatcher_StartOnLoginPage(t *testing.T) {
+	w, _ := newCrawlAuthWatcher(CrawlSession{URL: "https://example.com/login"}, &playwrightStorageState{})
+	if got, _ := w.detect("https://example.com/login", nil); got {
+		t.Error("URL check should be disabled when the crawl starts on the login page")
+	}
+}
+
+func TestCrawlAuthWatcher_IgnoresExpiredCookies(t *testing.T) {
+	state := &playwrightStorageState{Cookies: []playwrightCookie{
+		{Name: "old", Expires: float64(time.Now().Add(-time.Hour).Unix())},
+	}}
+	w, _ := newCrawlAuthWatcher(CrawlSession{URL: "https://example.com/"}, state)
+	if got, _ := w.detect("https://example.com/", nil); got {
+		t.Error("expired cookies should not be watched")
+	}
+}
+
+func TestCrawlAuthWatcher_CustomPattern(t *testing.T) {
+	if _, err := newCrawlAuthWatcher(CrawlSession{LoggedOutURLPattern: "("}, &playwrightStorageState{}); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+
+	w, _ := newCrawlAuthWatcher(CrawlSession{URL: "https://example.com/", LoggedOutURLPattern: `/account/expired`}, &playwrightStorageState{})
+	if got, _ := w.detect("https://example.com/account/expired", nil); !got {
+		t.Error("expected custom pattern to match")
+	}
+	if got, _ := w.detect("https://example.com/login", nil); got {
+		t.Error("default pattern should be replaced by the custom one")
+	}
+}
@@ -173,7 +173,7 @@ func TestExecuteCrawlAction_Click(t *testing.T) {
 
 	a := &Agent{}
 	action := &CrawlAction{Action: "click", Selector: "#btn"}
-	err := a.executeCrawlAction(ctx, "test-session", action)
+	err := a.executeCrawlAction(ctx, "test-session", action, nil, 0)
 	if err != nil {
 		t.Fatalf("executeCrawlAction click failed: %v", err)
 	}
@@ -209,7 +209,7 @@ func TestExecuteCrawlAction_Fill(t *testing.T) {
 
 	a := &Agent{}
 	action := &CrawlAction{Action: "fill", Selector: "#email", Value: "test@example.com"}
-	err := a.executeCrawlAction(ctx, "test-session", action)
+	err := a.executeCrawlAction(ctx, "test-session", action, nil, 0)
 	if err != nil {
 		t.Fatalf("executeCrawlAction fill failed: %v", err)
 	}
@@ -248,7 +248,7 @@ func TestExecuteCrawlAction_Select(t *testing.T) {
 
 	a := &Agent{}
 	action := &CrawlAction{Action: "select", Selector: "#country", Value: "US"}
-	err := a.executeCrawlAction(ctx, "test-session", action)
+	err := a.executeCrawlAction(ctx, "test-session", action, nil, 0)
 	if err != nil {
 		t.Fatalf("executeCrawlAction select failed: %v", err)
 	}
@@ -266,7 +266,7 @@ func TestExecuteCrawlAction_UnknownAction(t *testing.T) {
 	}
 	a := &Agent{}
 	action := &CrawlAction{Action: "unknown_action", Selector: "#btn"}
-	err := a.executeCrawlAction(context.Background(), "test", action)
+	err := a.executeCrawlAction(context.Background(), "test", action, nil, 0)
 	if err == nil {
 		t.Error("expected error for unknown action")
 	}
@@ -337,7 +337,7 @@ func TestDismissCookieConsent_WithAcceptButton(t *testing.T) {
 	}
 
 	a := &Agent{}
-	a.dismissCookieConsent(ctx, "test-session")
+	a.dismissCookieConsent(ctx, "test-session", nil)
 
 	// Check if the cookie consent was dismissed
 	var title string
@@ -368,7 +368,77 @@ func TestDismissCookieConsent_NoBanner(t *testing.T) {
 
 	// Should not panic or error
 	a := &Agent{}
-	a.dismissCookieConsent(ctx, "test-session")
+	a.dismissCookieConsent(ctx, "test-session", nil)
+}
+
+func TestDismissCookieConsent_GermanBannerInIframe(t *testing.T) {
+	if os.Getenv("QMAX_BROWSER_TESTS") == "" {
+		t.Skip("Skipping browser test (set QMAX_BROWSER_TESTS=1 to run)")
+	}
+	skipIfNoChrome(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/banner" {
+			fmt.Fprint(w, `<!DOCTYPE html><html><body>
+				<button onclick="parent.document.title='akzeptiert'">Alle akzeptieren</button>
+			</body></html>`)
+			return
+		}
+		fmt.Fprint(w, `<!DOCTYPE html><html><body><h1>Inhalt</h1><iframe src="/banner"></iframe></body></html>`)
+	}))
+	defer ts.Close()
+
+	ctx, cancel := newBrowserContext(t)
+	defer cancel()
+
+	if err := chromedp.Run(ctx, chromedp.Navigate(ts.URL), chromedp.WaitReady("body"), chromedp.Sleep(300*time.Millisecond)); err != nil {
+		t.Fatalf("navigation failed: %v", err)
+	}
+
+	a := &Agent{}
+	result := a.dismissCookieConsent(ctx, "test-session", nil)
+	if result.Status != "dismissed" || result.Rule != "text-de" || result.FrameURL == "" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
+
+func TestDismissCookieConsent_RejectInShadowDOM(t *testing.T) {
+	if os.Getenv("QMAX_BROWSER_TESTS") == "" {
+		t.Skip("Skipping browser test (set QMAX_BROWSER_TESTS=1 to run)")
+	}
+	skipIfNoChrome(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<!DOCTYPE html><html><body>
+			<div id="usercentrics-root"></div>
+			<script>
+				const root = document.getElementById('usercentrics-root').attachShadow({mode: 'open'});
+				root.innerHTML = '<button data-testid="uc-accept-all-button" onclick="document.title=\'accepted\'">Accept All</button>' +
+					'<button data-testid="uc-deny-all-button" onclick="document.title=\'rejected\'">Deny</button>';
+			</script>
+		</body></html>`)
+	}))
+	defer ts.Close()
+
+	ctx, cancel := newBrowserContext(t)
+	defer cancel()
+
+	if err := chromedp.Run(ctx, chromedp.Navigate(ts.URL), chromedp.WaitReady("body")); err != nil {
+		t.Fatalf("navigation failed: %v", err)
+	}
+
+	rules, _ := loadCookieConsentRules("")
+	rules, _ = rules.withPreference("reject")
+	a := &Agent{}
+	result := a.dismissCookieConsent(ctx, "test-session", rules)
+
+	var title string
+	_ = chromedp.Run(ctx, chromedp.Title(&title))
+	if title != "rejected" || result.Rule != "usercentrics" || result.Choice != "reject" {
+		t.Errorf("title=%q result=%+v", title, result)
+	}
 }
 
 // --- ExecuteCrawlSession integration test ---
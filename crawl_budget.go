@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+)
+
+const (
+	defaultCrawlSessionTimeout  = 10 * time.Minute
+	defaultCrawlActionTimeout   = 5 * time.Second
+	defaultCrawlSnapshotTimeout = 5 * time.Second
+)
+
+// Why a crawl session ended.
+const (
+	crawlEndDone      = "done" // the server said done
+	crawlEndMaxSteps  = "max_steps"
+	crawlEndMaxPages  = "max_pages"
+	crawlEndTimeout   = "timeout"
+	crawlEndCancelled = "cancelled"
+	crawlEndError     = "error"
+)
+
+// CrawlLimits are local ceilings for crawl sessions, set under "crawl" in
+// ~/.qmax/config.json. Session budgets above a ceiling are lowered to it;
+// zero means no ceiling.
+type CrawlLimits struct {
+	MaxSessionTimeoutSec int `json:"max_session_timeout_sec,omitempty"`
+	MaxActionTimeoutMs   int `json:"max_action_timeout_ms,omitempty"`
+	MaxSnapshotTimeoutMs int `json:"max_snapshot_timeout_ms,omitempty"`
+	MaxSteps             int `json:"max_steps,omitempty"`
+	MaxPages             int `json:"max_pages,omitempty"`
+}
+
+// CrawlBudgets are the effective budgets of a session.
+type CrawlBudgets struct {
+	SessionTimeoutSec int `json:"session_timeout_sec"`
+	ActionTimeoutMs   int `json:"action_timeout_ms"`
+	SnapshotTimeoutMs int `json:"snapshot_timeout_ms"`
+	MaxSteps          int `json:"max_steps"`
+	MaxPages          int `json:"max_pages"` // 0 means no limit
+}
+
+func (s CrawlSession) sessionTimeout() time.Duration {
+	return durationOr(time.Duration(s.SessionTimeoutSec)*time.Second, defaultCrawlSessionTimeout)
+}
+
+func (s CrawlSession) actionTimeout() time.Duration {
+	return durationOr(time.Duration(s.ActionTimeoutMs)*time.Millisecond, defaultCrawlActionTimeout)
+}
+
+func (s CrawlSession) snapshotTimeout() time.Duration {
+	return durationOr(time.Duration(s.SnapshotTimeoutMs)*time.Millisecond, defaultCrawlSnapshotTimeout)
+}
+
+func durationOr(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
+
+// crawlStageContext bounds one of the remaining stages of an action to its
+// share of the time left on ctx, so that later stages, such as a JS click
+// after a failed native one, still get some. Without a deadline on ctx the
+// action is taken to have defaultCrawlActionTimeout.
+func crawlStageContext(ctx context.Context, stagesLeft int) (context.Context, context.CancelFunc) {
+	remaining := defaultCrawlActionTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		remaining = time.Until(deadline)
+	}
+	if stagesLeft < 1 {
+		stagesLeft = 1
+	}
+	return context.WithTimeout(ctx, remaining/time.Duration(stagesLeft))
+}
+
+// budgets returns the session's budgets with defaults filled in.
+func (s CrawlSession) budgets() CrawlBudgets {
+	return CrawlBudgets{
+		SessionTimeoutSec: int(s.sessionTimeout() / time.Second),
+		ActionTimeoutMs:   int(s.actionTimeout() / time.Millisecond),
+		SnapshotTimeoutMs: int(s.snapshotTimeout() / time.Millisecond),
+		MaxSteps:          s.MaxSteps,
+		MaxPages:          s.MaxPages,
+	}
+}
+
+// applyCrawlLimits fills in the default budgets and lowers those above the
+// local ceilings. It returns a note for every lowered budget.
+func applyCrawlLimits(session CrawlSession, limits *CrawlLimits) (CrawlSession, []string) {
+	b := session.budgets()
+	session.SessionTimeoutSec = b.SessionTimeoutSec
+	session.ActionTimeoutMs = b.ActionTimeoutMs
+	session.SnapshotTimeoutMs = b.SnapshotTimeoutMs
+	if limits == nil {
+		return session, nil
+	}
+
+	var notes []string
+	clamp := func(name string, v *int, ceiling int, unlimited bool) {
+		if ceiling <= 0 || (*v <= ceiling && !(unlimited && *v == 0)) {
+			return
+		}
+		if *v == 0 {
+			notes = append(notes, fmt.Sprintf("%s set to local ceiling %d", name, ceiling))
+		} else {
+			notes = append(notes, fmt.Sprintf("%s %d lowered to local ceiling %d", name, *v, ceiling))
+		}
+		*v = ceiling
+	}
+	clamp("session_timeout_sec", &session.SessionTimeoutSec, limits.MaxSessionTimeoutSec, false)
+	clamp("action_timeout_ms", &session.ActionTimeoutMs, limits.MaxActionTimeoutMs, false)
+	clamp("snapshot_timeout_ms", &session.SnapshotTimeoutMs, limits.MaxSnapshotTimeoutMs, false)
+	clamp("max_steps", &session.MaxSteps, limits.MaxSteps, false)
+	clamp("max_pages", &session.MaxPages, limits.MaxPages, true)
+	return session, notes
+}
+
+// --- Session run ---
+
+// CrawlSessionSummary is reported when a session ends.
+type CrawlSessionSummary struct {
+	Reason         string               `json:"reason"`           // done, max_steps, max_pages, timeout, cancelled, error
+	Budget         string               `json:"budget,omitempty"` // budget that ended the session, if any
+	Steps          int                  `json:"steps"`
+	FailedActions  int                  `json:"failed_actions"`
+	BlockedActions int                  `json:"blocked_actions"`
+	PagesVisited   int                  `json:"pages_visited"`
+	ElapsedMs      int64                `json:"elapsed_ms"`
+	Budgets        CrawlBudgets         `json:"budgets"`
+	Console        *CrawlConsoleSummary `json:"console,omitempty"`
+	Network        *CrawlNetworkSummary `json:"network,omitempty"`
+}
+
+// crawlRun tracks a running session against its budgets.
+type crawlRun struct {
+	budgets CrawlBudgets
+	started time.Time
+	steps   int
+	failed  int
+	blocked int
+	visited map[string]bool
+	reason  string
+	now     func() time.Time
+}
+
+func newCrawlRun(session CrawlSession) *crawlRun {
+	return &crawlRun{
+		budgets: session.budgets(),
+		started: time.Now(),
+		visited: map[string]bool{},
+		now:     time.Now,
+	}
+}
+
+// step records a snapshot of the page at rawURL.
+func (r *crawlRun) step(rawURL string) {
+	r.steps++
+	if u, err := url.Parse(rawURL); err == nil {
+		u.Fragment = ""
+		rawURL = u.String()
+	}
+	r.visited[rawURL] = true
+}
+
+// action records the outcome of an executed action.
+func (r *crawlRun) action(result *CrawlActionResult) {
+	switch result.Status {
+	case "failed":
+		r.failed++
+	case "blocked":
+		r.blocked++
+	}
+}
+
+// pagesExhausted reports whether the session has seen max_pages pages.
+func (r *crawlRun) pagesExhausted() bool {
+	return r.budgets.MaxPages > 0 && len(r.visited) >= r.budgets.MaxPages
+}
+
+// end records why the session ended; the first reason wins.
+func (r *crawlRun) end(reason string) {
+	if r.reason == "" {
+		r.reason = reason
+	}
+}
+
+// endFromContext ends the session with timeout or cancelled, depending on
+// whether the session's own deadline or the caller stopped it. It reports
+// false while ctx is still live.
+func (r *crawlRun) endFromContext(ctx context.Context) bool {
+	switch {
+	case ctx.Err() == nil:
+		return false
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		r.end(crawlEndTimeout)
+	default:
+		r.end(crawlEndCancelled)
+	}
+	return true
+}
+
+func (r *crawlRun) summary() *CrawlSessionSummary {
+	s := &CrawlSessionSummary{
+		Reason:         r.reason,
+		Steps:          r.steps,
+		FailedActions:  r.failed,
+		BlockedActions: r.blocked,
+		PagesVisited:   len(r.visited),
+		ElapsedMs:      r.now().Sub(r.started).Milliseconds(),
+		Budgets:        r.budgets,
+	}
+	if s.Reason == "" {
+		s.Reason = crawlEndError
+	}
+	switch s.Reason {
+	case crawlEndTimeout:
+		s.Budget = "session_timeout"
+	case crawlEndMaxSteps, crawlEndMaxPages:
+		s.Budget = s.Reason
+	}
+	return s
+}
+
+// logCrawlSummary logs the final "done" line of a session.
+func logCrawlSummary(sessionID string, s *CrawlSessionSummary) {
+	ended := s.Reason
+	if s.Budget != "" {
+		ended = fmt.Sprintf("%s budget (%s)", s.Budget, s.budgetValue())
+	}
+	log.Printf("CRAWL [%s] Done: ended by %s after %d steps (%d failed, %d blocked), %d pages, %s",
+		sessionID, ended, s.Steps, s.FailedActions, s.BlockedActions, s.PagesVisited, (time.Duration(s.ElapsedMs) * time.Millisecond).Round(time.Second))
+}
+
+func (s *CrawlSessionSummary) budgetValue() string {
+	switch s.Budget {
+	case "session_timeout":
+		return (time.Duration(s.Budgets.SessionTimeoutSec) * time.Second).String()
+	case "max_steps":
+		return fmt.Sprintf("%d", s.Budgets.MaxSteps)
+	case "max_pages":
+		return fmt.Sprintf("%d", s.Budgets.MaxPages)
+	}
+	return ""
+}
+
+// overrideCrawlBudgets replaces the server's budgets with the non-zero CLI
+// values.
+func overrideCrawlBudgets(session *CrawlSession, sessionTimeout, actionTimeout, snapshotTimeout time.Duration, maxSteps, maxPages int) {
+	if sessionTimeout > 0 {
+		session.SessionTimeoutSec = int(sessionTimeout.Round(time.Second) / time.Second)
+	}
+	if actionTimeout > 0 {
+		session.ActionTimeoutMs = int(actionTimeout / time.Millisecond)
+	}
+	if snapshotTimeout > 0 {
+		session.SnapshotTimeoutMs = int(snapshotTimeout / time.Millisecond)
+	}
+	if maxSteps > 0 {
+		session.MaxSteps = maxSteps
+	}
+	if maxPages > 0 {
+		session.MaxPages = maxPages
+	}
+}
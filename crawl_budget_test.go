@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCrawlSession_BudgetDefaults(t *testing.T) {
+	s := CrawlSession{MaxSteps: 20}
+	if s.sessionTimeout() != 10*time.Minute || s.actionTimeout() != 5*time.Second || s.snapshotTimeout() != 5*time.Second {
+		t.Errorf("unexpected defaults: %v %v %v", s.sessionTimeout(), s.actionTimeout(), s.snapshotTimeout())
+	}
+
+	var fromServer CrawlSession
+	_ = json.Unmarshal([]byte(`{"session_id": "s", "max_steps": 30, "session_timeout_sec": 120, "action_timeout_ms": 8000, "snapshot_timeout_ms": 3000, "max_pages": 12}`), &fromServer)
+	want := CrawlBudgets{SessionTimeoutSec: 120, ActionTimeoutMs: 8000, SnapshotTimeoutMs: 3000, MaxSteps: 30, MaxPages: 12}
+	if got := fromServer.budgets(); got != want {
+		t.Errorf("budgets() = %+v, want %+v", got, want)
+	}
+}
+
+func TestApplyCrawlLimits(t *testing.T) {
+	session := CrawlSession{MaxSteps: 100, ActionTimeoutMs: 2000, SessionTimeoutSec: 3600}
+	got, notes := applyCrawlLimits(session, nil)
+	if got.SessionTimeoutSec != 3600 || got.SnapshotTimeoutMs != 5000 || len(notes) != 0 {
+		t.Errorf("without limits only defaults are filled in: %+v %v", got, notes)
+	}
+
+	limits := &CrawlLimits{MaxSessionTimeoutSec: 900, MaxActionTimeoutMs: 10000, MaxSteps: 50, MaxPages: 25}
+	got, notes = applyCrawlLimits(session, limits)
+	if got.SessionTimeoutSec != 900 || got.ActionTimeoutMs != 2000 || got.MaxSteps != 50 || got.MaxPages != 25 {
+		t.Errorf("unexpected clamped session: %+v", got)
+	}
+	joined := strings.Join(notes, "; ")
+	for _, want := range []string{"session_timeout_sec 3600 lowered to local ceiling 900", "max_steps 100 lowered", "max_pages set to local ceiling 25"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("notes missing %q: %s", want, joined)
+		}
+	}
+	if len(notes) != 3 {
+		t.Errorf("expected 3 notes, got %v", notes)
+	}
+}
+
+func TestOverrideCrawlBudgets(t *testing.T) {
+	s := &CrawlSession{MaxSteps: 10, MaxPages: 5, ActionTimeoutMs: 4000}
+	overrideCrawlBudgets(s, 15*time.Minute, 0, 1500*time.Millisecond, 0, 40)
+	if s.SessionTimeoutSec != 900 || s.ActionTimeoutMs != 4000 || s.SnapshotTimeoutMs != 1500 || s.MaxSteps != 10 || s.MaxPages != 40 {
+		t.Errorf("unexpected overrides: %+v", s)
+	}
+}
+
+func TestCrawlRun_Pages(t *testing.T) {
+	r := newCrawlRun(CrawlSession{MaxSteps: 10, MaxPages: 2})
+	r.step("https://example.com/")
+	r.step("https://example.com/#top")
+	if r.pagesExhausted() {
+		t.Error("fragments should not count as new pages")
+	}
+	r.step("https://example.com/about")
+	if !r.pagesExhausted() {
+		t.Error("2 pages should exhaust max_pages=2")
+	}
+	if s := r.summary(); s.Steps != 3 || s.PagesVisited != 2 {
+		t.Errorf("unexpected summary: %+v", s)
+	}
+
+	if newCrawlRun(CrawlSession{}).pagesExhausted() {
+		t.Error("max_pages 0 means no limit")
+	}
+}
+
+func TestCrawlRun_EndReasons(t *testing.T) {
+	r := newCrawlRun(CrawlSession{MaxSteps: 7})
+	if s := r.summary(); s.Reason != crawlEndError || s.Budget != "" {
+		t.Errorf("a session that never ended cleanly is an error: %+v", s)
+	}
+	r.end(crawlEndMaxSteps)
+	r.end(crawlEndDone)
+	if s := r.summary(); s.Reason != crawlEndMaxSteps || s.Budget != "max_steps" || s.budgetValue() != "7" {
+		t.Errorf("first reason should win: %+v", s)
+	}
+
+	r = newCrawlRun(CrawlSession{})
+	if r.endFromContext(context.Background()) {
+		t.Error("a live context should not end the session")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	if !r.endFromContext(ctx) {
+		t.Fatal("an expired context should end the session")
+	}
+	if s := r.summary(); s.Reason != crawlEndTimeout || s.Budget != "session_timeout" || s.budgetValue() != "10m0s" {
+		t.Errorf("unexpected timeout summary: %+v", s)
+	}
+
+	r = newCrawlRun(CrawlSession{})
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	r.endFromContext(cancelled)
+	if s := r.summary(); s.Reason != crawlEndCancelled || s.Budget != "" {
+		t.Errorf("unexpected cancel summary: %+v", s)
+	}
+}
+
+func TestCrawlRun_Elapsed(t *testing.T) {
+	r := newCrawlRun(CrawlSession{})
+	start := r.started
+	r.now = func() time.Time { return start.Add(90 * time.Second) }
+	r.end(crawlEndDone)
+	if s := r.summary(); s.ElapsedMs != 90000 || s.Budgets.SessionTimeoutSec != 600 {
+		t.Errorf("unexpected summary: %+v", s)
+	}
+}
+
+func TestConfig_CrawlLimits(t *testing.T) {
+	var cfg Config
+	if err := json.Unmarshal([]byte(`{"token": "t", "crawl": {"max_session_timeout_sec": 300, "max_pages": 50}}`), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Crawl == nil || cfg.Crawl.MaxSessionTimeoutSec != 300 || cfg.Crawl.MaxPages != 50 {
+		t.Errorf("unexpected crawl limits: %+v", cfg.Crawl)
+	}
+	data, _ := json.Marshal(Config{Token: "t"})
+	if strings.Contains(string(data), "crawl") {
+		t.Errorf("empty limits should be omitted: %s", data)
+	}
+}
+
+func TestCrawlStageContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	stage, stageCancel := crawlStageContext(ctx, 2)
+	defer stageCancel()
+	deadline, _ := stage.Deadline()
+	if left := time.Until(deadline); left > 2*time.Second || left < 1500*time.Millisecond {
+		t.Errorf("half of 4s: got %v", left)
+	}
+
+	last, lastCancel := crawlStageContext(ctx, 0)
+	defer lastCancel()
+	want, _ := ctx.Deadline()
+	if d, _ := last.Deadline(); !d.Equal(want) {
+		t.Errorf("the last stage should get all that is left: %v", d)
+	}
+
+	free, freeCancel := crawlStageContext(context.Background(), 1)
+	defer freeCancel()
+	if d, ok := free.Deadline(); !ok || time.Until(d) > defaultCrawlActionTimeout {
+		t.Errorf("without a deadline the action budget is the default: %v %v", d, ok)
+	}
+}
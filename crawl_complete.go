@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+const (
+	maxCrawlNetworkFailures = 10
+	maxCrawlNetworkURLLen   = 300
+)
+
+// CrawlNetworkSummary aggregates network activity over a whole crawl session.
+type CrawlNetworkSummary struct {
+	Requests      int                   `json:"requests"`
+	Failed        int                   `json:"failed"`      // network errors; cancelled requests are not counted
+	HTTPErrors    int                   `json:"http_errors"` // 4xx and 5xx responses
+	BytesReceived int64                 `json:"bytes_received"`
+	StatusClasses map[string]int        `json:"status_classes"` // e.g. "2xx": 41
+	TopFailures   []CrawlNetworkFailure `json:"top_failures"`
+}
+
+// CrawlNetworkFailure is a failed request or error response and how often
+// it was seen.
+type CrawlNetworkFailure struct {
+	URL    string `json:"url"`
+	Status int64  `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Count  int    `json:"count"`
+}
+
+// crawlNetworkStats counts requests, responses and failures from CDP network
+// events. Like crawlConsoleCollector it is fed from a chromedp target
+// listener, so handleEvent must never block.
+type crawlNetworkStats struct {
+	mu       sync.Mutex
+	urls     map[network.RequestID]string // requests in flight
+	summary  CrawlNetworkSummary
+	failures map[string]*CrawlNetworkFailure
+	order    []string
+}
+
+func newCrawlNetworkStats() *crawlNetworkStats {
+	return &crawlNetworkStats{
+		urls:     map[network.RequestID]string{},
+		summary:  CrawlNetworkSummary{StatusClasses: map[string]int{}},
+		failures: map[string]*CrawlNetworkFailure{},
+	}
+}
+
+// handleEvent is a chromedp.ListenTarget callback.
+func (n *crawlNetworkStats) handleEvent(ev interface{}) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	switch e := ev.(type) {
+	case *network.EventRequestWillBeSent:
+		// Redirects reuse the request ID
+		if _, ok := n.urls[e.RequestID]; !ok {
+			n.summary.Requests++
+		}
+		if e.Request != nil {
+			n.urls[e.RequestID] = e.Request.URL
+		}
+	case *network.EventResponseReceived:
+		if e.Response == nil || e.Response.Status == 0 {
+			return
+		}
+		n.summary.StatusClasses[fmt.Sprintf("%dxx", e.Response.Status/100)]++
+		if e.Response.Status >= 400 {
+			n.summary.HTTPErrors++
+			n.addFailure(CrawlNetworkFailure{URL: e.Response.URL, Status: e.Response.Status})
+		}
+	case *network.EventLoadingFinished:
+		n.summary.BytesReceived += int64(e.EncodedDataLength)
+		delete(n.urls, e.RequestID)
+	case *network.EventLoadingFailed:
+		url := n.urls[e.RequestID]
+		delete(n.urls, e.RequestID)
+		if e.Canceled {
+			return
+		}
+		n.summary.Failed++
+		n.addFailure(CrawlNetworkFailure{URL: url, Error: e.ErrorText})
+	}
+}
+
+func (n *crawlNetworkStats) addFailure(f CrawlNetworkFailure) {
+	f.URL = truncate(f.URL, maxCrawlNetworkURLLen)
+	key := fmt.Sprintf("%s\x00%d\x00%s", f.URL, f.Status, f.Error)
+	if existing, ok := n.failures[key]; ok {
+		existing.Count++
+		return
+	}
+	f.Count = 1
+	n.failures[key] = &f
+	n.order = append(n.order, key)
+}
+
+// result returns the totals and the most frequent failures.
+func (n *crawlNetworkStats) result() CrawlNetworkSummary {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	s := n.summary
+	s.StatusClasses = make(map[string]int, len(n.summary.StatusClasses))
+	for k, v := range n.summary.StatusClasses {
+		s.StatusClasses[k] = v
+	}
+	order := append([]string(nil), n.order...)
+	// Stable sort keeps first-seen order among equally frequent failures
+	sort.SliceStable(order, func(i, j int) bool {
+		return n.failures[order[i]].Count > n.failures[order[j]].Count
+	})
+	s.TopFailures = []CrawlNetworkFailure{}
+	for i, key := range order {
+		if i >= maxCrawlNetworkFailures {
+			break
+		}
+		s.TopFailures = append(s.TopFailures, *n.failures[key])
+	}
+	return s
+}
+
+// --- Completion ---
+
+// completeCrawlSession logs the session summary and reports it to the
+// server as the session's terminal message.
+func (a *Agent) completeCrawlSession(sessionID string, run *crawlRun, console *crawlConsoleCollector, net *crawlNetworkStats) {
+	summary := run.summary()
+	consoleSummary := console.summary()
+	netSummary := net.result()
+	summary.Console = &consoleSummary
+	summary.Network = &netSummary
+
+	logCrawlSummary(sessionID, summary)
+	a.logConsoleSummary(sessionID, console)
+	log.Printf("CRAWL [%s] Network summary: %d requests, %d failed, %d HTTP errors, %d bytes",
+		sessionID, netSummary.Requests, netSummary.Failed, netSummary.HTTPErrors, netSummary.BytesReceived)
+
+	if err := a.submitCrawlComplete(sessionID, summary); err != nil {
+		log.Printf("CRAWL [%s] WARN: could not report completion: %v", sessionID, err)
+	}
+}
+
+// submitCrawlComplete sends the session summary.
+func (a *Agent) submitCrawlComplete(sessionID string, summary *CrawlSessionSummary) error {
+	url := fmt.Sprintf("%s/api/agent/%s/crawl/%s/complete", a.CloudURL, a.AgentID, sessionID)
+	resp, body, err := a.doJSONWithRetry("POST", url, summary, a.authHeaders(), 30*time.Second)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("report completion failed: %d - %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+func TestCrawlNetworkStats(t *testing.T) {
+	n := newCrawlNetworkStats()
+	send := func(id, url string) {
+		n.handleEvent(&network.EventRequestWillBeSent{RequestID: network.RequestID(id), Request: &network.Request{URL: url}})
+	}
+	respond := func(url string, status int64) {
+		n.handleEvent(&network.EventResponseReceived{Response: &network.Response{URL: url, Status: status}})
+	}
+
+	send("1", "https://example.com/")
+	send("1", "https://example.com/home") // redirect, same request
+	respond("https://example.com/home", 200)
+	n.handleEvent(&network.EventLoadingFinished{RequestID: "1", EncodedDataLength: 1000})
+
+	for _, id := range []string{"2", "3"} {
+		send(id, "https://example.com/api/missing")
+		respond("https://example.com/api/missing", 404)
+		n.handleEvent(&network.EventLoadingFinished{RequestID: network.RequestID(id), EncodedDataLength: 50})
+	}
+	send("4", "https://cdn.example.com/app.js")
+	n.handleEvent(&network.EventLoadingFailed{RequestID: "4", ErrorText: "net::ERR_NAME_NOT_RESOLVED"})
+	send("5", "https://example.com/poll")
+	n.handleEvent(&network.EventLoadingFailed{RequestID: "5", ErrorText: "net::ERR_ABORTED", Canceled: true})
+
+	s := n.result()
+	if s.Requests != 5 || s.Failed != 1 || s.HTTPErrors != 2 || s.BytesReceived != 1100 {
+		t.Errorf("unexpected totals: %+v", s)
+	}
+	if s.StatusClasses["2xx"] != 1 || s.StatusClasses["4xx"] != 2 {
+		t.Errorf("unexpected status classes: %v", s.StatusClasses)
+	}
+	if len(s.TopFailures) != 2 || s.TopFailures[0].Status != 404 || s.TopFailures[0].Count != 2 ||
+		s.TopFailures[1].URL != "https://cdn.example.com/app.js" || s.TopFailures[1].Error != "net::ERR_NAME_NOT_RESOLVED" {
+		t.Errorf("unexpected top failures: %+v", s.TopFailures)
+	}
+	if len(n.urls) != 0 {
+		t.Errorf("finished requests should be forgotten: %v", n.urls)
+	}
+}
+
+func TestCrawlNetworkStats_TopFailuresCapped(t *testing.T) {
+	n := newCrawlNetworkStats()
+	for i := 0; i < maxCrawlNetworkFailures+5; i++ {
+		n.handleEvent(&network.EventResponseReceived{Response: &network.Response{URL: "https://example.com/" + strings.Repeat("x", i), Status: 500}})
+	}
+	if s := n.result(); len(s.TopFailures) != maxCrawlNetworkFailures || s.HTTPErrors != maxCrawlNetworkFailures+5 {
+		t.Errorf("got %d top failures, %d errors", len(s.TopFailures), s.HTTPErrors)
+	}
+}
+
+func TestCrawlRun_Actions(t *testing.T) {
+	r := newCrawlRun(CrawlSession{})
+	for _, status := range []string{"ok", "failed", "blocked", "failed"} {
+		r.action(&CrawlActionResult{Status: status})
+	}
+	if s := r.summary(); s.FailedActions != 2 || s.BlockedActions != 1 {
+		t.Errorf("unexpected action counts: %+v", s)
+	}
+}
+
+func TestCompleteCrawlSession(t *testing.T) {
+	var path string
+	var received CrawlSessionSummary
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		_ = json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	run := newCrawlRun(CrawlSession{MaxSteps: 5, MaxPages: 1})
+	run.step("https://example.com/")
+	run.action(&CrawlActionResult{Status: "failed"})
+	run.end(crawlEndMaxPages)
+
+	console := newCrawlConsoleCollector()
+	console.all = []CrawlConsoleEntry{{Source: "console", Level: "error", Text: "boom", Step: 1}}
+	net := newCrawlNetworkStats()
+	net.handleEvent(&network.EventRequestWillBeSent{RequestID: "1", Request: &network.Request{URL: "https://example.com/"}})
+
+	a := newTestAgent(server.URL)
+	a.completeCrawlSession("sess-1", run, console, net)
+
+	if !strings.HasSuffix(path, "/crawl/sess-1/complete") {
+		t.Errorf("unexpected path %s", path)
+	}
+	if received.Reason != "max_pages" || received.Budget != "max_pages" || received.Steps != 1 || received.FailedActions != 1 || received.PagesVisited != 1 {
+		t.Errorf("unexpected summary: %+v", received)
+	}
+	if received.Console == nil || received.Console.Errors != 1 || received.Network == nil || received.Network.Requests != 1 {
+		t.Errorf("missing console or network stats: %+v %+v", received.Console, received.Network)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// defaultCookieConsentRules is the built-in rule set: known CMPs first, then
+// button texts per language.
+//
+//go:embed cookie_consent_rules.json
+var defaultCookieConsentRules []byte
+
+// cookieConsentRulesFile is the optional user rule file in ~/.qmax.
+const cookieConsentRulesFile = "cookie-consent.json"
+
+// CookieConsentRule describes one kind of cookie banner and how to answer it.
+// Selectors are tried before texts; texts match a button's whole label,
+// ignoring case and extra whitespace.
+type CookieConsentRule struct {
+	Name            string   `json:"name"`
+	Language        string   `json:"language,omitempty"`
+	FrameURL        string   `json:"frame_url,omitempty"` // regex; limits the rule to matching (i)frames
+	AcceptSelectors []string `json:"accept_selectors,omitempty"`
+	RejectSelectors []string `json:"reject_selectors,omitempty"`
+	AcceptTexts     []string `json:"accept_texts,omitempty"`
+	RejectTexts     []string `json:"reject_texts,omitempty"`
+}
+
+// cookieConsentConfig is the layout of both the embedded and the user rule file.
+type cookieConsentConfig struct {
+	Prefer          string              `json:"prefer,omitempty"` // accept (default), reject, none
+	ReplaceDefaults bool                `json:"replace_defaults,omitempty"`
+	Rules           []CookieConsentRule `json:"rules"`
+}
+
+// CookieConsentResult reports how the first page's cookie banner was handled.
+type CookieConsentResult struct {
+	Status   string `json:"status"`           // dismissed, not_found, skipped
+	Rule     string `json:"rule,omitempty"`   // name of the matched rule
+	Source   string `json:"source,omitempty"` // default or user
+	Choice   string `json:"choice,omitempty"` // accept or reject
+	Match    string `json:"match,omitempty"`  // selector or button text that was clicked
+	FrameURL string `json:"frame_url,omitempty"`
+}
+
+type cookieConsentRuleSet struct {
+	prefer string
+	rules  []compiledConsentRule
+}
+
+type compiledConsentRule struct {
+	CookieConsentRule
+	source string
+	frame  *regexp.Regexp
+}
+
+// cookieConsentCandidate is what the in-page script tries for one rule.
+type cookieConsentCandidate struct {
+	Rule      int      `json:"rule"`
+	Selectors []string `json:"selectors,omitempty"`
+	Texts     []string `json:"texts,omitempty"`
+}
+
+// normalizeConsentPreference maps user spellings onto accept, reject or none.
+func normalizeConsentPreference(p string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(p)) {
+	case "", "accept":
+		return "accept", nil
+	case "reject":
+		return "reject", nil
+	case "none", "off", "skip":
+		return "none", nil
+	}
+	return "", fmt.Errorf("unknown cookie consent preference %q (want accept, reject or none)", p)
+}
+
+func parseCookieConsentConfig(data []byte, source string) (cookieConsentConfig, []compiledConsentRule, error) {
+	var cfg cookieConsentConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, nil, fmt.Errorf("parse %s cookie consent rules: %w", source, err)
+	}
+	var compiled []compiledConsentRule
+	for i, r := range cfg.Rules {
+		if r.Name == "" {
+			return cfg, nil, fmt.Errorf("%s cookie consent rule %d has no name", source, i)
+		}
+		if len(r.AcceptSelectors)+len(r.RejectSelectors)+len(r.AcceptTexts)+len(r.RejectTexts) == 0 {
+			return cfg, nil, fmt.Errorf("cookie consent rule %q has no selectors or texts", r.Name)
+		}
+		c := compiledConsentRule{CookieConsentRule: r, source: source}
+		if r.FrameURL != "" {
+			re, err := regexp.Compile(r.FrameURL)
+			if err != nil {
+				return cfg, nil, fmt.Errorf("cookie consent rule %q: invalid frame_url: %w", r.Name, err)
+			}
+			c.frame = re
+		}
+		compiled = append(compiled, c)
+	}
+	return cfg, compiled, nil
+}
+
+// loadCookieConsentRules builds the rule set from the embedded defaults and
+// the user file at userPath, if it exists. User rules are tried first; a user
+// "prefer" overrides the default preference.
+func loadCookieConsentRules(userPath string) (*cookieConsentRuleSet, error) {
+	defaults, rules, err := parseCookieConsentConfig(defaultCookieConsentRules, "default")
+	if err != nil {
+		return nil, err
+	}
+	set := &cookieConsentRuleSet{prefer: defaults.Prefer, rules: rules}
+
+	if userPath != "" {
+		data, err := os.ReadFile(userPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("read cookie consent rules: %w", err)
+		}
+		if err == nil {
+			user, userRules, err := parseCookieConsentConfig(data, "user")
+			if err != nil {
+				return nil, err
+			}
+			if user.ReplaceDefaults {
+				set.rules = userRules
+			} else {
+				set.rules = append(userRules, set.rules...)
+			}
+			if user.Prefer != "" {
+				set.prefer = user.Prefer
+			}
+		}
+	}
+
+	if set.prefer, err = normalizeConsentPreference(set.prefer); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// cookieConsentRulesPath returns ~/.qmax/cookie-consent.json.
+func cookieConsentRulesPath() string {
+	dir, err := ConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, cookieConsentRulesFile)
+}
+
+// withPreference returns a copy of the rule set answering banners with p.
+func (s *cookieConsentRuleSet) withPreference(p string) (*cookieConsentRuleSet, error) {
+	prefer, err := normalizeConsentPreference(p)
+	if err != nil {
+		return nil, err
+	}
+	return &cookieConsentRuleSet{prefer: prefer, rules: s.rules}, nil
+}
+
+// candidates lists what to try in a frame with the given URL. Only the
+// preferred answer is tried: a "reject" preference never clicks accept.
+func (s *cookieConsentRuleSet) candidates(frameURL string) []cookieConsentCandidate {
+	var out []cookieConsentCandidate
+	for i, r := range s.rules {
+		if r.frame != nil && !r.frame.MatchString(frameURL) {
+			continue
+		}
+		c := cookieConsentCandidate{Rule: i, Selectors: r.AcceptSelectors, Texts: r.AcceptTexts}
+		if s.prefer == "reject" {
+			c.Selectors, c.Texts = r.RejectSelectors, r.RejectTexts
+		}
+		if len(c.Selectors)+len(c.Texts) > 0 {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// cookieConsentJS returns a script that clicks the first visible match for
+// the candidates, searching the document and any open shadow roots.
+func cookieConsentJS(candidates []cookieConsentCandidate) string {
+	data, _ := json.Marshal(candidates)
+	return fmt.Sprintf(`(() => {
+	const candidates = %s;
+	const norm = (s) => (s || '').replace(/’/g, "'").replace(/\s+/g, ' ').trim().toLowerCase();
+	const roots = [document];
+	const walk = (root) => {
+		for (const el of root.querySelectorAll('*')) {
+			if (el.shadowRoot) { roots.push(el.shadowRoot); walk(el.shadowRoot); }
+		}
+	};
+	walk(document);
+	const visible = (el) => el.getClientRects().length > 0 && getComputedStyle(el).visibility !== 'hidden';
+	const clickable = 'button, a, [role="button"], input[type="button"], input[type="submit"]';
+	for (const c of candidates) {
+		for (const sel of c.selectors || []) {
+			for (const root of roots) {
+				let el = null;
+				try { el = root.querySelector(sel); } catch (e) { break; }
+				if (el && visible(el)) { el.click(); return {rule: c.rule, match: sel}; }
+			}
+		}
+		const wanted = (c.texts || []).map(norm);
+		if (!wanted.length) continue;
+		for (const root of roots) {
+			for (const el of root.querySelectorAll(clickable)) {
+				const i = wanted.indexOf(norm(el.innerText || el.value || el.getAttribute('aria-label')));
+				if (i >= 0 && visible(el)) { el.click(); return {rule: c.rule, match: c.texts[i]}; }
+			}
+		}
+	}
+	return null;
+})()`, data)
+}
+
+// flattenFrameTree lists the main frame followed by its descendants.
+func flattenFrameTree(tree *page.FrameTree) []*page.FrameTree {
+	if tree == nil {
+		return nil
+	}
+	out := []*page.FrameTree{tree}
+	for _, child := range tree.ChildFrames {
+		out = append(out, flattenFrameTree(child)...)
+	}
+	return out
+}
+
+// dismissCookieConsent answers the cookie banner on the current page using
+// rules, or the embedded defaults when rules is nil. Each frame, including
+// cross-origin iframes, is searched in its own isolated world.
+func (a *Agent) dismissCookieConsent(ctx context.Context, sessionID string, rules *cookieConsentRuleSet) *CookieConsentResult {
+	if rules == nil {
+		var err error
+		if rules, err = loadCookieConsentRules(""); err != nil {
+			log.Printf("CRAWL [%s] WARN: cookie consent rules: %v", sessionID, err)
+			return &CookieConsentResult{Status: "not_found"}
+		}
+	}
+	if rules.prefer == "none" {
+		log.Printf("CRAWL [%s] Cookie consent handling disabled", sessionID)
+		return &CookieConsentResult{Status: "skipped"}
+	}
+
+	consentCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var result *CookieConsentResult
+	err := chromedp.Run(consentCtx, chromedp.ActionFunc(func(ctx context.Context) error {
+		tree, err := page.GetFrameTree().Do(ctx)
+		if err != nil {
+			return err
+		}
+		for _, f := range flattenFrameTree(tree) {
+			candidates := rules.candidates(f.Frame.URL)
+			if len(candidates) == 0 {
+				continue
+			}
+			world, err := page.CreateIsolatedWorld(f.Frame.ID).WithWorldName("qmax_consent").Do(ctx)
+			if err != nil {
+				// Frames can detach while we walk the tree
+				continue
+			}
+			obj, exc, err := runtime.Evaluate(cookieConsentJS(candidates)).
+				WithContextID(world).
+				WithReturnByValue(true).
+				Do(ctx)
+			if err != nil || exc != nil || obj == nil || len(obj.Value) == 0 || string(obj.Value) == "null" {
+				continue
+			}
+			var match struct {
+				Rule  int    `json:"rule"`
+				Match string `json:"match"`
+			}
+			if err := json.Unmarshal(obj.Value, &match); err != nil || match.Rule < 0 || match.Rule >= len(rules.rules) {
+				continue
+			}
+			rule := rules.rules[match.Rule]
+			result = &CookieConsentResult{
+				Status: "dismissed",
+				Rule:   rule.Name,
+				Source: rule.source,
+				Choice: rules.prefer,
+				Match:  match.Match,
+			}
+			if f.Frame.ParentID != "" {
+				result.FrameURL = f.Frame.URL
+			}
+			return nil
+		}
+		return nil
+	}))
+	if err != nil {
+		log.Printf("CRAWL [%s] WARN: cookie consent check failed: %v", sessionID, err)
+	}
+
+	if result == nil {
+		log.Printf("CRAWL [%s] No cookie consent overlay detected", sessionID)
+		return &CookieConsentResult{Status: "not_found"}
+	}
+
+	where := ""
+	if result.FrameURL != "" {
+		where = " in frame " + result.FrameURL
+	}
+	log.Printf("CRAWL [%s] Dismissed cookie consent (%s) with rule %s: %q%s",
+		sessionID, result.Choice, result.Rule, result.Match, where)
+	// Wait for overlay to disappear
+	_ = chromedp.Run(ctx, chromedp.Sleep(500*time.Millisecond))
+	return result
+}
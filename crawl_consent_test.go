@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/page"
+)
+
+func TestLoadCookieConsentRules_Defaults(t *testing.T) {
+	set, err := loadCookieConsentRules("")
+	if err != nil {
+		t.Fatalf("embedded rules should parse: %v", err)
+	}
+	if set.prefer != "accept" {
+		t.Errorf("prefer: got %q", set.prefer)
+	}
+
+	names := map[string]bool{}
+	for _, r := range set.rules {
+		names[r.Name] = true
+		if r.source != "default" {
+			t.Errorf("rule %s: source %q", r.Name, r.source)
+		}
+	}
+	for _, want := range []string{"onetrust", "cookiebot", "didomi", "text-en", "text-de", "text-fr", "text-es"} {
+		if !names[want] {
+			t.Errorf("missing default rule %s", want)
+		}
+	}
+}
+
+func TestLoadCookieConsentRules_MissingUserFile(t *testing.T) {
+	set, err := loadCookieConsentRules(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatalf("missing user file should fall back to defaults: %v", err)
+	}
+	if len(set.rules) == 0 {
+		t.Error("expected default rules")
+	}
+}
+
+func TestLoadCookieConsentRules_UserFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), cookieConsentRulesFile)
+	user := `{"prefer": "reject", "rules": [{"name": "intranet", "reject_selectors": ["#no-thanks"]}]}`
+	if err := os.WriteFile(path, []byte(user), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	set, err := loadCookieConsentRules(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if set.prefer != "reject" {
+		t.Errorf("prefer: got %q", set.prefer)
+	}
+	if set.rules[0].Name != "intranet" || set.rules[0].source != "user" {
+		t.Errorf("user rules should come first, got %s (%s)", set.rules[0].Name, set.rules[0].source)
+	}
+	if len(set.rules) < 2 {
+		t.Error("defaults should be kept")
+	}
+}
+
+func TestLoadCookieConsentRules_ReplaceDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), cookieConsentRulesFile)
+	user := `{"replace_defaults": true, "rules": [{"name": "only", "accept_texts": ["Weiter"]}]}`
+	if err := os.WriteFile(path, []byte(user), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	set, err := loadCookieConsentRules(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(set.rules) != 1 || set.rules[0].Name != "only" {
+		t.Errorf("expected only the user rule, got %d rules", len(set.rules))
+	}
+	if set.prefer != "accept" {
+		t.Errorf("prefer should stay the default, got %q", set.prefer)
+	}
+}
+
+func TestLoadCookieConsentRules_InvalidUserFile(t *testing.T) {
+	tests := map[string]string{
+		"malformed":     `{"rules": [`,
+		"no name":       `{"rules": [{"accept_texts": ["OK"]}]}`,
+		"empty rule":    `{"rules": [{"name": "x"}]}`,
+		"bad frame url": `{"rules": [{"name": "x", "frame_url": "(", "accept_texts": ["OK"]}]}`,
+		"bad prefer":    `{"prefer": "maybe", "rules": []}`,
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), cookieConsentRulesFile)
+			if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := loadCookieConsentRules(path); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestNormalizeConsentPreference(t *testing.T) {
+	tests := map[string]string{"": "accept", "Accept": "accept", "reject": "reject", "none": "none", "off": "none"}
+	for in, want := range tests {
+		got, err := normalizeConsentPreference(in)
+		if err != nil || got != want {
+			t.Errorf("normalizeConsentPreference(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := normalizeConsentPreference("deny"); err == nil {
+		t.Error("expected error for unknown preference")
+	}
+}
+
+func TestCookieConsentCandidates(t *testing.T) {
+	set, _ := loadCookieConsentRules("")
+
+	accept := set.candidates("https://example.com/")
+	if len(accept) == 0 {
+		t.Fatal("expected candidates")
+	}
+	first := set.rules[accept[0].Rule]
+	if first.Name != "onetrust" || accept[0].Selectors[0] != "#onetrust-accept-btn-handler" {
+		t.Errorf("unexpected first candidate: %s %v", first.Name, accept[0].Selectors)
+	}
+	for _, c := range accept {
+		if set.rules[c.Rule].Name == "sourcepoint" {
+			t.Error("frame-scoped rule should not apply to an unrelated frame")
+		}
+	}
+
+	inFrame := set.candidates("https://cdn.privacy-mgmt.com/index.html?message_id=1")
+	found := false
+	for _, c := range inFrame {
+		found = found || set.rules[c.Rule].Name == "sourcepoint"
+	}
+	if !found {
+		t.Error("frame-scoped rule should apply to a matching frame")
+	}
+
+	reject, _ := set.withPreference("reject")
+	for _, c := range reject.candidates("https://example.com/") {
+		rule := reject.rules[c.Rule]
+		for _, sel := range c.Selectors {
+			for _, acc := range rule.AcceptSelectors {
+				if sel == acc {
+					t.Errorf("reject preference must not try accept selector %s", sel)
+				}
+			}
+		}
+	}
+	if set.prefer != "accept" {
+		t.Error("withPreference should not modify the original set")
+	}
+}
+
+func TestCookieConsentJS(t *testing.T) {
+	js := cookieConsentJS([]cookieConsentCandidate{{Rule: 3, Selectors: []string{`[data-testid="x"]`}, Texts: []string{"Alle akzeptieren"}}})
+	if !strings.Contains(js, `"selectors":["[data-testid=\"x\"]"]`) {
+		t.Errorf("script missing selectors: %s", js)
+	}
+	if !strings.Contains(js, "shadowRoot") {
+		t.Error("script should search shadow roots")
+	}
+}
+
+func TestFlattenFrameTree(t *testing.T) {
+	tree := &page.FrameTree{
+		Frame: &cdp.Frame{ID: "main"},
+		ChildFrames: []*page.FrameTree{
+			{Frame: &cdp.Frame{ID: "a"}, ChildFrames: []*page.FrameTree{{Frame: &cdp.Frame{ID: "a1"}}}},
+			{Frame: &cdp.Frame{ID: "b"}},
+		},
+	}
+	var ids []string
+	for _, f := range flattenFrameTree(tree) {
+		ids = append(ids, string(f.Frame.ID))
+	}
+	if strings.Join(ids, ",") != "main,a,a1,b" {
+		t.Errorf("got %v", ids)
+	}
+	if flattenFrameTree(nil) != nil {
+		t.Error("nil tree should give no frames")
+	}
+}
+
+func TestCrawlSnapshot_CookieConsentSerialization(t *testing.T) {
+	snap := CrawlSnapshot{StepNum: 1, CookieConsent: &CookieConsentResult{
+		Status: "dismissed", Rule: "didomi", Source: "default", Choice: "reject", Match: "#didomi-notice-disagree-button",
+	}}
+	data, _ := json.Marshal(snap)
+	if !strings.Contains(string(data), `"cookie_consent":{"status":"dismissed","rule":"didomi","source":"default","choice":"reject"`) {
+		t.Errorf("unexpected JSON: %s", data)
+	}
+
+	var session CrawlSession
+	if err := json.Unmarshal([]byte(`{"session_id": "c", "cookie_consent": "reject"}`), &session); err != nil {
+		t.Fatal(err)
+	}
+	if session.CookieConsent != "reject" {
+		t.Errorf("CookieConsent: got %q", session.CookieConsent)
+	}
+}
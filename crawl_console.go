@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	cdplog "github.com/chromedp/cdproto/log"
+	"github.com/chromedp/cdproto/runtime"
+)
+
+const (
+	maxConsoleEntriesPerStep = 100
+	maxConsoleTextLen        = 1000
+	maxConsoleTopMessages    = 10
+)
+
+// CrawlConsoleEntry is a console error, uncaught exception or browser log
+// entry observed while the crawl browser was on a page.
+type CrawlConsoleEntry struct {
+	Source string `json:"source"` // console, exception, log
+	Level  string `json:"level"`  // error, warning
+	Text   string `json:"text"`
+	URL    string `json:"url,omitempty"`
+	Line   int64  `json:"line,omitempty"`
+	Column int64  `json:"column,omitempty"`
+	Step   int    `json:"step"`
+}
+
+// CrawlConsoleMessageCount is a distinct console message and how often it was seen.
+type CrawlConsoleMessageCount struct {
+	Source string `json:"source"`
+	Level  string `json:"level"`
+	Text   string `json:"text"`
+	Count  int    `json:"count"`
+}
+
+// CrawlConsoleSummary aggregates console activity over a whole crawl session.
+type CrawlConsoleSummary struct {
+	Errors          int                        `json:"errors"`
+	Warnings        int                        `json:"warnings"`
+	Exceptions      int                        `json:"exceptions"`
+	Dropped         int                        `json:"dropped"`
+	StepsWithErrors []int                      `json:"steps_with_errors"`
+	TopMessages     []CrawlConsoleMessageCount `json:"top_messages"`
+}
+
+// crawlConsoleCollector buffers console events from the crawl browser between
+// snapshots. It is fed from a chromedp target listener, so handleEvent must
+// never block.
+type crawlConsoleCollector struct {
+	mu      sync.Mutex
+	pending []CrawlConsoleEntry
+	all     []CrawlConsoleEntry
+	dropped int
+}
+
+func newCrawlConsoleCollector() *crawlConsoleCollector {
+	return &crawlConsoleCollector{}
+}
+
+// handleEvent is a chromedp.ListenTarget callback.
+func (c *crawlConsoleCollector) handleEvent(ev interface{}) {
+	var entry *CrawlConsoleEntry
+
+	switch ev := ev.(type) {
+	case *runtime.EventConsoleAPICalled:
+		level := consoleLevel(string(ev.Type))
+		if level == "" {
+			return
+		}
+		parts := make([]string, 0, len(ev.Args))
+		for _, arg := range ev.Args {
+			parts = append(parts, remoteObjectText(arg))
+		}
+		entry = &CrawlConsoleEntry{
+			Source: "console",
+			Level:  level,
+			Text:   strings.Join(parts, " "),
+		}
+		if ev.StackTrace != nil && len(ev.StackTrace.CallFrames) > 0 {
+			frame := ev.StackTrace.CallFrames[0]
+			entry.URL = frame.URL
+			entry.Line = frame.LineNumber + 1
+			entry.Column = frame.ColumnNumber + 1
+		}
+	case *runtime.EventExceptionThrown:
+		details := ev.ExceptionDetails
+		if details == nil {
+			return
+		}
+		text := details.Text
+		if details.Exception != nil && details.Exception.Description != "" {
+			// Description carries "TypeError: x is undefined" plus the stack
+			text = strings.SplitN(details.Exception.Description, "\n", 2)[0]
+		}
+		entry = &CrawlConsoleEntry{
+			Source: "exception",
+			Level:  "error",
+			Text:   text,
+			URL:    details.URL,
+			Line:   details.LineNumber + 1,
+			Column: details.ColumnNumber + 1,
+		}
+	case *cdplog.EventEntryAdded:
+		if ev.Entry == nil {
+			return
+		}
+		level := consoleLevel(string(ev.Entry.Level))
+		if level == "" {
+			return
+		}
+		entry = &CrawlConsoleEntry{
+			Source: "log",
+			Level:  level,
+			Text:   ev.Entry.Text,
+			URL:    ev.Entry.URL,
+			Line:   ev.Entry.LineNumber,
+		}
+	default:
+		return
+	}
+
+	entry.Text = truncate(entry.Text, maxConsoleTextLen)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending) >= maxConsoleEntriesPerStep {
+		c.dropped++
+		return
+	}
+	c.pending = append(c.pending, *entry)
+}
+
+// drain returns the entries observed since the previous drain, tagged with
+// the given step number, and records them for the session summary.
+func (c *crawlConsoleCollector) drain(step int) []CrawlConsoleEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.pending) == 0 {
+		return nil
+	}
+	entries := c.pending
+	c.pending = nil
+	for i := range entries {
+		entries[i].Step = step
+	}
+	c.all = append(c.all, entries...)
+	return entries
+}
+
+// summary aggregates every drained entry into per-level totals and the most
+// frequent distinct messages.
+func (c *crawlConsoleCollector) summary() CrawlConsoleSummary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := CrawlConsoleSummary{
+		Dropped:         c.dropped,
+		StepsWithErrors: []int{},
+		TopMessages:     []CrawlConsoleMessageCount{},
+	}
+
+	counts := map[string]*CrawlConsoleMessageCount{}
+	var order []string
+	seenStep := map[int]bool{}
+
+	for _, e := range c.all {
+		switch {
+		case e.Source == "exception":
+			s.Exceptions++
+		case e.Level == "error":
+			s.Errors++
+		default:
+			s.Warnings++
+		}
+		if e.Level == "error" && !seenStep[e.Step] {
+			seenStep[e.Step] = true
+			s.StepsWithErrors = append(s.StepsWithErrors, e.Step)
+		}
+
+		key := e.Source + "\x00" + e.Level + "\x00" + e.Text
+		if mc, ok := counts[key]; ok {
+			mc.Count++
+			continue
+		}
+		counts[key] = &CrawlConsoleMessageCount{Source: e.Source, Level: e.Level, Text: e.Text, Count: 1}
+		order = append(order, key)
+	}
+
+	sort.Ints(s.StepsWithErrors)
+
+	// Stable sort keeps first-seen order among equally frequent messages
+	sort.SliceStable(order, func(i, j int) bool {
+		return counts[order[i]].Count > counts[order[j]].Count
+	})
+	for i, key := range order {
+		if i >= maxConsoleTopMessages {
+			break
+		}
+		s.TopMessages = append(s.TopMessages, *counts[key])
+	}
+
+	return s
+}
+
+// consoleLevel maps console API types and log levels to the two levels we
+// report, returning "" for anything quieter than a warning.
+func consoleLevel(level string) string {
+	switch level {
+	case "error", "assert":
+		return "error"
+	case "warning", "warn":
+		return "warning"
+	}
+	return ""
+}
+
+// remoteObjectText renders a console argument roughly the way DevTools does.
+func remoteObjectText(obj *runtime.RemoteObject) string {
+	if obj == nil {
+		return ""
+	}
+	if len(obj.Value) > 0 {
+		var s string
+		if err := json.Unmarshal(obj.Value, &s); err == nil {
+			return s
+		}
+		return string(obj.Value)
+	}
+	if obj.UnserializableValue != "" {
+		return string(obj.UnserializableValue)
+	}
+	if obj.Description != "" {
+		return obj.Description
+	}
+	return fmt.Sprintf("[%s]", obj.Type)
+}
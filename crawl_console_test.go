@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	cdplog "github.com/chromedp/cdproto/log"
+	"github.com/chromedp/cdproto/runtime"
+)
+
+func consoleStringArg(s string) *runtime.RemoteObject {
+	v, _ := json.Marshal(s)
+	return &runtime.RemoteObject{Type: runtime.TypeString, Value: v}
+}
+
+func TestCrawlConsoleCollector_ConsoleAPICalled(t *testing.T) {
+	c := newCrawlConsoleCollector()
+
+	c.handleEvent(&runtime.EventConsoleAPICalled{
+		Type: runtime.APITypeError,
+		Args: []*runtime.RemoteObject{consoleStringArg("failed to load"), {Type: runtime.TypeNumber, Value: []byte("42")}},
+		StackTrace: &runtime.StackTrace{CallFrames: []*runtime.CallFrame{
+			{URL: "https://example.com/app.js", LineNumber: 9, ColumnNumber: 4},
+		}},
+	})
+	// Plain console.log must be ignored
+	c.handleEvent(&runtime.EventConsoleAPICalled{
+		Type: runtime.APITypeLog,
+		Args: []*runtime.RemoteObject{consoleStringArg("hello")},
+	})
+
+	entries := c.drain(3)
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d: %+v", len(entries), entries)
+	}
+	e := entries[0]
+	if e.Source != "console" || e.Level != "error" {
+		t.Errorf("source/level: got %s/%s", e.Source, e.Level)
+	}
+	if e.Text != "failed to load 42" {
+		t.Errorf("Text: got %q", e.Text)
+	}
+	if e.URL != "https://example.com/app.js" || e.Line != 10 || e.Column != 5 {
+		t.Errorf("location: got %s:%d:%d", e.URL, e.Line, e.Column)
+	}
+	if e.Step != 3 {
+		t.Errorf("Step: got %d, want 3", e.Step)
+	}
+
+	if again := c.drain(4); again != nil {
+		t.Errorf("expected empty drain, got %+v", again)
+	}
+}
+
+func TestCrawlConsoleCollector_ExceptionThrown(t *testing.T) {
+	c := newCrawlConsoleCollector()
+
+	c.handleEvent(&runtime.EventExceptionThrown{
+		ExceptionDetails: &runtime.ExceptionDetails{
+			Text:         "Uncaught",
+			URL:          "https://example.com/main.js",
+			LineNumber:   0,
+			ColumnNumber: 12,
+			Exception: &runtime.RemoteObject{
+				Type:        runtime.TypeObject,
+				Description: "TypeError: x is undefined\n    at main.js:1:13",
+			},
+		},
+	})
+
+	entries := c.drain(1)
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+	if entries[0].Source != "exception" {
+		t.Errorf("Source: got %q", entries[0].Source)
+	}
+	if entries[0].Text != "TypeError: x is undefined" {
+		t.Errorf("Text: got %q", entries[0].Text)
+	}
+	if entries[0].Line != 1 || entries[0].Column != 13 {
+		t.Errorf("location: got %d:%d", entries[0].Line, entries[0].Column)
+	}
+}
+
+func TestCrawlConsoleCollector_LogEntryAdded(t *testing.T) {
+	c := newCrawlConsoleCollector()
+
+	c.handleEvent(&cdplog.EventEntryAdded{Entry: &cdplog.Entry{
+		Source: cdplog.SourceNetwork,
+		Level:  cdplog.LevelError,
+		Text:   "Failed to load resource: the server responded with a status of 404",
+		URL:    "https://example.com/missing.png",
+	}})
+	c.handleEvent(&cdplog.EventEntryAdded{Entry: &cdplog.Entry{
+		Source: cdplog.SourceViolation,
+		Level:  cdplog.LevelVerbose,
+		Text:   "'click' handler took 200ms",
+	}})
+
+	entries := c.drain(2)
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+	if entries[0].Source != "log" || entries[0].URL != "https://example.com/missing.png" {
+		t.Errorf("unexpected entry: %+v", entries[0])
+	}
+}
+
+func TestCrawlConsoleCollector_CapsEntriesPerStep(t *testing.T) {
+	c := newCrawlConsoleCollector()
+	for i := 0; i < maxConsoleEntriesPerStep+5; i++ {
+		c.handleEvent(&runtime.EventConsoleAPICalled{
+			Type: runtime.APITypeWarning,
+			Args: []*runtime.RemoteObject{consoleStringArg(strings.Repeat("w", maxConsoleTextLen*2))},
+		})
+	}
+
+	entries := c.drain(1)
+	if len(entries) != maxConsoleEntriesPerStep {
+		t.Errorf("expected %d entries, got %d", maxConsoleEntriesPerStep, len(entries))
+	}
+	if len(entries[0].Text) != maxConsoleTextLen {
+		t.Errorf("expected text truncated to %d, got %d", maxConsoleTextLen, len(entries[0].Text))
+	}
+	if s := c.summary(); s.Dropped != 5 {
+		t.Errorf("Dropped: got %d, want 5", s.Dropped)
+	}
+}
+
+func TestCrawlConsoleCollector_Summary(t *testing.T) {
+	c := newCrawlConsoleCollector()
+
+	consoleErr := &runtime.EventConsoleAPICalled{Type: runtime.APITypeError, Args: []*runtime.RemoteObject{consoleStringArg("boom")}}
+	consoleWarn := &runtime.EventConsoleAPICalled{Type: runtime.APITypeWarning, Args: []*runtime.RemoteObject{consoleStringArg("deprecated")}}
+	exception := &runtime.EventExceptionThrown{ExceptionDetails: &runtime.ExceptionDetails{Text: "Uncaught ReferenceError"}}
+
+	c.handleEvent(consoleErr)
+	c.handleEvent(consoleWarn)
+	c.drain(1)
+
+	c.handleEvent(consoleWarn)
+	c.drain(2)
+
+	c.handleEvent(consoleErr)
+	c.handleEvent(consoleErr)
+	c.handleEvent(exception)
+	c.drain(4)
+
+	s := c.summary()
+	if s.Errors != 3 {
+		t.Errorf("Errors: got %d, want 3", s.Errors)
+	}
+	if s.Warnings != 2 {
+		t.Errorf("Warnings: got %d, want 2", s.Warnings)
+	}
+	if s.Exceptions != 1 {
+		t.Errorf("Exceptions: got %d, want 1", s.Exceptions)
+	}
+	if len(s.StepsWithErrors) != 2 || s.StepsWithErrors[0] != 1 || s.StepsWithErrors[1] != 4 {
+		t.Errorf("StepsWithErrors: got %v, want [1 4]", s.StepsWithErrors)
+	}
+	if len(s.TopMessages) != 3 {
+		t.Fatalf("TopMessages: got %d, want 3", len(s.TopMessages))
+	}
+	if s.TopMessages[0].Text != "boom" || s.TopMessages[0].Count != 3 {
+		t.Errorf("top message: got %+v", s.TopMessages[0])
+	}
+}
+
+func TestCrawlConsoleSummary_EmptySerializesArrays(t *testing.T) {
+	data, err := json.Marshal(newCrawlConsoleCollector().summary())
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if !strings.Contains(string(data), `"steps_with_errors":[]`) || !strings.Contains(string(data), `"top_messages":[]`) {
+		t.Errorf("expected empty arrays, got %s", data)
+	}
+}
+
+func TestCrawlSnapshot_ConsoleEntriesOmittedWhenEmpty(t *testing.T) {
+	data, _ := json.Marshal(CrawlSnapshot{SessionID: "s"})
+	if strings.Contains(string(data), "console_entries") {
+		t.Errorf("console_entries should be omitted when empty: %s", data)
+	}
+
+	data, _ = json.Marshal(CrawlSnapshot{ConsoleEntries: []CrawlConsoleEntry{{Source: "exception", Level: "error", Text: "x"}}})
+	if !strings.Contains(string(data), `"console_entries":[{"source":"exception"`) {
+		t.Errorf("expected console_entries in JSON, got %s", data)
+	}
+}
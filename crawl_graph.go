@@ -0,0 +1,528 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png" // decode PNG screenshots
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	crawlThumbnailWidth = 240
+	crawlReportsDirName = "crawls"
+)
+
+// CrawlGraph is the sitemap of a crawl: the pages seen (grouped by URL
+// template), the actions that led from one page to another, and the forms
+// found on each page.
+type CrawlGraph struct {
+	SessionID   string                  `json:"session_id"`
+	StartURL    string                  `json:"start_url"`
+	GeneratedAt time.Time               `json:"generated_at"`
+	Pages       []*CrawlGraphPage       `json:"pages"`
+	Transitions []*CrawlGraphTransition `json:"transitions"`
+	Forms       []CrawlGraphForm        `json:"forms"`
+
+	pagesByTemplate map[string]*CrawlGraphPage
+	last            *CrawlGraphPage
+}
+
+// CrawlGraphPage is one URL template, e.g. example.com/orders/:id.
+type CrawlGraphPage struct {
+	ID        string   `json:"id"`
+	Template  string   `json:"template"`
+	Title     string   `json:"title"`
+	URLs      []string `json:"urls"`
+	Visits    int      `json:"visits"`
+	FirstStep int      `json:"first_step"`
+	Thumbnail string   `json:"thumbnail,omitempty"` // data: URI, JPEG
+}
+
+// CrawlGraphTransition is an action that led from one page to another (or
+// back to the same page).
+type CrawlGraphTransition struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Action   string `json:"action"`
+	Selector string `json:"selector"`
+	Step     int    `json:"step"` // first step that took it
+	Count    int    `json:"count"`
+}
+
+// CrawlGraphForm is a form found on a page.
+type CrawlGraphForm struct {
+	Page   string   `json:"page"`
+	Name   string   `json:"name,omitempty"`
+	Action string   `json:"action,omitempty"`
+	Method string   `json:"method,omitempty"`
+	Fields []string `json:"fields,omitempty"`
+}
+
+func newCrawlGraph(sessionID, startURL string) *CrawlGraph {
+	return &CrawlGraph{
+		SessionID:       sessionID,
+		StartURL:        startURL,
+		GeneratedAt:     time.Now().UTC(),
+		Pages:           []*CrawlGraphPage{},
+		Transitions:     []*CrawlGraphTransition{},
+		Forms:           []CrawlGraphForm{},
+		pagesByTemplate: map[string]*CrawlGraphPage{},
+	}
+}
+
+var (
+	uuidPathSegment    = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	numericPathSegment = regexp.MustCompile(`^\d+$`)
+	hashPathSegment    = regexp.MustCompile(`^[0-9a-fA-F]{16,}$`)
+	tokenPathSegment   = regexp.MustCompile(`^[A-Za-z0-9_-]{20,}$`)
+)
+
+// crawlURLTemplate reduces a URL to host and path, with IDs replaced by
+// placeholders. Query strings are dropped; hash routes (#/users/1) are kept.
+func crawlURLTemplate(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return raw
+	}
+	t := u.Host + templatePath(u.Path)
+	if strings.HasPrefix(u.Fragment, "/") || strings.HasPrefix(u.Fragment, "!/") {
+		route := u.Fragment
+		if i := strings.IndexByte(route, '?'); i >= 0 {
+			route = route[:i]
+		}
+		t += "#" + templatePath(route)
+	}
+	return t
+}
+
+func templatePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		switch {
+		case s == "":
+		case numericPathSegment.MatchString(s):
+			segments[i] = ":id"
+		case uuidPathSegment.MatchString(s):
+			segments[i] = ":uuid"
+		case hashPathSegment.MatchString(s):
+			segments[i] = ":hash"
+		case tokenPathSegment.MatchString(s) && strings.ContainsAny(s, "0123456789"):
+			segments[i] = ":token"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// observe records a snapshot: its page, the transition from the previous
+// snapshot's page via the last action, and the page's forms.
+func (g *CrawlGraph) observe(snap *CrawlSnapshot) {
+	tmpl := crawlURLTemplate(snap.URL)
+	p, seen := g.pagesByTemplate[tmpl]
+	if !seen {
+		p = &CrawlGraphPage{
+			ID:        fmt.Sprintf("p%d", len(g.Pages)+1),
+			Template:  tmpl,
+			Title:     snap.Title,
+			FirstStep: snap.StepNum,
+		}
+		if thumb, err := crawlThumbnail(snap.ScreenshotBase64); err == nil {
+			p.Thumbnail = thumb
+		}
+		g.Pages = append(g.Pages, p)
+		g.pagesByTemplate[tmpl] = p
+	}
+	p.Visits++
+	if !containsString(p.URLs, snap.URL) && len(p.URLs) < 20 {
+		p.URLs = append(p.URLs, snap.URL)
+	}
+
+	if g.last != nil && snap.LastAction != nil && snap.LastAction.Status == "ok" {
+		g.addTransition(g.last, p, snap.LastAction, snap.StepNum-1)
+	}
+	g.last = p
+
+	for _, f := range snap.Forms {
+		form := summarizeCrawlForm(f)
+		form.Page = p.ID
+		if !g.hasForm(form) {
+			g.Forms = append(g.Forms, form)
+		}
+	}
+}
+
+func (g *CrawlGraph) addTransition(from, to *CrawlGraphPage, action *CrawlActionResult, step int) {
+	for _, t := range g.Transitions {
+		if t.From == from.ID && t.To == to.ID && t.Action == action.Action && t.Selector == action.Selector {
+			t.Count++
+			return
+		}
+	}
+	g.Transitions = append(g.Transitions, &CrawlGraphTransition{
+		From: from.ID, To: to.ID, Action: action.Action, Selector: action.Selector, Step: step, Count: 1,
+	})
+}
+
+func (g *CrawlGraph) hasForm(form CrawlGraphForm) bool {
+	for _, f := range g.Forms {
+		if f.Page == form.Page && f.Name == form.Name && f.Action == form.Action {
+			return true
+		}
+	}
+	return false
+}
+
+// summarizeCrawlForm picks the name, action, method and field names out of a
+// form entry from the snapshot script.
+func summarizeCrawlForm(f map[string]any) CrawlGraphForm {
+	str := func(m map[string]any, keys ...string) string {
+		for _, k := range keys {
+			if v, ok := m[k].(string); ok && v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	form := CrawlGraphForm{
+		Name:   str(f, "name", "id", "selector"),
+		Action: str(f, "action"),
+		Method: strings.ToUpper(str(f, "method")),
+	}
+	for _, key := range []string{"fields", "inputs", "elements"} {
+		items, ok := f[key].([]any)
+		if !ok {
+			continue
+		}
+		for _, item := range items {
+			switch v := item.(type) {
+			case string:
+				form.Fields = append(form.Fields, v)
+			case map[string]any:
+				if name := str(v, "name", "label", "id", "placeholder", "selector"); name != "" {
+					form.Fields = append(form.Fields, name)
+				}
+			}
+		}
+		break
+	}
+	return form
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// crawlThumbnail scales a base64 PNG or JPEG screenshot down to a JPEG data
+// URI. WebP screenshots are not decoded and get no thumbnail.
+func crawlThumbnail(screenshotBase64 string) (string, error) {
+	if screenshotBase64 == "" {
+		return "", fmt.Errorf("no screenshot")
+	}
+	data, err := base64.StdEncoding.DecodeString(screenshotBase64)
+	if err != nil {
+		return "", err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleToWidth(img, crawlThumbnailWidth), &jpeg.Options{Quality: 70}); err != nil {
+		return "", err
+	}
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// scaleToWidth shrinks img to width w by averaging source pixels.
+func scaleToWidth(img image.Image, w int) image.Image {
+	b := img.Bounds()
+	if b.Dx() <= w {
+		return img
+	}
+	h := max(1, b.Dy()*w/b.Dx())
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		sy0, sy1 := b.Min.Y+y*b.Dy()/h, b.Min.Y+(y+1)*b.Dy()/h
+		for x := 0; x < w; x++ {
+			sx0, sx1 := b.Min.X+x*b.Dx()/w, b.Min.X+(x+1)*b.Dx()/w
+			var r, g, bl, n uint32
+			for sy := sy0; sy < max(sy1, sy0+1); sy++ {
+				for sx := sx0; sx < max(sx1, sx0+1); sx++ {
+					cr, cg, cb, _ := img.At(sx, sy).RGBA()
+					r, g, bl, n = r+cr, g+cg, bl+cb, n+1
+				}
+			}
+			out.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: 0xffff})
+		}
+	}
+	return out
+}
+
+// --- Export ---
+
+// crawlGraphFormat returns the export format for path: the explicit format
+// if given, otherwise one derived from the file extension.
+func crawlGraphFormat(path, format string) (string, error) {
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".dot", ".gv":
+			format = "dot"
+		case ".html", ".htm":
+			format = "html"
+		default:
+			format = "json"
+		}
+	}
+	switch format {
+	case "json", "dot", "html":
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown sitemap format %q (use json, dot or html)", format)
+}
+
+// Write renders the graph in the given format.
+func (g *CrawlGraph) Write(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(g)
+	case "dot":
+		return g.writeDOT(w)
+	case "html":
+		return crawlGraphHTML.Execute(w, g)
+	}
+	return fmt.Errorf("unknown sitemap format %q", format)
+}
+
+// WriteFile writes the graph to path; format may be empty to derive it
+// from the extension.
+func (g *CrawlGraph) WriteFile(path, format string) error {
+	format, err := crawlGraphFormat(path, format)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := g.Write(&buf, format); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
+
+func (g *CrawlGraph) writeDOT(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph crawl {\n")
+	fmt.Fprintf(&b, "  label=%s;\n", dotQuote("Crawl "+g.SessionID+" — "+g.StartURL))
+	b.WriteString("  rankdir=LR;\n  node [shape=box, style=rounded, fontname=\"Helvetica\"];\n  edge [fontname=\"Helvetica\", fontsize=10];\n")
+	forms := map[string]int{}
+	for _, f := range g.Forms {
+		forms[f.Page]++
+	}
+	for _, p := range g.Pages {
+		label := p.Template
+		if p.Title != "" {
+			label = truncate(p.Title, 60) + "\n" + label
+		}
+		if n := forms[p.ID]; n > 0 {
+			label += fmt.Sprintf("\n%d form(s)", n)
+		}
+		fmt.Fprintf(&b, "  %s [label=%s];\n", p.ID, dotQuote(label))
+	}
+	for _, t := range g.Transitions {
+		label := t.Action + " " + truncate(t.Selector, 40)
+		if t.Count > 1 {
+			label += fmt.Sprintf(" (x%d)", t.Count)
+		}
+		fmt.Fprintf(&b, "  %s -> %s [label=%s];\n", t.From, t.To, dotQuote(label))
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// PageByID returns the page with the given ID, or nil.
+func (g *CrawlGraph) PageByID(id string) *CrawlGraphPage {
+	for _, p := range g.Pages {
+		if p.ID == id {
+			return p
+		}
+	}
+	return nil
+}
+
+// Outgoing returns the transitions leaving a page, in step order.
+func (g *CrawlGraph) Outgoing(id string) []*CrawlGraphTransition {
+	var out []*CrawlGraphTransition
+	for _, t := range g.Transitions {
+		if t.From == id {
+			out = append(out, t)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Step < out[j].Step })
+	return out
+}
+
+// FormsOn returns the forms found on a page.
+func (g *CrawlGraph) FormsOn(id string) []CrawlGraphForm {
+	var out []CrawlGraphForm
+	for _, f := range g.Forms {
+		if f.Page == id {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+var crawlGraphHTML = template.Must(template.New("sitemap").Funcs(template.FuncMap{
+	"thumb": func(s string) template.URL {
+		// Only our own JPEG data URIs are trusted as image sources
+		if strings.HasPrefix(s, "data:image/jpeg;base64,") {
+			return template.URL(s)
+		}
+		return ""
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Crawl sitemap {{.SessionID}}</title>
+<style>
+body { font-family: -apple-system, Helvetica, Arial, sans-serif; margin: 2rem; color: #1f2328; background: #f6f8fa; }
+h1 { font-size: 1.4rem; margin-bottom: .2rem; }
+.meta { color: #59636e; margin-bottom: 1.5rem; }
+.summary span { display: inline-block; margin-right: 1.5rem; font-weight: 600; }
+.page { display: flex; gap: 1rem; background: #fff; border: 1px solid #d1d9e0; border-radius: 8px; padding: 1rem; margin: 1rem 0; }
+.page img { width: 240px; border: 1px solid #d1d9e0; border-radius: 4px; align-self: flex-start; }
+.page .noimg { width: 240px; height: 135px; background: #eef1f4; border-radius: 4px; }
+.page h2 { font-size: 1.05rem; margin: 0 0 .3rem; }
+.tmpl { font-family: ui-monospace, Menlo, monospace; font-size: .85rem; color: #0969da; }
+.small { color: #59636e; font-size: .85rem; }
+ul { margin: .4rem 0; padding-left: 1.2rem; }
+code { background: #eef1f4; padding: 0 .25rem; border-radius: 3px; font-size: .85rem; }
+</style>
+</head>
+<body>
+<h1>Crawl sitemap</h1>
+<div class="meta">Session {{.SessionID}} · {{.StartURL}} · generated {{.GeneratedAt.Format "2006-01-02 15:04 MST"}}</div>
+<div class="summary"><span>{{len .Pages}} pages</span><span>{{len .Transitions}} transitions</span><span>{{len .Forms}} forms</span></div>
+{{range .Pages}}{{$page := .}}
+<div class="page" id="{{.ID}}">
+  {{if .Thumbnail}}<img src="{{thumb .Thumbnail}}" alt="{{.Title}}">{{else}}<div class="noimg"></div>{{end}}
+  <div>
+    <h2>{{.ID}} · {{if .Title}}{{.Title}}{{else}}(untitled){{end}}</h2>
+    <div class="tmpl">{{.Template}}</div>
+    <div class="small">First seen at step {{.FirstStep}} · {{.Visits}} visit(s)</div>
+    <ul class="small">{{range .URLs}}<li>{{.}}</li>{{end}}</ul>
+    {{with $.Outgoing .ID}}<strong>Leads to</strong><ul>{{range .}}{{$to := $.PageByID .To}}
+      <li><code>{{.Action}} {{.Selector}}</code> → <a href="#{{.To}}">{{.To}}{{if $to}} {{$to.Title}}{{end}}</a>{{if gt .Count 1}} (×{{.Count}}){{end}}</li>{{end}}
+    </ul>{{end}}
+    {{with $.FormsOn .ID}}<strong>Forms</strong><ul>{{range .}}
+      <li>{{if .Name}}<code>{{.Name}}</code>{{else}}form{{end}}{{if .Method}} {{.Method}}{{end}}{{if .Action}} → {{.Action}}{{end}}{{if .Fields}}: {{range $i, $f := .Fields}}{{if $i}}, {{end}}{{$f}}{{end}}{{end}}</li>{{end}}
+    </ul>{{end}}
+  </div>
+</div>
+{{end}}
+</body>
+</html>
+`))
+
+// --- Session output ---
+
+// crawlReportDir is where a crawl's sitemap files are saved locally.
+func crawlReportDir(crawlID string) (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, crawlReportsDirName, visualPathPart("", crawlID)), nil
+}
+
+// reportID names the session's report directory: the crawl ID, which is
+// what `qmax crawl results --crawl-id` looks up, or the session ID when the
+// server did not send one.
+func (s CrawlSession) reportID() string {
+	if s.CrawlID != "" {
+		return s.CrawlID
+	}
+	return s.SessionID
+}
+
+// saveCrawlGraph writes the sitemap as JSON, DOT and HTML to the crawl's
+// report directory, and to session.SitemapPath when set.
+func saveCrawlGraph(session CrawlSession, g *CrawlGraph) {
+	if dir, err := crawlReportDir(session.reportID()); err != nil {
+		log.Printf("CRAWL [%s] WARN: could not save sitemap: %v", session.SessionID, err)
+	} else {
+		for _, name := range []string{"sitemap.json", "sitemap.dot", "sitemap.html"} {
+			if err := g.WriteFile(filepath.Join(dir, name), ""); err != nil {
+				log.Printf("CRAWL [%s] WARN: could not write %s: %v", session.SessionID, name, err)
+			}
+		}
+		log.Printf("CRAWL [%s] Sitemap: %d pages, %d transitions, %d forms (%s)",
+			session.SessionID, len(g.Pages), len(g.Transitions), len(g.Forms), filepath.Join(dir, "sitemap.html"))
+	}
+	if session.SitemapPath != "" {
+		if err := g.WriteFile(session.SitemapPath, ""); err != nil {
+			log.Printf("CRAWL [%s] WARN: could not write sitemap: %v", session.SessionID, err)
+		} else {
+			log.Printf("CRAWL [%s] Wrote sitemap to %s", session.SessionID, session.SitemapPath)
+		}
+	}
+}
+
+// crawlGraphFromResults reads the sitemap from a crawl results response, or
+// from the copy a local session saved under ~/.qmax/crawls.
+func crawlGraphFromResults(body []byte, crawlID string) (*CrawlGraph, error) {
+	var resp struct {
+		Results struct {
+			Sitemap *CrawlGraph `json:"sitemap"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(body, &resp); err == nil && resp.Results.Sitemap != nil {
+		return resp.Results.Sitemap, nil
+	}
+	dir, err := crawlReportDir(crawlID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "sitemap.json"))
+	if err != nil {
+		return nil, fmt.Errorf("no sitemap for crawl %s: not in the results and not saved locally", crawlID)
+	}
+	var g CrawlGraph
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", filepath.Join(dir, "sitemap.json"), err)
+	}
+	return &g, nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCrawlURLTemplate(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com", "example.com/"},
+		{"https://example.com/orders/123?tab=items", "example.com/orders/:id"},
+		{"https://example.com/users/3f2b1c9a-8d7e-4f60-a1b2-c3d4e5f60718/edit", "example.com/users/:uuid/edit"},
+		{"https://example.com/blob/0123456789abcdef0123", "example.com/blob/:hash"},
+		{"https://example.com/invite/aZ3kQ9xLmP2vR7tY8wB4", "example.com/invite/:token"},
+		{"https://example.com/docs/getting-started-with-the-cli", "example.com/docs/getting-started-with-the-cli"},
+		{"http://localhost:3000/#/projects/42?x=1", "localhost:3000/#/projects/:id"},
+		{"https://example.com/page#section-2", "example.com/page"},
+		{"not a url", "not a url"},
+	}
+	for _, tt := range tests {
+		if got := crawlURLTemplate(tt.url); got != tt.want {
+			t.Errorf("crawlURLTemplate(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func testCrawlGraph() *CrawlGraph {
+	g := newCrawlGraph("sess-1", "https://example.com")
+	g.observe(&CrawlSnapshot{StepNum: 1, URL: "https://example.com/", Title: "Home"})
+	g.observe(&CrawlSnapshot{
+		StepNum: 2, URL: "https://example.com/orders/1", Title: "Order 1",
+		LastAction: &CrawlActionResult{Action: "click", Selector: "a.order", Status: "ok"},
+		Forms: []map[string]any{{
+			"id": "refund", "action": "/refund", "method": "post",
+			"fields": []any{map[string]any{"name": "reason"}, map[string]any{"label": "Amount"}, "notes"},
+		}},
+	})
+	g.observe(&CrawlSnapshot{
+		StepNum: 3, URL: "https://example.com/",
+		LastAction: &CrawlActionResult{Action: "click", Selector: "a.home", Status: "ok"},
+	})
+	g.observe(&CrawlSnapshot{
+		StepNum: 4, URL: "https://example.com/orders/2", Title: "Order 2",
+		LastAction: &CrawlActionResult{Action: "click", Selector: "a.order", Status: "ok"},
+		Forms:      []map[string]any{{"id": "refund", "action": "/refund", "method": "post"}},
+	})
+	// A blocked action never left the page
+	g.observe(&CrawlSnapshot{
+		StepNum: 5, URL: "https://example.com/orders/2",
+		LastAction: &CrawlActionResult{Action: "click", Selector: "#delete", Status: "blocked"},
+	})
+	return g
+}
+
+func TestCrawlGraph_Observe(t *testing.T) {
+	g := testCrawlGraph()
+
+	if len(g.Pages) != 2 {
+		t.Fatalf("expected 2 page templates, got %+v", g.Pages)
+	}
+	orders := g.Pages[1]
+	if orders.Template != "example.com/orders/:id" || orders.Title != "Order 1" || orders.Visits != 3 || orders.FirstStep != 2 {
+		t.Errorf("unexpected page: %+v", orders)
+	}
+	if len(orders.URLs) != 2 {
+		t.Errorf("expected both order URLs, got %v", orders.URLs)
+	}
+
+	if len(g.Transitions) != 2 {
+		t.Fatalf("expected 2 transitions, got %+v", g.Transitions)
+	}
+	if tr := g.Transitions[0]; tr.From != "p1" || tr.To != "p2" || tr.Selector != "a.order" || tr.Count != 2 || tr.Step != 1 {
+		t.Errorf("unexpected transition: %+v", tr)
+	}
+	if out := g.Outgoing("p2"); len(out) != 1 || out[0].To != "p1" {
+		t.Errorf("unexpected outgoing transitions: %+v", out)
+	}
+
+	if len(g.Forms) != 1 {
+		t.Fatalf("the same form on the same page should be recorded once: %+v", g.Forms)
+	}
+	f := g.Forms[0]
+	if f.Page != "p2" || f.Name != "refund" || f.Method != "POST" || strings.Join(f.Fields, ",") != "reason,Amount,notes" {
+		t.Errorf("unexpected form: %+v", f)
+	}
+}
+
+func TestCrawlThumbnail(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, solidImage(960, 540, black)); err != nil {
+		t.Fatal(err)
+	}
+	thumb, err := crawlThumbnail(base64.StdEncoding.EncodeToString(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(thumb, "data:image/jpeg;base64,") {
+		t.Fatalf("unexpected thumbnail %.40s", thumb)
+	}
+	if _, err := crawlThumbnail(base64.StdEncoding.EncodeToString([]byte("RIFF....WEBP"))); err == nil {
+		t.Error("undecodable screenshots should not get a thumbnail")
+	}
+
+	img := scaleToWidth(solidImage(960, 540, white), crawlThumbnailWidth)
+	if b := img.Bounds(); b.Dx() != 240 || b.Dy() != 135 {
+		t.Errorf("unexpected thumbnail size %v", b)
+	}
+	if r, _, _, _ := img.At(100, 100).RGBA(); r != 0xffff {
+		t.Errorf("scaled pixel should stay white, got %x", r)
+	}
+}
+
+func TestCrawlGraphFormat(t *testing.T) {
+	for path, want := range map[string]string{"map.json": "json", "map.DOT": "dot", "map.gv": "dot", "map.html": "html", "map": "json"} {
+		if got, err := crawlGraphFormat(path, ""); err != nil || got != want {
+			t.Errorf("crawlGraphFormat(%q) = %q, %v; want %q", path, got, err, want)
+		}
+	}
+	if got, _ := crawlGraphFormat("map.json", "html"); got != "html" {
+		t.Errorf("explicit format should win, got %q", got)
+	}
+	if _, err := crawlGraphFormat("map.svg", "svg"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestCrawlGraph_Write(t *testing.T) {
+	g := testCrawlGraph()
+	g.Pages[0].Title = `Home "quoted" <b>`
+	g.Pages[0].Thumbnail = "data:image/jpeg;base64,AAAA"
+
+	var dot bytes.Buffer
+	if err := g.Write(&dot, "dot"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"digraph crawl {", `p1 [label="Home \"quoted\" <b>\nexample.com/"];`, `p2 -> p1 [label="click a.home"];`, `(x2)`, `1 form(s)`} {
+		if !strings.Contains(dot.String(), want) {
+			t.Errorf("DOT output missing %q:\n%s", want, dot.String())
+		}
+	}
+
+	var html bytes.Buffer
+	if err := g.Write(&html, "html"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`<img src="data:image/jpeg;base64,AAAA"`, "Home &#34;quoted&#34; &lt;b&gt;", `<a href="#p2">p2 Order 1</a>`, "<code>refund</code> POST → /refund: reason, Amount, notes"} {
+		if !strings.Contains(html.String(), want) {
+			t.Errorf("HTML output missing %q", want)
+		}
+	}
+
+	var raw bytes.Buffer
+	if err := g.Write(&raw, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var decoded CrawlGraph
+	if err := json.Unmarshal(raw.Bytes(), &decoded); err != nil || len(decoded.Pages) != 2 || len(decoded.Transitions) != 2 {
+		t.Errorf("JSON round trip: %v %+v", err, decoded)
+	}
+}
+
+func TestSaveCrawlGraph(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	out := filepath.Join(t.TempDir(), "out", "map.html")
+
+	saveCrawlGraph(CrawlSession{SessionID: "sess-1", CrawlID: "crawl-1", SitemapPath: out}, testCrawlGraph())
+
+	for _, name := range []string{"sitemap.json", "sitemap.dot", "sitemap.html"} {
+		if _, err := os.Stat(filepath.Join(home, configDirName, crawlReportsDirName, "crawl-1", name)); err != nil {
+			t.Errorf("%s not saved: %v", name, err)
+		}
+	}
+	if data, err := os.ReadFile(out); err != nil || !strings.HasPrefix(string(data), "<!DOCTYPE html>") {
+		t.Errorf("sitemap path not written as HTML: %v", err)
+	}
+
+	// crawl results falls back to the local copy, saved under the crawl ID
+	g, err := crawlGraphFromResults([]byte(`{"success": true, "results": {}}`), "crawl-1")
+	if err != nil || len(g.Pages) != 2 {
+		t.Errorf("local fallback: %v %+v", err, g)
+	}
+	g, err = crawlGraphFromResults([]byte(`{"results": {"sitemap": {"session_id": "remote", "pages": [{"id": "p1"}]}}}`), "crawl-1")
+	if err != nil || g.SessionID != "remote" {
+		t.Errorf("sitemap from results: %v %+v", err, g)
+	}
+	if _, err := crawlGraphFromResults([]byte(`{}`), "sess-1"); err == nil {
+		t.Error("expected error when no sitemap exists")
+	}
+
+	// without a crawl ID the session ID names the directory
+	saveCrawlGraph(CrawlSession{SessionID: "sess-2"}, testCrawlGraph())
+	if _, err := crawlGraphFromResults([]byte(`{}`), "sess-2"); err != nil {
+		t.Errorf("session ID fallback: %v", err)
+	}
+}
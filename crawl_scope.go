@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+)
+
+// defaultDestructiveActionPattern matches the text of buttons and links a
+// discovery crawl must never click on its own.
+const defaultDestructiveActionPattern = `(?i)\b(delete|remove|destroy|deactivate|terminate|close\s+(my\s+)?account|cancel\s+(my\s+)?(subscription|plan|order)|unsubscribe|log\s*-?\s*out|sign\s*-?\s*out|pay(\s+now)?|purchase|buy(\s+now)?|place\s+order|checkout|submit\s+payment|transfer\s+funds|revoke|wipe|erase)\b`
+
+// CrawlScope restricts where a crawl may navigate and what it may click.
+// AllowedHosts defaults to the session URL's host and its subdomains, so
+// example.com also allows www.example.com and login.example.com;
+// "*.example.com" matches example.com and any subdomain. AllowDestructive is
+// a pointer so that a local scope only overrides the server's when it is set.
+type CrawlScope struct {
+	AllowedHosts       []string `json:"allowed_hosts,omitempty"`
+	IncludeURLPatterns []string `json:"include_url_patterns,omitempty"`
+	ExcludeURLPatterns []string `json:"exclude_url_patterns,omitempty"`
+	DenyActionPatterns []string `json:"deny_action_patterns,omitempty"`
+	AllowDestructive   *bool    `json:"allow_destructive,omitempty"`
+}
+
+// crawlActionBlockedError is returned when a scope rule refuses an action.
+type crawlActionBlockedError struct {
+	Reason string
+}
+
+func (e *crawlActionBlockedError) Error() string {
+	return "action blocked: " + e.Reason
+}
+
+// crawlScopeGuard is the compiled form of a CrawlScope.
+type crawlScopeGuard struct {
+	hosts       []string
+	include     []*regexp.Regexp
+	exclude     []*regexp.Regexp
+	deny        []*regexp.Regexp
+	destructive *regexp.Regexp // nil when destructive actions are allowed
+}
+
+// newCrawlScopeGuard compiles a session's scope rules.
+func newCrawlScopeGuard(startURL string, scope *CrawlScope) (*crawlScopeGuard, error) {
+	if scope == nil {
+		scope = &CrawlScope{}
+	}
+	g := &crawlScopeGuard{}
+
+	for _, h := range scope.AllowedHosts {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			g.hosts = append(g.hosts, h)
+		}
+	}
+	if len(g.hosts) == 0 {
+		u, err := url.Parse(startURL)
+		if err != nil || u.Hostname() == "" {
+			return nil, fmt.Errorf("cannot derive allowed host from %q", startURL)
+		}
+		g.hosts = []string{defaultCrawlHost(u.Hostname())}
+	}
+
+	var err error
+	if g.include, err = compileScopePatterns("include_url_patterns", scope.IncludeURLPatterns); err != nil {
+		return nil, err
+	}
+	if g.exclude, err = compileScopePatterns("exclude_url_patterns", scope.ExcludeURLPatterns); err != nil {
+		return nil, err
+	}
+	if g.deny, err = compileScopePatterns("deny_action_patterns", scope.DenyActionPatterns); err != nil {
+		return nil, err
+	}
+	if scope.AllowDestructive == nil || !*scope.AllowDestructive {
+		g.destructive = regexp.MustCompile(defaultDestructiveActionPattern)
+	}
+	return g, nil
+}
+
+// defaultCrawlHost is the host rule for a crawl started on host: the host
+// and its subdomains, with a leading "www." dropped so that redirects
+// between the apex and www, and to sign-in subdomains, stay in scope.
+// IP addresses only match themselves.
+func defaultCrawlHost(host string) string {
+	host = strings.ToLower(host)
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	if base := strings.TrimPrefix(host, "www."); strings.Contains(base, ".") {
+		host = base
+	}
+	return "*." + host
+}
+
+func compileScopePatterns(field string, patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, p := range patterns {
+		if p == "" {
+			continue
+		}
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s pattern %q: %w", field, p, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// hostAllowed reports whether host matches one of the allowed host rules.
+func (g *crawlScopeGuard) hostAllowed(host string) bool {
+	host = strings.ToLower(host)
+	for _, h := range g.hosts {
+		if base, ok := strings.CutPrefix(h, "*."); ok {
+			if host == base || strings.HasSuffix(host, "."+base) {
+				return true
+			}
+		} else if host == h {
+			return true
+		}
+	}
+	return false
+}
+
+// checkURL returns an error if the crawl may not visit rawURL.
+// Non-navigating schemes (javascript:, mailto:, about:) are left alone.
+func (g *crawlScopeGuard) checkURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return &crawlActionBlockedError{Reason: fmt.Sprintf("unparseable URL %q", rawURL)}
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil
+	}
+	if !g.hostAllowed(u.Hostname()) {
+		return &crawlActionBlockedError{Reason: fmt.Sprintf("host %s is outside the crawl scope", u.Hostname())}
+	}
+	for _, re := range g.exclude {
+		if re.MatchString(rawURL) {
+			return &crawlActionBlockedError{Reason: fmt.Sprintf("URL %s matches exclude rule %q", rawURL, re.String())}
+		}
+	}
+	if len(g.include) > 0 {
+		for _, re := range g.include {
+			if re.MatchString(rawURL) {
+				return nil
+			}
+		}
+		return &crawlActionBlockedError{Reason: fmt.Sprintf("URL %s matches no include rule", rawURL)}
+	}
+	return nil
+}
+
+// crawlElementInfo describes the element an action targets, as far as the
+// scope rules care.
+type crawlElementInfo struct {
+	Text         string `json:"text"`
+	AriaLabel    string `json:"aria_label"`
+	Title        string `json:"title"`
+	Name         string `json:"name"`
+	ID           string `json:"id"`
+	Href         string `json:"href"`
+	FormAction   string `json:"form_action"`
+	Submits      bool   `json:"submits"`
+	PaymentForm  bool   `json:"payment_form"`
+	PaymentInput bool   `json:"payment_input"`
+}
+
+// crawlElementInfoFn describes the element it is called on (this).
+const crawlElementInfoFn = `function() {
+	const el = this;
+	const attr = (n) => el.getAttribute(n) || '';
+	const link = el.closest('a[href]');
+	const form = el.form || el.closest('form');
+	const paymentRe = /(^cc-|card|cvv|cvc|iban|expir)/i;
+	const isPayment = (i) => paymentRe.test(i.getAttribute('autocomplete') || '') || paymentRe.test(i.getAttribute('name') || '') || paymentRe.test(i.id || '');
+	return {
+		text: ((el.innerText || el.value || '') + '').trim().slice(0, 200),
+		aria_label: attr('aria-label'),
+		title: attr('title'),
+		name: attr('name'),
+		id: el.id || '',
+		href: link ? link.href : '',
+		form_action: form ? (form.action || '') : '',
+		submits: el.type === 'submit' || (el.tagName === 'BUTTON' && !attr('type') && !!form),
+		payment_form: form ? Array.from(form.querySelectorAll('input,select')).some(isPayment) : false,
+		payment_input: (el.tagName === 'INPUT' || el.tagName === 'SELECT') && isPayment(el),
+	};
+}`
+
+// crawlElementInfoJS returns a script that describes the element matched by selector.
+func crawlElementInfoJS(selector string) string {
+	return fmt.Sprintf(`(() => {
+	const el = document.querySelector(%q);
+	if (!el) return null;
+	return (%s).call(el);
+})()`, selector, crawlElementInfoFn)
+}
+
+// checkAction returns an error if the action violates the scope rules.
+// el may be nil when the element could not be inspected.
+// Configured deny rules apply to the selector and every element attribute;
+// the built-in destructive rule only to what a click target says, its text,
+// aria-label and title, since ids and selectors of harmless form fields
+// often contain words like "remove" or "checkout". A click whose target
+// could not be inspected is refused unless destructive actions are allowed,
+// as the element may still render as a "Delete account" button.
+func (g *crawlScopeGuard) checkAction(action *CrawlAction, el *crawlElementInfo) error {
+	candidates := []string{action.Selector}
+	if el != nil {
+		candidates = append(candidates, el.Text, el.AriaLabel, el.Title, el.Name, el.ID)
+	}
+	for _, re := range g.deny {
+		if err := denyMatch(re, candidates); err != nil {
+			return err
+		}
+	}
+
+	if el == nil {
+		if g.destructive != nil && action.Action == "click" {
+			if err := denyMatch(g.destructive, []string{action.Selector}); err != nil {
+				return err
+			}
+			return &crawlActionBlockedError{Reason: "could not inspect the click target to check it against the deny rules"}
+		}
+		return nil
+	}
+
+	if g.destructive != nil && action.Action == "click" {
+		if err := denyMatch(g.destructive, []string{el.Text, el.AriaLabel, el.Title}); err != nil {
+			return err
+		}
+	}
+
+	if el.PaymentInput && (action.Action == "fill" || action.Action == "select" || action.Action == "combobox_select") {
+		return &crawlActionBlockedError{Reason: "refusing to fill payment details"}
+	}
+
+	if action.Action == "click" {
+		if el.Href != "" {
+			if err := g.checkURL(el.Href); err != nil {
+				return err
+			}
+		}
+		if el.Submits {
+			if el.PaymentForm {
+				return &crawlActionBlockedError{Reason: "refusing to submit a payment form"}
+			}
+			if el.FormAction != "" {
+				if err := g.checkURL(el.FormAction); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func denyMatch(re *regexp.Regexp, candidates []string) error {
+	for _, c := range candidates {
+		if c != "" && re.MatchString(c) {
+			return &crawlActionBlockedError{Reason: fmt.Sprintf("%q matches deny rule %q", truncate(c, 80), truncate(re.String(), 80))}
+		}
+	}
+	return nil
+}
+
+// enforceCrawlScope checks where the page ended up after an action and
+// navigates back if a redirect or script took it out of scope.
+func (a *Agent) enforceCrawlScope(ctx context.Context, sessionID string, scope *crawlScopeGuard) error {
+	var currentURL string
+	if err := chromedp.Run(ctx, chromedp.Location(&currentURL)); err != nil {
+		return nil
+	}
+	err := scope.checkURL(currentURL)
+	if err == nil {
+		return nil
+	}
+	log.Printf("CRAWL [%s] WARN: left crawl scope (%v), navigating back", sessionID, err)
+	if backErr := chromedp.Run(ctx, chromedp.NavigateBack(), chromedp.WaitReady("body")); backErr != nil {
+		log.Printf("CRAWL [%s] WARN: could not navigate back: %v", sessionID, backErr)
+	}
+	return err
+}
+
+// inspectCrawlElement evaluates crawlElementInfoFn on the target element,
+// following frames and shadow roots for extended selectors. Returns nil if the
+// element is missing or the page could not be queried.
+func inspectCrawlElement(ctx context.Context, selector string) *crawlElementInfo {
+	var info *crawlElementInfo
+	if isExtendedCrawlSelector(selector) {
+		if err := callOnCrawlElement(ctx, selector, crawlElementInfoFn, &info); err != nil {
+			return nil
+		}
+		return info
+	}
+	if err := chromedp.Run(ctx, chromedp.Evaluate(crawlElementInfoJS(selector), &info)); err != nil {
+		return nil
+	}
+	return info
+}
+
+// mergeCrawlScope layers locally configured rules over the server's. Local
+// host and include lists replace the server's, exclude and deny rules add up,
+// and a local allow_destructive, when set, overrides the server's.
+func mergeCrawlScope(server, local *CrawlScope) *CrawlScope {
+	if local == nil {
+		return server
+	}
+	merged := CrawlScope{}
+	if server != nil {
+		merged = *server
+	}
+	if len(local.AllowedHosts) > 0 {
+		merged.AllowedHosts = local.AllowedHosts
+	}
+	if len(local.IncludeURLPatterns) > 0 {
+		merged.IncludeURLPatterns = local.IncludeURLPatterns
+	}
+	merged.ExcludeURLPatterns = append(append([]string{}, merged.ExcludeURLPatterns...), local.ExcludeURLPatterns...)
+	merged.DenyActionPatterns = append(append([]string{}, merged.DenyActionPatterns...), local.DenyActionPatterns...)
+	if local.AllowDestructive != nil {
+		merged.AllowDestructive = local.AllowDestructive
+	}
+	return &merged
+}
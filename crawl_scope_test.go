@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewCrawlScopeGuard_DefaultHost(t *testing.T) {
+	g, err := newCrawlScopeGuard("https://App.Example.com/start", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(g.hosts) != 1 || g.hosts[0] != "*.app.example.com" {
+		t.Errorf("hosts: got %v", g.hosts)
+	}
+	if g.destructive == nil {
+		t.Error("expected the default destructive rule")
+	}
+
+	tests := map[string]string{
+		"https://www.example.com/": "*.example.com",
+		"https://example.com/":     "*.example.com",
+		"http://localhost:3000/":   "*.localhost",
+		"http://127.0.0.1:8080/":   "127.0.0.1",
+		"https://www.localhost/":   "*.www.localhost",
+	}
+	for u, want := range tests {
+		g, err := newCrawlScopeGuard(u, nil)
+		if err != nil || g.hosts[0] != want {
+			t.Errorf("newCrawlScopeGuard(%q): hosts %v, %v, want %s", u, g.hosts, err, want)
+		}
+	}
+
+	g, _ = newCrawlScopeGuard("https://example.com/", nil)
+	for _, u := range []string{"https://www.example.com/home", "https://login.example.com/sso"} {
+		if err := g.checkURL(u); err != nil {
+			t.Errorf("checkURL(%q): %v", u, err)
+		}
+	}
+}
+
+func TestNewCrawlScopeGuard_Errors(t *testing.T) {
+	if _, err := newCrawlScopeGuard("not a url", nil); err == nil {
+		t.Error("expected error when no host can be derived")
+	}
+	for _, scope := range []*CrawlScope{
+		{IncludeURLPatterns: []string{"("}},
+		{ExcludeURLPatterns: []string{"[a-"}},
+		{DenyActionPatterns: []string{"*"}},
+	} {
+		if _, err := newCrawlScopeGuard("https://example.com", scope); err == nil {
+			t.Errorf("expected error for %+v", scope)
+		}
+	}
+}
+
+func TestCrawlScopeGuard_CheckURL(t *testing.T) {
+	g, err := newCrawlScopeGuard("https://example.com", &CrawlScope{
+		AllowedHosts:       []string{"example.com", "*.example.org"},
+		IncludeURLPatterns: []string{`/app/`, `/docs/`},
+		ExcludeURLPatterns: []string{`/app/admin`},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://example.com/app/home", true},
+		{"https://EXAMPLE.com/docs/intro", true},
+		{"https://example.org/app/home", true},
+		{"https://shop.example.org/docs/", true},
+		{"https://evil.com/app/home", false},
+		{"https://notexample.org/app/", false},
+		{"https://example.com/blog/", false},
+		{"https://example.com/app/admin/users", false},
+		{"javascript:void(0)", true},
+		{"mailto:support@example.com", true},
+	}
+	for _, tt := range tests {
+		err := g.checkURL(tt.url)
+		if (err == nil) != tt.allowed {
+			t.Errorf("checkURL(%q) = %v, want allowed=%v", tt.url, err, tt.allowed)
+		}
+	}
+}
+
+func TestCrawlScopeGuard_CheckAction_DenyRules(t *testing.T) {
+	g, _ := newCrawlScopeGuard("https://example.com", &CrawlScope{
+		DenyActionPatterns: []string{`(?i)archive`},
+	})
+
+	tests := []struct {
+		name    string
+		action  CrawlAction
+		el      *crawlElementInfo
+		blocked bool
+	}{
+		{"plain link", CrawlAction{Action: "click", Selector: "#next"}, &crawlElementInfo{Text: "Next page"}, false},
+		{"delete button text", CrawlAction{Action: "click", Selector: "button.btn"}, &crawlElementInfo{Text: "Delete project"}, true},
+		{"logout title", CrawlAction{Action: "click", Selector: "a"}, &crawlElementInfo{Title: "Log out"}, true},
+		{"sign out aria label", CrawlAction{Action: "click", Selector: "button"}, &crawlElementInfo{AriaLabel: "Sign out"}, true},
+		{"checkout id", CrawlAction{Action: "click", Selector: "button"}, &crawlElementInfo{ID: "checkout"}, false},
+		{"remove field selector", CrawlAction{Action: "fill", Selector: "#remove-reason", Value: "x"}, &crawlElementInfo{ID: "remove-reason"}, false},
+		{"custom rule on selector", CrawlAction{Action: "click", Selector: "#archive"}, nil, true},
+		{"custom rule", CrawlAction{Action: "click", Selector: "button"}, &crawlElementInfo{Text: "Archive all"}, true},
+		{"word boundary", CrawlAction{Action: "click", Selector: "button"}, &crawlElementInfo{Text: "Payments overview"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := g.checkAction(&tt.action, tt.el)
+			if (err != nil) != tt.blocked {
+				t.Errorf("checkAction = %v, want blocked=%v", err, tt.blocked)
+			}
+		})
+	}
+}
+
+func TestCrawlScopeGuard_CheckAction_SelectorDenied(t *testing.T) {
+	g, _ := newCrawlScopeGuard("https://example.com", &CrawlScope{DenyActionPatterns: []string{`delete`}})
+	err := g.checkAction(&CrawlAction{Action: "click", Selector: "button.delete-account"}, nil)
+	if err == nil {
+		t.Fatal("expected selector to be denied")
+	}
+	var blocked *crawlActionBlockedError
+	if !errors.As(err, &blocked) {
+		t.Errorf("expected crawlActionBlockedError, got %T", err)
+	}
+	if !strings.HasPrefix(err.Error(), "action blocked: ") {
+		t.Errorf("unexpected message: %s", err)
+	}
+}
+
+func TestCrawlScopeGuard_CheckAction_NotInspected(t *testing.T) {
+	g, _ := newCrawlScopeGuard("https://example.com", nil)
+	if err := g.checkAction(&CrawlAction{Action: "click", Selector: "#late-button"}, nil); err == nil {
+		t.Error("expected a click on an uninspected element to be blocked")
+	}
+	if err := g.checkAction(&CrawlAction{Action: "click", Selector: "button.delete-account"}, nil); err == nil || !strings.Contains(err.Error(), "delete") {
+		t.Errorf("expected the selector to match the destructive rule: %v", err)
+	}
+	if err := g.checkAction(&CrawlAction{Action: "fill", Selector: "#name", Value: "x"}, nil); err != nil {
+		t.Errorf("fill on an uninspected element should be allowed: %v", err)
+	}
+
+	allow := true
+	g, _ = newCrawlScopeGuard("https://example.com", &CrawlScope{AllowDestructive: &allow})
+	if err := g.checkAction(&CrawlAction{Action: "click", Selector: "#late-button"}, nil); err != nil {
+		t.Errorf("with destructive actions allowed the click should go ahead: %v", err)
+	}
+}
+
+func TestCrawlScopeGuard_CheckAction_Payment(t *testing.T) {
+	g, _ := newCrawlScopeGuard("https://example.com", nil)
+
+	if err := g.checkAction(&CrawlAction{Action: "fill", Selector: "#cc", Value: "4242"}, &crawlElementInfo{PaymentInput: true}); err == nil {
+		t.Error("expected payment fill to be blocked")
+	}
+	if err := g.checkAction(&CrawlAction{Action: "click", Selector: "#cc"}, &crawlElementInfo{PaymentInput: true}); err != nil {
+		t.Errorf("clicking a payment field should be allowed: %v", err)
+	}
+	if err := g.checkAction(&CrawlAction{Action: "click", Selector: "#go"}, &crawlElementInfo{Text: "Continue", Submits: true, PaymentForm: true}); err == nil {
+		t.Error("expected payment form submit to be blocked")
+	}
+}
+
+func TestCrawlScopeGuard_CheckAction_Navigation(t *testing.T) {
+	g, _ := newCrawlScopeGuard("https://example.com", &CrawlScope{ExcludeURLPatterns: []string{`/admin`}})
+
+	if err := g.checkAction(&CrawlAction{Action: "click", Selector: "a"}, &crawlElementInfo{Href: "https://other.com/"}); err == nil {
+		t.Error("expected off-host link to be blocked")
+	}
+	if err := g.checkAction(&CrawlAction{Action: "click", Selector: "a"}, &crawlElementInfo{Href: "https://example.com/admin"}); err == nil {
+		t.Error("expected excluded link to be blocked")
+	}
+	if err := g.checkAction(&CrawlAction{Action: "hover", Selector: "a"}, &crawlElementInfo{Href: "https://other.com/"}); err != nil {
+		t.Errorf("hover does not navigate: %v", err)
+	}
+	if err := g.checkAction(&CrawlAction{Action: "click", Selector: "#save"}, &crawlElementInfo{Text: "Save", Submits: true, FormAction: "https://other.com/save"}); err == nil {
+		t.Error("expected off-host form submit to be blocked")
+	}
+	if err := g.checkAction(&CrawlAction{Action: "click", Selector: "#save"}, &crawlElementInfo{Text: "Save", Submits: true, FormAction: "https://example.com/save"}); err != nil {
+		t.Errorf("in-scope form submit should be allowed: %v", err)
+	}
+}
+
+func TestCrawlScopeGuard_AllowDestructive(t *testing.T) {
+	allow := true
+	g, _ := newCrawlScopeGuard("https://example.com", &CrawlScope{AllowDestructive: &allow})
+	if err := g.checkAction(&CrawlAction{Action: "click", Selector: "#delete"}, &crawlElementInfo{Text: "Delete"}); err != nil {
+		t.Errorf("destructive action should be allowed: %v", err)
+	}
+}
+
+func TestMergeCrawlScope(t *testing.T) {
+	allow, deny := true, false
+	server := &CrawlScope{
+		AllowedHosts:       []string{"example.com"},
+		ExcludeURLPatterns: []string{"/billing"},
+		DenyActionPatterns: []string{"archive"},
+		AllowDestructive:   &allow,
+	}
+
+	if got := mergeCrawlScope(server, nil); got != server {
+		t.Error("nil local scope should return the server scope")
+	}
+
+	merged := mergeCrawlScope(server, &CrawlScope{
+		AllowedHosts:       []string{"localhost"},
+		ExcludeURLPatterns: []string{"/admin"},
+	})
+	if len(merged.AllowedHosts) != 1 || merged.AllowedHosts[0] != "localhost" {
+		t.Errorf("AllowedHosts: got %v", merged.AllowedHosts)
+	}
+	if len(merged.ExcludeURLPatterns) != 2 {
+		t.Errorf("ExcludeURLPatterns: got %v", merged.ExcludeURLPatterns)
+	}
+	if len(merged.DenyActionPatterns) != 1 {
+		t.Errorf("DenyActionPatterns: got %v", merged.DenyActionPatterns)
+	}
+	if merged.AllowDestructive == nil || !*merged.AllowDestructive {
+		t.Error("an unset local allow_destructive should keep the server's")
+	}
+	if m := mergeCrawlScope(server, &CrawlScope{AllowDestructive: &deny}); *m.AllowDestructive {
+		t.Error("an explicit local allow_destructive should override the server's")
+	}
+	if len(server.ExcludeURLPatterns) != 1 {
+		t.Error("merge should not modify the server scope")
+	}
+
+	if got := mergeCrawlScope(nil, &CrawlScope{AllowedHosts: []string{"a.com"}}); got.AllowedHosts[0] != "a.com" {
+		t.Errorf("nil server scope: got %+v", got)
+	}
+}
+
+func TestCrawlSessionParsing_Scope(t *testing.T) {
+	raw := `{
+		"session_id": "scoped",
+		"url": "https://example.com",
+		"scope": {
+			"allowed_hosts": ["example.com", "*.example.com"],
+			"exclude_url_patterns": ["/logout"],
+			"allow_destructive": true
+		}
+	}`
+	var session CrawlSession
+	if err := json.Unmarshal([]byte(raw), &session); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if session.Scope == nil || len(session.Scope.AllowedHosts) != 2 || session.Scope.AllowDestructive == nil || !*session.Scope.AllowDestructive {
+		t.Errorf("Scope: got %+v", session.Scope)
+	}
+}
+
+func TestCrawlSnapshot_LastActionSerialization(t *testing.T) {
+	snap := CrawlSnapshot{StepNum: 2, LastAction: &CrawlActionResult{
+		Action: "click", Selector: "#delete", Status: "blocked", Error: "action blocked: nope",
+	}}
+	data, _ := json.Marshal(snap)
+	if !strings.Contains(string(data), `"last_action":{"action":"click","selector":"#delete","status":"blocked","error":"action blocked: nope"}`) {
+		t.Errorf("unexpected JSON: %s", data)
+	}
+
+	data, _ = json.Marshal(CrawlSnapshot{StepNum: 1})
+	if strings.Contains(string(data), "last_action") {
+		t.Errorf("last_action should be omitted on the first step: %s", data)
+	}
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/dom"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// crawlSelectorSeparator joins the segments of an extended crawl selector.
+// Each segment but the last must match an iframe, whose document the next
+// segment is queried in, or a shadow host, whose open or closed shadow root
+// it is queried in. For example, an iframe, then a shadow host inside it:
+//
+//	iframe#checkout >>> payment-form >>> input[name="cardnumber"]
+//
+// Name the iframe element (iframe#checkout rather than #checkout) so that
+// exported Playwright specs can use frameLocator for it.
+const crawlSelectorSeparator = ">>>"
+
+// crawlSelectorObjectGroup groups the remote objects created while resolving
+// an extended selector so they can be released together.
+const crawlSelectorObjectGroup = "qmax_selector"
+
+// maxNestedCrawlElements caps the iframe and shadow DOM elements added to a
+// snapshot.
+const maxNestedCrawlElements = 200
+
+var errCrawlElementNotFound = errors.New("element not found")
+
+// splitCrawlSelector splits an extended selector into its segments, ignoring
+// separators inside quoted attribute values.
+func splitCrawlSelector(selector string) ([]string, error) {
+	var segments []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(selector); i++ {
+		c := selector[i]
+		switch {
+		case c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case strings.HasPrefix(selector[i:], crawlSelectorSeparator):
+			segments = append(segments, strings.TrimSpace(selector[start:i]))
+			i += len(crawlSelectorSeparator) - 1
+			start = i + 1
+		}
+	}
+	segments = append(segments, strings.TrimSpace(selector[start:]))
+	for _, s := range segments {
+		if s == "" {
+			return nil, fmt.Errorf("invalid selector %q: empty segment", selector)
+		}
+	}
+	return segments, nil
+}
+
+// isExtendedCrawlSelector reports whether selector needs frame and shadow
+// root resolution rather than a plain document query.
+func isExtendedCrawlSelector(selector string) bool {
+	if !strings.Contains(selector, crawlSelectorSeparator) {
+		return false
+	}
+	segments, err := splitCrawlSelector(selector)
+	return err != nil || len(segments) > 1
+}
+
+// --- Resolution ---
+
+// withCrawlElement resolves an extended selector, retrying until the element
+// exists or ctx expires, and calls fn with it.
+func withCrawlElement(ctx context.Context, selector string, fn func(ctx context.Context, el runtime.RemoteObjectID) error) error {
+	segments, err := splitCrawlSelector(selector)
+	if err != nil {
+		return err
+	}
+	return chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		defer func() { _ = runtime.ReleaseObjectGroup(crawlSelectorObjectGroup).Do(ctx) }()
+		for {
+			el, err := resolveCrawlElement(ctx, segments)
+			if err == nil {
+				return fn(ctx, el)
+			}
+			if !errors.Is(err, errCrawlElementNotFound) {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}))
+}
+
+// resolveCrawlElement walks the segments from the top document, entering
+// iframe documents and shadow roots, and returns the final element.
+func resolveCrawlElement(ctx context.Context, segments []string) (runtime.RemoteObjectID, error) {
+	doc, exc, err := runtime.Evaluate("document").WithObjectGroup(crawlSelectorObjectGroup).Do(ctx)
+	if err != nil {
+		return "", err
+	}
+	if exc != nil {
+		return "", fmt.Errorf("get document: %s", exc.Text)
+	}
+	root := doc.ObjectID
+	for i, segment := range segments {
+		el, err := queryCrawlRoot(ctx, root, segment)
+		if err != nil {
+			return "", err
+		}
+		if i == len(segments)-1 {
+			return el, nil
+		}
+		if root, err = enterCrawlElement(ctx, el, segment); err != nil {
+			return "", err
+		}
+	}
+	return root, nil
+}
+
+// queryCrawlRoot runs querySelector on a document or shadow root.
+func queryCrawlRoot(ctx context.Context, root runtime.RemoteObjectID, segment string) (runtime.RemoteObjectID, error) {
+	res, exc, err := runtime.CallFunctionOn("function() { return this.querySelector(" + jsString(segment) + "); }").
+		WithObjectID(root).
+		WithObjectGroup(crawlSelectorObjectGroup).
+		Do(ctx)
+	if err != nil {
+		return "", err
+	}
+	if exc != nil {
+		return "", fmt.Errorf("invalid selector %q: %s", segment, exceptionText(exc))
+	}
+	if res.ObjectID == "" {
+		return "", fmt.Errorf("%w: %q", errCrawlElementNotFound, segment)
+	}
+	return res.ObjectID, nil
+}
+
+// enterCrawlElement returns the content document of an iframe or the shadow
+// root of a host element.
+func enterCrawlElement(ctx context.Context, el runtime.RemoteObjectID, segment string) (runtime.RemoteObjectID, error) {
+	node, err := dom.DescribeNode().WithObjectID(el).WithPierce(true).Do(ctx)
+	if err != nil {
+		return "", fmt.Errorf("describe %q: %w", segment, err)
+	}
+	var inner cdp.BackendNodeID
+	if node.ContentDocument != nil {
+		inner = node.ContentDocument.BackendNodeID
+	} else {
+		for _, sr := range node.ShadowRoots {
+			if sr.ShadowRootType != cdp.ShadowRootTypeUserAgent {
+				inner = sr.BackendNodeID
+				break
+			}
+		}
+	}
+	if inner == 0 {
+		return "", fmt.Errorf("%q is neither an iframe nor a shadow host", segment)
+	}
+	obj, err := dom.ResolveNode().WithBackendNodeID(inner).WithObjectGroup(crawlSelectorObjectGroup).Do(ctx)
+	if err != nil {
+		return "", fmt.Errorf("enter %q: %w", segment, err)
+	}
+	return obj.ObjectID, nil
+}
+
+// callCrawlFunction calls a JS function with el as this and decodes the
+// returned value into out when out is not nil.
+func callCrawlFunction(ctx context.Context, el runtime.RemoteObjectID, fn string, out interface{}) error {
+	res, exc, err := runtime.CallFunctionOn(fn).WithObjectID(el).WithReturnByValue(true).Do(ctx)
+	if err != nil {
+		return err
+	}
+	if exc != nil {
+		return errors.New(exceptionText(exc))
+	}
+	if out == nil || len(res.Value) == 0 {
+		return nil
+	}
+	return json.Unmarshal(res.Value, out)
+}
+
+// callOnCrawlElement resolves selector and calls fn on the element.
+func callOnCrawlElement(ctx context.Context, selector, fn string, out interface{}) error {
+	return withCrawlElement(ctx, selector, func(ctx context.Context, el runtime.RemoteObjectID) error {
+		return callCrawlFunction(ctx, el, fn, out)
+	})
+}
+
+func exceptionText(exc *runtime.ExceptionDetails) string {
+	if exc.Exception != nil && exc.Exception.Description != "" {
+		return exc.Exception.Description
+	}
+	return exc.Text
+}
+
+// --- Actions ---
+
+// executeNestedCrawlAction is executeCrawlAction for extended selectors.
+func (a *Agent) executeNestedCrawlAction(ctx context.Context, sessionID string, action *CrawlAction) error {
+	switch action.Action {
+	case "click":
+		return a.crawlClickNested(ctx, sessionID, action.Selector)
+	case "fill":
+		return crawlTypeNested(ctx, action.Selector, action.Value, true)
+	case "select":
+		return callOnCrawlElement(ctx, action.Selector, fmt.Sprintf(`function() {
+	this.value = %s;
+	this.dispatchEvent(new Event('input', { bubbles: true }));
+	this.dispatchEvent(new Event('change', { bubbles: true }));
+}`, jsString(action.Value)), nil)
+	case "combobox_select":
+		return a.crawlComboboxSelectNested(ctx, sessionID, action.Selector, action.Value)
+	default:
+		return fmt.Errorf("unknown action: %s", action.Action)
+	}
+}
+
+// crawlClickNested clicks the center of the element, falling back to a JS
+// click when it has no box.
+func (a *Agent) crawlClickNested(ctx context.Context, sessionID, selector string) error {
+	return withCrawlElement(ctx, selector, func(ctx context.Context, el runtime.RemoteObjectID) error {
+		err := dom.ScrollIntoViewIfNeeded().WithObjectID(el).Do(ctx)
+		if err == nil {
+			var quads []dom.Quad
+			if quads, err = dom.GetContentQuads().WithObjectID(el).Do(ctx); err == nil {
+				if x, y, ok := quadCenter(quads); ok {
+					return chromedp.MouseClickXY(x, y).Do(ctx)
+				}
+				err = errors.New("element has no box")
+			}
+		}
+		log.Printf("CRAWL [%s] WARN: native click failed on %q, trying JS click: %v", sessionID, selector, err)
+		return callCrawlFunction(ctx, el, "function() { this.click(); }", nil)
+	})
+}
+
+// crawlTypeNested focuses the element, optionally clears it, and types value.
+func crawlTypeNested(ctx context.Context, selector, value string, clear bool) error {
+	return withCrawlElement(ctx, selector, func(ctx context.Context, el runtime.RemoteObjectID) error {
+		fn := "function() { this.focus(); }"
+		if clear {
+			fn = `function() {
+	this.focus();
+	if ('value' in this) {
+		this.value = '';
+		this.dispatchEvent(new Event('input', { bubbles: true }));
+	}
+}`
+		}
+		if err := callCrawlFunction(ctx, el, fn, nil); err != nil {
+			return err
+		}
+		return chromedp.KeyEvent(value).Do(ctx)
+	})
+}
+
+// crawlComboboxSelectNested mirrors crawlComboboxSelect. Options are looked
+// up next to the trigger first, then in the top document.
+func (a *Agent) crawlComboboxSelectNested(ctx context.Context, sessionID, selector, value string) error {
+	clickCtx, clickCancel := crawlStageContext(ctx, 3)
+	defer clickCancel()
+	if err := a.crawlClickNested(clickCtx, sessionID, selector); err != nil {
+		return fmt.Errorf("click combobox trigger: %w", err)
+	}
+	_ = chromedp.Run(ctx, chromedp.Sleep(300*time.Millisecond))
+
+	typeCtx, typeCancel := crawlStageContext(ctx, 2)
+	defer typeCancel()
+	if err := crawlTypeNested(typeCtx, selector, value, false); err != nil {
+		return fmt.Errorf("type combobox value: %w", err)
+	}
+	_ = chromedp.Run(ctx, chromedp.Sleep(300*time.Millisecond))
+
+	segments, _ := splitCrawlSelector(selector)
+	container := strings.Join(segments[:len(segments)-1], " "+crawlSelectorSeparator+" ")
+	optionSelectors := []string{
+		`[role="option"]:not([aria-hidden="true"])`,
+		`[role="listbox"] [role="option"]`,
+		`.option:not(.hidden)`,
+		`li[data-value]`,
+	}
+	for i, optSel := range optionSelectors {
+		optionCtx, optionCancel := crawlStageContext(ctx, len(optionSelectors)+1-i)
+		err := a.crawlClickNested(optionCtx, sessionID, container+" "+crawlSelectorSeparator+" "+optSel)
+		optionCancel()
+		if err == nil {
+			return nil
+		}
+	}
+	optionCtx, optionCancel := crawlStageContext(ctx, 1)
+	defer optionCancel()
+	for _, optSel := range optionSelectors {
+		if err := chromedp.Run(optionCtx, chromedp.Click(optSel, chromedp.ByQuery)); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("could not find a visible option to click after typing %q", value)
+}
+
+// quadCenter returns the center of the first non-empty content quad.
+func quadCenter(quads []dom.Quad) (float64, float64, bool) {
+	for _, q := range quads {
+		if len(q) != 8 {
+			continue
+		}
+		minX, maxX, minY, maxY := q[0], q[0], q[1], q[1]
+		for i := 2; i < 8; i += 2 {
+			minX, maxX = min(minX, q[i]), max(maxX, q[i])
+			minY, maxY = min(minY, q[i+1]), max(maxY, q[i+1])
+		}
+		if maxX-minX < 1 || maxY-minY < 1 {
+			continue
+		}
+		return (q[0] + q[2] + q[4] + q[6]) / 4, (q[1] + q[3] + q[5] + q[7]) / 4, true
+	}
+	return 0, 0, false
+}
+
+// --- Snapshot reporting ---
+
+// crawlElementPathJS defines qmaxPath(el), which builds an extended selector
+// for an element relative to its frame's document, piercing shadow roots.
+const crawlElementPathJS = `const qmaxPath = (target) => {
+	const esc = (s) => CSS.escape(s);
+	const unique = (root, sel) => { try { return root.querySelectorAll(sel).length === 1; } catch (e) { return false; } };
+	const local = (el, root) => {
+		const tag = el.localName;
+		if (el.id && unique(root, tag + '#' + esc(el.id))) return tag + '#' + esc(el.id);
+		for (const a of ['data-testid', 'data-test', 'name', 'aria-label']) {
+			const v = el.getAttribute(a);
+			if (!v) continue;
+			const sel = tag + '[' + a + '="' + v.replace(/["\\]/g, '\\$&') + '"]';
+			if (unique(root, sel)) return sel;
+		}
+		const parts = [];
+		for (let e = el; e && e.nodeType === 1; e = e.parentElement) {
+			if (e !== el && e.id && unique(root, '#' + esc(e.id))) { parts.unshift('#' + esc(e.id)); break; }
+			let n = 1;
+			for (let s = e.previousElementSibling; s; s = s.previousElementSibling) if (s.localName === e.localName) n++;
+			parts.unshift(e.localName + ':nth-of-type(' + n + ')');
+		}
+		return parts.join(' > ');
+	};
+	const segments = [];
+	for (let el = target; el; ) {
+		const root = el.getRootNode();
+		segments.unshift(local(el, root));
+		el = root.host || null;
+	}
+	return segments.join(' >>> ');
+};`
+
+// crawlElementPathFn returns the qmaxPath of the element it is called on.
+const crawlElementPathFn = "function() {\n" + crawlElementPathJS + "\nreturn qmaxPath(this);\n}"
+
+// nestedCrawlElementsJS lists visible interactive elements in the frame's
+// open shadow roots and, unless shadowOnly, its document.
+func nestedCrawlElementsJS(shadowOnly bool, limit int) string {
+	return fmt.Sprintf(`(() => {
+	%s
+	const interactive = 'a[href], button, input:not([type="hidden"]), select, textarea, [role="button"], [role="link"], [role="checkbox"], [role="radio"], [role="tab"], [role="menuitem"], [role="option"], [role="combobox"], [contenteditable="true"], [contenteditable=""]';
+	const roots = [];
+	const walk = (root) => {
+		roots.push(root);
+		for (const el of root.querySelectorAll('*')) if (el.shadowRoot) walk(el.shadowRoot);
+	};
+	walk(document);
+	const visible = (el) => el.getClientRects().length > 0 && getComputedStyle(el).visibility !== 'hidden';
+	const attr = (el, n) => el.getAttribute(n) || '';
+	const out = [];
+	for (const root of roots) {
+		if (%t && root === document) continue;
+		for (const el of root.querySelectorAll(interactive)) {
+			if (out.length >= %d) return out;
+			if (!visible(el)) continue;
+			out.push({
+				selector: qmaxPath(el),
+				tag: el.localName,
+				type: attr(el, 'type'),
+				role: attr(el, 'role'),
+				text: ((el.innerText || el.value || '') + '').trim().slice(0, 100),
+				name: attr(el, 'name'),
+				aria_label: attr(el, 'aria-label'),
+				placeholder: attr(el, 'placeholder'),
+				href: el.href || '',
+			});
+		}
+	}
+	return out;
+})()`, crawlElementPathJS, shadowOnly, limit)
+}
+
+// collectNestedCrawlElements lists the interactive elements inside iframes
+// and shadow roots with extended selectors that executeCrawlAction accepts.
+// Top-level document elements are left to the snapshot script.
+func collectNestedCrawlElements(ctx context.Context) ([]map[string]any, error) {
+	var out []map[string]any
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		defer func() { _ = runtime.ReleaseObjectGroup(crawlSelectorObjectGroup).Do(ctx) }()
+		tree, err := page.GetFrameTree().Do(ctx)
+		if err != nil {
+			return err
+		}
+		prefixes := map[cdp.FrameID]string{}
+		for _, f := range flattenFrameTree(tree) {
+			if len(out) >= maxNestedCrawlElements {
+				break
+			}
+			prefix := ""
+			if f.Frame.ParentID != "" {
+				parent, ok := prefixes[f.Frame.ParentID]
+				if !ok {
+					continue
+				}
+				owner, err := crawlFrameOwnerPath(ctx, f.Frame.ID)
+				if err != nil {
+					// Frames can detach while we walk the tree
+					continue
+				}
+				prefix = parent + owner + " " + crawlSelectorSeparator + " "
+			}
+			prefixes[f.Frame.ID] = prefix
+
+			world, err := page.CreateIsolatedWorld(f.Frame.ID).WithWorldName("qmax_selectors").Do(ctx)
+			if err != nil {
+				continue
+			}
+			obj, exc, err := runtime.Evaluate(nestedCrawlElementsJS(f.Frame.ParentID == "", maxNestedCrawlElements-len(out))).
+				WithContextID(world).
+				WithReturnByValue(true).
+				Do(ctx)
+			if err != nil || exc != nil || obj == nil || len(obj.Value) == 0 {
+				continue
+			}
+			var elements []map[string]any
+			if err := json.Unmarshal(obj.Value, &elements); err != nil {
+				continue
+			}
+			for _, el := range elements {
+				el["selector"] = prefix + fmt.Sprint(el["selector"])
+				if f.Frame.ParentID != "" {
+					el["context"] = "iframe"
+					el["frame_url"] = f.Frame.URL
+				} else {
+					el["context"] = "shadow"
+				}
+			}
+			out = append(out, elements...)
+		}
+		return nil
+	}))
+	return out, err
+}
+
+// crawlFrameOwnerPath returns the extended selector of a frame's iframe
+// element within its parent document.
+func crawlFrameOwnerPath(ctx context.Context, frameID cdp.FrameID) (string, error) {
+	backendID, _, err := dom.GetFrameOwner(frameID).Do(ctx)
+	if err != nil {
+		return "", err
+	}
+	obj, err := dom.ResolveNode().WithBackendNodeID(backendID).WithObjectGroup(crawlSelectorObjectGroup).Do(ctx)
+	if err != nil {
+		return "", err
+	}
+	var path string
+	if err := callCrawlFunction(ctx, obj.ObjectID, crawlElementPathFn, &path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// playwrightLocator converts a crawl selector to a Playwright locator chain.
+// Segments naming an iframe become frameLocator calls; Playwright's CSS
+// engine already pierces open shadow roots.
+func playwrightLocator(selector string) string {
+	segments, err := splitCrawlSelector(selector)
+	if err != nil || len(segments) == 1 {
+		return "page.locator(" + jsString(selector) + ")"
+	}
+	loc := "page"
+	for i, s := range segments {
+		if i < len(segments)-1 && isFrameSegment(s) {
+			loc += ".frameLocator(" + jsString(s) + ")"
+		} else {
+			loc += ".locator(" + jsString(s) + ")"
+		}
+	}
+	return loc
+}
+
+// isFrameSegment reports whether a selector segment targets an iframe or
+// frame element by tag.
+func isFrameSegment(segment string) bool {
+	tag := segment
+	if i := strings.IndexAny(segment, "#.[: >+~"); i >= 0 {
+		tag = segment[:i]
+	}
+	tag = strings.ToLower(tag)
+	return tag == "iframe" || tag == "frame"
+}
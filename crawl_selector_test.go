@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chromedp/cdproto/dom"
+	"github.com/chromedp/chromedp"
+)
+
+func TestSplitCrawlSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		want     []string
+		wantErr  bool
+	}{
+		{"#buy", []string{"#buy"}, false},
+		{"iframe#pay >>> input[name=card]", []string{"iframe#pay", "input[name=card]"}, false},
+		{"app-shell>>>nav-menu >>> a", []string{"app-shell", "nav-menu", "a"}, false},
+		{`a[title=">>>"] >>> b`, []string{`a[title=">>>"]`, "b"}, false},
+		{`a[title='x\'>>>'] >>> b`, []string{`a[title='x\'>>>']`, "b"}, false},
+		{"iframe >>> ", nil, true},
+		{">>> a", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := splitCrawlSelector(tt.selector)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: err = %v, wantErr %v", tt.selector, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.selector, got, tt.want)
+		}
+	}
+}
+
+func TestIsExtendedCrawlSelector(t *testing.T) {
+	for sel, want := range map[string]bool{
+		"#buy":                   false,
+		"a > b":                  false,
+		`a[title=">>>"]`:         false,
+		"my-widget >>> button":   true,
+		"iframe >>> ":            true, // malformed, reported by the resolver
+		"iframe#pay >>> #card":   true,
+		`[data-x="y"] >>> input`: true,
+	} {
+		if got := isExtendedCrawlSelector(sel); got != want {
+			t.Errorf("%q: got %v, want %v", sel, got, want)
+		}
+	}
+}
+
+func TestPlaywrightLocator(t *testing.T) {
+	tests := map[string]string{
+		"#buy":                               `page.locator("#buy")`,
+		"iframe#pay >>> input[name=card]":    `page.frameLocator("iframe#pay").locator("input[name=card]")`,
+		"my-app >>> iframe >>> button.go":    `page.locator("my-app").frameLocator("iframe").locator("button.go")`,
+		"IFRAME[name=x] >>> my-el >>> #deep": `page.frameLocator("IFRAME[name=x]").locator("my-el").locator("#deep")`,
+		"#frame >>> a":                       `page.locator("#frame").locator("a")`,
+	}
+	for sel, want := range tests {
+		if got := playwrightLocator(sel); got != want {
+			t.Errorf("%q: got %s, want %s", sel, got, want)
+		}
+	}
+}
+
+func TestPlaywrightSpecFromTrace_NestedSelector(t *testing.T) {
+	spec := playwrightSpecFromTrace(&CrawlTrace{
+		SessionID: "n",
+		StartURL:  "https://example.com",
+		Steps: []CrawlTraceStep{
+			{Step: 1, Action: "fill", Selector: "iframe#pay >>> #card", Value: "4242", Status: "ok"},
+		},
+	})
+	if !strings.Contains(spec, `await page.frameLocator("iframe#pay").locator("#card").fill("4242");`) {
+		t.Errorf("unexpected spec:\n%s", spec)
+	}
+}
+
+func TestQuadCenter(t *testing.T) {
+	if _, _, ok := quadCenter(nil); ok {
+		t.Error("no quads should have no center")
+	}
+	quads := []dom.Quad{
+		{5, 5, 5, 5, 5, 5, 5, 5}, // collapsed
+		{10, 20, 110, 20, 110, 60, 10, 60},
+	}
+	x, y, ok := quadCenter(quads)
+	if !ok || x != 60 || y != 40 {
+		t.Errorf("got %v, %v, %v", x, y, ok)
+	}
+}
+
+func TestNestedCrawlElementsJS(t *testing.T) {
+	js := nestedCrawlElementsJS(true, 50)
+	if !strings.Contains(js, "const qmaxPath") || !strings.Contains(js, "if (true && root === document) continue;") ||
+		!strings.Contains(js, "out.length >= 50") {
+		t.Errorf("unexpected script: %s", js)
+	}
+}
+
+// --- Browser tests ---
+
+func TestNestedCrawlSelectors_Browser(t *testing.T) {
+	if os.Getenv("QMAX_BROWSER_TESTS") == "" {
+		t.Skip("Skipping browser test (set QMAX_BROWSER_TESTS=1 to run)")
+	}
+	skipIfNoBrowser(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/frame" {
+			fmt.Fprint(w, `<!DOCTYPE html><html><body>
+				<input id="card" name="card" onkeyup="parent.document.title='card:' + this.value">
+			</body></html>`)
+			return
+		}
+		fmt.Fprint(w, `<!DOCTYPE html><html><body>
+			<my-widget></my-widget>
+			<iframe id="pay" src="/frame"></iframe>
+			<script>
+				const root = document.querySelector('my-widget').attachShadow({ mode: 'closed' });
+				root.innerHTML = '<button id="go" onclick="document.title=\'shadow-clicked\'">Go</button>';
+				const open = document.createElement('open-widget');
+				open.attachShadow({ mode: 'open' }).innerHTML = '<a href="#x" id="link">Link</a>';
+				document.body.appendChild(open);
+			</script>
+		</body></html>`)
+	}))
+	defer ts.Close()
+
+	ctx, cancel := newTimedBrowserContext(t, 20*time.Second)
+	defer cancel()
+	if err := chromedp.Run(ctx, chromedp.Navigate(ts.URL), chromedp.WaitReady("body")); err != nil {
+		t.Fatalf("navigation failed: %v", err)
+	}
+
+	a := &Agent{}
+	actionCtx, actionCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer actionCancel()
+
+	if err := a.executeCrawlAction(actionCtx, "nested", &CrawlAction{Action: "click", Selector: "my-widget >>> #go"}, nil, 0); err != nil {
+		t.Fatalf("shadow click failed: %v", err)
+	}
+	var title string
+	_ = chromedp.Run(ctx, chromedp.Title(&title))
+	if title != "shadow-clicked" {
+		t.Errorf("expected shadow button click, title is %q", title)
+	}
+
+	if err := a.executeCrawlAction(actionCtx, "nested", &CrawlAction{Action: "fill", Selector: "iframe#pay >>> #card", Value: "42"}, nil, 0); err != nil {
+		t.Fatalf("iframe fill failed: %v", err)
+	}
+	_ = chromedp.Run(ctx, chromedp.Title(&title))
+	if title != "card:42" {
+		t.Errorf("expected iframe fill, title is %q", title)
+	}
+
+	if err := a.executeCrawlAction(actionCtx, "nested", &CrawlAction{Action: "click", Selector: "iframe#pay >>> #missing"}, nil, 0); err == nil {
+		t.Error("expected error for missing element")
+	}
+
+	elements, err := collectNestedCrawlElements(actionCtx)
+	if err != nil {
+		t.Fatalf("collect failed: %v", err)
+	}
+	selectors := map[string]any{}
+	for _, el := range elements {
+		selectors[fmt.Sprint(el["selector"])] = el["context"]
+	}
+	if selectors["open-widget >>> a#link"] != "shadow" || selectors["iframe#pay >>> input#card"] != "iframe" {
+		t.Errorf("unexpected nested elements: %v", selectors)
+	}
+}
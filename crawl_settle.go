@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// crawlSettleOptions controls how long the crawler waits for a page to
+// settle after a navigation or action.
+type crawlSettleOptions struct {
+	MinWait     time.Duration // always wait this long so the action can start work
+	NetworkIdle time.Duration // no requests in flight for this long
+	DOMQuiet    time.Duration // no DOM mutations for this long
+	Max         time.Duration // give up and snapshot anyway
+	Poll        time.Duration
+}
+
+// defaultCrawlSettleOptions returns the settle defaults; maxMs > 0 overrides the cap.
+func defaultCrawlSettleOptions(maxMs int) crawlSettleOptions {
+	opts := crawlSettleOptions{
+		MinWait:     100 * time.Millisecond,
+		NetworkIdle: 500 * time.Millisecond,
+		DOMQuiet:    300 * time.Millisecond,
+		Max:         5 * time.Second,
+		Poll:        50 * time.Millisecond,
+	}
+	if maxMs > 0 {
+		opts.Max = time.Duration(maxMs) * time.Millisecond
+	}
+	return opts
+}
+
+// longRequestGrace is how long a request may stay open before it stops
+// counting against network idle (long polling, streaming downloads).
+const longRequestGrace = 10 * time.Second
+
+// crawlNetworkTracker counts in-flight requests from CDP network events.
+type crawlNetworkTracker struct {
+	mu           sync.Mutex
+	inflight     map[network.RequestID]time.Time
+	lastActivity time.Time
+	transferred  int64 // bytes received since the last takeTransferred
+	now          func() time.Time
+}
+
+func newCrawlNetworkTracker() *crawlNetworkTracker {
+	return &crawlNetworkTracker{
+		inflight:     map[network.RequestID]time.Time{},
+		lastActivity: time.Now(),
+		now:          time.Now,
+	}
+}
+
+// handleEvent is registered with chromedp.ListenTarget.
+func (t *crawlNetworkTracker) handleEvent(ev interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	switch e := ev.(type) {
+	case *network.EventRequestWillBeSent:
+		// Server-sent event streams never finish
+		if e.Type == network.ResourceTypeEventSource {
+			return
+		}
+		if _, ok := t.inflight[e.RequestID]; !ok {
+			t.inflight[e.RequestID] = now
+		}
+		t.lastActivity = now
+	case *network.EventLoadingFinished:
+		delete(t.inflight, e.RequestID)
+		t.lastActivity = now
+		t.transferred += int64(e.EncodedDataLength)
+	case *network.EventLoadingFailed:
+		delete(t.inflight, e.RequestID)
+		t.lastActivity = now
+	}
+}
+
+// idleFor returns how long the network has been idle, or 0 while requests
+// younger than longRequestGrace are in flight.
+func (t *crawlNetworkTracker) idleFor() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	for _, started := range t.inflight {
+		if now.Sub(started) < longRequestGrace {
+			return 0
+		}
+	}
+	return now.Sub(t.lastActivity)
+}
+
+// takeTransferred returns the bytes received since the previous call.
+func (t *crawlNetworkTracker) takeTransferred() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := t.transferred
+	t.transferred = 0
+	return n
+}
+
+// crawlPageStateJS installs a MutationObserver on first use and reports the
+// DOM quiet time, finite running animations and the document ready state.
+const crawlPageStateJS = `(() => {
+	const w = window;
+	if (!w.__qmaxSettle) {
+		w.__qmaxSettle = { last: performance.now() };
+		new MutationObserver(() => { w.__qmaxSettle.last = performance.now(); })
+			.observe(document, { subtree: true, childList: true, attributes: true, characterData: true });
+	}
+	let animations = 0;
+	if (document.getAnimations) {
+		for (const a of document.getAnimations()) {
+			if (a.playState === 'running' && a.effect && isFinite(a.effect.getComputedTiming().endTime)) animations++;
+		}
+	}
+	return { quiet_ms: performance.now() - w.__qmaxSettle.last, animations, ready: document.readyState };
+})()`
+
+type crawlPageState struct {
+	QuietMs    float64 `json:"quiet_ms"`
+	Animations int     `json:"animations"`
+	Ready      string  `json:"ready"`
+}
+
+// settled reports whether a page state plus network idle time meets opts.
+func (s crawlPageState) settled(networkIdle time.Duration, opts crawlSettleOptions) bool {
+	return s.Ready != "loading" &&
+		networkIdle >= opts.NetworkIdle &&
+		time.Duration(s.QuietMs*float64(time.Millisecond)) >= opts.DOMQuiet &&
+		s.Animations == 0
+}
+
+// waitForSettle waits until the network is idle, the DOM has stopped changing
+// and finite animations are done, or opts.Max elapses. It returns the time
+// spent and whether the cap was hit.
+func waitForSettle(ctx context.Context, tracker *crawlNetworkTracker, opts crawlSettleOptions) (time.Duration, bool) {
+	start := time.Now()
+	deadline := start.Add(opts.Max)
+
+	select {
+	case <-ctx.Done():
+		return time.Since(start), false
+	case <-time.After(opts.MinWait):
+	}
+
+	for {
+		var state crawlPageState
+		evalCtx, cancel := context.WithTimeout(ctx, time.Second)
+		err := chromedp.Run(evalCtx, chromedp.Evaluate(crawlPageStateJS, &state))
+		cancel()
+		// Evaluation fails mid-navigation; treat that as not settled
+		if err == nil && state.settled(tracker.idleFor(), opts) {
+			return time.Since(start), false
+		}
+		if time.Now().After(deadline) {
+			return time.Since(start), true
+		}
+		select {
+		case <-ctx.Done():
+			return time.Since(start), false
+		case <-time.After(opts.Poll):
+		}
+	}
+}
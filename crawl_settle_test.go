@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+func newFakeClockTracker() (*crawlNetworkTracker, *time.Time) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tr := newCrawlNetworkTracker()
+	tr.now = func() time.Time { return now }
+	tr.lastActivity = now
+	return tr, &now
+}
+
+func TestCrawlNetworkTracker_Idle(t *testing.T) {
+	tr, now := newFakeClockTracker()
+
+	tr.handleEvent(&network.EventRequestWillBeSent{RequestID: "1", Type: network.ResourceTypeXHR})
+	tr.handleEvent(&network.EventRequestWillBeSent{RequestID: "2", Type: network.ResourceTypeFetch})
+	*now = now.Add(time.Second)
+	if got := tr.idleFor(); got != 0 {
+		t.Errorf("busy network should not be idle, got %v", got)
+	}
+
+	tr.handleEvent(&network.EventLoadingFinished{RequestID: "1"})
+	if got := tr.idleFor(); got != 0 {
+		t.Errorf("one request still in flight, got %v", got)
+	}
+
+	tr.handleEvent(&network.EventLoadingFailed{RequestID: "2"})
+	*now = now.Add(700 * time.Millisecond)
+	if got := tr.idleFor(); got != 700*time.Millisecond {
+		t.Errorf("idleFor: got %v, want 700ms", got)
+	}
+}
+
+func TestCrawlNetworkTracker_IgnoresLongRequests(t *testing.T) {
+	tr, now := newFakeClockTracker()
+
+	tr.handleEvent(&network.EventRequestWillBeSent{RequestID: "poll", Type: network.ResourceTypeXHR})
+	*now = now.Add(longRequestGrace + time.Second)
+	if got := tr.idleFor(); got != longRequestGrace+time.Second {
+		t.Errorf("long-running request should not block idle, got %v", got)
+	}
+
+	tr.handleEvent(&network.EventRequestWillBeSent{RequestID: "sse", Type: network.ResourceTypeEventSource})
+	if got := tr.idleFor(); got == 0 {
+		t.Error("event streams should be ignored")
+	}
+}
+
+func TestCrawlNetworkTracker_Redirect(t *testing.T) {
+	tr, now := newFakeClockTracker()
+
+	tr.handleEvent(&network.EventRequestWillBeSent{RequestID: "1"})
+	*now = now.Add(time.Second)
+	// Redirects reuse the request ID and keep the original start time
+	tr.handleEvent(&network.EventRequestWillBeSent{RequestID: "1"})
+	if len(tr.inflight) != 1 {
+		t.Errorf("expected 1 in-flight request, got %d", len(tr.inflight))
+	}
+	tr.handleEvent(&network.EventLoadingFinished{RequestID: "1"})
+	if len(tr.inflight) != 0 {
+		t.Error("request should be done")
+	}
+}
+
+func TestCrawlPageState_Settled(t *testing.T) {
+	opts := defaultCrawlSettleOptions(0)
+	calm := crawlPageState{QuietMs: 400, Animations: 0, Ready: "complete"}
+
+	tests := []struct {
+		name  string
+		state crawlPageState
+		idle  time.Duration
+		want  bool
+	}{
+		{"settled", calm, time.Second, true},
+		{"network busy", calm, 0, false},
+		{"network recently active", calm, 200 * time.Millisecond, false},
+		{"dom changing", crawlPageState{QuietMs: 50, Ready: "complete"}, time.Second, false},
+		{"animating", crawlPageState{QuietMs: 400, Animations: 1, Ready: "complete"}, time.Second, false},
+		{"still loading", crawlPageState{QuietMs: 400, Ready: "loading"}, time.Second, false},
+		{"interactive is enough", crawlPageState{QuietMs: 400, Ready: "interactive"}, time.Second, true},
+	}
+	for _, tt := range tests {
+		if got := tt.state.settled(tt.idle, opts); got != tt.want {
+			t.Errorf("%s: settled = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultCrawlSettleOptions(t *testing.T) {
+	if opts := defaultCrawlSettleOptions(0); opts.Max != 5*time.Second {
+		t.Errorf("default cap: got %v", opts.Max)
+	}
+	if opts := defaultCrawlSettleOptions(1500); opts.Max != 1500*time.Millisecond {
+		t.Errorf("custom cap: got %v", opts.Max)
+	}
+}
+
+func TestWaitForSettle_CapsWithoutBrowser(t *testing.T) {
+	// Evaluation fails outside a chromedp context, so the page never settles
+	// and the cap applies.
+	opts := crawlSettleOptions{MinWait: 10 * time.Millisecond, Max: 100 * time.Millisecond, Poll: 10 * time.Millisecond}
+	elapsed, capped := waitForSettle(context.Background(), newCrawlNetworkTracker(), opts)
+	if !capped {
+		t.Error("expected the cap to be hit")
+	}
+	if elapsed < opts.Max || elapsed > time.Second {
+		t.Errorf("elapsed: got %v", elapsed)
+	}
+}
+
+func TestWaitForSettle_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	opts := defaultCrawlSettleOptions(0)
+	if _, capped := waitForSettle(ctx, newCrawlNetworkTracker(), opts); capped {
+		t.Error("cancellation should not report a capped settle")
+	}
+}
+
+func TestCrawlSnapshot_SettleSerialization(t *testing.T) {
+	data, _ := json.Marshal(CrawlSnapshot{StepNum: 2, SettleMs: 850, SettleTimedOut: true})
+	if !strings.Contains(string(data), `"settle_ms":850,"settle_timed_out":true`) {
+		t.Errorf("unexpected JSON: %s", data)
+	}
+}
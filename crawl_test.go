@@ -196,6 +196,31 @@ func TestPollCrawlSessions_URLPath(t *testing.T) {
 	}
 }
 
+func TestWaitForCrawlSession_SkipsOtherCrawls(t *testing.T) {
+	sessions := []string{
+		`{"session": {"session_id": "sess-other", "crawl_id": "crawl-other"}}`,
+		`{"session": {"session_id": "sess-none"}}`,
+		`{"session": {"session_id": "sess-mine", "crawl_id": "crawl-mine"}}`,
+	}
+	var polls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, sessions[polls%len(sessions)])
+		polls++
+	}))
+	defer server.Close()
+
+	agent := newTestAgent(server.URL)
+	agent.PollInterval = time.Millisecond
+	session := waitForCrawlSession(agent, "crawl-mine", 5*time.Second)
+	if session == nil || session.SessionID != "sess-mine" {
+		t.Fatalf("got %+v, want sess-mine", session)
+	}
+	if polls != 3 {
+		t.Errorf("polls: got %d, want 3", polls)
+	}
+}
+
 // --- SubmitSnapshot tests ---
 
 func TestSubmitSnapshot_Success(t *testing.T) {
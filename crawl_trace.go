@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// CrawlTraceStep is one action the agent executed and the page it led to.
+type CrawlTraceStep struct {
+	Step     int    `json:"step"`
+	Action   string `json:"action"`
+	Selector string `json:"selector"`
+	Value    string `json:"value,omitempty"`
+	Status   string `json:"status"` // ok, failed, blocked
+	URL      string `json:"url"`
+	Title    string `json:"title"`
+}
+
+// CrawlTrace is the recorded path of a crawl session.
+type CrawlTrace struct {
+	SessionID     string               `json:"session_id"`
+	StartURL      string               `json:"start_url"`
+	LandedURL     string               `json:"landed_url"` // after redirects
+	StartTitle    string               `json:"start_title"`
+	Viewport      CrawlViewport        `json:"viewport"`
+	Authenticated bool                 `json:"authenticated,omitempty"`
+	CookieConsent *CookieConsentResult `json:"cookie_consent,omitempty"`
+	Steps         []CrawlTraceStep     `json:"steps"`
+}
+
+// recordStep appends an executed action, reading the resulting URL and title
+// from the page.
+func (t *CrawlTrace) recordStep(ctx context.Context, step int, action *CrawlAction, result *CrawlActionResult) {
+	s := CrawlTraceStep{
+		Step:     step,
+		Action:   action.Action,
+		Selector: action.Selector,
+		Value:    action.Value,
+		Status:   result.Status,
+	}
+	infoCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	_ = chromedp.Run(infoCtx, chromedp.Location(&s.URL), chromedp.Title(&s.Title))
+	t.Steps = append(t.Steps, s)
+}
+
+// jsString quotes s as a JavaScript string literal.
+func jsString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// playwrightSpecFromTrace renders a Playwright test that replays the
+// successful actions of a trace and asserts the URL and title after each.
+func playwrightSpecFromTrace(trace *CrawlTrace) string {
+	var b strings.Builder
+	b.WriteString("// Generated by qmax from crawl session " + trace.SessionID + "\n")
+	b.WriteString("const { test, expect } = require('@playwright/test');\n\n")
+
+	vp := trace.Viewport
+	use := []string{fmt.Sprintf("viewport: { width: %d, height: %d }", vp.Width, vp.Height)}
+	if vp.DeviceScaleFactor > 0 && vp.DeviceScaleFactor != 1 {
+		use = append(use, fmt.Sprintf("deviceScaleFactor: %g", vp.DeviceScaleFactor))
+	}
+	if vp.Mobile {
+		use = append(use, "isMobile: true")
+	}
+	if vp.Touch {
+		use = append(use, "hasTouch: true")
+	}
+	if vp.UserAgent != "" {
+		use = append(use, "userAgent: "+jsString(vp.UserAgent))
+	}
+	b.WriteString("test.use({ " + strings.Join(use, ", ") + " });\n\n")
+
+	b.WriteString("test(" + jsString("crawl "+trace.SessionID) + ", async ({ page }) => {\n")
+	if trace.Authenticated {
+		b.WriteString("  // The crawl started from a storage state; set storageState in playwright.config.js\n")
+	}
+	b.WriteString("  await page.goto(" + jsString(trace.StartURL) + ");\n")
+	if c := trace.CookieConsent; c != nil && c.Status == "dismissed" && c.FrameURL == "" {
+		fmt.Fprintf(&b, "  // Cookie banner (%s rule %s)\n", c.Choice, c.Rule)
+		b.WriteString("  await " + playwrightConsentLocator(c.Match) + ".click({ timeout: 5000 }).catch(() => {});\n")
+	}
+	writePageAssertions(&b, trace.LandedURL, trace.StartTitle)
+
+	for _, s := range trace.Steps {
+		fmt.Fprintf(&b, "\n  // Step %d: %s %s\n", s.Step, s.Action, s.Selector)
+		if s.Status != "ok" {
+			fmt.Fprintf(&b, "  // skipped: action %s during the crawl\n", s.Status)
+			continue
+		}
+		loc := playwrightLocator(s.Selector)
+		switch s.Action {
+		case "click":
+			b.WriteString("  await " + loc + ".click();\n")
+		case "fill":
+			b.WriteString("  await " + loc + ".fill(" + jsString(s.Value) + ");\n")
+		case "select":
+			b.WriteString("  await " + loc + ".selectOption(" + jsString(s.Value) + ");\n")
+		case "combobox_select":
+			b.WriteString("  await " + loc + ".click();\n")
+			b.WriteString("  await " + loc + ".pressSequentially(" + jsString(s.Value) + ");\n")
+			b.WriteString("  await page.locator('[role=\"option\"]:visible').first().click();\n")
+		default:
+			b.WriteString("  // unsupported action\n")
+			continue
+		}
+		writePageAssertions(&b, s.URL, s.Title)
+	}
+
+	b.WriteString("});\n")
+	return b.String()
+}
+
+func writePageAssertions(b *strings.Builder, url, title string) {
+	if url != "" {
+		b.WriteString("  await expect(page).toHaveURL(" + jsString(url) + ");\n")
+	}
+	if title != "" {
+		b.WriteString("  await expect(page).toHaveTitle(" + jsString(title) + ");\n")
+	}
+}
+
+// playwrightConsentLocator turns a cookie consent match (a CSS selector or a
+// button text) into a Playwright locator.
+func playwrightConsentLocator(match string) string {
+	if strings.ContainsAny(match, "#.[") {
+		return "page.locator(" + jsString(match) + ")"
+	}
+	return "page.getByRole('button', { name: " + jsString(match) + ", exact: true })"
+}
+
+// submitCrawlRecording uploads the action trace, generated spec and sitemap.
+func (a *Agent) submitCrawlRecording(sessionID string, trace *CrawlTrace, spec string, graph *CrawlGraph) error {
+	url := fmt.Sprintf("%s/api/agent/%s/crawl/%s/recording", a.CloudURL, a.AgentID, sessionID)
+	payload := map[string]interface{}{
+		"trace":           trace,
+		"playwright_spec": spec,
+	}
+	if graph != nil {
+		payload["sitemap"] = graph
+	}
+
+	resp, body, err := a.doJSONWithRetry("POST", url, payload, a.authHeaders(), 30*time.Second)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("upload recording failed: %d - %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// finishCrawlRecording generates the spec for a finished session, uploads it
+// with the sitemap, writes it to session.EmitSpecPath when set and saves the
+// sitemap locally.
+func (a *Agent) finishCrawlRecording(session CrawlSession, trace *CrawlTrace, graph *CrawlGraph) {
+	spec := playwrightSpecFromTrace(trace)
+
+	if err := a.submitCrawlRecording(session.SessionID, trace, spec, graph); err != nil {
+		log.Printf("CRAWL [%s] WARN: could not upload recording: %v", session.SessionID, err)
+	} else {
+		log.Printf("CRAWL [%s] Uploaded recording (%d steps)", session.SessionID, len(trace.Steps))
+	}
+
+	if session.EmitSpecPath != "" {
+		if err := os.WriteFile(session.EmitSpecPath, []byte(spec), 0644); err != nil {
+			log.Printf("CRAWL [%s] WARN: could not write spec: %v", session.SessionID, err)
+		} else {
+			log.Printf("CRAWL [%s] Wrote Playwright spec to %s", session.SessionID, session.EmitSpecPath)
+		}
+	}
+
+	if graph != nil {
+		saveCrawlGraph(session, graph)
+	}
+}
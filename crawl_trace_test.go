@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testCrawlTrace() *CrawlTrace {
+	return &CrawlTrace{
+		SessionID:  "sess-1",
+		StartURL:   "https://example.com",
+		LandedURL:  "https://example.com/home",
+		StartTitle: "Home",
+		Viewport:   CrawlViewport{Width: 1280, Height: 720, DeviceScaleFactor: 1},
+		CookieConsent: &CookieConsentResult{
+			Status: "dismissed", Rule: "onetrust", Choice: "accept", Match: "#onetrust-accept-btn-handler",
+		},
+		Steps: []CrawlTraceStep{
+			{Step: 1, Action: "click", Selector: "a[href=\"/login\"]", Status: "ok", URL: "https://example.com/login", Title: "Log in"},
+			{Step: 2, Action: "fill", Selector: "#email", Value: "qa@example.com", Status: "ok", URL: "https://example.com/login", Title: "Log in"},
+			{Step: 3, Action: "click", Selector: "#delete", Status: "blocked", URL: "https://example.com/login", Title: "Log in"},
+			{Step: 4, Action: "select", Selector: "#country", Value: "DE", Status: "ok", URL: "https://example.com/login"},
+		},
+	}
+}
+
+func TestPlaywrightSpecFromTrace(t *testing.T) {
+	spec := playwrightSpecFromTrace(testCrawlTrace())
+
+	for _, want := range []string{
+		"const { test, expect } = require('@playwright/test');",
+		"test.use({ viewport: { width: 1280, height: 720 } });",
+		`test("crawl sess-1", async ({ page }) => {`,
+		`await page.goto("https://example.com");`,
+		`await page.locator("#onetrust-accept-btn-handler").click({ timeout: 5000 }).catch(() => {});`,
+		`await expect(page).toHaveURL("https://example.com/home");`,
+		`await expect(page).toHaveTitle("Home");`,
+		`await page.locator("a[href=\"/login\"]").click();`,
+		`await page.locator("#email").fill("qa@example.com");`,
+		`await page.locator("#country").selectOption("DE");`,
+		"// skipped: action blocked during the crawl",
+	} {
+		if !strings.Contains(spec, want) {
+			t.Errorf("spec missing %q\n%s", want, spec)
+		}
+	}
+	if strings.Contains(spec, `locator("#delete").click()`) {
+		t.Error("blocked actions must not be replayed")
+	}
+	if strings.Count(spec, "toHaveTitle") != 3 {
+		t.Errorf("expected a title assertion for each titled page, got %d", strings.Count(spec, "toHaveTitle"))
+	}
+}
+
+func TestPlaywrightSpecFromTrace_MobileAndAuth(t *testing.T) {
+	trace := &CrawlTrace{
+		SessionID:     "m",
+		StartURL:      "https://example.com",
+		Authenticated: true,
+		Viewport:      CrawlViewport{Width: 390, Height: 844, DeviceScaleFactor: 3, Mobile: true, Touch: true, UserAgent: "UA \"quoted\""},
+	}
+	spec := playwrightSpecFromTrace(trace)
+	if !strings.Contains(spec, `deviceScaleFactor: 3, isMobile: true, hasTouch: true, userAgent: "UA \"quoted\""`) {
+		t.Errorf("unexpected test.use: %s", spec)
+	}
+	if !strings.Contains(spec, "storageState") {
+		t.Error("authenticated crawls should point at storageState")
+	}
+}
+
+func TestPlaywrightConsentLocator(t *testing.T) {
+	if got := playwrightConsentLocator("Alle akzeptieren"); got != `page.getByRole('button', { name: "Alle akzeptieren", exact: true })` {
+		t.Errorf("text match: got %s", got)
+	}
+	if got := playwrightConsentLocator(`[data-testid="uc-accept-all-button"]`); !strings.HasPrefix(got, "page.locator(") {
+		t.Errorf("selector match: got %s", got)
+	}
+}
+
+func TestJSString(t *testing.T) {
+	// HTML-significant characters come out as \u escapes, which JS accepts
+	if got := jsString("it's \"x\"\n</script>"); got != `"it's \"x\"\n\u003c/script\u003e"` {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestFinishCrawlRecording(t *testing.T) {
+	var received struct {
+		Trace CrawlTrace `json:"trace"`
+		Spec  string     `json:"playwright_spec"`
+	}
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		_ = json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	out := filepath.Join(t.TempDir(), "crawl.spec.js")
+	a := newTestAgent(server.URL)
+	a.finishCrawlRecording(CrawlSession{SessionID: "sess-1", EmitSpecPath: out}, testCrawlTrace(), nil)
+
+	if path != "/api/agent/test-agent-id-xyz/crawl/sess-1/recording" {
+		t.Errorf("unexpected path %s", path)
+	}
+	if len(received.Trace.Steps) != 4 || !strings.Contains(received.Spec, "page.goto") {
+		t.Errorf("unexpected upload: %+v", received)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("spec not written: %v", err)
+	}
+	if string(data) != received.Spec {
+		t.Error("written spec should match the uploaded one")
+	}
+}
+
+func TestSubmitCrawlRecording_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	a := newTestAgent(server.URL)
+	if err := a.submitCrawlRecording("s", &CrawlTrace{}, "", nil); err == nil {
+		t.Error("expected error on 400")
+	}
+}
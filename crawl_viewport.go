@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+	"github.com/chromedp/chromedp/device"
+)
+
+const (
+	defaultCrawlViewportWidth  = 1280
+	defaultCrawlViewportHeight = 720
+	defaultCrawlLossyQuality   = 80
+
+	// Chrome refuses to capture surfaces taller than this
+	maxFullPageScreenshotHeight = 16384
+)
+
+// CrawlViewport configures the crawl browser's viewport and device emulation.
+// A Device preset is applied first; any explicit field overrides it.
+type CrawlViewport struct {
+	Device            string  `json:"device,omitempty"`
+	Width             int64   `json:"width,omitempty"`
+	Height            int64   `json:"height,omitempty"`
+	DeviceScaleFactor float64 `json:"device_scale_factor,omitempty"`
+	Mobile            bool    `json:"mobile,omitempty"`
+	Touch             bool    `json:"touch,omitempty"`
+	Landscape         bool    `json:"landscape,omitempty"`
+	UserAgent         string  `json:"user_agent,omitempty"`
+}
+
+// CrawlScreenshotOptions controls how each step's screenshot is captured.
+type CrawlScreenshotOptions struct {
+	Mode     string `json:"mode,omitempty"`     // viewport (default), full_page, element
+	Selector string `json:"selector,omitempty"` // required for element mode
+	Format   string `json:"format,omitempty"`   // png (default), jpeg, webp
+	Quality  int    `json:"quality,omitempty"`  // 1-100, jpeg and webp only
+}
+
+// crawlDevicePresets are the device names a session may ask for.
+var crawlDevicePresets = map[string]device.Info{
+	"desktop":           {Name: "Desktop", Width: defaultCrawlViewportWidth, Height: defaultCrawlViewportHeight, Scale: 1},
+	"iphone-se":         device.IPhoneSE.Device(),
+	"iphone-13":         device.IPhone13.Device(),
+	"iphone-13-pro-max": device.IPhone13ProMax.Device(),
+	"pixel-5":           device.Pixel5.Device(),
+	"galaxy-s9":         device.GalaxyS9.Device(),
+	"ipad":              device.IPad.Device(),
+	"ipad-mini":         device.IPadMini.Device(),
+	"ipad-pro":          device.IPadPro.Device(),
+}
+
+// resolveCrawlViewport fills in a session's viewport from its device preset
+// and the 1280x720 desktop defaults.
+func resolveCrawlViewport(v *CrawlViewport) (CrawlViewport, error) {
+	resolved := CrawlViewport{
+		Width:             defaultCrawlViewportWidth,
+		Height:            defaultCrawlViewportHeight,
+		DeviceScaleFactor: 1,
+	}
+	if v == nil {
+		return resolved, nil
+	}
+
+	if v.Device != "" {
+		key := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(v.Device), " ", "-"))
+		preset, ok := crawlDevicePresets[key]
+		if !ok {
+			return resolved, fmt.Errorf("unknown device preset %q", v.Device)
+		}
+		resolved.Device = key
+		resolved.Width = preset.Width
+		resolved.Height = preset.Height
+		resolved.DeviceScaleFactor = preset.Scale
+		resolved.Mobile = preset.Mobile
+		resolved.Touch = preset.Touch
+		resolved.UserAgent = preset.UserAgent
+	}
+
+	if v.Width > 0 {
+		resolved.Width = v.Width
+	}
+	if v.Height > 0 {
+		resolved.Height = v.Height
+	}
+	if v.DeviceScaleFactor > 0 {
+		resolved.DeviceScaleFactor = v.DeviceScaleFactor
+	}
+	resolved.Mobile = resolved.Mobile || v.Mobile
+	resolved.Touch = resolved.Touch || v.Touch
+	if v.UserAgent != "" {
+		resolved.UserAgent = v.UserAgent
+	}
+	if v.Landscape {
+		resolved.Landscape = true
+		if resolved.Height > resolved.Width {
+			resolved.Width, resolved.Height = resolved.Height, resolved.Width
+		}
+	}
+
+	if resolved.Width > 10000 || resolved.Height > 10000 {
+		return resolved, fmt.Errorf("viewport %dx%d is too large", resolved.Width, resolved.Height)
+	}
+	if resolved.DeviceScaleFactor > 4 {
+		return resolved, fmt.Errorf("device scale factor %.1f is too large", resolved.DeviceScaleFactor)
+	}
+	return resolved, nil
+}
+
+// resolveCrawlScreenshot validates screenshot options and applies defaults.
+func resolveCrawlScreenshot(o *CrawlScreenshotOptions) (CrawlScreenshotOptions, error) {
+	resolved := CrawlScreenshotOptions{Mode: "viewport", Format: "png"}
+	if o == nil {
+		return resolved, nil
+	}
+
+	if o.Mode != "" {
+		resolved.Mode = strings.ReplaceAll(strings.ToLower(o.Mode), "-", "_")
+	}
+	switch resolved.Mode {
+	case "viewport", "full_page":
+	case "element":
+		if o.Selector == "" {
+			return resolved, fmt.Errorf("screenshot mode element requires a selector")
+		}
+		resolved.Selector = o.Selector
+	default:
+		return resolved, fmt.Errorf("unknown screenshot mode %q", o.Mode)
+	}
+
+	if o.Format != "" {
+		resolved.Format = strings.ToLower(o.Format)
+	}
+	switch resolved.Format {
+	case "png":
+	case "jpg":
+		resolved.Format = "jpeg"
+		fallthrough
+	case "jpeg", "webp":
+		resolved.Quality = defaultCrawlLossyQuality
+		if o.Quality != 0 {
+			if o.Quality < 1 || o.Quality > 100 {
+				return resolved, fmt.Errorf("screenshot quality must be between 1 and 100, got %d", o.Quality)
+			}
+			resolved.Quality = o.Quality
+		}
+	default:
+		return resolved, fmt.Errorf("unknown screenshot format %q", o.Format)
+	}
+
+	return resolved, nil
+}
+
+// emulateCrawlViewport applies viewport metrics, touch and user agent
+// overrides to the crawl tab.
+func emulateCrawlViewport(vp CrawlViewport) chromedp.Tasks {
+	metrics := emulation.SetDeviceMetricsOverride(vp.Width, vp.Height, vp.DeviceScaleFactor, vp.Mobile)
+	if vp.Landscape {
+		metrics = metrics.WithScreenOrientation(&emulation.ScreenOrientation{
+			Type:  emulation.OrientationTypeLandscapePrimary,
+			Angle: 90,
+		})
+	}
+	touch := emulation.SetTouchEmulationEnabled(vp.Touch)
+	if vp.Touch {
+		touch = touch.WithMaxTouchPoints(5)
+	}
+
+	tasks := chromedp.Tasks{metrics, touch}
+	if vp.UserAgent != "" {
+		tasks = append(tasks, emulation.SetUserAgentOverride(vp.UserAgent))
+	}
+	return tasks
+}
+
+// captureCrawlScreenshot takes the step screenshot according to opts.
+// Element mode falls back to the viewport when the element is missing.
+func captureCrawlScreenshot(ctx context.Context, vp CrawlViewport, opts CrawlScreenshotOptions) ([]byte, error) {
+	clip := &page.Viewport{X: 0, Y: 0, Width: float64(vp.Width), Height: float64(vp.Height), Scale: 1}
+	beyondViewport := false
+
+	switch opts.Mode {
+	case "full_page":
+		var size struct {
+			Width  float64 `json:"width"`
+			Height float64 `json:"height"`
+		}
+		if err := chromedp.Evaluate(`({
+			width: Math.max(document.documentElement.scrollWidth, window.innerWidth),
+			height: Math.max(document.documentElement.scrollHeight, document.body ? document.body.scrollHeight : 0, window.innerHeight)
+		})`, &size).Do(ctx); err != nil {
+			return nil, fmt.Errorf("measure page: %w", err)
+		}
+		clip.Width = math.Max(size.Width, 1)
+		clip.Height = math.Min(math.Max(size.Height, 1), maxFullPageScreenshotHeight)
+		beyondViewport = true
+	case "element":
+		var rect *struct {
+			X      float64 `json:"x"`
+			Y      float64 `json:"y"`
+			Width  float64 `json:"width"`
+			Height float64 `json:"height"`
+		}
+		js := fmt.Sprintf(`(() => {
+			const el = document.querySelector(%q);
+			if (!el) return null;
+			const r = el.getBoundingClientRect();
+			if (r.width === 0 || r.height === 0) return null;
+			return {x: r.left + window.scrollX, y: r.top + window.scrollY, width: r.width, height: r.height};
+		})()`, opts.Selector)
+		if err := chromedp.Evaluate(js, &rect).Do(ctx); err == nil && rect != nil {
+			clip = &page.Viewport{X: rect.X, Y: rect.Y, Width: rect.Width, Height: rect.Height, Scale: 1}
+			beyondViewport = true
+		}
+	}
+
+	params := page.CaptureScreenshot().
+		WithFormat(page.CaptureScreenshotFormat(opts.Format)).
+		WithClip(clip).
+		WithCaptureBeyondViewport(beyondViewport)
+	if opts.Format != "png" {
+		params = params.WithQuality(int64(opts.Quality))
+	}
+	return params.Do(ctx)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/chromedp/cdproto/emulation"
+)
+
+func TestResolveCrawlViewport_Defaults(t *testing.T) {
+	vp, err := resolveCrawlViewport(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if vp.Width != 1280 || vp.Height != 720 || vp.DeviceScaleFactor != 1 {
+		t.Errorf("unexpected defaults: %+v", vp)
+	}
+	if vp.Mobile || vp.Touch || vp.UserAgent != "" {
+		t.Errorf("default viewport should be plain desktop: %+v", vp)
+	}
+}
+
+func TestResolveCrawlViewport_DevicePreset(t *testing.T) {
+	vp, err := resolveCrawlViewport(&CrawlViewport{Device: "iPhone 13"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if vp.Device != "iphone-13" {
+		t.Errorf("Device: got %q", vp.Device)
+	}
+	if vp.Width != 390 || vp.Height != 844 || vp.DeviceScaleFactor != 3 {
+		t.Errorf("unexpected metrics: %+v", vp)
+	}
+	if !vp.Mobile || !vp.Touch {
+		t.Error("iPhone preset should be mobile with touch")
+	}
+	if !strings.Contains(vp.UserAgent, "iPhone") {
+		t.Errorf("UserAgent: got %q", vp.UserAgent)
+	}
+}
+
+func TestResolveCrawlViewport_Overrides(t *testing.T) {
+	vp, err := resolveCrawlViewport(&CrawlViewport{
+		Device:            "pixel-5",
+		Width:             400,
+		DeviceScaleFactor: 2,
+		UserAgent:         "custom-agent",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if vp.Width != 400 || vp.Height != 851 {
+		t.Errorf("size: got %dx%d", vp.Width, vp.Height)
+	}
+	if vp.DeviceScaleFactor != 2 {
+		t.Errorf("DeviceScaleFactor: got %v", vp.DeviceScaleFactor)
+	}
+	if vp.UserAgent != "custom-agent" {
+		t.Errorf("UserAgent: got %q", vp.UserAgent)
+	}
+}
+
+func TestResolveCrawlViewport_Landscape(t *testing.T) {
+	vp, err := resolveCrawlViewport(&CrawlViewport{Device: "ipad", Landscape: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if vp.Width <= vp.Height {
+		t.Errorf("landscape should swap to wide: %dx%d", vp.Width, vp.Height)
+	}
+}
+
+func TestResolveCrawlViewport_Errors(t *testing.T) {
+	tests := []CrawlViewport{
+		{Device: "nokia-3310"},
+		{Width: 20000},
+		{DeviceScaleFactor: 8},
+	}
+	for _, v := range tests {
+		if _, err := resolveCrawlViewport(&v); err == nil {
+			t.Errorf("expected error for %+v", v)
+		}
+	}
+}
+
+func TestResolveCrawlScreenshot(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      *CrawlScreenshotOptions
+		want    CrawlScreenshotOptions
+		wantErr bool
+	}{
+		{"nil", nil, CrawlScreenshotOptions{Mode: "viewport", Format: "png"}, false},
+		{"full page dash", &CrawlScreenshotOptions{Mode: "full-page"}, CrawlScreenshotOptions{Mode: "full_page", Format: "png"}, false},
+		{"jpeg default quality", &CrawlScreenshotOptions{Format: "JPEG"}, CrawlScreenshotOptions{Mode: "viewport", Format: "jpeg", Quality: 80}, false},
+		{"jpg alias", &CrawlScreenshotOptions{Format: "jpg", Quality: 50}, CrawlScreenshotOptions{Mode: "viewport", Format: "jpeg", Quality: 50}, false},
+		{"webp", &CrawlScreenshotOptions{Format: "webp", Quality: 60}, CrawlScreenshotOptions{Mode: "viewport", Format: "webp", Quality: 60}, false},
+		{"png ignores quality", &CrawlScreenshotOptions{Format: "png", Quality: 10}, CrawlScreenshotOptions{Mode: "viewport", Format: "png"}, false},
+		{"element", &CrawlScreenshotOptions{Mode: "element", Selector: "#main"}, CrawlScreenshotOptions{Mode: "element", Selector: "#main", Format: "png"}, false},
+		{"element without selector", &CrawlScreenshotOptions{Mode: "element"}, CrawlScreenshotOptions{}, true},
+		{"unknown mode", &CrawlScreenshotOptions{Mode: "thumbnail"}, CrawlScreenshotOptions{}, true},
+		{"unknown format", &CrawlScreenshotOptions{Format: "gif"}, CrawlScreenshotOptions{}, true},
+		{"quality out of range", &CrawlScreenshotOptions{Format: "jpeg", Quality: 101}, CrawlScreenshotOptions{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveCrawlScreenshot(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmulateCrawlViewport_Tasks(t *testing.T) {
+	desktop, _ := resolveCrawlViewport(nil)
+	if tasks := emulateCrawlViewport(desktop); len(tasks) != 2 {
+		t.Errorf("desktop: expected metrics and touch tasks, got %d", len(tasks))
+	}
+
+	mobile, _ := resolveCrawlViewport(&CrawlViewport{Device: "iphone-13", Landscape: true})
+	tasks := emulateCrawlViewport(mobile)
+	if len(tasks) != 3 {
+		t.Fatalf("mobile: expected 3 tasks, got %d", len(tasks))
+	}
+	metrics, ok := tasks[0].(*emulation.SetDeviceMetricsOverrideParams)
+	if !ok {
+		t.Fatalf("first task should set device metrics, got %T", tasks[0])
+	}
+	if !metrics.Mobile || metrics.DeviceScaleFactor != 3 || metrics.ScreenOrientation == nil {
+		t.Errorf("unexpected metrics: %+v", metrics)
+	}
+	touch, ok := tasks[1].(*emulation.SetTouchEmulationEnabledParams)
+	if !ok || !touch.Enabled || touch.MaxTouchPoints != 5 {
+		t.Errorf("unexpected touch task: %+v", tasks[1])
+	}
+	if ua, ok := tasks[2].(*emulation.SetUserAgentOverrideParams); !ok || ua.UserAgent != mobile.UserAgent {
+		t.Errorf("unexpected user agent task: %+v", tasks[2])
+	}
+}
+
+func TestCrawlSessionParsing_ViewportAndScreenshot(t *testing.T) {
+	raw := `{
+		"session_id": "vp",
+		"url": "https://example.com",
+		"max_steps": 3,
+		"viewport": {"device": "pixel-5", "landscape": true},
+		"screenshot": {"mode": "full_page", "format": "webp", "quality": 70}
+	}`
+	var session CrawlSession
+	if err := json.Unmarshal([]byte(raw), &session); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if session.Viewport == nil || session.Viewport.Device != "pixel-5" || !session.Viewport.Landscape {
+		t.Errorf("Viewport: got %+v", session.Viewport)
+	}
+	if session.Screenshot == nil || session.Screenshot.Mode != "full_page" || session.Screenshot.Quality != 70 {
+		t.Errorf("Screenshot: got %+v", session.Screenshot)
+	}
+}
@@ -43,6 +43,8 @@ func main() {
 		cmdSast(os.Args[2:])
 	case "ci":
 		cmdCI(os.Args[2:])
+	case "visual":
+		cmdVisual(os.Args[2:])
 	case "help", "--help", "-h":
 		printUsage()
 	case "version", "--version", "-v":
@@ -81,6 +83,7 @@ Commands:
   logout     Remove saved credentials
   sast       SAST security scanning (verify, install, scan, setup)
   ci         Headless CI runner (auth + run + report for GitHub Actions)
+  visual     Visual regression baselines (list, approve)
 
 Flags:
   --help     Show this help message
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/performance"
+	"github.com/chromedp/chromedp"
+)
+
+// Page performance metrics. The same collector runs in crawls (over CDP) and,
+// when enabled, in Playwright test runs (as a test fixture): an observer
+// script injected before any page script records LCP, CLS and INP, and a
+// read script adds navigation timing and transfer size. In Chromium the
+// numbers from Performance.getMetrics are added as well.
+
+const (
+	perfFixtureFile = "qmax-perf.js"
+	perfResultsDir  = "qmax-perf"
+)
+
+// PerfMetrics are the performance numbers of one page. Times are in
+// milliseconds from the start of the navigation.
+type PerfMetrics struct {
+	URL  string `json:"url"`
+	Test string `json:"test,omitempty"` // test title, in Playwright runs
+
+	TTFBMs             float64 `json:"ttfb_ms"`
+	FCPMs              float64 `json:"fcp_ms"`
+	DOMContentLoadedMs float64 `json:"dom_content_loaded_ms"`
+	LoadMs             float64 `json:"load_ms"`
+
+	// Web Vitals from the observer; 0 when the page never produced them
+	// (e.g. INP without interactions).
+	LCPMs float64 `json:"lcp_ms"`
+	CLS   float64 `json:"cls"`
+	INPMs float64 `json:"inp_ms"`
+
+	// Bytes received for the page. Crawls count everything Chrome received
+	// since the previous step; test runs sum Resource Timing, where
+	// cross-origin responses without Timing-Allow-Origin count as 0.
+	TransferBytes int64 `json:"transfer_bytes"`
+	Requests      int   `json:"requests"`
+
+	// Selected Performance.getMetrics values (Chromium only).
+	Chrome map[string]float64 `json:"chrome,omitempty"`
+}
+
+// chromePerfMetrics are the Performance.getMetrics values kept in PerfMetrics.
+var chromePerfMetrics = []string{
+	"JSHeapUsedSize", "JSHeapTotalSize", "Nodes", "Documents", "JSEventListeners",
+	"LayoutCount", "RecalcStyleCount", "LayoutDuration", "RecalcStyleDuration",
+	"ScriptDuration", "TaskDuration",
+}
+
+// perfObserverJS runs before any page script and keeps the Web Vitals in
+// window.__qmaxPerf. CLS is the largest session window (shifts less than 1s
+// apart, at most 5s long); INP is the slowest interaction.
+const perfObserverJS = `(function () {
+  if (window.__qmaxPerf) return;
+  var perf = window.__qmaxPerf = { lcp: 0, cls: 0, inp: 0 };
+  function observe(type, cb, opts) {
+    try {
+      var o = { type: type, buffered: true };
+      for (var k in opts || {}) o[k] = opts[k];
+      new PerformanceObserver(function (list) { list.getEntries().forEach(cb); }).observe(o);
+    } catch (e) {}
+  }
+  observe('largest-contentful-paint', function (e) { perf.lcp = e.renderTime || e.loadTime || e.startTime; });
+  var win = 0, first = 0, last = 0;
+  observe('layout-shift', function (e) {
+    if (e.hadRecentInput) return;
+    if (win && e.startTime - last < 1000 && e.startTime - first < 5000) {
+      win += e.value;
+    } else {
+      win = e.value;
+      first = e.startTime;
+    }
+    last = e.startTime;
+    if (win > perf.cls) perf.cls = win;
+  });
+  observe('event', function (e) {
+    if (e.interactionId && e.duration > perf.inp) perf.inp = e.duration;
+  }, { durationThreshold: 16 });
+})();`
+
+// perfReadJS returns the page's metrics as a PerfMetrics object.
+const perfReadJS = `(function () {
+  var p = window.__qmaxPerf || {};
+  var nav = performance.getEntriesByType('navigation')[0] || {};
+  var fcp = performance.getEntriesByName('first-contentful-paint')[0];
+  var bytes = nav.transferSize || 0, requests = 1;
+  performance.getEntriesByType('resource').forEach(function (r) {
+    bytes += r.transferSize || 0;
+    requests++;
+  });
+  function ms(v) { return Math.round((v || 0) * 10) / 10; }
+  return {
+    url: location.href,
+    ttfb_ms: ms(nav.responseStart),
+    fcp_ms: ms(fcp && fcp.startTime),
+    dom_content_loaded_ms: ms(nav.domContentLoadedEventEnd),
+    load_ms: ms(nav.loadEventEnd),
+    lcp_ms: ms(p.lcp),
+    cls: Math.round((p.cls || 0) * 10000) / 10000,
+    inp_ms: ms(p.inp),
+    transfer_bytes: bytes,
+    requests: requests
+  };
+})()`
+
+// --- Budgets ---
+
+// PerfBudget sets upper limits for page metrics; zero means no limit.
+type PerfBudget struct {
+	TTFBMs             float64 `json:"ttfb_ms,omitempty"`
+	FCPMs              float64 `json:"fcp_ms,omitempty"`
+	DOMContentLoadedMs float64 `json:"dom_content_loaded_ms,omitempty"`
+	LoadMs             float64 `json:"load_ms,omitempty"`
+	LCPMs              float64 `json:"lcp_ms,omitempty"`
+	CLS                float64 `json:"cls,omitempty"`
+	INPMs              float64 `json:"inp_ms,omitempty"`
+	TransferBytes      int64   `json:"transfer_bytes,omitempty"`
+	Requests           int     `json:"requests,omitempty"`
+	JSHeapBytes        int64   `json:"js_heap_bytes,omitempty"`
+
+	// Only check pages whose URL matches this regex.
+	URLPattern string `json:"url_pattern,omitempty"`
+	urlRe      *regexp.Regexp
+}
+
+// PerfViolation is a metric over its budget on one page.
+type PerfViolation struct {
+	URL    string  `json:"url"`
+	Test   string  `json:"test,omitempty"`
+	Metric string  `json:"metric"`
+	Value  float64 `json:"value"`
+	Limit  float64 `json:"limit"`
+}
+
+func (v PerfViolation) String() string {
+	return fmt.Sprintf("%s %s %g > %g", v.URL, v.Metric, v.Value, v.Limit)
+}
+
+// LoadPerfBudget reads a PerfBudget from a JSON file.
+func LoadPerfBudget(path string) (*PerfBudget, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read perf budget: %w", err)
+	}
+	var b PerfBudget
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("parse perf budget %s: %w", path, err)
+	}
+	if err := b.compile(); err != nil {
+		return nil, fmt.Errorf("perf budget %s: %w", path, err)
+	}
+	return &b, nil
+}
+
+func (b *PerfBudget) compile() error {
+	if b.URLPattern == "" || b.urlRe != nil {
+		return nil
+	}
+	re, err := regexp.Compile(b.URLPattern)
+	if err != nil {
+		return fmt.Errorf("invalid url_pattern: %w", err)
+	}
+	b.urlRe = re
+	return nil
+}
+
+// check returns the metrics of m that exceed the budget.
+func (b *PerfBudget) check(m *PerfMetrics) []PerfViolation {
+	if b == nil || m == nil {
+		return nil
+	}
+	if err := b.compile(); err != nil || (b.urlRe != nil && !b.urlRe.MatchString(m.URL)) {
+		return nil
+	}
+	limits := []struct {
+		metric       string
+		value, limit float64
+	}{
+		{"ttfb_ms", m.TTFBMs, b.TTFBMs},
+		{"fcp_ms", m.FCPMs, b.FCPMs},
+		{"dom_content_loaded_ms", m.DOMContentLoadedMs, b.DOMContentLoadedMs},
+		{"load_ms", m.LoadMs, b.LoadMs},
+		{"lcp_ms", m.LCPMs, b.LCPMs},
+		{"cls", m.CLS, b.CLS},
+		{"inp_ms", m.INPMs, b.INPMs},
+		{"transfer_bytes", float64(m.TransferBytes), float64(b.TransferBytes)},
+		{"requests", float64(m.Requests), float64(b.Requests)},
+		{"js_heap_bytes", m.Chrome["JSHeapUsedSize"], float64(b.JSHeapBytes)},
+	}
+	var out []PerfViolation
+	for _, l := range limits {
+		if l.limit > 0 && l.value > l.limit {
+			out = append(out, PerfViolation{URL: m.URL, Test: m.Test, Metric: l.metric, Value: l.value, Limit: l.limit})
+		}
+	}
+	return out
+}
+
+// --- Crawls ---
+
+// enableCrawlPerf installs the Web Vitals observer for every new document
+// and enables the CDP Performance domain. Must run before the first navigation.
+func enableCrawlPerf(ctx context.Context) error {
+	return chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		if _, err := page.AddScriptToEvaluateOnNewDocument(perfObserverJS).Do(ctx); err != nil {
+			return fmt.Errorf("install perf observer: %w", err)
+		}
+		return performance.Enable().Do(ctx)
+	}))
+}
+
+// collectCrawlPerf reads the current page's metrics. transferred is what
+// Chrome received since the previous step; it replaces the Resource Timing
+// estimate when known.
+func collectCrawlPerf(ctx context.Context, transferred int64) (*PerfMetrics, error) {
+	var m PerfMetrics
+	if err := chromedp.Run(ctx, chromedp.Evaluate(perfReadJS, &m)); err != nil {
+		return nil, fmt.Errorf("read perf metrics: %w", err)
+	}
+	if transferred > 0 {
+		m.TransferBytes = transferred
+	}
+	var metrics []*performance.Metric
+	if err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		metrics, err = performance.GetMetrics().Do(ctx)
+		return err
+	})); err != nil {
+		return &m, fmt.Errorf("get performance metrics: %w", err)
+	}
+	m.Chrome = filterChromePerfMetrics(metrics)
+	return &m, nil
+}
+
+func filterChromePerfMetrics(metrics []*performance.Metric) map[string]float64 {
+	out := map[string]float64{}
+	for _, metric := range metrics {
+		for _, name := range chromePerfMetrics {
+			if metric.Name == name {
+				out[name] = metric.Value
+			}
+		}
+	}
+	return out
+}
+
+// --- Playwright runs ---
+
+// PerfOptions turns on metrics collection in test runs. It can come with an
+// assignment or from `qmax run --perf` / `--perf-budget`.
+type PerfOptions struct {
+	Enabled bool        `json:"enabled"`
+	Budget  *PerfBudget `json:"budget,omitempty"`
+}
+
+// PerfReport is the collected metrics of a test run, with the budget
+// violations. Status is "ok" or "degraded".
+type PerfReport struct {
+	Status     string          `json:"status"`
+	Pages      []PerfMetrics   `json:"pages"`
+	Violations []PerfViolation `json:"violations,omitempty"`
+}
+
+// Degraded reports whether any page exceeded the budget.
+func (r *PerfReport) Degraded() bool {
+	return r != nil && len(r.Violations) > 0
+}
+
+// perfFixtureJS wraps @playwright/test with an automatic fixture that
+// installs the observer in every browser context and, after each test,
+// writes the metrics of the test's open pages to $QMAX_PERF_DIR.
+var perfFixtureJS = fmt.Sprintf(`// Generated by qmax: records page performance metrics for each test.
+const base = require('@playwright/test');
+const fs = require('fs');
+const path = require('path');
+
+const OBSERVER = %s;
+const READ = %s;
+const CHROME_METRICS = %s;
+
+const test = base.test.extend({
+  qmaxPerf: [async ({ context, browserName }, use, testInfo) => {
+    await context.addInitScript({ content: OBSERVER });
+    await use();
+    const results = [];
+    for (const page of context.pages()) {
+      if (page.isClosed()) continue;
+      try {
+        const m = await page.evaluate(READ);
+        if (browserName === 'chromium') {
+          const cdp = await context.newCDPSession(page);
+          await cdp.send('Performance.enable');
+          const { metrics } = await cdp.send('Performance.getMetrics');
+          m.chrome = {};
+          for (const x of metrics) if (CHROME_METRICS.includes(x.name)) m.chrome[x.name] = x.value;
+          await cdp.detach();
+        }
+        m.test = testInfo.titlePath.slice(1).join(' › ');
+        results.push(m);
+      } catch (e) {}
+    }
+    const dir = process.env.QMAX_PERF_DIR;
+    if (dir && results.length) {
+      fs.mkdirSync(dir, { recursive: true });
+      fs.writeFileSync(path.join(dir, testInfo.testId + '-' + testInfo.retry + '.json'), JSON.stringify(results));
+    }
+  }, { auto: true }],
+});
+
+module.exports = { ...base, test, default: test };
+`, jsString(perfObserverJS), jsString(perfReadJS), mustJSON(chromePerfMetrics))
+
+func mustJSON(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
+
+var playwrightImportPattern = regexp.MustCompile(`(require\(\s*|from\s+)(['"])@playwright/test(['"])`)
+
+// usePerfFixture points the test's @playwright/test imports at the perf
+// fixture. It reports false when the code has no such import.
+func usePerfFixture(code string) (string, bool) {
+	if !playwrightImportPattern.MatchString(code) {
+		return code, false
+	}
+	return playwrightImportPattern.ReplaceAllString(code, "${1}${2}./"+perfFixtureFile+"${3}"), true
+}
+
+// setupPerfFixture writes the fixture next to the test and rewrites its
+// imports. It returns the test code to write and the results directory to
+// pass as QMAX_PERF_DIR, or "" when the test can't be instrumented.
+func setupPerfFixture(testDir, code string) (string, string) {
+	rewritten, ok := usePerfFixture(code)
+	if !ok {
+		log.Printf("WARN: perf metrics skipped: test does not import @playwright/test")
+		return code, ""
+	}
+	if err := os.WriteFile(filepath.Join(testDir, perfFixtureFile), []byte(perfFixtureJS), 0644); err != nil {
+		log.Printf("WARN: perf metrics skipped: %v", err)
+		return code, ""
+	}
+	return rewritten, filepath.Join(testDir, perfResultsDir)
+}
+
+// readPerfReport collects the fixture's result files and checks them
+// against the budget.
+func readPerfReport(dir string, budget *PerfBudget) *PerfReport {
+	report := &PerfReport{Status: "ok", Pages: []PerfMetrics{}}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	sort.Strings(files)
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		var pages []PerfMetrics
+		if err := json.Unmarshal(data, &pages); err != nil {
+			log.Printf("WARN: ignoring %s: %v", filepath.Base(f), err)
+			continue
+		}
+		report.Pages = append(report.Pages, pages...)
+	}
+	for i := range report.Pages {
+		report.Violations = append(report.Violations, budget.check(&report.Pages[i])...)
+	}
+	if report.Degraded() {
+		report.Status = "degraded"
+	}
+	return report
+}
+
+// perfOptions returns the metrics settings for an assignment, or nil when
+// collection is off.
+func (a *Agent) perfOptions(assignment Assignment) *PerfOptions {
+	opts := a.Perf
+	if assignment.Perf != nil {
+		opts = assignment.Perf
+	}
+	if opts == nil || !opts.Enabled {
+		return nil
+	}
+	return opts
+}
+
+// logPerfReport logs the budget violations of a test run.
+func logPerfReport(assignmentID string, report *PerfReport) {
+	log.Printf("Perf metrics for assignment %s: %d page(s), %d budget violation(s)",
+		assignmentID, len(report.Pages), len(report.Violations))
+	for _, v := range report.Violations {
+		log.Printf("  %s", v)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/performance"
+	"github.com/chromedp/chromedp"
+)
+
+func TestPerfBudget_Check(t *testing.T) {
+	b := &PerfBudget{LCPMs: 2500, CLS: 0.1, TransferBytes: 1000, JSHeapBytes: 5e6}
+	m := &PerfMetrics{
+		URL: "https://example.com/", LCPMs: 3100, CLS: 0.05, INPMs: 900, TransferBytes: 1000,
+		Chrome: map[string]float64{"JSHeapUsedSize": 6e6},
+	}
+	got := b.check(m)
+	if len(got) != 2 || got[0].Metric != "lcp_ms" || got[0].Value != 3100 || got[0].Limit != 2500 || got[1].Metric != "js_heap_bytes" {
+		t.Fatalf("unexpected violations: %+v", got)
+	}
+	if s := got[0].String(); s != "https://example.com/ lcp_ms 3100 > 2500" {
+		t.Errorf("unexpected string %q", s)
+	}
+
+	b.URLPattern = `/checkout`
+	if got := b.check(m); len(got) != 0 {
+		t.Errorf("url_pattern should skip other pages: %+v", got)
+	}
+	m.URL = "https://example.com/checkout"
+	if got := b.check(m); len(got) != 2 {
+		t.Errorf("url_pattern should match: %+v", got)
+	}
+
+	var none *PerfBudget
+	if none.check(m) != nil || b.check(nil) != nil {
+		t.Error("nil budget or metrics should have no violations")
+	}
+}
+
+func TestLoadPerfBudget(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "budget.json")
+	_ = os.WriteFile(path, []byte(`{"lcp_ms": 2500, "cls": 0.1, "url_pattern": "^https://"}`), 0644)
+	b, err := LoadPerfBudget(path)
+	if err != nil || b.LCPMs != 2500 || b.CLS != 0.1 || b.urlRe == nil {
+		t.Fatalf("unexpected budget %+v, %v", b, err)
+	}
+
+	_ = os.WriteFile(path, []byte(`{"url_pattern": "("}`), 0644)
+	if _, err := LoadPerfBudget(path); err == nil || !strings.Contains(err.Error(), "url_pattern") {
+		t.Errorf("expected url_pattern error, got %v", err)
+	}
+	if _, err := LoadPerfBudget(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestUsePerfFixture(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{`const { test, expect } = require('@playwright/test');`, `const { test, expect } = require('./qmax-perf.js');`},
+		{`const pw = require( "@playwright/test" );`, `const pw = require( "./qmax-perf.js" );`},
+		{`import { test, expect } from '@playwright/test';`, `import { test, expect } from './qmax-perf.js';`},
+		{`import test from "@playwright/test"`, `import test from "./qmax-perf.js"`},
+	}
+	for _, tt := range tests {
+		got, ok := usePerfFixture(tt.code)
+		if !ok || got != tt.want {
+			t.Errorf("usePerfFixture(%q) = %q, %v", tt.code, got, ok)
+		}
+	}
+	if _, ok := usePerfFixture(`const { chromium } = require('playwright');`); ok {
+		t.Error("code without @playwright/test should not be rewritten")
+	}
+	if _, ok := usePerfFixture(`// uses @playwright/test`); ok {
+		t.Error("comments should not count as imports")
+	}
+}
+
+func TestPerfFixtureJS_Syntax(t *testing.T) {
+	if !commandExists("node") {
+		t.Skip("node not available")
+	}
+	path := filepath.Join(t.TempDir(), perfFixtureFile)
+	if err := os.WriteFile(path, []byte(perfFixtureJS), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("node", "--check", path).CombinedOutput(); err != nil {
+		t.Errorf("fixture does not parse: %v\n%s", err, out)
+	}
+	for _, script := range []string{perfObserverJS, perfReadJS} {
+		if out, err := exec.Command("node", "-e", "new Function("+jsString(script)+")").CombinedOutput(); err != nil {
+			t.Errorf("script does not parse: %v\n%s", err, out)
+		}
+	}
+}
+
+func TestSetupPerfFixture_ReadReport(t *testing.T) {
+	testDir := t.TempDir()
+	code, dir := setupPerfFixture(testDir, `const { test } = require('@playwright/test');`)
+	if dir != filepath.Join(testDir, perfResultsDir) || !strings.Contains(code, "./qmax-perf.js") {
+		t.Fatalf("unexpected setup: %q %q", code, dir)
+	}
+	if data, err := os.ReadFile(filepath.Join(testDir, perfFixtureFile)); err != nil || !strings.Contains(string(data), "QMAX_PERF_DIR") {
+		t.Fatalf("fixture not written: %v", err)
+	}
+	if _, dir := setupPerfFixture(testDir, `console.log(1)`); dir != "" {
+		t.Error("uninstrumentable test should not get a results dir")
+	}
+
+	_ = os.MkdirAll(dir, 0755)
+	_ = os.WriteFile(filepath.Join(dir, "a-0.json"), []byte(`[{"url": "https://example.com/", "test": "home", "lcp_ms": 1200, "cls": 0.3}]`), 0644)
+	_ = os.WriteFile(filepath.Join(dir, "b-0.json"), []byte(`[{"url": "https://example.com/about", "lcp_ms": 4000}]`), 0644)
+	_ = os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{`), 0644)
+
+	report := readPerfReport(dir, nil)
+	if report.Status != "ok" || len(report.Pages) != 2 || report.Degraded() {
+		t.Errorf("without a budget nothing is degraded: %+v", report)
+	}
+	report = readPerfReport(dir, &PerfBudget{CLS: 0.1, LCPMs: 2500})
+	if report.Status != "degraded" || len(report.Violations) != 2 || report.Violations[0].Test != "home" {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if resultCategory(true, nil, report) != "degraded" || resultCategory(false, nil, report) != "failed" {
+		t.Error("unexpected result categories")
+	}
+	if resultCategory(true, &VisualReport{Changed: 1}, report) != "visual_diff" {
+		t.Error("visual diffs should take precedence over perf budgets")
+	}
+}
+
+func TestAgentPerfOptions(t *testing.T) {
+	a := &Agent{}
+	if a.perfOptions(Assignment{}) != nil {
+		t.Error("perf metrics should be off by default")
+	}
+	a.Perf = &PerfOptions{Enabled: true}
+	if a.perfOptions(Assignment{}) == nil {
+		t.Error("agent default not used")
+	}
+
+	var assignment Assignment
+	_ = json.Unmarshal([]byte(`{"id": 1, "perf": {"enabled": true, "budget": {"lcp_ms": 1000}}}`), &assignment)
+	if opts := a.perfOptions(assignment); opts == nil || opts.Budget == nil || opts.Budget.LCPMs != 1000 {
+		t.Errorf("assignment options not used: %+v", opts)
+	}
+	if a.perfOptions(Assignment{Perf: &PerfOptions{}}) != nil {
+		t.Error("assignment should be able to turn collection off")
+	}
+}
+
+func TestCIResultStatus_Degraded(t *testing.T) {
+	s := &ciExecutionStatus{Status: "completed", Category: "degraded"}
+	if got := s.resultStatus(); got != "degraded" {
+		t.Errorf("got %s", got)
+	}
+	if !strings.Contains(ciStatusLabel("degraded"), "Degraded") {
+		t.Error("unexpected status label")
+	}
+}
+
+func TestCrawlNetworkTracker_Transferred(t *testing.T) {
+	tr, _ := newFakeClockTracker()
+	tr.handleEvent(&network.EventRequestWillBeSent{RequestID: "1"})
+	tr.handleEvent(&network.EventLoadingFinished{RequestID: "1", EncodedDataLength: 1500})
+	tr.handleEvent(&network.EventLoadingFinished{RequestID: "2", EncodedDataLength: 500})
+	if got := tr.takeTransferred(); got != 2000 {
+		t.Errorf("got %d bytes, want 2000", got)
+	}
+	if got := tr.takeTransferred(); got != 0 {
+		t.Errorf("counter should reset, got %d", got)
+	}
+}
+
+func TestFilterChromePerfMetrics(t *testing.T) {
+	got := filterChromePerfMetrics([]*performance.Metric{
+		{Name: "JSHeapUsedSize", Value: 42}, {Name: "Timestamp", Value: 1}, {Name: "Nodes", Value: 7},
+	})
+	if len(got) != 2 || got["JSHeapUsedSize"] != 42 || got["Nodes"] != 7 {
+		t.Errorf("unexpected metrics: %v", got)
+	}
+}
+
+func TestCrawlSnapshot_PerfSerialization(t *testing.T) {
+	data, _ := json.Marshal(CrawlSnapshot{SessionID: "s"})
+	if strings.Contains(string(data), `"perf`) {
+		t.Errorf("empty perf fields should be omitted: %s", data)
+	}
+	data, _ = json.Marshal(CrawlSnapshot{
+		Perf:           &PerfMetrics{URL: "u", LCPMs: 1},
+		PerfViolations: []PerfViolation{{URL: "u", Metric: "lcp_ms", Value: 1, Limit: 0.5}},
+	})
+	for _, want := range []string{`"perf":{"url":"u"`, `"lcp_ms":1`, `"perf_violations":[{"url":"u","metric":"lcp_ms"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("missing %s in %s", want, data)
+		}
+	}
+}
+
+// --- Browser tests ---
+
+func TestCollectCrawlPerf_Browser(t *testing.T) {
+	if os.Getenv("QMAX_BROWSER_TESTS") == "" {
+		t.Skip("Skipping browser test (set QMAX_BROWSER_TESTS=1 to run)")
+	}
+	skipIfNoBrowser(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<!DOCTYPE html><html><body><h1>Perf</h1><p>Some text to paint.</p></body></html>`)
+	}))
+	defer ts.Close()
+
+	ctx, cancel := newTimedBrowserContext(t, 20*time.Second)
+	defer cancel()
+
+	if err := enableCrawlPerf(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := chromedp.Run(ctx, chromedp.Navigate(ts.URL), chromedp.WaitReady("body"), chromedp.Sleep(300*time.Millisecond)); err != nil {
+		t.Fatalf("navigation failed: %v", err)
+	}
+	m, err := collectCrawlPerf(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.URL != ts.URL+"/" || m.TTFBMs <= 0 || m.LoadMs <= 0 || m.Requests < 1 {
+		t.Errorf("unexpected navigation metrics: %+v", m)
+	}
+	if _, ok := m.Chrome["Nodes"]; !ok {
+		t.Errorf("missing Chrome metrics: %v", m.Chrome)
+	}
+	if m2, _ := collectCrawlPerf(ctx, 12345); m2.TransferBytes != 12345 {
+		t.Errorf("CDP byte count should win, got %d", m2.TransferBytes)
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Visual regression: screenshots from test runs are compared against local
+// baselines under ~/.qmax/visual (or $QMAX_VISUAL_DIR):
+//
+//	baselines/project-<id>/script-<id>/<browser>-<w>x<h>/<name>.png
+//	pending/...   screenshots that differ, waiting for `qmax visual approve`
+//	diffs/...     diff images for the pending screenshots
+//
+// The first screenshot for a name becomes its baseline.
+
+const (
+	defaultVisualThreshold = 0.1
+	visualDirName          = "visual"
+)
+
+// VisualOptions configures screenshot comparison. It can come with an
+// assignment or from `qmax run --visual-config`.
+type VisualOptions struct {
+	Enabled       bool           `json:"enabled"`
+	Threshold     float64        `json:"threshold,omitempty"`      // per-pixel color distance, 0-1 (default 0.1)
+	MaxDiffRatio  float64        `json:"max_diff_ratio,omitempty"` // fraction of pixels allowed to differ (default 0)
+	IncludeAA     bool           `json:"include_aa,omitempty"`     // count anti-aliasing differences
+	IgnoreRegions []VisualRegion `json:"ignore_regions,omitempty"`
+}
+
+// LoadVisualOptions reads VisualOptions from a JSON file. Options loaded
+// from a file are enabled unless the file says otherwise.
+func LoadVisualOptions(path string) (*VisualOptions, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read visual config: %w", err)
+	}
+	opts := VisualOptions{Enabled: true}
+	if err := json.Unmarshal(data, &opts); err != nil {
+		return nil, fmt.Errorf("parse visual config %s: %w", path, err)
+	}
+	if opts.Threshold < 0 || opts.Threshold > 1 {
+		return nil, fmt.Errorf("visual config %s: threshold must be between 0 and 1", path)
+	}
+	if opts.MaxDiffRatio < 0 || opts.MaxDiffRatio > 1 {
+		return nil, fmt.Errorf("visual config %s: max_diff_ratio must be between 0 and 1", path)
+	}
+	return &opts, nil
+}
+
+func (o VisualOptions) diffOptions() visualDiffOptions {
+	threshold := o.Threshold
+	if threshold == 0 {
+		threshold = defaultVisualThreshold
+	}
+	return visualDiffOptions{Threshold: threshold, IncludeAA: o.IncludeAA, IgnoreRegions: o.IgnoreRegions}
+}
+
+// visualKey identifies a baseline set.
+type visualKey struct {
+	ProjectID string
+	ScriptID  string
+	Browser   string
+	Width     int
+	Height    int
+}
+
+var unsafePathChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func visualPathPart(prefix, v string) string {
+	v = unsafePathChars.ReplaceAllString(v, "_")
+	if v == "" || v == "." || v == ".." {
+		v = "unknown"
+	}
+	return prefix + v
+}
+
+// dir returns the key's directory below baselines/, pending/ and diffs/.
+func (k visualKey) dir() string {
+	return filepath.Join(
+		visualPathPart("project-", k.ProjectID),
+		visualPathPart("script-", k.ScriptID),
+		visualPathPart("", fmt.Sprintf("%s-%dx%d", k.Browser, k.Width, k.Height)),
+	)
+}
+
+// VisualComparison is the result for one screenshot.
+type VisualComparison struct {
+	Name        string  `json:"name"`
+	Status      string  `json:"status"` // new, passed, changed
+	DiffPixels  int     `json:"diff_pixels"`
+	DiffRatio   float64 `json:"diff_ratio"`
+	SizeChanged bool    `json:"size_changed,omitempty"`
+	Baseline    string  `json:"baseline"`
+	Pending     string  `json:"pending,omitempty"`
+	Diff        string  `json:"diff,omitempty"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// VisualReport summarizes the comparisons of one test run.
+type VisualReport struct {
+	Status      string             `json:"status"` // passed, changed
+	New         int                `json:"new"`
+	Passed      int                `json:"passed"`
+	Changed     int                `json:"changed"`
+	Comparisons []VisualComparison `json:"comparisons"`
+}
+
+// visualStore is the on-disk baseline store.
+type visualStore struct {
+	Root string
+}
+
+// defaultVisualStore returns the store in $QMAX_VISUAL_DIR or ~/.qmax/visual.
+func defaultVisualStore() (*visualStore, error) {
+	if dir := os.Getenv("QMAX_VISUAL_DIR"); dir != "" {
+		return &visualStore{Root: dir}, nil
+	}
+	dir, err := ConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	return &visualStore{Root: filepath.Join(dir, visualDirName)}, nil
+}
+
+func (s *visualStore) path(kind string, key visualKey, name string) string {
+	return filepath.Join(s.Root, kind, key.dir(), filepath.FromSlash(name))
+}
+
+// compare checks one screenshot against its baseline, creating the baseline
+// if there is none yet.
+func (s *visualStore) compare(key visualKey, name, actualPath string, opts VisualOptions) VisualComparison {
+	c := VisualComparison{Name: name, Baseline: s.path("baselines", key, name)}
+	pendingPath := s.path("pending", key, name)
+	diffPath := s.path("diffs", key, name)
+
+	if _, err := os.Stat(c.Baseline); os.IsNotExist(err) {
+		if err := copyFile(actualPath, c.Baseline); err != nil {
+			c.Status, c.Error = "changed", err.Error()
+			return c
+		}
+		c.Status = "new"
+		return c
+	}
+
+	res, err := diffFiles(c.Baseline, actualPath, opts)
+	if err == nil {
+		c.DiffPixels, c.DiffRatio, c.SizeChanged = res.DiffPixels, res.Ratio(), res.SizeChanged
+		if !res.SizeChanged && res.Ratio() <= opts.MaxDiffRatio {
+			c.Status = "passed"
+			_ = os.Remove(pendingPath)
+			_ = os.Remove(diffPath)
+			return c
+		}
+		if err = writePNG(diffPath, res.Diff); err == nil {
+			c.Diff = diffPath
+		}
+	}
+	c.Status = "changed"
+	if err != nil {
+		c.Error = err.Error()
+	}
+	if cpErr := copyFile(actualPath, pendingPath); cpErr == nil {
+		c.Pending = pendingPath
+	} else if c.Error == "" {
+		c.Error = cpErr.Error()
+	}
+	return c
+}
+
+// compareVisualArtifacts compares every screenshot Playwright left in
+// testDir/test-results. Playwright's own toHaveScreenshot outputs are skipped.
+func compareVisualArtifacts(store *visualStore, testDir string, key visualKey, opts VisualOptions) *VisualReport {
+	report := &VisualReport{Status: "passed", Comparisons: []VisualComparison{}}
+	results := filepath.Join(testDir, "test-results")
+	_ = filepath.Walk(results, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(info.Name(), ".png") {
+			return nil
+		}
+		for _, suffix := range []string{"-actual.png", "-expected.png", "-diff.png"} {
+			if strings.HasSuffix(info.Name(), suffix) {
+				return nil
+			}
+		}
+		rel, err := filepath.Rel(results, path)
+		if err != nil {
+			return nil
+		}
+		c := store.compare(key, filepath.ToSlash(rel), path, opts)
+		switch c.Status {
+		case "new":
+			report.New++
+		case "passed":
+			report.Passed++
+		default:
+			report.Changed++
+			report.Status = "changed"
+		}
+		report.Comparisons = append(report.Comparisons, c)
+		return nil
+	})
+	return report
+}
+
+// resultCategory is reported with each result next to success: passed,
+// failed, visual_diff when the test passed but screenshots changed, or
+// degraded when it passed but a page exceeded the perf budget.
+func resultCategory(success bool, visual *VisualReport, perf *PerfReport) string {
+	switch {
+	case !success:
+		return "failed"
+	case visual != nil && visual.Changed > 0:
+		return "visual_diff"
+	case perf.Degraded():
+		return "degraded"
+	default:
+		return "passed"
+	}
+}
+
+// visualOptions returns the comparison settings for an assignment, or nil
+// when visual comparison is off.
+func (a *Agent) visualOptions(assignment Assignment) *VisualOptions {
+	opts := a.Visual
+	if assignment.Visual != nil {
+		opts = assignment.Visual
+	}
+	if opts == nil || !opts.Enabled {
+		return nil
+	}
+	return opts
+}
+
+// runVisualComparison compares the screenshots of a finished test run.
+func (a *Agent) runVisualComparison(assignmentID string, opts VisualOptions, testDir string, key visualKey) *VisualReport {
+	store, err := defaultVisualStore()
+	if err != nil {
+		log.Printf("WARN: visual comparison skipped: %v", err)
+		return nil
+	}
+	report := compareVisualArtifacts(store, testDir, key, opts)
+	log.Printf("Visual comparison for assignment %s: %d passed, %d new, %d changed",
+		assignmentID, report.Passed, report.New, report.Changed)
+	for _, c := range report.Comparisons {
+		if c.Status == "changed" {
+			log.Printf("  %s: %.2f%% of pixels differ (diff: %s)", c.Name, c.DiffRatio*100, c.Diff)
+		}
+	}
+	return report
+}
+
+// --- Pending screenshots ---
+
+// pendingVisual is a changed screenshot waiting for approval.
+type pendingVisual struct {
+	Dir      string // key directory, e.g. project-1/script-2/chromium-1280x720
+	Name     string
+	Pending  string
+	Baseline string
+	Diff     string
+}
+
+// listPending returns pending screenshots whose key directory starts with
+// the given project and script filters (empty matches all) and whose name
+// contains nameFilter.
+func (s *visualStore) listPending(projectID, scriptID, nameFilter string) ([]pendingVisual, error) {
+	pendingRoot := filepath.Join(s.Root, "pending")
+	var out []pendingVisual
+	err := filepath.Walk(pendingRoot, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == pendingRoot {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(pendingRoot, path)
+		if err != nil {
+			return err
+		}
+		parts := strings.SplitN(filepath.ToSlash(rel), "/", 4)
+		if len(parts) < 4 {
+			return nil
+		}
+		if projectID != "" && parts[0] != visualPathPart("project-", projectID) {
+			return nil
+		}
+		if scriptID != "" && parts[1] != visualPathPart("script-", scriptID) {
+			return nil
+		}
+		if nameFilter != "" && !strings.Contains(parts[3], nameFilter) {
+			return nil
+		}
+		dir := filepath.Join(parts[0], parts[1], parts[2])
+		out = append(out, pendingVisual{
+			Dir:      filepath.ToSlash(dir),
+			Name:     parts[3],
+			Pending:  path,
+			Baseline: filepath.Join(s.Root, "baselines", dir, filepath.FromSlash(parts[3])),
+			Diff:     filepath.Join(s.Root, "diffs", dir, filepath.FromSlash(parts[3])),
+		})
+		return nil
+	})
+	return out, err
+}
+
+// approve promotes a pending screenshot to be the new baseline.
+func (s *visualStore) approve(p pendingVisual) error {
+	if err := os.MkdirAll(filepath.Dir(p.Baseline), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(p.Pending, p.Baseline); err != nil {
+		if err := copyFile(p.Pending, p.Baseline); err != nil {
+			return err
+		}
+		_ = os.Remove(p.Pending)
+	}
+	_ = os.Remove(p.Diff)
+	return nil
+}
+
+// --- File helpers ---
+
+func diffFiles(baselinePath, actualPath string, opts VisualOptions) (visualDiffResult, error) {
+	baseline, err := readPNG(baselinePath)
+	if err != nil {
+		return visualDiffResult{}, err
+	}
+	actual, err := readPNG(actualPath)
+	if err != nil {
+		return visualDiffResult{}, err
+	}
+	return diffImages(baseline, actual, opts.diffOptions()), nil
+}
+
+func readPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return img, nil
+}
+
+func writePNG(path string, img image.Image) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
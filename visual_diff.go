@@ -0,0 +1,230 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// Perceptual image diff after pixelmatch (https://github.com/mapbox/pixelmatch):
+// pixels are compared by their distance in YIQ color space, and differences
+// caused by anti-aliasing are detected and tolerated.
+
+// VisualRegion is a rectangle in screenshot pixels that is left out of the
+// comparison (clocks, ads, avatars).
+type VisualRegion struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+func (r VisualRegion) contains(x, y int) bool {
+	return x >= r.X && x < r.X+r.Width && y >= r.Y && y < r.Y+r.Height
+}
+
+// visualDiffOptions tunes diffImages.
+type visualDiffOptions struct {
+	Threshold     float64 // per-pixel color distance, 0 (exact) to 1
+	IncludeAA     bool    // count anti-aliased pixels as different
+	IgnoreRegions []VisualRegion
+}
+
+// visualDiffResult is the outcome of diffImages.
+type visualDiffResult struct {
+	DiffPixels  int
+	TotalPixels int
+	SizeChanged bool
+	Diff        *image.NRGBA // baseline faded to gray, differences in red, anti-aliasing in yellow
+}
+
+// Ratio returns the fraction of pixels that differ.
+func (r visualDiffResult) Ratio() float64 {
+	if r.TotalPixels == 0 {
+		return 0
+	}
+	return float64(r.DiffPixels) / float64(r.TotalPixels)
+}
+
+var (
+	visualDiffColor    = color.NRGBA{R: 255, A: 255}
+	visualAAColor      = color.NRGBA{R: 255, G: 255, A: 255}
+	visualIgnoredColor = color.NRGBA{R: 200, G: 220, B: 255, A: 255}
+)
+
+// maxYIQDelta is the largest possible YIQ distance between two colors.
+const maxYIQDelta = 35215
+
+// diffImages compares actual against baseline. Images of different sizes
+// are compared over the larger size; pixels only one of them has count as
+// different.
+func diffImages(baseline, actual image.Image, opts visualDiffOptions) visualDiffResult {
+	a, b := toNRGBA(baseline), toNRGBA(actual)
+	aw, ah := a.Rect.Dx(), a.Rect.Dy()
+	bw, bh := b.Rect.Dx(), b.Rect.Dy()
+	w, h := max(aw, bw), max(ah, bh)
+
+	res := visualDiffResult{
+		TotalPixels: w * h,
+		SizeChanged: aw != bw || ah != bh,
+		Diff:        image.NewNRGBA(image.Rect(0, 0, w, h)),
+	}
+	maxDelta := maxYIQDelta * opts.Threshold * opts.Threshold
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if ignoredPixel(opts.IgnoreRegions, x, y) {
+				res.Diff.SetNRGBA(x, y, visualIgnoredColor)
+				continue
+			}
+			if x >= aw || y >= ah || x >= bw || y >= bh {
+				res.DiffPixels++
+				res.Diff.SetNRGBA(x, y, visualDiffColor)
+				continue
+			}
+			delta := colorDelta(a, b, x, y, x, y, false)
+			if math.Abs(delta) <= maxDelta {
+				res.Diff.SetNRGBA(x, y, fadedPixel(a, x, y))
+				continue
+			}
+			if !opts.IncludeAA && (isAntialiased(a, b, x, y) || isAntialiased(b, a, x, y)) {
+				res.Diff.SetNRGBA(x, y, visualAAColor)
+				continue
+			}
+			res.DiffPixels++
+			res.Diff.SetNRGBA(x, y, visualDiffColor)
+		}
+	}
+	return res
+}
+
+func ignoredPixel(regions []VisualRegion, x, y int) bool {
+	for _, r := range regions {
+		if r.contains(x, y) {
+			return true
+		}
+	}
+	return false
+}
+
+func toNRGBA(img image.Image) *image.NRGBA {
+	if n, ok := img.(*image.NRGBA); ok && n.Rect.Min == (image.Point{}) {
+		return n
+	}
+	bounds := img.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(out, out.Rect, img, bounds.Min, draw.Src)
+	return out
+}
+
+// blendedRGB returns a pixel's color blended onto a white background.
+func blendedRGB(img *image.NRGBA, x, y int) (r, g, b float64) {
+	c := img.NRGBAAt(x, y)
+	alpha := float64(c.A) / 255
+	blend := func(v uint8) float64 { return 255 + (float64(v)-255)*alpha }
+	return blend(c.R), blend(c.G), blend(c.B)
+}
+
+func rgbToY(r, g, b float64) float64 { return r*0.29889531 + g*0.58662247 + b*0.11448223 }
+func rgbToI(r, g, b float64) float64 { return r*0.59597799 - g*0.27417610 - b*0.32180189 }
+func rgbToQ(r, g, b float64) float64 { return r*0.21147017 - g*0.52261711 + b*0.31114694 }
+
+// colorDelta returns the squared YIQ distance between two pixels, negative
+// when the second is brighter. With yOnly it returns the brightness
+// difference alone.
+func colorDelta(a, b *image.NRGBA, ax, ay, bx, by int, yOnly bool) float64 {
+	if a.NRGBAAt(ax, ay) == b.NRGBAAt(bx, by) {
+		return 0
+	}
+	r1, g1, b1 := blendedRGB(a, ax, ay)
+	r2, g2, b2 := blendedRGB(b, bx, by)
+	y1, y2 := rgbToY(r1, g1, b1), rgbToY(r2, g2, b2)
+	dy := y1 - y2
+	if yOnly {
+		return dy
+	}
+	di := rgbToI(r1, g1, b1) - rgbToI(r2, g2, b2)
+	dq := rgbToQ(r1, g1, b1) - rgbToQ(r2, g2, b2)
+	delta := 0.5053*dy*dy + 0.299*di*di + 0.1957*dq*dq
+	if y1 > y2 {
+		return -delta
+	}
+	return delta
+}
+
+// isAntialiased reports whether the pixel at x, y of img looks like an
+// anti-aliased edge: it sits between a darker and a brighter neighbour, one
+// of which is part of a flat area in both images.
+func isAntialiased(img, other *image.NRGBA, x, y int) bool {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	x0, y0 := max(x-1, 0), max(y-1, 0)
+	x2, y2 := min(x+1, w-1), min(y+1, h-1)
+	zeroes := 0
+	if x == x0 || x == x2 || y == y0 || y == y2 {
+		zeroes = 1
+	}
+	var minDelta, maxDelta float64
+	var minX, minY, maxX, maxY int
+	for nx := x0; nx <= x2; nx++ {
+		for ny := y0; ny <= y2; ny++ {
+			if nx == x && ny == y {
+				continue
+			}
+			delta := colorDelta(img, img, x, y, nx, ny, true)
+			switch {
+			case delta == 0:
+				zeroes++
+				if zeroes > 2 {
+					return false
+				}
+			case delta < minDelta:
+				minDelta, minX, minY = delta, nx, ny
+			case delta > maxDelta:
+				maxDelta, maxX, maxY = delta, nx, ny
+			}
+		}
+	}
+	if minDelta == 0 || maxDelta == 0 {
+		return false
+	}
+	return (hasManySiblings(img, minX, minY) && hasManySiblings(other, minX, minY)) ||
+		(hasManySiblings(img, maxX, maxY) && hasManySiblings(other, maxX, maxY))
+}
+
+// hasManySiblings reports whether at least three neighbours of a pixel have
+// exactly its color.
+func hasManySiblings(img *image.NRGBA, x, y int) bool {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if x >= w || y >= h {
+		return false
+	}
+	x0, y0 := max(x-1, 0), max(y-1, 0)
+	x2, y2 := min(x+1, w-1), min(y+1, h-1)
+	zeroes := 0
+	if x == x0 || x == x2 || y == y0 || y == y2 {
+		zeroes = 1
+	}
+	c := img.NRGBAAt(x, y)
+	for nx := x0; nx <= x2; nx++ {
+		for ny := y0; ny <= y2; ny++ {
+			if nx == x && ny == y {
+				continue
+			}
+			if img.NRGBAAt(nx, ny) == c {
+				zeroes++
+			}
+			if zeroes > 2 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fadedPixel renders an unchanged pixel as light gray for the diff image.
+func fadedPixel(img *image.NRGBA, x, y int) color.NRGBA {
+	r, g, b := blendedRGB(img, x, y)
+	v := uint8(255 + (rgbToY(r, g, b)-255)*0.1)
+	return color.NRGBA{R: v, G: v, B: v, A: 255}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func solidImage(w, h int, c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+var (
+	white = color.NRGBA{255, 255, 255, 255}
+	black = color.NRGBA{0, 0, 0, 255}
+)
+
+func TestDiffImages_Identical(t *testing.T) {
+	a := solidImage(20, 10, white)
+	res := diffImages(a, solidImage(20, 10, white), visualDiffOptions{Threshold: 0.1})
+	if res.DiffPixels != 0 || res.Ratio() != 0 || res.SizeChanged {
+		t.Errorf("identical images: %+v", res)
+	}
+	if res.Diff.Rect.Dx() != 20 || res.Diff.Rect.Dy() != 10 {
+		t.Errorf("diff image size: %v", res.Diff.Rect)
+	}
+}
+
+func TestDiffImages_ChangedBlock(t *testing.T) {
+	a := solidImage(20, 20, white)
+	b := solidImage(20, 20, white)
+	for y := 5; y < 10; y++ {
+		for x := 5; x < 10; x++ {
+			b.SetNRGBA(x, y, black)
+		}
+	}
+	res := diffImages(a, b, visualDiffOptions{Threshold: 0.1})
+	if res.DiffPixels != 25 {
+		t.Errorf("expected 25 different pixels, got %d", res.DiffPixels)
+	}
+	if got := res.Diff.NRGBAAt(7, 7); got != visualDiffColor {
+		t.Errorf("changed pixel should be red in the diff, got %v", got)
+	}
+	if got := res.Diff.NRGBAAt(0, 0); got.R != got.G || got.R < 230 {
+		t.Errorf("unchanged pixel should be faded gray, got %v", got)
+	}
+
+	res = diffImages(a, b, visualDiffOptions{Threshold: 0.1, IgnoreRegions: []VisualRegion{{X: 5, Y: 5, Width: 5, Height: 3}}})
+	if res.DiffPixels != 10 {
+		t.Errorf("ignore region should hide 15 pixels, got %d different", res.DiffPixels)
+	}
+}
+
+func TestDiffImages_Threshold(t *testing.T) {
+	a := solidImage(10, 10, white)
+	b := solidImage(10, 10, color.NRGBA{250, 250, 250, 255})
+	if res := diffImages(a, b, visualDiffOptions{Threshold: 0.1}); res.DiffPixels != 0 {
+		t.Errorf("slight shade change should be under the threshold, got %d", res.DiffPixels)
+	}
+	if res := diffImages(a, b, visualDiffOptions{Threshold: 0}); res.DiffPixels != 100 {
+		t.Errorf("threshold 0 should flag every pixel, got %d", res.DiffPixels)
+	}
+}
+
+func TestDiffImages_SizeChange(t *testing.T) {
+	res := diffImages(solidImage(10, 10, white), solidImage(10, 12, white), visualDiffOptions{Threshold: 0.1})
+	if !res.SizeChanged || res.DiffPixels != 20 || res.TotalPixels != 120 {
+		t.Errorf("unexpected result: %+v", res)
+	}
+}
+
+func TestDiffImages_Antialiasing(t *testing.T) {
+	// A vertical edge between black and white, shifted by a gray column
+	a := solidImage(10, 10, white)
+	b := solidImage(10, 10, white)
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 5; x++ {
+			a.SetNRGBA(x, y, black)
+			b.SetNRGBA(x, y, black)
+		}
+		b.SetNRGBA(5, y, color.NRGBA{128, 128, 128, 255})
+	}
+	res := diffImages(a, b, visualDiffOptions{Threshold: 0.1})
+	if res.DiffPixels != 0 {
+		t.Errorf("anti-aliased edge should be tolerated, got %d", res.DiffPixels)
+	}
+	if got := res.Diff.NRGBAAt(5, 5); got != visualAAColor {
+		t.Errorf("anti-aliased pixel should be yellow, got %v", got)
+	}
+	if res := diffImages(a, b, visualDiffOptions{Threshold: 0.1, IncludeAA: true}); res.DiffPixels != 10 {
+		t.Errorf("IncludeAA should count the edge, got %d", res.DiffPixels)
+	}
+}
+
+func TestToNRGBA_Offset(t *testing.T) {
+	src := image.NewRGBA(image.Rect(5, 5, 8, 7))
+	src.Set(5, 5, black)
+	out := toNRGBA(src)
+	if out.Rect != image.Rect(0, 0, 3, 2) || out.NRGBAAt(0, 0) != black {
+		t.Errorf("unexpected conversion: %v %v", out.Rect, out.NRGBAAt(0, 0))
+	}
+}
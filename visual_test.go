@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestPNG(t *testing.T, path string, img image.Image) {
+	t.Helper()
+	if err := writePNG(path, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadVisualOptions(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "visual.json")
+	_ = os.WriteFile(path, []byte(`{"threshold": 0.2, "max_diff_ratio": 0.01, "ignore_regions": [{"x": 1, "y": 2, "width": 3, "height": 4}]}`), 0644)
+	opts, err := LoadVisualOptions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !opts.Enabled || opts.Threshold != 0.2 || opts.MaxDiffRatio != 0.01 || opts.IgnoreRegions[0] != (VisualRegion{1, 2, 3, 4}) {
+		t.Errorf("unexpected options: %+v", opts)
+	}
+
+	_ = os.WriteFile(path, []byte(`{"threshold": 2}`), 0644)
+	if _, err := LoadVisualOptions(path); err == nil {
+		t.Error("expected error for threshold > 1")
+	}
+	if _, err := LoadVisualOptions(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestVisualKeyDir(t *testing.T) {
+	key := visualKey{ProjectID: "42", ScriptID: "../7", Browser: "chromium", Width: 1280, Height: 720}
+	if got := filepath.ToSlash(key.dir()); got != "project-42/script-.._7/chromium-1280x720" {
+		t.Errorf("got %s", got)
+	}
+	if got := filepath.ToSlash(visualKey{Browser: "webkit"}.dir()); got != "project-unknown/script-unknown/webkit-0x0" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestVisualStore_Lifecycle(t *testing.T) {
+	store := &visualStore{Root: t.TempDir()}
+	key := visualKey{ProjectID: "1", ScriptID: "2", Browser: "chromium", Width: 20, Height: 20}
+	opts := VisualOptions{Enabled: true}
+	shot := filepath.Join(t.TempDir(), "shot.png")
+
+	writeTestPNG(t, shot, solidImage(20, 20, white))
+	if c := store.compare(key, "t/home.png", shot, opts); c.Status != "new" {
+		t.Fatalf("first run should create the baseline: %+v", c)
+	}
+	if c := store.compare(key, "t/home.png", shot, opts); c.Status != "passed" {
+		t.Fatalf("same screenshot should pass: %+v", c)
+	}
+
+	changed := solidImage(20, 20, white)
+	changed.SetNRGBA(3, 3, black)
+	writeTestPNG(t, shot, changed)
+	c := store.compare(key, "t/home.png", shot, opts)
+	if c.Status != "changed" || c.DiffPixels != 1 || c.Pending == "" || c.Diff == "" {
+		t.Fatalf("changed screenshot: %+v", c)
+	}
+	if _, err := os.Stat(c.Diff); err != nil {
+		t.Errorf("diff image not written: %v", err)
+	}
+
+	// A tolerant ratio accepts the change without touching the baseline
+	if c := store.compare(key, "t/home.png", shot, VisualOptions{MaxDiffRatio: 0.01}); c.Status != "passed" {
+		t.Errorf("1/400 pixels should be within 1%%: %+v", c)
+	}
+
+	store.compare(key, "t/home.png", shot, opts)
+	pending, err := store.listPending("1", "", "home")
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("expected 1 pending screenshot, got %v, %v", pending, err)
+	}
+	if pending[0].Dir != "project-1/script-2/chromium-20x20" || pending[0].Name != "t/home.png" {
+		t.Errorf("unexpected pending entry: %+v", pending[0])
+	}
+	if other, _ := store.listPending("9", "", ""); len(other) != 0 {
+		t.Errorf("project filter should exclude it, got %v", other)
+	}
+
+	if err := store.approve(pending[0]); err != nil {
+		t.Fatal(err)
+	}
+	if c := store.compare(key, "t/home.png", shot, opts); c.Status != "passed" {
+		t.Errorf("approved screenshot should pass: %+v", c)
+	}
+	if after, _ := store.listPending("", "", ""); len(after) != 0 {
+		t.Errorf("nothing should be pending after approval, got %v", after)
+	}
+}
+
+func TestListPending_EmptyStore(t *testing.T) {
+	store := &visualStore{Root: filepath.Join(t.TempDir(), "none")}
+	pending, err := store.listPending("", "", "")
+	if err != nil || len(pending) != 0 {
+		t.Errorf("got %v, %v", pending, err)
+	}
+}
+
+func TestCompareVisualArtifacts(t *testing.T) {
+	testDir := t.TempDir()
+	results := filepath.Join(testDir, "test-results", "home-chromium")
+	writeTestPNG(t, filepath.Join(results, "test-finished-1.png"), solidImage(4, 4, white))
+	writeTestPNG(t, filepath.Join(results, "hero-diff.png"), solidImage(4, 4, black))
+	_ = os.WriteFile(filepath.Join(results, "video.webm"), []byte("x"), 0644)
+
+	store := &visualStore{Root: t.TempDir()}
+	key := visualKey{ProjectID: "1", ScriptID: "2", Browser: "chromium", Width: 4, Height: 4}
+	report := compareVisualArtifacts(store, testDir, key, VisualOptions{Enabled: true})
+	if report.New != 1 || len(report.Comparisons) != 1 || report.Comparisons[0].Name != "home-chromium/test-finished-1.png" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	writeTestPNG(t, filepath.Join(results, "test-finished-1.png"), solidImage(4, 4, black))
+	report = compareVisualArtifacts(store, testDir, key, VisualOptions{Enabled: true})
+	if report.Status != "changed" || report.Changed != 1 {
+		t.Errorf("expected a change: %+v", report)
+	}
+	if resultCategory(true, report, nil) != "visual_diff" || resultCategory(false, report, nil) != "failed" || resultCategory(true, nil, nil) != "passed" {
+		t.Error("unexpected result categories")
+	}
+}
+
+func TestAgentVisualOptions(t *testing.T) {
+	a := &Agent{}
+	if a.visualOptions(Assignment{}) != nil {
+		t.Error("visual comparison should be off by default")
+	}
+	a.Visual = &VisualOptions{Enabled: true, Threshold: 0.3}
+	if opts := a.visualOptions(Assignment{}); opts == nil || opts.Threshold != 0.3 {
+		t.Errorf("agent default not used: %+v", opts)
+	}
+	if a.visualOptions(Assignment{Visual: &VisualOptions{Enabled: false}}) != nil {
+		t.Error("assignment should be able to turn comparison off")
+	}
+
+	var assignment Assignment
+	_ = json.Unmarshal([]byte(`{"id": 1, "project_id": 42, "visual": {"enabled": true, "max_diff_ratio": 0.5}}`), &assignment)
+	if opts := a.visualOptions(assignment); opts == nil || opts.MaxDiffRatio != 0.5 || assignment.ProjectID.String() != "42" {
+		t.Errorf("assignment options not used: %+v", opts)
+	}
+}
+
+func TestReportResult_Category(t *testing.T) {
+	var payload map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/result") {
+			_ = json.NewDecoder(r.Body).Decode(&payload)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	a := newTestAgent(server.URL)
+	a.reportResult("a1", true, "ok", map[string]interface{}{
+		"category": "visual_diff",
+		"visual":   &VisualReport{Status: "changed", Changed: 1},
+	})
+	if payload["category"] != "visual_diff" || payload["visual"] == nil {
+		t.Errorf("unexpected payload: %v", payload)
+	}
+}
+
+func TestCIResultStatus_VisualDiff(t *testing.T) {
+	s := &ciExecutionStatus{Status: "completed", Category: "visual_diff"}
+	if got := s.resultStatus(); got != "visual_diff" {
+		t.Errorf("got %s", got)
+	}
+	s.TestErrors = "boom"
+	if got := s.resultStatus(); got != "failed" {
+		t.Errorf("test errors should win, got %s", got)
+	}
+	if !strings.Contains(ciStatusLabel("visual_diff"), "Visual diff") || !strings.Contains(ciStatusLabel("error"), "Failed") {
+		t.Error("unexpected status labels")
+	}
+}
+
+func TestCmdVisualSubprocess_Approve(t *testing.T) {
+	if args := os.Getenv("RUN_CMD_TEST_VISUAL"); args != "" {
+		cmdVisual(strings.Fields(args))
+		return
+	}
+
+	root := t.TempDir()
+	store := &visualStore{Root: root}
+	key := visualKey{ProjectID: "5", ScriptID: "6", Browser: "chromium", Width: 2, Height: 2}
+	writeTestPNG(t, store.path("pending", key, "a.png"), solidImage(2, 2, color.NRGBA{1, 2, 3, 255}))
+
+	run := func(args string) (string, error) {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestCmdVisualSubprocess_Approve$")
+		cmd.Env = append(os.Environ(), "RUN_CMD_TEST_VISUAL="+args, "QMAX_VISUAL_DIR="+root)
+		out, err := cmd.CombinedOutput()
+		return string(out), err
+	}
+
+	if out, err := run("approve"); err == nil || !strings.Contains(out, "--all") {
+		t.Errorf("approve without filters should fail: %v\n%s", err, out)
+	}
+	if out, err := run("list"); err != nil || !strings.Contains(out, "project-5/script-6/chromium-2x2/a.png") {
+		t.Errorf("list: %v\n%s", err, out)
+	}
+	if out, err := run("approve --script-id 6"); err != nil || !strings.Contains(out, "1 baseline(s) updated") {
+		t.Errorf("approve: %v\n%s", err, out)
+	}
+	if _, err := os.Stat(store.path("baselines", key, "a.png")); err != nil {
+		t.Errorf("baseline not written: %v", err)
+	}
+}